@@ -0,0 +1,187 @@
+// Command goat provides developer tooling for GOAT
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"goat/internal/audit"
+	"goat/internal/inspect"
+)
+
+const usage = `Usage: goat <command> [flags]
+
+Commands:
+  webhook-inspect   run a local webhook receiver that verifies, prints and saves requests
+  audit-verify      verify the hash chain and signed checkpoints of an audit log export
+
+Run "goat <command> -h" for the flags of a command.
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	switch args[0] {
+	case "webhook-inspect":
+		return webhookInspect(args[1:], stdout, stderr)
+	case "audit-verify":
+		return auditVerify(args[1:], stdout, stderr)
+	case "help", "-h", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "goat: unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+}
+
+func webhookInspect(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("webhook-inspect", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	addr := flags.String("addr", "127.0.0.1:8089", "address to listen on")
+	secret := flags.String("secret", os.Getenv("GOAT_WEBHOOK_SECRET"), "webhook secret for verifying X-Webhook-Signature (default $GOAT_WEBHOOK_SECRET)")
+	out := flags.String("out", "", "directory to save each request to as JSON")
+	status := flags.String("status", "200", `status codes for successive requests, e.g. "503x3,200"; the last one repeats`)
+	latency := flags.String("latency", "0s", `response delays for successive requests, e.g. "0s,45s"; the last one repeats`)
+	keyFile := flags.String("decrypt-key", "", "PEM private key for decrypting JWE payloads")
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	config := inspect.Config{Secret: *secret, OutputDir: *out, Out: stdout}
+	var err error
+	if config.Statuses, err = inspect.ParseStatusScript(*status); err != nil {
+		fmt.Fprintf(stderr, "goat webhook-inspect: -status: %v\n", err)
+		return 2
+	}
+	if config.Latencies, err = inspect.ParseLatencyScript(*latency); err != nil {
+		fmt.Fprintf(stderr, "goat webhook-inspect: -latency: %v\n", err)
+		return 2
+	}
+	if *keyFile != "" {
+		data, err := os.ReadFile(*keyFile)
+		if err != nil {
+			fmt.Fprintf(stderr, "goat webhook-inspect: %v\n", err)
+			return 1
+		}
+		if config.DecryptionKey, err = inspect.ParsePrivateKeyPEM(data); err != nil {
+			fmt.Fprintf(stderr, "goat webhook-inspect: -decrypt-key: %v\n", err)
+			return 1
+		}
+	}
+
+	receiver, err := inspect.NewReceiver(config)
+	if err != nil {
+		fmt.Fprintf(stderr, "goat webhook-inspect: %v\n", err)
+		return 1
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	fmt.Fprintf(stdout, "Listening for webhooks on http://%s\n\n", *addr)
+	if err := inspect.Serve(ctx, *addr, receiver); err != nil {
+		fmt.Fprintf(stderr, "goat webhook-inspect: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// keyFlags collects repeated -key [id=]path flags
+type keyFlags []string
+
+func (k *keyFlags) String() string { return strings.Join(*k, ",") }
+
+func (k *keyFlags) Set(value string) error {
+	*k = append(*k, value)
+	return nil
+}
+
+func auditVerify(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("audit-verify", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	file := flags.String("file", "-", `chain export from GET /api/audit/chain, or "-" for stdin`)
+	var keyFiles keyFlags
+	flags.Var(&keyFiles, "key", "[key-id=]path of a PEM Ed25519 checkpoint public key; repeatable, a key without an ID verifies any checkpoint")
+	from := flags.Int64("from", 1, "first sequence to verify")
+	to := flags.Int64("to", 0, "last sequence to verify; 0 verifies to the end of the export")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+
+	keys := make(map[string]ed25519.PublicKey)
+	for _, spec := range keyFiles {
+		keyID, path := "", spec
+		if i := strings.Index(spec, "="); i >= 0 {
+			keyID, path = spec[:i], spec[i+1:]
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "goat audit-verify: %v\n", err)
+			return 2
+		}
+		if keys[keyID], err = audit.ParsePublicKeyPEM(data); err != nil {
+			fmt.Fprintf(stderr, "goat audit-verify: -key %s: %v\n", spec, err)
+			return 2
+		}
+	}
+
+	var data []byte
+	var err error
+	if *file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(*file)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "goat audit-verify: %v\n", err)
+		return 2
+	}
+	export, err := audit.ParseChainExport(data)
+	if err != nil {
+		fmt.Fprintf(stderr, "goat audit-verify: %v\n", err)
+		return 2
+	}
+
+	report := audit.VerifyEntries(export.Range(*from, *to), keys)
+	if *asJSON {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			fmt.Fprintf(stderr, "goat audit-verify: %v\n", err)
+			return 2
+		}
+	} else {
+		fmt.Fprintf(stdout, "Verified %d entries (%d to %d), %d anonymized, %d checkpoints\n", report.Entries,
+			report.FirstSequence, report.LastSequence, report.Anonymized, report.CheckpointsVerified)
+		for _, problem := range report.Problems {
+			fmt.Fprintf(stdout, "  #%d %s: %s\n", problem.Sequence, problem.Kind, problem.Detail)
+		}
+		if report.Valid() {
+			fmt.Fprintln(stdout, "Chain is intact")
+		}
+	}
+	if !report.Valid() {
+		return 1
+	}
+	return 0
+}
@@ -25,6 +25,9 @@ const (
 	EventTypeSecurityAlert   EventType = "security.alert"
 	EventTypeRateLimitExceed EventType = "ratelimit.exceed"
 	EventTypeSSOLogin        EventType = "sso.login"
+	EventTypeAuthHook        EventType = "auth.hook"
+	EventTypeDataExport      EventType = "compliance.data.export"
+	EventTypeDataDeletion    EventType = "compliance.data.delete"
 )
 
 // Severity represents the severity level of an audit event
@@ -53,6 +56,13 @@ type AuditLog struct {
 	Details     map[string]interface{} `json:"details,omitempty" db:"details"`
 	ErrorMsg    string                 `json:"error_msg,omitempty" db:"error_msg"`
 	GeoLocation *GeoLocation           `json:"geo_location,omitempty" db:"geo_location"`
+
+	// Hash chain fields, set when the entry is stored; see ComputeHash
+	Sequence           int64  `json:"sequence,omitempty" db:"sequence"`
+	PrevHash           string `json:"prev_hash,omitempty" db:"prev_hash"`
+	Hash               string `json:"hash,omitempty" db:"hash"`
+	PersonalDataSalt   string `json:"personal_data_salt,omitempty" db:"personal_data_salt"`
+	PersonalDataDigest string `json:"personal_data_digest,omitempty" db:"personal_data_digest"`
 }
 
 // GeoLocation represents geographic information
@@ -218,14 +228,20 @@ func (l *AuditLogger) LogSecurityEvent(ctx context.Context, eventType EventType,
 	})
 }
 
-// AuditMiddleware provides HTTP middleware for audit logging
-type AuditMiddleware struct {
-	logger *AuditLogger
-}
-
-// NewAuditMiddleware creates new audit middleware
-func NewAuditMiddleware(logger *AuditLogger) *AuditMiddleware {
-	return &AuditMiddleware{logger: logger}
+// LogHookDecision logs the outcome of an authentication hook chain; result is the final decision
+func (l *AuditLogger) LogHookDecision(ctx context.Context, point, userID, ip, userAgent, result string, severity Severity, details map[string]interface{}) error {
+	return l.service.Log(ctx, &AuditLog{
+		Timestamp: time.Now(),
+		EventType: EventTypeAuthHook,
+		Severity:  severity,
+		UserID:    userID,
+		IP:        ip,
+		UserAgent: userAgent,
+		Resource:  point,
+		Action:    "hook." + point,
+		Result:    result,
+		Details:   details,
+	})
 }
 
 // SerializeDetails converts details to JSON for database storage
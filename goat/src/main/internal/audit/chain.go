@@ -0,0 +1,470 @@
+package audit
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// GenesisHash is the PrevHash of the first entry of a chain
+const GenesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// Kinds of ChainProblem
+const (
+	ProblemGap                = "gap"
+	ProblemModified           = "modified"
+	ProblemReordered          = "reordered"
+	ProblemDuplicate          = "duplicate"
+	ProblemBrokenLink         = "broken_link"
+	ProblemBadCheckpoint      = "bad_checkpoint"
+	ProblemCheckpointMismatch = "checkpoint_mismatch"
+)
+
+// ChainHead is the newest entry of an audit hash chain; Sequence is 0 for an empty chain
+type ChainHead struct {
+	Sequence int64  `json:"sequence"`
+	Hash     string `json:"hash"`
+}
+
+// Checkpoint is a signed statement that the chain's entry Sequence had Hash at IssuedAt. An
+// attacker able to rewrite the log and recompute every hash cannot reproduce the signature.
+type Checkpoint struct {
+	Sequence  int64     `json:"sequence"`
+	Hash      string    `json:"hash"`
+	IssuedAt  time.Time `json:"issued_at"`
+	KeyID     string    `json:"key_id"`
+	Signature string    `json:"signature"`
+}
+
+// AuditChain is implemented by audit services whose entries are hash-chained
+type AuditChain interface {
+	// ChainHead returns the newest entry's sequence and hash
+	ChainHead(ctx context.Context) (*ChainHead, error)
+
+	// ChainEntries returns the entries with sequences in [from, to], in sequence order
+	ChainEntries(ctx context.Context, from, to int64) ([]*AuditLog, error)
+
+	// SaveCheckpoint stores a checkpoint
+	SaveCheckpoint(ctx context.Context, checkpoint *Checkpoint) error
+
+	// Checkpoints returns the checkpoints with sequences in [from, to], in sequence order
+	Checkpoints(ctx context.Context, from, to int64) ([]*Checkpoint, error)
+}
+
+// ChainExport is a range of the chain with its checkpoints, as read by "goat audit-verify"
+type ChainExport struct {
+	Entries     []*AuditLog   `json:"entries"`
+	Checkpoints []*Checkpoint `json:"checkpoints"`
+}
+
+// ChainProblem is one integrity failure found by verification
+type ChainProblem struct {
+	Sequence int64  `json:"sequence"`
+	Kind     string `json:"kind"`
+	Detail   string `json:"detail"`
+}
+
+// VerificationReport is the outcome of verifying a range of the chain
+type VerificationReport struct {
+	Entries             int            `json:"entries"`
+	FirstSequence       int64          `json:"first_sequence"`
+	LastSequence        int64          `json:"last_sequence"`
+	Anonymized          int            `json:"anonymized"`
+	CheckpointsVerified int            `json:"checkpoints_verified"`
+	Problems            []ChainProblem `json:"problems"`
+}
+
+// Valid reports whether verification found no problems
+func (r *VerificationReport) Valid() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *VerificationReport) add(sequence int64, kind, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, ChainProblem{Sequence: sequence, Kind: kind, Detail: fmt.Sprintf(format, args...)})
+}
+
+// canonicalEntry fixes the field order and formats hashed by ComputeHash. Personal data enters
+// only through its salted digest, so erasing it for GDPR keeps the chain verifiable.
+type canonicalEntry struct {
+	Version            int             `json:"v"`
+	Sequence           int64           `json:"sequence"`
+	PrevHash           string          `json:"prev_hash"`
+	ID                 string          `json:"id"`
+	Timestamp          string          `json:"timestamp"`
+	EventType          EventType       `json:"event_type"`
+	Severity           Severity        `json:"severity"`
+	Resource           string          `json:"resource"`
+	Action             string          `json:"action"`
+	Result             string          `json:"result"`
+	Details            json.RawMessage `json:"details"`
+	ErrorMsg           string          `json:"error_msg"`
+	PersonalDataDigest string          `json:"personal_data_digest"`
+}
+
+type personalData struct {
+	UserID      string       `json:"user_id"`
+	SessionID   string       `json:"session_id"`
+	IP          string       `json:"ip"`
+	UserAgent   string       `json:"user_agent"`
+	GeoLocation *GeoLocation `json:"geo_location"`
+}
+
+// ComputeHash returns the hex SHA-256 of an entry's canonical serialization, which includes its
+// sequence and the previous entry's hash
+func ComputeHash(log *AuditLog) (string, error) {
+	details, err := canonicalJSON(log.Details)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(canonicalEntry{
+		Version:            1,
+		Sequence:           log.Sequence,
+		PrevHash:           log.PrevHash,
+		ID:                 log.ID,
+		Timestamp:          log.Timestamp.UTC().Format(time.RFC3339Nano),
+		EventType:          log.EventType,
+		Severity:           log.Severity,
+		Resource:           log.Resource,
+		Action:             log.Action,
+		Result:             log.Result,
+		Details:            details,
+		ErrorMsg:           log.ErrorMsg,
+		PersonalDataDigest: log.PersonalDataDigest,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// canonicalJSON encodes v as it reads back from a JSONB column: generic values with sorted keys
+func canonicalJSON(v interface{}) (json.RawMessage, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+	return json.Marshal(generic)
+}
+
+func personalDataDigest(log *AuditLog) (string, error) {
+	data, err := json.Marshal(personalData{
+		UserID:      log.UserID,
+		SessionID:   log.SessionID,
+		IP:          log.IP,
+		UserAgent:   log.UserAgent,
+		GeoLocation: log.GeoLocation,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(log.PersonalDataSalt+"\n"), data...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// personalDataErased reports whether an entry's personal data was erased by DeleteUserData
+func personalDataErased(log *AuditLog) bool {
+	return log.PersonalDataSalt == "" && log.UserID == "" && log.SessionID == "" && log.IP == "" &&
+		log.UserAgent == "" && log.GeoLocation == nil
+}
+
+// sealLog appends an entry to the chain ending at head
+func sealLog(log *AuditLog, head ChainHead) error {
+	var salt [16]byte
+	if _, err := rand.Read(salt[:]); err != nil {
+		return err
+	}
+	log.PersonalDataSalt = hex.EncodeToString(salt[:])
+	digest, err := personalDataDigest(log)
+	if err != nil {
+		return err
+	}
+	log.PersonalDataDigest = digest
+	log.Sequence = head.Sequence + 1
+	log.PrevHash = head.Hash
+	if log.PrevHash == "" {
+		log.PrevHash = GenesisHash
+	}
+	log.Hash, err = ComputeHash(log)
+	return err
+}
+
+// erasePersonalData clears an entry's personal data and the salt that would let its digest be brute-forced
+func erasePersonalData(log *AuditLog) {
+	log.UserID = ""
+	log.SessionID = ""
+	log.IP = ""
+	log.UserAgent = ""
+	log.GeoLocation = nil
+	log.PersonalDataSalt = ""
+}
+
+// checkpointMessage is the byte string a checkpoint's signature covers
+func checkpointMessage(checkpoint *Checkpoint) []byte {
+	return []byte(fmt.Sprintf("goat-audit-checkpoint/v1\n%d\n%s\n%s\n%s", checkpoint.Sequence, checkpoint.Hash,
+		checkpoint.IssuedAt.UTC().Format(time.RFC3339Nano), checkpoint.KeyID))
+}
+
+// SignCheckpoint signs a checkpoint of head with key
+func SignCheckpoint(head ChainHead, keyID string, key ed25519.PrivateKey, issuedAt time.Time) *Checkpoint {
+	checkpoint := &Checkpoint{
+		Sequence: head.Sequence,
+		Hash:     head.Hash,
+		IssuedAt: issuedAt.UTC().Truncate(time.Microsecond),
+		KeyID:    keyID,
+	}
+	checkpoint.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, checkpointMessage(checkpoint)))
+	return checkpoint
+}
+
+// Checkpointer periodically signs and saves the chain head
+type Checkpointer struct {
+	chain AuditChain
+	keyID string
+	key   ed25519.PrivateKey
+
+	mu   sync.Mutex
+	last *ChainHead
+}
+
+// NewCheckpointer creates a checkpointer signing with key, published to verifiers under keyID
+func NewCheckpointer(chain AuditChain, keyID string, key ed25519.PrivateKey) *Checkpointer {
+	return &Checkpointer{chain: chain, keyID: keyID, key: key}
+}
+
+// Checkpoint signs and saves the current chain head. It returns nil without saving when the
+// chain is empty or has not grown since the last checkpoint.
+func (c *Checkpointer) Checkpoint(ctx context.Context) (*Checkpoint, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	head, err := c.chain.ChainHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if head.Sequence == 0 || (c.last != nil && c.last.Sequence == head.Sequence) {
+		return nil, nil
+	}
+	checkpoint := SignCheckpoint(*head, c.keyID, c.key, time.Now())
+	if err := c.chain.SaveCheckpoint(ctx, checkpoint); err != nil {
+		return nil, err
+	}
+	c.last = head
+	return checkpoint, nil
+}
+
+// Run issues a checkpoint every interval until ctx is done, reporting failures through onError
+func (c *Checkpointer) Run(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := c.Checkpoint(ctx); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// ExportChain reads entries [from, to] and their checkpoints; to <= 0 means up to the head. When
+// from is past the first entry, the entry before it is included so its link can be checked. Without
+// an upper bound every later checkpoint is included too, so entries cut from the end are detected.
+func ExportChain(ctx context.Context, chain AuditChain, from, to int64) (*ChainExport, error) {
+	if from < 1 {
+		from = 1
+	}
+	lastCheckpoint := to
+	if to <= 0 {
+		head, err := chain.ChainHead(ctx)
+		if err != nil {
+			return nil, err
+		}
+		to, lastCheckpoint = head.Sequence, math.MaxInt64
+	}
+	start := from
+	if start > 1 {
+		start--
+	}
+	entries := []*AuditLog{}
+	if to >= from {
+		var err error
+		if entries, err = chain.ChainEntries(ctx, start, to); err != nil {
+			return nil, err
+		}
+	}
+	checkpoints, err := chain.Checkpoints(ctx, from, lastCheckpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &ChainExport{Entries: entries, Checkpoints: checkpoints}, nil
+}
+
+// VerifyChain exports entries [from, to] and verifies them; to <= 0 means up to the head
+func VerifyChain(ctx context.Context, chain AuditChain, from, to int64, keys map[string]ed25519.PublicKey) (*VerificationReport, error) {
+	export, err := ExportChain(ctx, chain, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return VerifyEntries(export, keys), nil
+}
+
+// VerifyEntries checks a chain range in the order its entries were read. It reports entries out
+// of sequence order, duplicate and missing sequences, entries whose contents or personal data no
+// longer match their hash, broken links to the previous entry, and checkpoints that are not
+// signed by a key in keys or whose hash differs from the entry's. Keys are looked up by key ID;
+// the key under "" verifies checkpoints of any ID. Entries whose personal data was erased are
+// counted as anonymized rather than modified.
+func VerifyEntries(export *ChainExport, keys map[string]ed25519.PublicKey) *VerificationReport {
+	report := &VerificationReport{Entries: len(export.Entries), Problems: []ChainProblem{}}
+	entries := export.Entries
+	for i := 1; i < len(entries); i++ {
+		if entries[i].Sequence < entries[i-1].Sequence {
+			report.add(entries[i].Sequence, ProblemReordered, "entry %d appears after entry %d", entries[i].Sequence, entries[i-1].Sequence)
+		}
+	}
+
+	sorted := append([]*AuditLog(nil), entries...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Sequence < sorted[j].Sequence })
+	bySequence := make(map[int64]*AuditLog, len(sorted))
+	for i, entry := range sorted {
+		if i == 0 {
+			report.FirstSequence = entry.Sequence
+		}
+		report.LastSequence = entry.Sequence
+		if entry.Sequence < 1 {
+			report.add(entry.Sequence, ProblemModified, "entry %s has no sequence number", entry.ID)
+			continue
+		}
+		if _, ok := bySequence[entry.Sequence]; ok {
+			report.add(entry.Sequence, ProblemDuplicate, "sequence %d appears more than once", entry.Sequence)
+			continue
+		}
+		bySequence[entry.Sequence] = entry
+		verifyEntry(report, entry)
+
+		if i == 0 {
+			if entry.Sequence == 1 && entry.PrevHash != GenesisHash {
+				report.add(entry.Sequence, ProblemBrokenLink, "first entry does not start from the genesis hash")
+			}
+			continue
+		}
+		prev := sorted[i-1]
+		switch {
+		case entry.Sequence > prev.Sequence+1:
+			report.add(prev.Sequence+1, ProblemGap, "entries %d to %d are missing", prev.Sequence+1, entry.Sequence-1)
+		case entry.PrevHash != prev.Hash:
+			report.add(entry.Sequence, ProblemBrokenLink, "previous hash does not match entry %d", prev.Sequence)
+		}
+	}
+
+	// checkpointed is the newest signed sequence past the last entry, showing entries were cut from the end
+	var checkpointed int64
+	for _, checkpoint := range export.Checkpoints {
+		key, ok := keys[checkpoint.KeyID]
+		if !ok {
+			key, ok = keys[""]
+		}
+		signature, err := base64.StdEncoding.DecodeString(checkpoint.Signature)
+		switch {
+		case !ok:
+			report.add(checkpoint.Sequence, ProblemBadCheckpoint, "no key for checkpoint key ID %q", checkpoint.KeyID)
+			continue
+		case err != nil || !ed25519.Verify(key, checkpointMessage(checkpoint), signature):
+			report.add(checkpoint.Sequence, ProblemBadCheckpoint, "checkpoint signature is invalid")
+			continue
+		}
+		report.CheckpointsVerified++
+		if checkpoint.Sequence > report.LastSequence {
+			if checkpoint.Sequence > checkpointed {
+				checkpointed = checkpoint.Sequence
+			}
+		} else if entry, ok := bySequence[checkpoint.Sequence]; ok && entry.Hash != checkpoint.Hash {
+			report.add(checkpoint.Sequence, ProblemCheckpointMismatch, "entry hash differs from the checkpoint issued at %s",
+				checkpoint.IssuedAt.Format(time.RFC3339))
+		}
+	}
+	if checkpointed > 0 {
+		report.add(report.LastSequence+1, ProblemGap, "entries %d to %d are missing; a checkpoint covers them",
+			report.LastSequence+1, checkpointed)
+	}
+	sort.SliceStable(report.Problems, func(i, j int) bool { return report.Problems[i].Sequence < report.Problems[j].Sequence })
+	return report
+}
+
+// verifyEntry checks an entry's personal data digest and hash
+func verifyEntry(report *VerificationReport, entry *AuditLog) {
+	if personalDataErased(entry) {
+		report.Anonymized++
+	} else if digest, err := personalDataDigest(entry); err != nil || digest != entry.PersonalDataDigest {
+		report.add(entry.Sequence, ProblemModified, "personal data does not match its digest")
+	}
+	if hash, err := ComputeHash(entry); err != nil || hash != entry.Hash {
+		report.add(entry.Sequence, ProblemModified, "contents do not match the entry hash")
+	}
+}
+
+// ParseChainExport decodes a ChainExport
+func ParseChainExport(data []byte) (*ChainExport, error) {
+	var export ChainExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, fmt.Errorf("decode chain export: %w", err)
+	}
+	if export.Entries == nil {
+		return nil, errors.New("chain export has no entries")
+	}
+	return &export, nil
+}
+
+// Range returns the part of an export with sequences in [from, to] and, as ExportChain does, the
+// entry before from; to <= 0 means no upper bound
+func (e *ChainExport) Range(from, to int64) *ChainExport {
+	inRange := func(sequence, start int64) bool { return sequence >= start && (to <= 0 || sequence <= to) }
+	out := &ChainExport{Entries: []*AuditLog{}, Checkpoints: []*Checkpoint{}}
+	for _, entry := range e.Entries {
+		if inRange(entry.Sequence, from-1) {
+			out.Entries = append(out.Entries, entry)
+		}
+	}
+	for _, checkpoint := range e.Checkpoints {
+		if inRange(checkpoint.Sequence, from) {
+			out.Checkpoints = append(out.Checkpoints, checkpoint)
+		}
+	}
+	return out
+}
+
+// ParsePublicKeyPEM decodes a PEM "PUBLIC KEY" block holding an Ed25519 key, as written by
+// "openssl pkey -pubout"
+func ParsePublicKeyPEM(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("no PEM PUBLIC KEY block found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is %T, not Ed25519", key)
+	}
+	return public, nil
+}
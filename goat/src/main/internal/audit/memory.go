@@ -0,0 +1,418 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// InMemoryAuditService keeps audit logs, alerts and reports in memory, for tests and single-node
+// development setups
+type InMemoryAuditService struct {
+	mu        sync.RWMutex
+	logs      []*AuditLog
+	alerts    map[string]*SecurityAlert
+	reports   map[string]*ComplianceReport
+	exports   map[string]*UserDataExport
+	summaries map[string]*ReportSummary
+	// checkpoints are kept in sequence order
+	checkpoints []*Checkpoint
+}
+
+// NewInMemoryAuditService creates an empty in-memory audit service
+func NewInMemoryAuditService() *InMemoryAuditService {
+	return &InMemoryAuditService{
+		alerts:    make(map[string]*SecurityAlert),
+		reports:   make(map[string]*ComplianceReport),
+		exports:   make(map[string]*UserDataExport),
+		summaries: make(map[string]*ReportSummary),
+	}
+}
+
+// Log validates and stores an entry, filling in its ID, timestamp and severity, and appends it
+// to the hash chain
+func (s *InMemoryAuditService) Log(ctx context.Context, log *AuditLog) error {
+	if err := prepareLog(log, time.Now()); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := sealLog(log, s.headLocked()); err != nil {
+		return err
+	}
+	s.logs = append(s.logs, copyLog(log))
+	return nil
+}
+
+// Query returns logs matching every filter condition, newest first
+func (s *InMemoryAuditService) Query(ctx context.Context, filter *AuditFilter) ([]*AuditLog, error) {
+	if filter == nil {
+		filter = &AuditFilter{}
+	}
+	matchIP, err := ipMatcher(filter.IP)
+	if err != nil {
+		return nil, err
+	}
+	limit, offset := queryPage(filter)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := []*AuditLog{}
+	for _, log := range s.newestFirst() {
+		if !matchesFilter(log, filter) || !matchIP(log.IP) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		result = append(result, copyLog(log))
+		if len(result) == limit {
+			break
+		}
+	}
+	return result, nil
+}
+
+// GetUserLogs returns every log of a user, newest first
+func (s *InMemoryAuditService) GetUserLogs(ctx context.Context, userID string) ([]*AuditLog, error) {
+	if userID == "" {
+		return nil, errors.New("user ID is required")
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.userLogsLocked(userID), nil
+}
+
+// ExportUserData collects a user's logs and alerts into a completed gdpr report; the export is
+// read back with GetUserDataExport
+func (s *InMemoryAuditService) ExportUserData(ctx context.Context, userID string) (*ComplianceReport, error) {
+	if userID == "" {
+		return nil, errors.New("user ID is required")
+	}
+	now := time.Now().UTC()
+	s.mu.Lock()
+	export := &UserDataExport{UserID: userID, GeneratedAt: now, AuditLogs: s.userLogsLocked(userID), SecurityAlerts: []*SecurityAlert{}}
+	for _, alert := range s.alerts {
+		if alert.UserID == userID {
+			export.SecurityAlerts = append(export.SecurityAlerts, copyAlert(alert))
+		}
+	}
+	sortAlerts(export.SecurityAlerts)
+	report := &ComplianceReport{
+		ID:          newID(),
+		UserID:      userID,
+		RequestedAt: now,
+		CompletedAt: now,
+		Type:        ReportTypeGDPR,
+		Status:      ReportStatusCompleted,
+	}
+	s.reports[report.ID] = report
+	s.exports[report.ID] = export
+	s.mu.Unlock()
+
+	out := *report
+	return &out, s.Log(ctx, dataExportLog(report))
+}
+
+// GetUserDataExport returns the data collected by ExportUserData
+func (s *InMemoryAuditService) GetUserDataExport(ctx context.Context, reportID string) (*UserDataExport, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	export, ok := s.exports[reportID]
+	if !ok {
+		return nil, fmt.Errorf("report %s: %w", reportID, ErrReportNotFound)
+	}
+	out := *export
+	out.AuditLogs = make([]*AuditLog, len(export.AuditLogs))
+	for i, log := range export.AuditLogs {
+		out.AuditLogs[i] = copyLog(log)
+	}
+	out.SecurityAlerts = make([]*SecurityAlert, len(export.SecurityAlerts))
+	for i, alert := range export.SecurityAlerts {
+		out.SecurityAlerts[i] = copyAlert(alert)
+	}
+	return &out, nil
+}
+
+// DeleteUserData anonymizes a user's logs and alerts and deletes their reports and exports. Logs
+// are kept, without the user, session, IP, user agent and location, so the audit trail stays whole.
+func (s *InMemoryAuditService) DeleteUserData(ctx context.Context, userID string) error {
+	if userID == "" {
+		return errors.New("user ID is required")
+	}
+	s.mu.Lock()
+	anonymized := 0
+	for _, log := range s.logs {
+		if log.UserID == userID {
+			erasePersonalData(log)
+			anonymized++
+		}
+	}
+	for _, alert := range s.alerts {
+		if alert.UserID == userID {
+			alert.UserID = ""
+		}
+	}
+	deleted := 0
+	for id, report := range s.reports {
+		if report.UserID == userID {
+			delete(s.reports, id)
+			delete(s.exports, id)
+			deleted++
+		}
+	}
+	s.mu.Unlock()
+
+	return s.Log(ctx, dataDeletionLog(anonymized, deleted))
+}
+
+// GenerateComplianceReport summarizes the last 30 days of logs and the open alerts into a
+// completed report; the summary is read back with GetReportSummary
+func (s *InMemoryAuditService) GenerateComplianceReport(ctx context.Context, reportType string) (*ComplianceReport, error) {
+	if !validReportType(reportType) {
+		return nil, fmt.Errorf("unknown report type %q", reportType)
+	}
+	now := time.Now().UTC()
+	summary := newReportSummary(reportType, now.Add(-reportWindow), now)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := make(map[string]struct{})
+	for _, log := range s.logs {
+		if log.Timestamp.Before(summary.From) || log.Timestamp.After(summary.To) {
+			continue
+		}
+		failed := 0
+		if failedResult(log.Result) {
+			failed = 1
+		}
+		summary.add(log.EventType, log.Severity, 1, failed)
+		if log.UserID != "" {
+			users[log.UserID] = struct{}{}
+		}
+	}
+	summary.UniqueUsers = len(users)
+	for _, alert := range s.alerts {
+		if !alert.Resolved {
+			summary.OpenAlerts++
+		}
+	}
+
+	report := &ComplianceReport{ID: newID(), RequestedAt: now, CompletedAt: now, Type: reportType, Status: ReportStatusCompleted}
+	s.reports[report.ID] = report
+	s.summaries[report.ID] = summary
+	out := *report
+	return &out, nil
+}
+
+// GetReportSummary returns the summary recorded by GenerateComplianceReport
+func (s *InMemoryAuditService) GetReportSummary(ctx context.Context, reportID string) (*ReportSummary, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	summary, ok := s.summaries[reportID]
+	if !ok {
+		return nil, fmt.Errorf("report %s: %w", reportID, ErrReportNotFound)
+	}
+	out := *summary
+	out.LogsByEventType = make(map[string]int, len(summary.LogsByEventType))
+	for k, v := range summary.LogsByEventType {
+		out.LogsByEventType[k] = v
+	}
+	out.LogsBySeverity = make(map[Severity]int, len(summary.LogsBySeverity))
+	for k, v := range summary.LogsBySeverity {
+		out.LogsBySeverity[k] = v
+	}
+	return &out, nil
+}
+
+// CreateSecurityAlert validates and stores an alert, filling in its ID, timestamp and severity
+func (s *InMemoryAuditService) CreateSecurityAlert(ctx context.Context, alert *SecurityAlert) error {
+	if err := prepareAlert(alert, time.Now()); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.alerts[alert.ID] = copyAlert(alert)
+	return nil
+}
+
+// GetSecurityAlerts returns unresolved alerts, newest first
+func (s *InMemoryAuditService) GetSecurityAlerts(ctx context.Context) ([]*SecurityAlert, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := []*SecurityAlert{}
+	for _, alert := range s.alerts {
+		if !alert.Resolved {
+			result = append(result, copyAlert(alert))
+		}
+	}
+	sortAlerts(result)
+	return result, nil
+}
+
+// ResolveSecurityAlert marks an alert resolved; resolving it again does nothing
+func (s *InMemoryAuditService) ResolveSecurityAlert(ctx context.Context, alertID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	alert, ok := s.alerts[alertID]
+	if !ok {
+		return fmt.Errorf("alert %s: %w", alertID, ErrAlertNotFound)
+	}
+	alert.Resolved = true
+	return nil
+}
+
+// ChainHead returns the newest entry's sequence and hash
+func (s *InMemoryAuditService) ChainHead(ctx context.Context) (*ChainHead, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	head := s.headLocked()
+	return &head, nil
+}
+
+// ChainEntries returns the entries with sequences in [from, to], in sequence order
+func (s *InMemoryAuditService) ChainEntries(ctx context.Context, from, to int64) ([]*AuditLog, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := []*AuditLog{}
+	for _, log := range s.logs {
+		if log.Sequence >= from && log.Sequence <= to {
+			result = append(result, copyLog(log))
+		}
+	}
+	return result, nil
+}
+
+// SaveCheckpoint stores a checkpoint; one for a sequence and key ID already saved is ignored
+func (s *InMemoryAuditService) SaveCheckpoint(ctx context.Context, checkpoint *Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := sort.Search(len(s.checkpoints), func(i int) bool { return s.checkpoints[i].Sequence > checkpoint.Sequence })
+	for _, existing := range s.checkpoints[:i] {
+		if existing.Sequence == checkpoint.Sequence && existing.KeyID == checkpoint.KeyID {
+			return nil
+		}
+	}
+	out := *checkpoint
+	s.checkpoints = append(s.checkpoints, nil)
+	copy(s.checkpoints[i+1:], s.checkpoints[i:])
+	s.checkpoints[i] = &out
+	return nil
+}
+
+// Checkpoints returns the checkpoints with sequences in [from, to], in sequence order
+func (s *InMemoryAuditService) Checkpoints(ctx context.Context, from, to int64) ([]*Checkpoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := []*Checkpoint{}
+	for _, checkpoint := range s.checkpoints {
+		if checkpoint.Sequence >= from && checkpoint.Sequence <= to {
+			out := *checkpoint
+			result = append(result, &out)
+		}
+	}
+	return result, nil
+}
+
+// headLocked returns the chain head; logs are appended in sequence order
+func (s *InMemoryAuditService) headLocked() ChainHead {
+	if len(s.logs) == 0 {
+		return ChainHead{Hash: GenesisHash}
+	}
+	last := s.logs[len(s.logs)-1]
+	return ChainHead{Sequence: last.Sequence, Hash: last.Hash}
+}
+
+// newestFirst returns the stored logs ordered by timestamp, newest first; ties keep insertion order reversed
+func (s *InMemoryAuditService) newestFirst() []*AuditLog {
+	logs := make([]*AuditLog, len(s.logs))
+	for i, log := range s.logs {
+		logs[len(logs)-1-i] = log
+	}
+	sort.SliceStable(logs, func(i, j int) bool { return logs[i].Timestamp.After(logs[j].Timestamp) })
+	return logs
+}
+
+func (s *InMemoryAuditService) userLogsLocked(userID string) []*AuditLog {
+	result := []*AuditLog{}
+	for _, log := range s.newestFirst() {
+		if log.UserID == userID {
+			result = append(result, copyLog(log))
+		}
+	}
+	return result
+}
+
+// matchesFilter applies an AuditFilter's conditions except IP and paging
+func matchesFilter(log *AuditLog, filter *AuditFilter) bool {
+	if filter.StartTime != nil && log.Timestamp.Before(*filter.StartTime) {
+		return false
+	}
+	if filter.EndTime != nil && log.Timestamp.After(*filter.EndTime) {
+		return false
+	}
+	if filter.UserID != "" && log.UserID != filter.UserID {
+		return false
+	}
+	if filter.Resource != "" && log.Resource != filter.Resource {
+		return false
+	}
+	if len(filter.EventTypes) > 0 {
+		found := false
+		for _, eventType := range filter.EventTypes {
+			if eventType == log.EventType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(filter.Severity) > 0 {
+		found := false
+		for _, severity := range filter.Severity {
+			if severity == log.Severity {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func sortAlerts(alerts []*SecurityAlert) {
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].Timestamp.After(alerts[j].Timestamp) })
+}
+
+// dataExportLog is the entry recording a user data export
+func dataExportLog(report *ComplianceReport) *AuditLog {
+	return &AuditLog{
+		EventType: EventTypeDataExport,
+		Severity:  SeverityInfo,
+		UserID:    report.UserID,
+		Resource:  "compliance_report",
+		Action:    "export",
+		Result:    "success",
+		Details:   map[string]interface{}{"report_id": report.ID},
+	}
+}
+
+// dataDeletionLog is the entry recording a user data deletion. It does not name the user.
+func dataDeletionLog(anonymized, deletedReports int) *AuditLog {
+	return &AuditLog{
+		EventType: EventTypeDataDeletion,
+		Severity:  SeverityInfo,
+		Resource:  "user_data",
+		Action:    "delete",
+		Result:    "success",
+		Details:   map[string]interface{}{"logs_anonymized": anonymized, "reports_deleted": deletedReports},
+	}
+}
@@ -0,0 +1,492 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultRequestIDHeader = "X-Request-ID"
+	defaultAuditQueueSize  = 1024
+	defaultAuditWorkers    = 2
+	// auditDrainTimeout bounds storing each entry still queued when the Start context ends
+	auditDrainTimeout = 5 * time.Second
+)
+
+// ErrAuditQueueFull is reported for a request entry dropped because storage fell behind or the
+// middleware was stopped
+var ErrAuditQueueFull = errors.New("audit queue full")
+
+// Route describes how requests to a route pattern are audited
+type Route struct {
+	EventType EventType
+	Resource  string
+	// Action defaults to the request method
+	Action string
+	// Skip turns auditing off for the route, e.g. a health check under WithDefaultRoute
+	Skip bool
+}
+
+// MiddlewareOption configures an AuditMiddleware
+type MiddlewareOption func(*AuditMiddleware)
+
+// WithTrustedProxies sets the proxies whose X-Forwarded-For and X-Real-IP headers are believed;
+// see ParseTrustedProxies
+func WithTrustedProxies(networks []*net.IPNet) MiddlewareOption {
+	return func(m *AuditMiddleware) {
+		m.trustedProxies = networks
+	}
+}
+
+// WithRequestIDHeader sets the header carrying the request ID; the default is X-Request-ID
+func WithRequestIDHeader(header string) MiddlewareOption {
+	return func(m *AuditMiddleware) {
+		if header != "" {
+			m.requestIDHeader = header
+		}
+	}
+}
+
+// WithDefaultRoute audits requests matching no registered route with route. Without it, only
+// registered routes are audited.
+func WithDefaultRoute(route Route) MiddlewareOption {
+	return func(m *AuditMiddleware) {
+		m.defaultRoute = &route
+	}
+}
+
+// WithAuditQueue sets how many entries may wait for storage and how many workers store them.
+// The defaults are 1024 and 2.
+func WithAuditQueue(size, workers int) MiddlewareOption {
+	return func(m *AuditMiddleware) {
+		if size > 0 {
+			m.queueSize = size
+		}
+		if workers > 0 {
+			m.workers = workers
+		}
+	}
+}
+
+// WithMiddlewareErrorHandler is called with entries that could not be stored, including those
+// dropped with ErrAuditQueueFull
+func WithMiddlewareErrorHandler(onError func(log *AuditLog, err error)) MiddlewareOption {
+	return func(m *AuditMiddleware) {
+		m.onError = onError
+	}
+}
+
+// AuditMiddleware is net/http middleware that audits requests to registered routes. Entries are
+// queued and stored by background workers, so a slow audit store never delays a response; when
+// the queue is full the entry is dropped and reported instead.
+type AuditMiddleware struct {
+	logger          *AuditLogger
+	trustedProxies  []*net.IPNet
+	requestIDHeader string
+	defaultRoute    *Route
+	queueSize       int
+	workers         int
+	onError         func(log *AuditLog, err error)
+
+	// patterns resolves a request to the pattern it was registered under, as http.ServeMux would
+	patterns *http.ServeMux
+	mu       sync.RWMutex
+	routes   map[string]Route
+
+	queue    chan *AuditLog
+	closed   bool
+	stopped  chan struct{}
+	started  int32
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+	dropped  int64
+}
+
+// NewAuditMiddleware creates audit middleware storing entries through logger. Call Start before
+// serving and Stop on shutdown to store the entries still queued.
+func NewAuditMiddleware(logger *AuditLogger, opts ...MiddlewareOption) *AuditMiddleware {
+	m := &AuditMiddleware{
+		logger:          logger,
+		requestIDHeader: defaultRequestIDHeader,
+		queueSize:       defaultAuditQueueSize,
+		workers:         defaultAuditWorkers,
+		patterns:        http.NewServeMux(),
+		routes:          make(map[string]Route),
+		stopped:         make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	m.queue = make(chan *AuditLog, m.queueSize)
+	return m
+}
+
+// Route audits requests matching pattern, which uses http.ServeMux syntax such as
+// "POST /api/mfa/enroll" or "GET /api/audit/logs/{userId}". Requests are matched against the
+// registered patterns the way ServeMux matches them, and the pattern is recorded rather than the
+// path, so IDs in paths are not stored.
+func (m *AuditMiddleware) Route(pattern string, route Route) (err error) {
+	if !route.Skip && route.EventType == "" {
+		return fmt.Errorf("route %q: event type is required", pattern)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.routes[pattern]; ok {
+		return fmt.Errorf("route %q is already registered", pattern)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("route %q: %v", pattern, p)
+		}
+	}()
+	m.patterns.Handle(pattern, http.NotFoundHandler())
+	m.routes[pattern] = route
+	return nil
+}
+
+// Start starts the workers storing queued entries. When ctx is done or Stop is called, the
+// middleware stops accepting entries and the workers exit once the queue is drained.
+func (m *AuditMiddleware) Start(ctx context.Context) {
+	if !atomic.CompareAndSwapInt32(&m.started, 0, 1) {
+		return
+	}
+	for i := 0; i < m.workers; i++ {
+		m.wg.Add(1)
+		go m.worker(ctx)
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			m.close()
+		case <-m.stopped:
+		}
+	}()
+}
+
+// Stop stops accepting entries and waits for the workers to store the queued ones
+func (m *AuditMiddleware) Stop() {
+	m.close()
+	m.wg.Wait()
+}
+
+// close stops accepting entries; entries arriving afterwards are dropped with ErrAuditQueueFull
+func (m *AuditMiddleware) close() {
+	m.stopOnce.Do(func() {
+		m.mu.Lock()
+		m.closed = true
+		close(m.queue)
+		close(m.stopped)
+		m.mu.Unlock()
+	})
+}
+
+// Dropped returns the number of entries dropped because the queue was full
+func (m *AuditMiddleware) Dropped() int64 {
+	return atomic.LoadInt64(&m.dropped)
+}
+
+// Handler audits requests to next. The client IP comes from RemoteAddr, or from forwarding
+// headers set by trusted proxies; the user and session come from WithRequestUser; the request ID
+// comes from the request ID header, and one is generated and returned when it is missing.
+func (m *AuditMiddleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, pattern, ok := m.match(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		requestID := r.Header.Get(m.requestIDHeader)
+		if requestID == "" {
+			requestID = newID()
+			w.Header().Set(m.requestIDHeader, requestID)
+		}
+		user := &requestUser{}
+		if outer, ok := r.Context().Value(requestUserKey{}).(*requestUser); ok {
+			user.userID, user.sessionID = outer.get()
+		}
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		defer func() {
+			p := recover()
+			status := recorder.status
+			if p != nil {
+				status = http.StatusInternalServerError
+			} else if status == 0 {
+				status = http.StatusOK
+			}
+			userID, sessionID := user.get()
+			m.enqueue(&AuditLog{
+				Timestamp: start,
+				EventType: route.EventType,
+				Severity:  statusSeverity(status),
+				UserID:    userID,
+				SessionID: sessionID,
+				IP:        ClientIP(r, m.trustedProxies),
+				UserAgent: r.UserAgent(),
+				Resource:  route.Resource,
+				Action:    route.Action,
+				Result:    statusResult(status),
+				Details: map[string]interface{}{
+					"method":     r.Method,
+					"route":      pattern,
+					"status":     status,
+					"latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+					"request_id": requestID,
+				},
+			})
+			if p != nil {
+				panic(p)
+			}
+		}()
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), requestUserKey{}, user)))
+	})
+}
+
+// match returns the route auditing r and the pattern it matched
+func (m *AuditMiddleware) match(r *http.Request) (Route, string, bool) {
+	_, pattern := m.patterns.Handler(r)
+	m.mu.RLock()
+	route, ok := m.routes[pattern]
+	m.mu.RUnlock()
+	if !ok {
+		if m.defaultRoute == nil {
+			return Route{}, "", false
+		}
+		route, pattern = *m.defaultRoute, ""
+	}
+	if route.Skip {
+		return Route{}, "", false
+	}
+	if route.Action == "" {
+		route.Action = r.Method
+	}
+	return route, pattern, true
+}
+
+// enqueue hands an entry to the workers without waiting
+func (m *AuditMiddleware) enqueue(log *AuditLog) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.closed {
+		select {
+		case m.queue <- log:
+			return
+		default:
+		}
+	}
+	atomic.AddInt64(&m.dropped, 1)
+	m.reportError(log, ErrAuditQueueFull)
+}
+
+func (m *AuditMiddleware) worker(ctx context.Context) {
+	defer m.wg.Done()
+	for log := range m.queue {
+		m.store(ctx, log)
+	}
+}
+
+// store saves one entry. Once ctx is done, the entries still queued are stored with a short
+// deadline of their own rather than lost.
+func (m *AuditMiddleware) store(ctx context.Context, log *AuditLog) {
+	if ctx.Err() != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.WithoutCancel(ctx), auditDrainTimeout)
+		defer cancel()
+	}
+	if err := m.logger.service.Log(ctx, log); err != nil {
+		m.reportError(log, err)
+	}
+}
+
+func (m *AuditMiddleware) reportError(log *AuditLog, err error) {
+	if m.onError != nil {
+		m.onError(log, err)
+	}
+}
+
+// statusSeverity is warning for refused requests and error for server failures
+func statusSeverity(status int) Severity {
+	switch {
+	case status >= 500:
+		return SeverityError
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return SeverityWarning
+	}
+	return SeverityInfo
+}
+
+// statusResult maps a status to a result that compliance reports count as failed when it is not a success
+func statusResult(status int) string {
+	switch {
+	case status < 400:
+		return "success"
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return "deny"
+	}
+	return "failure"
+}
+
+type requestUserKey struct{}
+
+// requestUser is shared between the middleware and the handler, so authentication running after
+// the middleware is still recorded
+type requestUser struct {
+	mu        sync.Mutex
+	userID    string
+	sessionID string
+}
+
+func (u *requestUser) get() (string, string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.userID, u.sessionID
+}
+
+// WithRequestUser returns a context naming the authenticated user and session of a request. Within
+// a request audited by AuditMiddleware it also records them for the request's entry.
+func WithRequestUser(ctx context.Context, userID, sessionID string) context.Context {
+	if user, ok := ctx.Value(requestUserKey{}).(*requestUser); ok {
+		user.mu.Lock()
+		user.userID, user.sessionID = userID, sessionID
+		user.mu.Unlock()
+		return ctx
+	}
+	return context.WithValue(ctx, requestUserKey{}, &requestUser{userID: userID, sessionID: sessionID})
+}
+
+// RequestUserFromContext returns the user and session set by WithRequestUser
+func RequestUserFromContext(ctx context.Context) (userID, sessionID string) {
+	if user, ok := ctx.Value(requestUserKey{}).(*requestUser); ok {
+		return user.get()
+	}
+	return "", ""
+}
+
+// ParseTrustedProxies parses proxy addresses and CIDR blocks for WithTrustedProxies
+func ParseTrustedProxies(proxies ...string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// ClientIP returns the address of the client that sent r. Forwarding headers are only believed
+// when the connection comes from a trusted proxy: X-Forwarded-For is read from the right,
+// skipping trusted proxies, so a client cannot spoof its address by sending the header itself.
+func ClientIP(r *http.Request, trusted []*net.IPNet) string {
+	remote, err := normalizeIP(r.RemoteAddr)
+	if err != nil {
+		return ""
+	}
+	if !ipTrusted(remote, trusted) {
+		return remote
+	}
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := normalizeIP(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// Anything left of a malformed hop was written by an untrusted party
+			return remote
+		}
+		if !ipTrusted(hop, trusted) {
+			return hop
+		}
+		remote = hop
+	}
+	if len(hops) == 0 {
+		if realIP, err := normalizeIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil && realIP != "" {
+			return realIP
+		}
+	}
+	return remote
+}
+
+func ipTrusted(ip string, trusted []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range trusted {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// statusRecorder captures the status a handler writes
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush supports streaming handlers such as server-sent events
+func (r *statusRecorder) Flush() {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack supports WebSocket upgrades; a hijacked connection is recorded as 101 Switching Protocols
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil && r.status == 0 {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
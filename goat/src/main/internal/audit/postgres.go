@@ -0,0 +1,544 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PostgresAuditService stores audit data in the audit_logs, security_alerts and compliance_reports
+// tables. audit_logs is partitioned by month; Log creates the partition for an entry's month the
+// first time this process writes to it. Entries are hash-chained through the single audit_chain_head
+// row, which Log locks, so concurrent writers on every node append to one chain.
+type PostgresAuditService struct {
+	db *sql.DB
+
+	mu         sync.Mutex
+	partitions map[string]bool
+}
+
+// NewPostgresAuditService creates a new Postgres-backed audit service
+func NewPostgresAuditService(db *sql.DB) *PostgresAuditService {
+	return &PostgresAuditService{db: db, partitions: make(map[string]bool)}
+}
+
+// auditLogColumns selects an audit_logs row in the order scanAuditLog expects. actor_id holds the
+// user ID as logged; user_id only references users that exist.
+const auditLogColumns = `id::text, timestamp, event_type, severity, COALESCE(actor_id, ''), COALESCE(session_id, ''),
+	COALESCE(host(ip), ''), COALESCE(user_agent, ''), COALESCE(resource, ''), action, result, details,
+	COALESCE(error_msg, ''), geo_location, COALESCE(sequence, 0), COALESCE(prev_hash, ''), COALESCE(hash, ''),
+	COALESCE(personal_data_salt, ''), COALESCE(personal_data_digest, '')`
+
+// securityAlertColumns selects a security_alerts row in the order scanSecurityAlert expects
+const securityAlertColumns = `id::text, timestamp, alert_type, severity, COALESCE(user_id::text, ''), description,
+	COALESCE(actions, '{}'), resolved`
+
+// Log validates and stores an entry, filling in its ID, timestamp and severity, and appends it
+// to the hash chain
+func (s *PostgresAuditService) Log(ctx context.Context, log *AuditLog) error {
+	if err := prepareLog(log, time.Now()); err != nil {
+		return err
+	}
+	details, err := marshalJSONColumn(log.Details)
+	if err != nil {
+		return err
+	}
+	geo, err := marshalJSONColumn(log.GeoLocation)
+	if err != nil {
+		return err
+	}
+	if err := s.ensurePartition(ctx, log.Timestamp); err != nil {
+		return err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var head ChainHead
+	if err := tx.QueryRowContext(ctx, `SELECT sequence, hash FROM audit_chain_head FOR UPDATE`).Scan(&head.Sequence, &head.Hash); err != nil {
+		return fmt.Errorf("lock audit chain head: %w", err)
+	}
+	if err := sealLog(log, head); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO audit_logs (id, timestamp, event_type, severity, actor_id, user_id, session_id, ip, user_agent,
+			resource, action, result, details, error_msg, geo_location, sequence, prev_hash, hash,
+			personal_data_salt, personal_data_digest)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), (SELECT id FROM users WHERE id::text = $5), NULLIF($6, ''),
+			NULLIF($7, '')::inet, NULLIF($8, ''), NULLIF($9, ''), $10, $11, $12, NULLIF($13, ''), $14, $15, $16, $17,
+			$18, $19)`,
+		log.ID, log.Timestamp, string(log.EventType), string(log.Severity), log.UserID, log.SessionID, log.IP,
+		log.UserAgent, log.Resource, log.Action, log.Result, details, log.ErrorMsg, geo,
+		log.Sequence, log.PrevHash, log.Hash, log.PersonalDataSalt, log.PersonalDataDigest,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE audit_chain_head SET sequence = $1, hash = $2`, log.Sequence, log.Hash); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ensurePartition creates the monthly audit_logs partition holding ts unless this process already did
+func (s *PostgresAuditService) ensurePartition(ctx context.Context, ts time.Time) error {
+	month := time.Date(ts.Year(), ts.Month(), 1, 0, 0, 0, 0, time.UTC)
+	key := month.Format("2006_01")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.partitions[key] {
+		return nil
+	}
+	if _, err := s.db.ExecContext(ctx, `SELECT create_monthly_audit_partition($1::date)`, month.Format("2006-01-02")); err != nil {
+		return fmt.Errorf("create audit partition %s: %w", key, err)
+	}
+	s.partitions[key] = true
+	return nil
+}
+
+// Query returns logs matching every filter condition, newest first. IP may be an address or a
+// CIDR block. Time bounds let Postgres skip partitions outside the range.
+func (s *PostgresAuditService) Query(ctx context.Context, filter *AuditFilter) ([]*AuditLog, error) {
+	if filter == nil {
+		filter = &AuditFilter{}
+	}
+	if _, err := ipMatcher(filter.IP); err != nil {
+		return nil, err
+	}
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if filter.StartTime != nil {
+		conditions = append(conditions, "timestamp >= "+arg(*filter.StartTime))
+	}
+	if filter.EndTime != nil {
+		conditions = append(conditions, "timestamp <= "+arg(*filter.EndTime))
+	}
+	if filter.UserID != "" {
+		conditions = append(conditions, "actor_id = "+arg(filter.UserID))
+	}
+	if len(filter.EventTypes) > 0 {
+		types := make([]string, len(filter.EventTypes))
+		for i, eventType := range filter.EventTypes {
+			types[i] = string(eventType)
+		}
+		conditions = append(conditions, "event_type = ANY("+arg(formatTextArray(types))+"::text[])")
+	}
+	if len(filter.Severity) > 0 {
+		severities := make([]string, len(filter.Severity))
+		for i, severity := range filter.Severity {
+			severities[i] = string(severity)
+		}
+		conditions = append(conditions, "severity = ANY("+arg(formatTextArray(severities))+"::text[])")
+	}
+	if filter.IP != "" {
+		conditions = append(conditions, "ip <<= "+arg(filter.IP)+"::inet")
+	}
+	if filter.Resource != "" {
+		conditions = append(conditions, "resource = "+arg(filter.Resource))
+	}
+	where := "TRUE"
+	if len(conditions) > 0 {
+		where = strings.Join(conditions, " AND ")
+	}
+	limit, offset := queryPage(filter)
+	query := fmt.Sprintf(`SELECT %s FROM audit_logs WHERE %s ORDER BY timestamp DESC, id DESC LIMIT %s OFFSET %s`,
+		auditLogColumns, where, arg(limit), arg(offset))
+	return s.queryLogs(ctx, query, args...)
+}
+
+// GetUserLogs returns every log of a user, newest first
+func (s *PostgresAuditService) GetUserLogs(ctx context.Context, userID string) ([]*AuditLog, error) {
+	if userID == "" {
+		return nil, errors.New("user ID is required")
+	}
+	return s.queryLogs(ctx, `SELECT `+auditLogColumns+` FROM audit_logs WHERE actor_id = $1
+		ORDER BY timestamp DESC, id DESC`, userID)
+}
+
+func (s *PostgresAuditService) queryLogs(ctx context.Context, query string, args ...interface{}) ([]*AuditLog, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []*AuditLog{}
+	for rows.Next() {
+		log, err := scanAuditLog(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, log)
+	}
+	return result, rows.Err()
+}
+
+// ExportUserData collects a user's logs and alerts into a completed gdpr report, keeping the export
+// in the report's metadata; it is read back with GetUserDataExport
+func (s *PostgresAuditService) ExportUserData(ctx context.Context, userID string) (*ComplianceReport, error) {
+	logs, err := s.GetUserLogs(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	alerts, err := s.querySecurityAlerts(ctx, `SELECT `+securityAlertColumns+` FROM security_alerts
+		WHERE user_id::text = $1 ORDER BY timestamp DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	export := &UserDataExport{UserID: userID, GeneratedAt: now, AuditLogs: logs, SecurityAlerts: alerts}
+	report := &ComplianceReport{
+		ID:          newID(),
+		UserID:      userID,
+		RequestedAt: now,
+		CompletedAt: now,
+		Type:        ReportTypeGDPR,
+		Status:      ReportStatusCompleted,
+	}
+	if err := s.insertReport(ctx, report, export); err != nil {
+		return nil, err
+	}
+	return report, s.Log(ctx, dataExportLog(report))
+}
+
+// GetUserDataExport returns the data collected by ExportUserData
+func (s *PostgresAuditService) GetUserDataExport(ctx context.Context, reportID string) (*UserDataExport, error) {
+	var export UserDataExport
+	if err := s.reportMetadata(ctx, reportID, ReportTypeGDPR, &export); err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// DeleteUserData anonymizes a user's logs and alerts and deletes their reports and exports. Logs
+// are kept, without the user, session, IP, user agent and location, so the audit trail stays whole.
+func (s *PostgresAuditService) DeleteUserData(ctx context.Context, userID string) error {
+	if userID == "" {
+		return errors.New("user ID is required")
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	logs, err := tx.ExecContext(ctx, `
+		UPDATE audit_logs SET actor_id = NULL, user_id = NULL, session_id = NULL, ip = NULL, user_agent = NULL,
+			geo_location = NULL, personal_data_salt = NULL
+		WHERE actor_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE security_alerts SET user_id = NULL WHERE user_id::text = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE security_alerts SET resolved_by = NULL WHERE resolved_by::text = $1`, userID); err != nil {
+		return err
+	}
+	reports, err := tx.ExecContext(ctx, `DELETE FROM compliance_reports WHERE user_id::text = $1`, userID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	anonymized, _ := logs.RowsAffected()
+	deleted, _ := reports.RowsAffected()
+	return s.Log(ctx, dataDeletionLog(int(anonymized), int(deleted)))
+}
+
+// GenerateComplianceReport summarizes the last 30 days of logs and the open alerts into a
+// completed report, keeping the summary in the report's metadata; it is read back with GetReportSummary
+func (s *PostgresAuditService) GenerateComplianceReport(ctx context.Context, reportType string) (*ComplianceReport, error) {
+	if !validReportType(reportType) {
+		return nil, fmt.Errorf("unknown report type %q", reportType)
+	}
+	now := time.Now().UTC()
+	summary := newReportSummary(reportType, now.Add(-reportWindow), now)
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT event_type, severity, COUNT(*), COUNT(*) FILTER (WHERE result IN ('failure', 'deny'))
+		FROM audit_logs
+		WHERE timestamp >= $1 AND timestamp <= $2
+		GROUP BY event_type, severity`, summary.From, summary.To)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var (
+			eventType     EventType
+			severity      Severity
+			total, failed int
+		)
+		if err := rows.Scan(&eventType, &severity, &total, &failed); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		summary.add(eventType, severity, total, failed)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(DISTINCT actor_id) FROM audit_logs WHERE timestamp >= $1 AND timestamp <= $2`,
+		summary.From, summary.To).Scan(&summary.UniqueUsers); err != nil {
+		return nil, err
+	}
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM security_alerts WHERE NOT resolved`).Scan(&summary.OpenAlerts); err != nil {
+		return nil, err
+	}
+
+	report := &ComplianceReport{ID: newID(), RequestedAt: now, CompletedAt: now, Type: reportType, Status: ReportStatusCompleted}
+	if err := s.insertReport(ctx, report, summary); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// GetReportSummary returns the summary recorded by GenerateComplianceReport
+func (s *PostgresAuditService) GetReportSummary(ctx context.Context, reportID string) (*ReportSummary, error) {
+	var summary ReportSummary
+	if err := s.reportMetadata(ctx, reportID, "", &summary); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+func (s *PostgresAuditService) insertReport(ctx context.Context, report *ComplianceReport, metadata interface{}) error {
+	data, err := marshalJSONColumn(metadata)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO compliance_reports (id, user_id, requested_at, completed_at, report_type, status, data_export_url, metadata)
+		VALUES ($1, NULLIF($2, '')::uuid, $3, $4, $5, $6, NULLIF($7, ''), $8)`,
+		report.ID, report.UserID, report.RequestedAt, report.CompletedAt, report.Type, report.Status, report.DataExportURL, data,
+	)
+	return err
+}
+
+// reportMetadata decodes a report's metadata into v. A non-empty reportType must match the
+// report; an empty one accepts any report not belonging to a user.
+func (s *PostgresAuditService) reportMetadata(ctx context.Context, reportID, reportType string, v interface{}) error {
+	var (
+		actualType string
+		userID     string
+		metadata   []byte
+	)
+	err := s.db.QueryRowContext(ctx, `SELECT report_type, COALESCE(user_id::text, ''), metadata FROM compliance_reports WHERE id::text = $1`,
+		reportID).Scan(&actualType, &userID, &metadata)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && len(metadata) == 0) {
+		return fmt.Errorf("report %s: %w", reportID, ErrReportNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	if (reportType != "" && actualType != reportType) || (reportType == "" && userID != "") {
+		return fmt.Errorf("report %s: %w", reportID, ErrReportNotFound)
+	}
+	return json.Unmarshal(metadata, v)
+}
+
+// CreateSecurityAlert validates and stores an alert, filling in its ID, timestamp and severity
+func (s *PostgresAuditService) CreateSecurityAlert(ctx context.Context, alert *SecurityAlert) error {
+	if err := prepareAlert(alert, time.Now()); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO security_alerts (id, timestamp, alert_type, severity, user_id, description, actions, resolved)
+		VALUES ($1, $2, $3, $4, NULLIF($5, '')::uuid, $6, $7::text[], $8)`,
+		alert.ID, alert.Timestamp, alert.Type, string(alert.Severity), alert.UserID, alert.Description,
+		formatTextArray(alert.Actions), alert.Resolved,
+	)
+	return err
+}
+
+// GetSecurityAlerts returns unresolved alerts, newest first
+func (s *PostgresAuditService) GetSecurityAlerts(ctx context.Context) ([]*SecurityAlert, error) {
+	return s.querySecurityAlerts(ctx, `SELECT `+securityAlertColumns+` FROM security_alerts
+		WHERE NOT resolved ORDER BY timestamp DESC`)
+}
+
+func (s *PostgresAuditService) querySecurityAlerts(ctx context.Context, query string, args ...interface{}) ([]*SecurityAlert, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []*SecurityAlert{}
+	for rows.Next() {
+		var (
+			alert   SecurityAlert
+			actions string
+		)
+		if err := rows.Scan(&alert.ID, &alert.Timestamp, &alert.Type, &alert.Severity, &alert.UserID,
+			&alert.Description, &actions, &alert.Resolved); err != nil {
+			return nil, err
+		}
+		alert.Actions = parseTextArray(actions)
+		result = append(result, &alert)
+	}
+	return result, rows.Err()
+}
+
+// ResolveSecurityAlert marks an alert resolved; resolving it again keeps the first resolution time
+func (s *PostgresAuditService) ResolveSecurityAlert(ctx context.Context, alertID string) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE security_alerts SET resolved = TRUE, resolved_at = COALESCE(resolved_at, NOW())
+		WHERE id::text = $1`, alertID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("alert %s: %w", alertID, ErrAlertNotFound)
+	}
+	return nil
+}
+
+// ChainHead returns the newest entry's sequence and hash
+func (s *PostgresAuditService) ChainHead(ctx context.Context) (*ChainHead, error) {
+	var head ChainHead
+	if err := s.db.QueryRowContext(ctx, `SELECT sequence, hash FROM audit_chain_head`).Scan(&head.Sequence, &head.Hash); err != nil {
+		return nil, err
+	}
+	return &head, nil
+}
+
+// ChainEntries returns the entries with sequences in [from, to], in sequence order
+func (s *PostgresAuditService) ChainEntries(ctx context.Context, from, to int64) ([]*AuditLog, error) {
+	return s.queryLogs(ctx, `SELECT `+auditLogColumns+` FROM audit_logs WHERE sequence BETWEEN $1 AND $2 ORDER BY sequence`, from, to)
+}
+
+// SaveCheckpoint stores a checkpoint; one for a sequence and key ID already saved is ignored
+func (s *PostgresAuditService) SaveCheckpoint(ctx context.Context, checkpoint *Checkpoint) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO audit_checkpoints (sequence, hash, issued_at, key_id, signature)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (sequence, key_id) DO NOTHING`,
+		checkpoint.Sequence, checkpoint.Hash, checkpoint.IssuedAt, checkpoint.KeyID, checkpoint.Signature,
+	)
+	return err
+}
+
+// Checkpoints returns the checkpoints with sequences in [from, to], in sequence order
+func (s *PostgresAuditService) Checkpoints(ctx context.Context, from, to int64) ([]*Checkpoint, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT sequence, hash, issued_at, key_id, signature FROM audit_checkpoints
+		WHERE sequence BETWEEN $1 AND $2 ORDER BY sequence, key_id`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []*Checkpoint{}
+	for rows.Next() {
+		var checkpoint Checkpoint
+		if err := rows.Scan(&checkpoint.Sequence, &checkpoint.Hash, &checkpoint.IssuedAt, &checkpoint.KeyID, &checkpoint.Signature); err != nil {
+			return nil, err
+		}
+		result = append(result, &checkpoint)
+	}
+	return result, rows.Err()
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAuditLog(row rowScanner) (*AuditLog, error) {
+	var (
+		log     AuditLog
+		details []byte
+		geo     []byte
+	)
+	if err := row.Scan(&log.ID, &log.Timestamp, &log.EventType, &log.Severity, &log.UserID, &log.SessionID,
+		&log.IP, &log.UserAgent, &log.Resource, &log.Action, &log.Result, &details, &log.ErrorMsg, &geo,
+		&log.Sequence, &log.PrevHash, &log.Hash, &log.PersonalDataSalt, &log.PersonalDataDigest); err != nil {
+		return nil, err
+	}
+	if len(details) > 0 {
+		if err := json.Unmarshal(details, &log.Details); err != nil {
+			return nil, err
+		}
+	}
+	if len(geo) > 0 {
+		if err := json.Unmarshal(geo, &log.GeoLocation); err != nil {
+			return nil, err
+		}
+	}
+	return &log, nil
+}
+
+// marshalJSONColumn encodes a value for a nullable JSONB column, mapping empty values to NULL
+func marshalJSONColumn(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if string(data) == "null" {
+		return nil, nil
+	}
+	return data, nil
+}
+
+// formatTextArray encodes a string slice as a Postgres TEXT[] literal
+func formatTextArray(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		value = strings.ReplaceAll(value, `\`, `\\`)
+		value = strings.ReplaceAll(value, `"`, `\"`)
+		quoted[i] = `"` + value + `"`
+	}
+	return "{" + strings.Join(quoted, ",") + "}"
+}
+
+// parseTextArray decodes a Postgres TEXT[] literal into a string slice
+func parseTextArray(literal string) []string {
+	literal = strings.TrimSpace(literal)
+	if len(literal) < 2 || literal[0] != '{' || literal[len(literal)-1] != '}' {
+		return nil
+	}
+	body := literal[1 : len(literal)-1]
+	if body == "" {
+		return []string{}
+	}
+
+	var (
+		values  []string
+		current strings.Builder
+		quoted  bool
+		escaped bool
+	)
+	for _, r := range body {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			values = append(values, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	return append(values, current.String())
+}
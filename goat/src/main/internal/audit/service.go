@@ -0,0 +1,275 @@
+package audit
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 1000
+	// reportWindow is the period a generated compliance report summarizes
+	reportWindow = 30 * 24 * time.Hour
+)
+
+var (
+	// ErrAlertNotFound is returned when a security alert does not exist
+	ErrAlertNotFound = errors.New("security alert not found")
+	// ErrReportNotFound is returned when a compliance report does not exist
+	ErrReportNotFound = errors.New("compliance report not found")
+	// ErrInvalidAuditLog is returned when an audit log entry cannot be stored
+	ErrInvalidAuditLog = errors.New("invalid audit log")
+)
+
+// Report types accepted by GenerateComplianceReport, matching the compliance_reports table
+const (
+	ReportTypeGDPR     = "gdpr"
+	ReportTypeCCPA     = "ccpa"
+	ReportTypeAudit    = "audit"
+	ReportTypeSecurity = "security"
+)
+
+// Report statuses
+const (
+	ReportStatusPending    = "pending"
+	ReportStatusProcessing = "processing"
+	ReportStatusCompleted  = "completed"
+	ReportStatusFailed     = "failed"
+)
+
+// UserDataExport is the data ExportUserData collects about a user
+type UserDataExport struct {
+	UserID         string           `json:"user_id"`
+	GeneratedAt    time.Time        `json:"generated_at"`
+	AuditLogs      []*AuditLog      `json:"audit_logs"`
+	SecurityAlerts []*SecurityAlert `json:"security_alerts"`
+}
+
+// ReportSummary is what GenerateComplianceReport records about the report window
+type ReportSummary struct {
+	Type            string           `json:"type"`
+	From            time.Time        `json:"from"`
+	To              time.Time        `json:"to"`
+	TotalLogs       int              `json:"total_logs"`
+	LogsByEventType map[string]int   `json:"logs_by_event_type"`
+	LogsBySeverity  map[Severity]int `json:"logs_by_severity"`
+	FailedResults   int              `json:"failed_results"`
+	OpenAlerts      int              `json:"open_alerts"`
+	UniqueUsers     int              `json:"unique_users"`
+	DataExports     int              `json:"data_exports"`
+	DataDeletions   int              `json:"data_deletions"`
+}
+
+// prepareLog validates an entry and fills in its ID, timestamp and severity. An IP that does not
+// parse is moved to Details["raw_ip"].
+func prepareLog(log *AuditLog, now time.Time) error {
+	if log == nil {
+		return errors.New("audit log cannot be nil")
+	}
+	if log.EventType == "" {
+		return fmt.Errorf("%w: event type is required", ErrInvalidAuditLog)
+	}
+	if log.Action == "" {
+		return fmt.Errorf("%w: action is required", ErrInvalidAuditLog)
+	}
+	if log.Severity == "" {
+		log.Severity = SeverityInfo
+	}
+	if !validSeverity(log.Severity) {
+		return fmt.Errorf("%w: unknown severity %q", ErrInvalidAuditLog, log.Severity)
+	}
+	// An address that does not parse is kept in the details rather than losing the entry
+	ip, err := normalizeIP(log.IP)
+	if err != nil {
+		details := make(map[string]interface{}, len(log.Details)+1)
+		for k, v := range log.Details {
+			details[k] = v
+		}
+		details["raw_ip"] = log.IP
+		log.Details = details
+	}
+	log.IP = ip
+	if log.ID == "" {
+		log.ID = newID()
+	}
+	if log.Timestamp.IsZero() {
+		log.Timestamp = now
+	}
+	// Postgres keeps microseconds; truncating here keeps hashes valid after a round trip
+	log.Timestamp = log.Timestamp.UTC().Truncate(time.Microsecond)
+	return nil
+}
+
+// prepareAlert validates an alert and fills in its ID and timestamp
+func prepareAlert(alert *SecurityAlert, now time.Time) error {
+	if alert == nil {
+		return errors.New("security alert cannot be nil")
+	}
+	if alert.Type == "" {
+		return errors.New("alert type is required")
+	}
+	if alert.Description == "" {
+		return errors.New("alert description is required")
+	}
+	if alert.Severity == "" {
+		alert.Severity = SeverityWarning
+	}
+	if !validSeverity(alert.Severity) {
+		return fmt.Errorf("unknown severity %q", alert.Severity)
+	}
+	if alert.ID == "" {
+		alert.ID = newID()
+	}
+	if alert.Timestamp.IsZero() {
+		alert.Timestamp = now
+	}
+	alert.Timestamp = alert.Timestamp.UTC()
+	return nil
+}
+
+func validSeverity(severity Severity) bool {
+	switch severity {
+	case SeverityInfo, SeverityWarning, SeverityError, SeverityCritical:
+		return true
+	}
+	return false
+}
+
+func validReportType(reportType string) bool {
+	switch reportType {
+	case ReportTypeGDPR, ReportTypeCCPA, ReportTypeAudit, ReportTypeSecurity:
+		return true
+	}
+	return false
+}
+
+// normalizeIP returns the canonical form of an address as stored in an INET column. A port,
+// as in a request's RemoteAddr, is dropped.
+func normalizeIP(ip string) (string, error) {
+	if ip == "" {
+		return "", nil
+	}
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	parsed := net.ParseIP(strings.Trim(ip, "[]"))
+	if parsed == nil {
+		return "", fmt.Errorf("invalid IP address %q", ip)
+	}
+	return parsed.String(), nil
+}
+
+// ipMatcher returns a predicate for AuditFilter.IP, which is an address or a CIDR block
+func ipMatcher(filter string) (func(ip string) bool, error) {
+	if filter == "" {
+		return func(string) bool { return true }, nil
+	}
+	if _, block, err := net.ParseCIDR(filter); err == nil {
+		return func(ip string) bool {
+			parsed := net.ParseIP(ip)
+			return parsed != nil && block.Contains(parsed)
+		}, nil
+	}
+	want, err := normalizeIP(filter)
+	if err != nil {
+		return nil, err
+	}
+	return func(ip string) bool { return ip == want }, nil
+}
+
+// queryPage returns the limit and offset of a filter, applying the default and maximum limit
+func queryPage(filter *AuditFilter) (int, int) {
+	limit, offset := defaultQueryLimit, 0
+	if filter != nil {
+		if filter.Limit > 0 {
+			limit = filter.Limit
+		}
+		if limit > maxQueryLimit {
+			limit = maxQueryLimit
+		}
+		if filter.Offset > 0 {
+			offset = filter.Offset
+		}
+	}
+	return limit, offset
+}
+
+func newReportSummary(reportType string, from, to time.Time) *ReportSummary {
+	return &ReportSummary{
+		Type:            reportType,
+		From:            from,
+		To:              to,
+		LogsByEventType: make(map[string]int),
+		LogsBySeverity:  make(map[Severity]int),
+	}
+}
+
+// add counts total logs of one event type and severity, failed of which did not succeed
+func (s *ReportSummary) add(eventType EventType, severity Severity, total, failed int) {
+	s.TotalLogs += total
+	s.FailedResults += failed
+	s.LogsByEventType[string(eventType)] += total
+	s.LogsBySeverity[severity] += total
+	switch eventType {
+	case EventTypeDataExport:
+		s.DataExports += total
+	case EventTypeDataDeletion:
+		s.DataDeletions += total
+	}
+}
+
+// failedResult reports whether a log's result counts as a failure in reports
+func failedResult(result string) bool {
+	return result == "failure" || result == "deny"
+}
+
+func copyLog(log *AuditLog) *AuditLog {
+	out := *log
+	if log.Details != nil {
+		out.Details = copyValue(log.Details).(map[string]interface{})
+	}
+	if log.GeoLocation != nil {
+		geo := *log.GeoLocation
+		out.GeoLocation = &geo
+	}
+	return &out
+}
+
+func copyAlert(alert *SecurityAlert) *SecurityAlert {
+	out := *alert
+	out.Actions = append([]string(nil), alert.Actions...)
+	return &out
+}
+
+func copyValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			out[k] = copyValue(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(value))
+		for i, item := range value {
+			out[i] = copyValue(item)
+		}
+		return out
+	}
+	return v
+}
+
+// newID returns a random RFC 4122 version 4 UUID, matching the UUID primary keys of the audit tables
+func newID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("audit: failed to read random bytes: %v", err))
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
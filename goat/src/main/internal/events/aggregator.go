@@ -0,0 +1,564 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WindowMode selects how the aggregator slices time into windows
+type WindowMode string
+
+const (
+	// WindowTumbling aggregates over fixed, non-overlapping windows aligned to the window size
+	WindowTumbling WindowMode = "tumbling"
+	// WindowSliding aggregates over the trailing window ending at the current bucket
+	WindowSliding WindowMode = "sliding"
+)
+
+// ErrUnsupportedFilter is returned when a filter field cannot be answered from aggregated counters
+var ErrUnsupportedFilter = errors.New("filter field not supported by aggregator")
+
+// AggregatorConfig configures a WindowAggregator
+type AggregatorConfig struct {
+	// Mode selects tumbling or sliding windows for Aggregate
+	Mode WindowMode
+	// Window is the rollup window persisted by the background job
+	Window time.Duration
+	// Bucket is the counter resolution; sliding windows advance in Bucket steps
+	Bucket time.Duration
+	// Retention bounds how long buckets are kept in memory
+	Retention time.Duration
+	// TopN limits the top event types and users reported by GetStatistics
+	TopN int
+	// Now overrides the time source, mainly for tests
+	Now func() time.Time
+}
+
+// DefaultAggregatorConfig returns a one-minute tumbling configuration with ten-second buckets
+func DefaultAggregatorConfig() AggregatorConfig {
+	return AggregatorConfig{
+		Mode:      WindowTumbling,
+		Window:    time.Minute,
+		Bucket:    10 * time.Second,
+		Retention: time.Hour,
+		TopN:      10,
+	}
+}
+
+// AggregationStore persists rolled-up windows for long-range dashboards
+type AggregationStore interface {
+	// SaveAggregation stores a completed window, replacing any stored window with the same
+	// size and start
+	SaveAggregation(ctx context.Context, agg *AggregatedEvents) error
+
+	// ListAggregations returns stored windows overlapping the given range, oldest first
+	ListAggregations(ctx context.Context, start, end time.Time) ([]*AggregatedEvents, error)
+}
+
+// cubeKey identifies one combination of dimensions counted in a bucket
+type cubeKey struct {
+	eventType EventType
+	priority  Priority
+	userID    string
+}
+
+type cubeCounts struct {
+	total  int64
+	errors int64
+}
+
+type aggregationBucket struct {
+	start time.Time
+	cube  map[cubeKey]*cubeCounts
+}
+
+// WindowAggregator implements EventAggregator with in-memory streaming counters.
+// Events are fed through Handle, typically by subscribing it to an EventBus.
+type WindowAggregator struct {
+	config     AggregatorConfig
+	mu         sync.RWMutex
+	buckets    map[int64]*aggregationBucket
+	lastRolled time.Time
+	// late holds the starts of flushed windows that have since counted late events
+	late map[int64]time.Time
+	// flushMu serializes FlushCompleted, e.g. a manual flush racing the rollup ticker
+	flushMu sync.Mutex
+}
+
+// NewWindowAggregator creates a new window aggregator, filling unset config fields with defaults
+func NewWindowAggregator(config AggregatorConfig) *WindowAggregator {
+	defaults := DefaultAggregatorConfig()
+	if config.Mode == "" {
+		config.Mode = defaults.Mode
+	}
+	if config.Window <= 0 {
+		config.Window = defaults.Window
+	}
+	if config.Bucket <= 0 || config.Bucket > config.Window {
+		config.Bucket = minDuration(defaults.Bucket, config.Window)
+	}
+	if config.Retention < config.Window {
+		config.Retention = maxDuration(defaults.Retention, config.Window)
+	}
+	if config.TopN <= 0 {
+		config.TopN = defaults.TopN
+	}
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+	return &WindowAggregator{
+		config:  config,
+		buckets: make(map[int64]*aggregationBucket),
+		late:    make(map[int64]time.Time),
+	}
+}
+
+// Attach subscribes the aggregator to every event published on the bus
+func (a *WindowAggregator) Attach(ctx context.Context, bus EventBus) (string, error) {
+	if bus == nil {
+		return "", errors.New("event bus cannot be nil")
+	}
+	return bus.Subscribe(ctx, nil, a.Handle)
+}
+
+// Handle records an event; it satisfies EventHandler
+func (a *WindowAggregator) Handle(ctx context.Context, event *Event) error {
+	if event == nil {
+		return errors.New("event cannot be nil")
+	}
+	now := a.config.Now()
+	ts := event.Timestamp
+	if ts.IsZero() {
+		ts = now
+	}
+	if ts.Before(now.Add(-a.config.Retention)) {
+		// Too late to count; the window it belongs to has already been evicted.
+		return nil
+	}
+
+	start := ts.Truncate(a.config.Bucket)
+	key := cubeKey{eventType: event.Type, priority: event.Priority, userID: event.UserID}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	bucket, ok := a.buckets[start.UnixNano()]
+	if !ok {
+		bucket = &aggregationBucket{start: start, cube: make(map[cubeKey]*cubeCounts)}
+		a.buckets[start.UnixNano()] = bucket
+		a.evictLocked(now)
+	}
+	counts, ok := bucket.cube[key]
+	if !ok {
+		counts = &cubeCounts{}
+		bucket.cube[key] = counts
+	}
+	counts.total++
+	if isErrorEvent(event) {
+		counts.errors++
+	}
+	if window := ts.Truncate(a.config.Window); window.Before(a.lastRolled) {
+		a.late[window.UnixNano()] = window
+	}
+	return nil
+}
+
+// Aggregate aggregates events over a time window. Tumbling mode reports the aligned window
+// containing the current time; sliding mode reports the trailing window ending at the current bucket.
+func (a *WindowAggregator) Aggregate(ctx context.Context, window time.Duration) (*AggregatedEvents, error) {
+	if window <= 0 {
+		window = a.config.Window
+	}
+	if window > a.config.Retention {
+		return nil, fmt.Errorf("window %s exceeds aggregator retention %s", window, a.config.Retention)
+	}
+
+	now := a.config.Now()
+	var start, end time.Time
+	switch a.config.Mode {
+	case WindowSliding:
+		end = now.Truncate(a.config.Bucket).Add(a.config.Bucket)
+		start = end.Add(-window)
+	default:
+		start = now.Truncate(window)
+		end = start.Add(window)
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.aggregateLocked(start, end, window), nil
+}
+
+// GetStatistics gets event statistics for the retained range narrowed by the filter.
+// Only Types, Priority, UserID, StartTime and EndTime can be answered from counters.
+func (a *WindowAggregator) GetStatistics(ctx context.Context, filter *EventFilter) (*EventStatistics, error) {
+	now := a.config.Now()
+	start := now.Add(-a.config.Retention).Truncate(a.config.Bucket)
+	end := now.Truncate(a.config.Bucket).Add(a.config.Bucket)
+
+	match := func(cubeKey) bool { return true }
+	if filter != nil {
+		if filter.TenantID != "" || filter.SessionID != "" || filter.Resource != "" || len(filter.Metadata) > 0 || filter.Query != "" {
+			return nil, ErrUnsupportedFilter
+		}
+		if filter.StartTime != nil && filter.StartTime.After(start) {
+			start = filter.StartTime.Truncate(a.config.Bucket)
+		}
+		if filter.EndTime != nil && filter.EndTime.Before(end) {
+			end = *filter.EndTime
+		}
+		match = func(key cubeKey) bool {
+			if len(filter.Types) > 0 && !containsEventType(filter.Types, key.eventType) {
+				return false
+			}
+			if len(filter.Priority) > 0 && !containsPriority(filter.Priority, key.priority) {
+				return false
+			}
+			if filter.UserID != "" && filter.UserID != key.userID {
+				return false
+			}
+			return true
+		}
+	}
+
+	stats := &EventStatistics{}
+	if !end.After(start) {
+		return stats, nil
+	}
+
+	byType := make(map[EventType]int64)
+	byUser := make(map[string]int64)
+	var errorsSeen int64
+
+	a.mu.RLock()
+	for _, bucket := range a.buckets {
+		if bucket.start.Before(start) || !bucket.start.Before(end) {
+			continue
+		}
+		for key, counts := range bucket.cube {
+			if !match(key) {
+				continue
+			}
+			stats.TotalEvents += counts.total
+			errorsSeen += counts.errors
+			byType[key.eventType] += counts.total
+			if key.userID != "" {
+				byUser[key.userID] += counts.total
+			}
+		}
+	}
+	a.mu.RUnlock()
+
+	stats.UniqueUsers = int64(len(byUser))
+	stats.EventsPerSecond = float64(stats.TotalEvents) / end.Sub(start).Seconds()
+	if stats.TotalEvents > 0 {
+		stats.ErrorRate = float64(errorsSeen) / float64(stats.TotalEvents)
+	}
+	stats.TopEventTypes = topEventTypes(byType, a.config.TopN)
+	stats.TopUsers = topUsers(byUser, a.config.TopN)
+	return stats, nil
+}
+
+// FlushCompleted persists every tumbling window that has fully elapsed since the last flush, and
+// saves again the flushed windows that have counted late events since. Flushes run one at a time.
+func (a *WindowAggregator) FlushCompleted(ctx context.Context, store AggregationStore) error {
+	if store == nil {
+		return errors.New("aggregation store cannot be nil")
+	}
+	a.flushMu.Lock()
+	defer a.flushMu.Unlock()
+
+	window := a.config.Window
+	now := a.config.Now()
+	current := now.Truncate(window)
+
+	a.mu.Lock()
+	// Late windows are taken out of the set now, so events arriving during the save mark them again.
+	var late []*AggregatedEvents
+	for key, start := range a.late {
+		delete(a.late, key)
+		// A window whose oldest buckets were evicted would be saved with too low a count.
+		if start.Before(now.Add(-a.config.Retention)) {
+			continue
+		}
+		late = append(late, a.aggregateLocked(start, start.Add(window), window))
+	}
+	next := a.lastRolled
+	if next.IsZero() {
+		next = a.oldestBucketLocked().Truncate(window)
+	}
+	var pending []*AggregatedEvents
+	for !next.IsZero() && next.Before(current) {
+		pending = append(pending, a.aggregateLocked(next, next.Add(window), window))
+		next = next.Add(window)
+	}
+	a.mu.Unlock()
+
+	sort.Slice(late, func(i, j int) bool { return late[i].StartTime.Before(late[j].StartTime) })
+	for i, agg := range late {
+		if err := store.SaveAggregation(ctx, agg); err != nil {
+			a.mu.Lock()
+			for _, unsaved := range late[i:] {
+				a.late[unsaved.StartTime.UnixNano()] = unsaved.StartTime
+			}
+			a.mu.Unlock()
+			return fmt.Errorf("failed to save aggregation for %s: %w", agg.StartTime.Format(time.RFC3339), err)
+		}
+	}
+	for _, agg := range pending {
+		if err := store.SaveAggregation(ctx, agg); err != nil {
+			return fmt.Errorf("failed to save aggregation for %s: %w", agg.StartTime.Format(time.RFC3339), err)
+		}
+		a.mu.Lock()
+		a.lastRolled = agg.EndTime
+		a.mu.Unlock()
+	}
+	return nil
+}
+
+// StartRollups runs FlushCompleted every window until the context is cancelled.
+// Flush errors are passed to onError when provided and otherwise retried on the next tick.
+func (a *WindowAggregator) StartRollups(ctx context.Context, store AggregationStore, onError func(error)) {
+	ticker := time.NewTicker(a.config.Window)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := a.FlushCompleted(ctx, store); err != nil && onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+}
+
+func (a *WindowAggregator) aggregateLocked(start, end time.Time, window time.Duration) *AggregatedEvents {
+	agg := &AggregatedEvents{
+		Window:     window,
+		StartTime:  start,
+		EndTime:    end,
+		ByType:     make(map[EventType]int64),
+		ByUser:     make(map[string]int64),
+		ByPriority: make(map[Priority]int64),
+	}
+	for ts := start.Truncate(a.config.Bucket); ts.Before(end); ts = ts.Add(a.config.Bucket) {
+		trend := Trend{Timestamp: ts}
+		if bucket, ok := a.buckets[ts.UnixNano()]; ok {
+			for key, counts := range bucket.cube {
+				trend.Count += counts.total
+				agg.Errors += counts.errors
+				agg.ByType[key.eventType] += counts.total
+				if key.priority != "" {
+					agg.ByPriority[key.priority] += counts.total
+				}
+				if key.userID != "" {
+					agg.ByUser[key.userID] += counts.total
+				}
+			}
+		}
+		agg.Total += trend.Count
+		agg.Trends = append(agg.Trends, trend)
+	}
+	return agg
+}
+
+func (a *WindowAggregator) oldestBucketLocked() time.Time {
+	var oldest time.Time
+	for _, bucket := range a.buckets {
+		if oldest.IsZero() || bucket.start.Before(oldest) {
+			oldest = bucket.start
+		}
+	}
+	return oldest
+}
+
+func (a *WindowAggregator) evictLocked(now time.Time) {
+	cutoff := now.Add(-a.config.Retention)
+	for key, bucket := range a.buckets {
+		if bucket.start.Add(a.config.Bucket).Before(cutoff) {
+			delete(a.buckets, key)
+		}
+	}
+	if a.lastRolled.Before(cutoff) && !a.lastRolled.IsZero() {
+		a.lastRolled = cutoff.Truncate(a.config.Window)
+	}
+	for key, start := range a.late {
+		if start.Before(cutoff) {
+			delete(a.late, key)
+		}
+	}
+}
+
+// isErrorEvent reports whether an event represents a failed operation
+func isErrorEvent(event *Event) bool {
+	switch strings.ToLower(event.Result) {
+	case "failure", "failed", "error", "denied":
+		return true
+	}
+	return strings.HasSuffix(string(event.Type), ".failed")
+}
+
+func topEventTypes(counts map[EventType]int64, n int) []EventTypeCount {
+	result := make([]EventTypeCount, 0, len(counts))
+	for eventType, count := range counts {
+		result = append(result, EventTypeCount{Type: eventType, Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Type < result[j].Type
+	})
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+func topUsers(counts map[string]int64, n int) []UserEventCount {
+	result := make([]UserEventCount, 0, len(counts))
+	for userID, count := range counts {
+		result = append(result, UserEventCount{UserID: userID, Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].UserID < result[j].UserID
+	})
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+func containsEventType(types []EventType, eventType EventType) bool {
+	for _, t := range types {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func containsPriority(priorities []Priority, priority Priority) bool {
+	for _, p := range priorities {
+		if p == priority {
+			return true
+		}
+	}
+	return false
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// PostgresAggregationStore persists rollups to the event_aggregations table
+type PostgresAggregationStore struct {
+	db *sql.DB
+}
+
+// NewPostgresAggregationStore creates a new Postgres-backed aggregation store
+func NewPostgresAggregationStore(db *sql.DB) *PostgresAggregationStore {
+	return &PostgresAggregationStore{db: db}
+}
+
+// SaveAggregation upserts a completed window into event_aggregations, so saving a window again,
+// e.g. after late events, replaces its counts
+func (s *PostgresAggregationStore) SaveAggregation(ctx context.Context, agg *AggregatedEvents) error {
+	if agg == nil {
+		return errors.New("aggregation cannot be nil")
+	}
+	byType, err := json.Marshal(agg.ByType)
+	if err != nil {
+		return err
+	}
+	byUser, err := json.Marshal(agg.ByUser)
+	if err != nil {
+		return err
+	}
+	byPriority, err := json.Marshal(agg.ByPriority)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO event_aggregations (
+			aggregation_window, window_start, window_end, total_events,
+			events_by_type, events_by_user, events_by_priority, error_count
+		) VALUES ($1::interval, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (aggregation_window, window_start) DO UPDATE SET
+			window_end = EXCLUDED.window_end,
+			total_events = EXCLUDED.total_events,
+			events_by_type = EXCLUDED.events_by_type,
+			events_by_user = EXCLUDED.events_by_user,
+			events_by_priority = EXCLUDED.events_by_priority,
+			error_count = EXCLUDED.error_count`,
+		fmt.Sprintf("%d milliseconds", agg.Window.Milliseconds()),
+		agg.StartTime, agg.EndTime, agg.Total,
+		byType, byUser, byPriority, agg.Errors,
+	)
+	return err
+}
+
+// ListAggregations returns stored windows overlapping [start, end), oldest first
+func (s *PostgresAggregationStore) ListAggregations(ctx context.Context, start, end time.Time) ([]*AggregatedEvents, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT EXTRACT(EPOCH FROM aggregation_window) * 1000, window_start, window_end, total_events,
+			events_by_type, events_by_user, events_by_priority, COALESCE(error_count, 0)
+		FROM event_aggregations
+		WHERE window_end > $1 AND window_start < $2
+		ORDER BY window_start ASC`,
+		start, end,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*AggregatedEvents
+	for rows.Next() {
+		var (
+			windowMillis               float64
+			byType, byUser, byPriority []byte
+			agg                        AggregatedEvents
+		)
+		if err := rows.Scan(&windowMillis, &agg.StartTime, &agg.EndTime, &agg.Total,
+			&byType, &byUser, &byPriority, &agg.Errors); err != nil {
+			return nil, err
+		}
+		agg.Window = time.Duration(windowMillis) * time.Millisecond
+		if err := unmarshalJSONColumn(byType, &agg.ByType); err != nil {
+			return nil, err
+		}
+		if err := unmarshalJSONColumn(byUser, &agg.ByUser); err != nil {
+			return nil, err
+		}
+		if err := unmarshalJSONColumn(byPriority, &agg.ByPriority); err != nil {
+			return nil, err
+		}
+		result = append(result, &agg)
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,498 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// PayloadFormat selects how events are encoded for a webhook or subscription
+type PayloadFormat string
+
+const (
+	// PayloadFormatNative sends the GOAT Event JSON; it is the default
+	PayloadFormatNative PayloadFormat = "native"
+	// PayloadFormatCloudEvents sends a CloudEvents 1.0 structured-mode JSON body
+	PayloadFormatCloudEvents PayloadFormat = "cloudevents"
+	// PayloadFormatCloudEventsBinary sends the event data as the body and the attributes as ce-* headers
+	PayloadFormatCloudEventsBinary PayloadFormat = "cloudevents-binary"
+	// PayloadFormatCloudEventsBatch hands out the messages or records a queue or stream consumer
+	// reads as one JSON array of structured-mode CloudEvents
+	PayloadFormatCloudEventsBatch PayloadFormat = "cloudevents-batch"
+)
+
+// Valid reports whether f is a known format; the empty format means native
+func (f PayloadFormat) Valid() bool {
+	switch f {
+	case "", PayloadFormatNative, PayloadFormatCloudEvents, PayloadFormatCloudEventsBinary, PayloadFormatCloudEventsBatch:
+		return true
+	}
+	return false
+}
+
+// errBatchFormatPerEvent rejects the batch format where each request carries a single event
+var errBatchFormatPerEvent = errors.New("payload format cloudevents-batch is only supported for queue and stream subscriptions")
+
+const (
+	// CloudEventsSpecVersion is the CloudEvents version GOAT produces and accepts
+	CloudEventsSpecVersion = "1.0"
+	// CloudEventsContentType is the media type of a structured-mode CloudEvent
+	CloudEventsContentType = "application/cloudevents+json"
+	// CloudEventsBatchContentType is the media type of a CloudEvents batch
+	CloudEventsBatchContentType = "application/cloudevents-batch+json"
+	// DefaultCloudEventSource is the source attribute of outgoing CloudEvents
+	DefaultCloudEventSource = "/goat"
+
+	cloudEventHeaderPrefix = "Ce-"
+)
+
+// ErrNotCloudEvent is returned by ParseCloudEvents for requests that are not CloudEvents
+var ErrNotCloudEvent = errors.New("request is not a CloudEvent")
+
+// Extension attributes that carry Event fields without a CloudEvents counterpart
+const (
+	cloudEventExtPriority  = "priority"
+	cloudEventExtUserID    = "userid"
+	cloudEventExtSessionID = "sessionid"
+	cloudEventExtAction    = "action"
+	cloudEventExtResult    = "result"
+
+	// Extensions that tell a consumer how to ack or commit an element of a batch
+	cloudEventExtReceipt  = "receipt"
+	cloudEventExtAttempts = "attempts"
+	cloudEventExtOffset   = "offset"
+)
+
+// CloudEvent is a CloudEvents 1.0 event. Extension attributes are flattened
+// into the top-level JSON object alongside the context attributes.
+type CloudEvent struct {
+	SpecVersion     string
+	ID              string
+	Source          string
+	Type            string
+	Subject         string
+	Time            time.Time
+	DataContentType string
+	DataSchema      string
+	Data            json.RawMessage
+	Extensions      map[string]interface{}
+}
+
+var cloudEventAttributes = map[string]bool{
+	"specversion": true, "id": true, "source": true, "type": true, "subject": true, "time": true,
+	"datacontenttype": true, "dataschema": true, "data": true, "data_base64": true,
+}
+
+// NewCloudEvent maps an event to a CloudEvent. Type, ID and Timestamp become type, id and time,
+// Resource becomes subject, and UserID, SessionID, Priority, Action and Result become extensions.
+func NewCloudEvent(event *Event, source string) (*CloudEvent, error) {
+	if source == "" {
+		source = DefaultCloudEventSource
+	}
+	ce := &CloudEvent{
+		SpecVersion: CloudEventsSpecVersion,
+		ID:          event.ID,
+		Source:      source,
+		Type:        string(event.Type),
+		Subject:     event.Resource,
+		Time:        event.Timestamp,
+		Extensions:  make(map[string]interface{}),
+	}
+	if event.Data != nil {
+		data, err := json.Marshal(event.Data)
+		if err != nil {
+			return nil, err
+		}
+		ce.Data = data
+		ce.DataContentType = "application/json"
+	}
+	for name, value := range map[string]string{
+		cloudEventExtPriority:  string(event.Priority),
+		cloudEventExtUserID:    event.UserID,
+		cloudEventExtSessionID: event.SessionID,
+		cloudEventExtAction:    event.Action,
+		cloudEventExtResult:    event.Result,
+	} {
+		if value != "" {
+			ce.Extensions[name] = value
+		}
+	}
+	return ce, nil
+}
+
+// Validate checks the required context attributes
+func (ce *CloudEvent) Validate() error {
+	if ce.SpecVersion != CloudEventsSpecVersion {
+		return fmt.Errorf("unsupported cloudevents specversion %q", ce.SpecVersion)
+	}
+	if ce.ID == "" || ce.Source == "" || ce.Type == "" {
+		return errors.New("cloudevent requires id, source and type")
+	}
+	for name := range ce.Extensions {
+		if !validExtensionName(name) {
+			return fmt.Errorf("invalid cloudevent extension name %q", name)
+		}
+	}
+	return nil
+}
+
+// ToEvent maps a CloudEvent back to an Event. JSON object data becomes Data, the source
+// and unknown extensions are kept in Metadata.
+func (ce *CloudEvent) ToEvent() (*Event, error) {
+	if err := ce.Validate(); err != nil {
+		return nil, err
+	}
+	event := &Event{
+		ID:        ce.ID,
+		Type:      EventType(ce.Type),
+		Timestamp: ce.Time,
+		Resource:  ce.Subject,
+		Priority:  PriorityNormal,
+		Metadata:  map[string]interface{}{"source": ce.Source},
+	}
+	if len(ce.Data) > 0 {
+		if !isJSONContentType(ce.DataContentType) {
+			return nil, fmt.Errorf("unsupported cloudevent datacontenttype %q", ce.DataContentType)
+		}
+		var data interface{}
+		if err := json.Unmarshal(ce.Data, &data); err != nil {
+			return nil, fmt.Errorf("invalid cloudevent data: %w", err)
+		}
+		if object, ok := data.(map[string]interface{}); ok {
+			event.Data = object
+		} else if data != nil {
+			event.Data = map[string]interface{}{"value": data}
+		}
+	}
+	for name, value := range ce.Extensions {
+		s, _ := value.(string)
+		switch name {
+		case cloudEventExtPriority:
+			// Unknown priorities would fail the events.priority CHECK, so they keep the default
+			if _, ok := priorityRank[Priority(s)]; ok {
+				event.Priority = Priority(s)
+			}
+		case cloudEventExtUserID:
+			event.UserID = s
+		case cloudEventExtSessionID:
+			event.SessionID = s
+		case cloudEventExtAction:
+			event.Action = s
+		case cloudEventExtResult:
+			event.Result = s
+		default:
+			event.Metadata[name] = value
+		}
+	}
+	return event, nil
+}
+
+// MarshalJSON encodes the event in structured mode
+func (ce CloudEvent) MarshalJSON() ([]byte, error) {
+	out := make(map[string]interface{}, len(ce.Extensions)+8)
+	for name, value := range ce.Extensions {
+		out[name] = value
+	}
+	out["specversion"] = ce.SpecVersion
+	out["id"] = ce.ID
+	out["source"] = ce.Source
+	out["type"] = ce.Type
+	if ce.Subject != "" {
+		out["subject"] = ce.Subject
+	}
+	if !ce.Time.IsZero() {
+		out["time"] = ce.Time.UTC().Format(time.RFC3339Nano)
+	}
+	if ce.DataContentType != "" {
+		out["datacontenttype"] = ce.DataContentType
+	}
+	if ce.DataSchema != "" {
+		out["dataschema"] = ce.DataSchema
+	}
+	if len(ce.Data) > 0 {
+		out["data"] = ce.Data
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON decodes a structured-mode event
+func (ce *CloudEvent) UnmarshalJSON(b []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	if _, ok := raw["data_base64"]; ok {
+		return errors.New("cloudevent data_base64 is not supported")
+	}
+
+	*ce = CloudEvent{Extensions: make(map[string]interface{})}
+	for name, target := range map[string]*string{
+		"specversion": &ce.SpecVersion, "id": &ce.ID, "source": &ce.Source, "type": &ce.Type,
+		"subject": &ce.Subject, "datacontenttype": &ce.DataContentType, "dataschema": &ce.DataSchema,
+	} {
+		if value, ok := raw[name]; ok {
+			if err := json.Unmarshal(value, target); err != nil {
+				return fmt.Errorf("cloudevent %s: %w", name, err)
+			}
+		}
+	}
+	if value, ok := raw["time"]; ok {
+		if err := json.Unmarshal(value, &ce.Time); err != nil {
+			return fmt.Errorf("cloudevent time: %w", err)
+		}
+	}
+	if data, ok := raw["data"]; ok && !bytes.Equal(data, []byte("null")) {
+		ce.Data = data
+	}
+	for name, value := range raw {
+		if cloudEventAttributes[name] {
+			continue
+		}
+		var ext interface{}
+		if err := json.Unmarshal(value, &ext); err != nil {
+			return fmt.Errorf("cloudevent %s: %w", name, err)
+		}
+		ce.Extensions[name] = ext
+	}
+	return nil
+}
+
+// BinaryHeaders returns the ce-* headers and Content-Type for binary mode; the body is Data
+func (ce *CloudEvent) BinaryHeaders() http.Header {
+	header := make(http.Header)
+	set := func(name, value string) {
+		if value != "" {
+			header.Set(cloudEventHeaderPrefix+name, encodeHeaderValue(value))
+		}
+	}
+	set("specversion", ce.SpecVersion)
+	set("id", ce.ID)
+	set("source", ce.Source)
+	set("type", ce.Type)
+	set("subject", ce.Subject)
+	set("dataschema", ce.DataSchema)
+	if !ce.Time.IsZero() {
+		set("time", ce.Time.UTC().Format(time.RFC3339Nano))
+	}
+	for name, value := range ce.Extensions {
+		set(name, fmt.Sprint(value))
+	}
+	if ce.DataContentType != "" {
+		header.Set("Content-Type", ce.DataContentType)
+	}
+	return header
+}
+
+// EncodeCloudEventBatch renders events as a CloudEvents JSON batch
+func EncodeCloudEventBatch(events []*Event, source string) ([]byte, error) {
+	batch := make([]*CloudEvent, 0, len(events))
+	for _, event := range events {
+		ce, err := NewCloudEvent(event, source)
+		if err != nil {
+			return nil, err
+		}
+		batch = append(batch, ce)
+	}
+	return json.Marshal(batch)
+}
+
+// EncodeQueueMessages renders messages received from a queue subscription in its format. The
+// cloudevents-batch format gives one CloudEvent per message, carrying the receipt and attempts as
+// extensions; other formats give the messages as JSON.
+func EncodeQueueMessages(format PayloadFormat, source string, messages []*QueueMessage) ([]byte, http.Header, error) {
+	if format != PayloadFormatCloudEventsBatch {
+		payload, err := json.Marshal(messages)
+		return payload, http.Header{"Content-Type": {"application/json"}}, err
+	}
+	batch := make([]*CloudEvent, 0, len(messages))
+	for _, message := range messages {
+		ce, err := NewCloudEvent(message.Event, source)
+		if err != nil {
+			return nil, nil, err
+		}
+		ce.Extensions[cloudEventExtReceipt] = message.Receipt
+		ce.Extensions[cloudEventExtAttempts] = message.Attempts
+		batch = append(batch, ce)
+	}
+	payload, err := json.Marshal(batch)
+	return payload, http.Header{"Content-Type": {CloudEventsBatchContentType}}, err
+}
+
+// EncodeStreamRecords renders records read from a stream subscription in its format. The
+// cloudevents-batch format gives one CloudEvent per record, carrying the offset as an extension;
+// other formats give the records as JSON.
+func EncodeStreamRecords(format PayloadFormat, source string, records []*StreamRecord) ([]byte, http.Header, error) {
+	if format != PayloadFormatCloudEventsBatch {
+		payload, err := json.Marshal(records)
+		return payload, http.Header{"Content-Type": {"application/json"}}, err
+	}
+	batch := make([]*CloudEvent, 0, len(records))
+	for _, record := range records {
+		ce, err := NewCloudEvent(record.Event, source)
+		if err != nil {
+			return nil, nil, err
+		}
+		ce.Extensions[cloudEventExtOffset] = record.Offset
+		batch = append(batch, ce)
+	}
+	payload, err := json.Marshal(batch)
+	return payload, http.Header{"Content-Type": {CloudEventsBatchContentType}}, err
+}
+
+// ParseCloudEvents decodes an inbound HTTP request body in structured, batched or binary mode.
+// Requests that are none of these return ErrNotCloudEvent so callers can fall back to Event JSON.
+func ParseCloudEvents(header http.Header, body []byte) ([]*Event, error) {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+
+	var batch []*CloudEvent
+	switch {
+	case mediaType == CloudEventsContentType:
+		var ce CloudEvent
+		if err := json.Unmarshal(body, &ce); err != nil {
+			return nil, fmt.Errorf("invalid cloudevent: %w", err)
+		}
+		batch = []*CloudEvent{&ce}
+	case mediaType == CloudEventsBatchContentType:
+		if err := json.Unmarshal(body, &batch); err != nil {
+			return nil, fmt.Errorf("invalid cloudevents batch: %w", err)
+		}
+	case header.Get(cloudEventHeaderPrefix+"specversion") != "":
+		ce, err := parseBinaryCloudEvent(header, mediaType, body)
+		if err != nil {
+			return nil, err
+		}
+		batch = []*CloudEvent{ce}
+	default:
+		return nil, ErrNotCloudEvent
+	}
+
+	result := make([]*Event, 0, len(batch))
+	for _, ce := range batch {
+		if ce == nil {
+			return nil, errors.New("cloudevents batch contains null")
+		}
+		event, err := ce.ToEvent()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, event)
+	}
+	return result, nil
+}
+
+func parseBinaryCloudEvent(header http.Header, mediaType string, body []byte) (*CloudEvent, error) {
+	ce := &CloudEvent{DataContentType: mediaType, Extensions: make(map[string]interface{})}
+	for key, values := range header {
+		if !strings.HasPrefix(key, cloudEventHeaderPrefix) || len(values) == 0 {
+			continue
+		}
+		name := strings.ToLower(strings.TrimPrefix(key, cloudEventHeaderPrefix))
+		value, err := url.PathUnescape(values[0])
+		if err != nil {
+			return nil, fmt.Errorf("cloudevent header %s: %w", key, err)
+		}
+		switch name {
+		case "specversion":
+			ce.SpecVersion = value
+		case "id":
+			ce.ID = value
+		case "source":
+			ce.Source = value
+		case "type":
+			ce.Type = value
+		case "subject":
+			ce.Subject = value
+		case "dataschema":
+			ce.DataSchema = value
+		case "time":
+			t, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				return nil, fmt.Errorf("cloudevent time: %w", err)
+			}
+			ce.Time = t
+		default:
+			ce.Extensions[name] = value
+		}
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		ce.Data = body
+	}
+	return ce, nil
+}
+
+// encodePayload renders an event in the given format, returning the body and the
+// headers that describe it
+func encodePayload(format PayloadFormat, source string, event *Event) ([]byte, http.Header, error) {
+	switch format {
+	case "", PayloadFormatNative:
+		payload, err := json.Marshal(event)
+		return payload, http.Header{"Content-Type": {"application/json"}}, err
+	case PayloadFormatCloudEvents:
+		ce, err := NewCloudEvent(event, source)
+		if err != nil {
+			return nil, nil, err
+		}
+		payload, err := json.Marshal(ce)
+		return payload, http.Header{"Content-Type": {CloudEventsContentType}}, err
+	case PayloadFormatCloudEventsBinary:
+		ce, err := NewCloudEvent(event, source)
+		if err != nil {
+			return nil, nil, err
+		}
+		header := ce.BinaryHeaders()
+		payload := []byte(ce.Data)
+		if len(payload) == 0 {
+			payload = []byte("{}")
+			header.Set("Content-Type", "application/json")
+		}
+		return payload, header, nil
+	case PayloadFormatCloudEventsBatch:
+		return nil, nil, errBatchFormatPerEvent
+	default:
+		return nil, nil, fmt.Errorf("unknown payload format %q", format)
+	}
+}
+
+func isJSONContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// validExtensionName reports whether name uses only lower-case letters and digits, as the spec requires
+func validExtensionName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeHeaderValue percent-encodes the characters the HTTP binding does not allow in ce-* headers
+func encodeHeaderValue(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c <= ' ' || c > '~' || c == '"' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
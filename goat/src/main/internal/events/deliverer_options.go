@@ -0,0 +1,221 @@
+package events
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultMaxRetries = 3
+	defaultRetryDelay = time.Second
+	defaultQueueSize  = 1000
+)
+
+// Clock supplies the current time to the deliverer
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// SystemClock returns a Clock backed by time.Now
+func SystemClock() Clock {
+	return systemClock{}
+}
+
+// RetryPolicy controls how failed deliveries are rescheduled
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first
+	MaxAttempts int
+	// Delay is the wait before the next attempt
+	Delay time.Duration
+}
+
+// BeforeSendHook runs after a request is built and before it is sent. Returning an error
+// aborts the attempt as an invalid request. Auth headers are added later by the transport.
+type BeforeSendHook func(ctx context.Context, req *http.Request, webhook *Webhook, event *Event) error
+
+// AfterResponseHook runs once a response has been read and classified
+type AfterResponseHook func(ctx context.Context, resp *http.Response, delivery *Delivery)
+
+// DelivererOption configures a DefaultWebhookDeliverer
+type DelivererOption func(*delivererConfig)
+
+type delivererConfig struct {
+	client        *http.Client
+	transport     http.RoundTripper
+	policy        SSRFPolicy
+	timeout       time.Duration
+	queueSize     int
+	retry         RetryPolicy
+	clock         Clock
+	newID         func() string
+	store         DeliveryStore
+	deactivator   WebhookDeactivator
+	beforeSend    []BeforeSendHook
+	afterResponse []AfterResponseHook
+	eventSource   string
+	redactor      *Redactor
+	metrics       *Metrics
+	quotas        *TenantQuotas
+}
+
+func defaultDelivererConfig() delivererConfig {
+	return delivererConfig{
+		policy:      DefaultSSRFPolicy(),
+		timeout:     defaultDeliveryTimeout,
+		queueSize:   defaultQueueSize,
+		retry:       RetryPolicy{MaxAttempts: defaultMaxRetries, Delay: defaultRetryDelay},
+		clock:       SystemClock(),
+		newID:       newUUID,
+		eventSource: DefaultCloudEventSource,
+	}
+}
+
+// httpClient returns the configured client, wrapping a bare transport or falling back to the SSRF-guarded client
+func (c delivererConfig) httpClient() *http.Client {
+	switch {
+	case c.client != nil:
+		return c.client
+	case c.transport != nil:
+		return &http.Client{Transport: c.transport, Timeout: c.timeout, CheckRedirect: redirectPolicy(c.policy.MaxRedirects)}
+	default:
+		return NewGuardedHTTPClient(c.policy, c.timeout)
+	}
+}
+
+// WithHTTPClient sends deliveries through client as-is, bypassing the SSRF guard
+func WithHTTPClient(client *http.Client) DelivererOption {
+	return func(c *delivererConfig) {
+		c.client = client
+	}
+}
+
+// WithTransport sends deliveries through transport, bypassing the SSRF guard
+func WithTransport(transport http.RoundTripper) DelivererOption {
+	return func(c *delivererConfig) {
+		c.transport = transport
+	}
+}
+
+// WithSSRFPolicy replaces the policy guarding outbound requests, e.g. to allowlist internal consumers
+func WithSSRFPolicy(policy SSRFPolicy) DelivererOption {
+	return func(c *delivererConfig) {
+		c.policy = policy
+	}
+}
+
+// WithTimeout sets the per-request timeout
+func WithTimeout(timeout time.Duration) DelivererOption {
+	return func(c *delivererConfig) {
+		if timeout > 0 {
+			c.timeout = timeout
+		}
+	}
+}
+
+// WithQueueSize sets how many tasks may wait for a worker
+func WithQueueSize(size int) DelivererOption {
+	return func(c *delivererConfig) {
+		if size > 0 {
+			c.queueSize = size
+		}
+	}
+}
+
+// WithRetryPolicy sets how many attempts are made and how long to wait between them; zero
+// fields keep the defaults of 3 attempts and a 1s delay
+func WithRetryPolicy(policy RetryPolicy) DelivererOption {
+	return func(c *delivererConfig) {
+		if policy.MaxAttempts > 0 {
+			c.retry.MaxAttempts = policy.MaxAttempts
+		}
+		if policy.Delay > 0 {
+			c.retry.Delay = policy.Delay
+		}
+	}
+}
+
+// WithClock replaces the time source used for timestamps, retry scheduling and token expiry
+func WithClock(clock Clock) DelivererOption {
+	return func(c *delivererConfig) {
+		if clock != nil {
+			c.clock = clock
+		}
+	}
+}
+
+// WithIDGenerator replaces how delivery IDs are generated. PostgresDeliveryStore requires UUIDs.
+func WithIDGenerator(newID func() string) DelivererOption {
+	return func(c *delivererConfig) {
+		if newID != nil {
+			c.newID = newID
+		}
+	}
+}
+
+// WithDeliveryStore replaces where delivery attempts are recorded for history and retries
+func WithDeliveryStore(store DeliveryStore) DelivererOption {
+	return func(c *delivererConfig) {
+		c.store = store
+	}
+}
+
+// WithWebhookDeactivator registers the service used to disable webhooks that answer 410 Gone
+func WithWebhookDeactivator(deactivator WebhookDeactivator) DelivererOption {
+	return func(c *delivererConfig) {
+		c.deactivator = deactivator
+	}
+}
+
+// WithBeforeSend adds a hook run before each request is sent
+func WithBeforeSend(hook BeforeSendHook) DelivererOption {
+	return func(c *delivererConfig) {
+		if hook != nil {
+			c.beforeSend = append(c.beforeSend, hook)
+		}
+	}
+}
+
+// WithAfterResponse adds a hook run after each response is received
+func WithAfterResponse(hook AfterResponseHook) DelivererOption {
+	return func(c *delivererConfig) {
+		if hook != nil {
+			c.afterResponse = append(c.afterResponse, hook)
+		}
+	}
+}
+
+// WithCloudEventSource sets the source attribute of CloudEvents payloads
+func WithCloudEventSource(source string) DelivererOption {
+	return func(c *delivererConfig) {
+		if source != "" {
+			c.eventSource = source
+		}
+	}
+}
+
+// WithRedactor applies the redactor's baseline policy and each webhook's own policy before payloads are encoded
+func WithRedactor(redactor *Redactor) DelivererOption {
+	return func(c *delivererConfig) {
+		c.redactor = redactor
+	}
+}
+
+// WithMetrics records queue length, in-flight requests, attempt outcomes and latency in m
+func WithMetrics(m *Metrics) DelivererOption {
+	return func(c *delivererConfig) {
+		c.metrics = m
+	}
+}
+
+// WithTenantQuotas limits each tenant's delivery rate. Deliveries over the rate fail as rate_limited
+// and are scheduled for retry once the tenant has capacity again.
+func WithTenantQuotas(quotas *TenantQuotas) DelivererOption {
+	return func(c *delivererConfig) {
+		c.quotas = quotas
+	}
+}
@@ -0,0 +1,132 @@
+package events
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRetryAfter caps how far a receiver's Retry-After header can push the next attempt
+const maxRetryAfter = time.Hour
+
+// DeliveryErrorCode classifies why a delivery attempt failed
+type DeliveryErrorCode string
+
+const (
+	DeliveryErrorInvalidRequest DeliveryErrorCode = "invalid_request"
+	DeliveryErrorBlocked        DeliveryErrorCode = "blocked_destination"
+	DeliveryErrorUnverified     DeliveryErrorCode = "unverified_endpoint"
+	DeliveryErrorAuth           DeliveryErrorCode = "auth_error"
+	DeliveryErrorDNS            DeliveryErrorCode = "dns_error"
+	DeliveryErrorTLS            DeliveryErrorCode = "tls_error"
+	DeliveryErrorTimeout        DeliveryErrorCode = "timeout"
+	DeliveryErrorNetwork        DeliveryErrorCode = "network_error"
+	DeliveryErrorResponseRead   DeliveryErrorCode = "response_read_error"
+	DeliveryErrorRateLimited    DeliveryErrorCode = "rate_limited"
+	DeliveryErrorServer         DeliveryErrorCode = "server_error"
+	DeliveryErrorClient         DeliveryErrorCode = "client_error"
+	DeliveryErrorGone           DeliveryErrorCode = "gone"
+	DeliveryErrorUnexpected     DeliveryErrorCode = "unexpected_status"
+)
+
+// Retryable reports whether a failure with this code may succeed on a later attempt
+func (c DeliveryErrorCode) Retryable() bool {
+	switch c {
+	case DeliveryErrorInvalidRequest, DeliveryErrorBlocked, DeliveryErrorUnverified, DeliveryErrorClient, DeliveryErrorGone:
+		return false
+	}
+	return true
+}
+
+// ErrWebhookAuth is returned when outbound credentials for a webhook cannot be obtained
+var ErrWebhookAuth = errors.New("webhook authentication failed")
+
+// WebhookDeactivator disables webhooks whose endpoint reports it is permanently gone
+type WebhookDeactivator interface {
+	// DeactivateWebhook marks the webhook inactive
+	DeactivateWebhook(ctx context.Context, webhookID string) error
+}
+
+// classifyStatus maps a non-2xx response status to an error code
+func classifyStatus(status int) DeliveryErrorCode {
+	switch {
+	case status == http.StatusGone:
+		return DeliveryErrorGone
+	case status == http.StatusTooManyRequests:
+		return DeliveryErrorRateLimited
+	case status == http.StatusBadRequest, status == http.StatusUnauthorized, status == http.StatusForbidden,
+		status == http.StatusNotFound, status == http.StatusUnprocessableEntity:
+		return DeliveryErrorClient
+	case status >= http.StatusInternalServerError:
+		return DeliveryErrorServer
+	default:
+		// Other 4xx (e.g. 408, 409, 425) and unfollowed 3xx may be transient.
+		return DeliveryErrorUnexpected
+	}
+}
+
+// classifyTransportError maps an error returned by http.Client.Do to an error code
+func classifyTransportError(err error) DeliveryErrorCode {
+	var (
+		dnsErr      *net.DNSError
+		certErr     *tls.CertificateVerificationError
+		recordErr   tls.RecordHeaderError
+		alertErr    tls.AlertError
+		unknownCA   x509.UnknownAuthorityError
+		hostnameErr x509.HostnameError
+		invalidCert x509.CertificateInvalidError
+		noRoots     x509.SystemRootsError
+		opErr       *net.OpError
+		netErr      net.Error
+	)
+	switch {
+	case errors.Is(err, ErrBlockedDestination):
+		return DeliveryErrorBlocked
+	case errors.Is(err, ErrWebhookAuth):
+		return DeliveryErrorAuth
+	case errors.As(err, &dnsErr):
+		return DeliveryErrorDNS
+	case errors.As(err, &certErr), errors.As(err, &recordErr), errors.As(err, &alertErr),
+		errors.As(err, &unknownCA), errors.As(err, &hostnameErr), errors.As(err, &invalidCert),
+		errors.As(err, &noRoots),
+		// crypto/tls reports alerts sent by the peer as a "remote error" OpError
+		errors.As(err, &opErr) && opErr.Op == "remote error":
+		return DeliveryErrorTLS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return DeliveryErrorTimeout
+	default:
+		return DeliveryErrorNetwork
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	var delay time.Duration
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		delay = time.Duration(seconds) * time.Second
+	} else if at, err := http.ParseTime(value); err == nil {
+		delay = at.Sub(now)
+		if delay < 0 {
+			delay = 0
+		}
+	} else {
+		return 0, false
+	}
+	if delay > maxRetryAfter {
+		delay = maxRetryAfter
+	}
+	return delay, true
+}
@@ -0,0 +1,309 @@
+package events
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrDeliveryNotFound is returned when a delivery is not in the store
+var ErrDeliveryNotFound = errors.New("delivery not found")
+
+// DeliveryRecord is a delivery attempt together with the webhook and event needed to retry it
+type DeliveryRecord struct {
+	Delivery *Delivery
+	Webhook  *Webhook
+	Event    *Event
+}
+
+// DeliveryStore persists delivery attempts
+type DeliveryStore interface {
+	// Save inserts or replaces a delivery record
+	Save(ctx context.Context, record *DeliveryRecord) error
+
+	// Get retrieves a delivery record by delivery ID
+	Get(ctx context.Context, deliveryID string) (*DeliveryRecord, error)
+
+	// ListDeliveries returns deliveries for a webhook, newest first
+	ListDeliveries(ctx context.Context, webhookID string, filter *DeliveryFilter) ([]*Delivery, error)
+}
+
+// MemoryDeliveryStoreConfig bounds the in-memory delivery store
+type MemoryDeliveryStoreConfig struct {
+	// MaxEntries evicts the least recently used records beyond this count
+	MaxEntries int
+	// TTL expires records this long after they were saved
+	TTL time.Duration
+	// MaxResponseBytes truncates stored response bodies
+	MaxResponseBytes int
+	// Now overrides the clock, mainly for tests
+	Now func() time.Time
+}
+
+// DefaultMemoryDeliveryStoreConfig keeps up to 10,000 records for 24 hours with 64KB responses
+func DefaultMemoryDeliveryStoreConfig() MemoryDeliveryStoreConfig {
+	return MemoryDeliveryStoreConfig{
+		MaxEntries:       10000,
+		TTL:              24 * time.Hour,
+		MaxResponseBytes: 64 << 10,
+	}
+}
+
+type memoryDeliveryEntry struct {
+	record  *DeliveryRecord
+	savedAt time.Time
+}
+
+// MemoryDeliveryStore is a bounded LRU/TTL DeliveryStore
+type MemoryDeliveryStore struct {
+	config  MemoryDeliveryStoreConfig
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// NewMemoryDeliveryStore creates a new bounded in-memory delivery store, filling unset config fields with defaults
+func NewMemoryDeliveryStore(config MemoryDeliveryStoreConfig) *MemoryDeliveryStore {
+	defaults := DefaultMemoryDeliveryStoreConfig()
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = defaults.MaxEntries
+	}
+	if config.TTL <= 0 {
+		config.TTL = defaults.TTL
+	}
+	if config.MaxResponseBytes <= 0 {
+		config.MaxResponseBytes = defaults.MaxResponseBytes
+	}
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+	return &MemoryDeliveryStore{
+		config:  config,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Save inserts or replaces a delivery record, evicting expired and least recently used records
+func (s *MemoryDeliveryStore) Save(ctx context.Context, record *DeliveryRecord) error {
+	if record == nil || record.Delivery == nil || record.Delivery.ID == "" {
+		return errors.New("delivery record must have a delivery id")
+	}
+	delivery := *record.Delivery
+	if len(delivery.Response) > s.config.MaxResponseBytes {
+		delivery.Response = delivery.Response[:s.config.MaxResponseBytes]
+	}
+	entry := &memoryDeliveryEntry{
+		record:  &DeliveryRecord{Delivery: &delivery, Webhook: record.Webhook, Event: record.Event},
+		savedAt: s.config.Now(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if element, ok := s.entries[delivery.ID]; ok {
+		element.Value = entry
+		s.order.MoveToFront(element)
+	} else {
+		s.entries[delivery.ID] = s.order.PushFront(entry)
+	}
+	s.evictLocked()
+	return nil
+}
+
+// Get retrieves a copy of a delivery record and marks it recently used
+func (s *MemoryDeliveryStore) Get(ctx context.Context, deliveryID string) (*DeliveryRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	element, ok := s.entries[deliveryID]
+	if !ok {
+		return nil, fmt.Errorf("delivery %s: %w", deliveryID, ErrDeliveryNotFound)
+	}
+	entry := element.Value.(*memoryDeliveryEntry)
+	if s.expired(entry) {
+		s.removeLocked(element)
+		return nil, fmt.Errorf("delivery %s: %w", deliveryID, ErrDeliveryNotFound)
+	}
+	s.order.MoveToFront(element)
+	delivery := *entry.record.Delivery
+	return &DeliveryRecord{Delivery: &delivery, Webhook: entry.record.Webhook, Event: entry.record.Event}, nil
+}
+
+// ListDeliveries returns unexpired deliveries for a webhook, newest first
+func (s *MemoryDeliveryStore) ListDeliveries(ctx context.Context, webhookID string, filter *DeliveryFilter) ([]*Delivery, error) {
+	s.mu.Lock()
+	all := make([]*Delivery, 0, len(s.entries))
+	for element := s.order.Front(); element != nil; element = element.Next() {
+		entry := element.Value.(*memoryDeliveryEntry)
+		if s.expired(entry) {
+			continue
+		}
+		delivery := *entry.record.Delivery
+		all = append(all, &delivery)
+	}
+	s.mu.Unlock()
+
+	return filterDeliveries(all, webhookID, filter), nil
+}
+
+// Len returns the number of records currently held, including expired ones not yet evicted
+func (s *MemoryDeliveryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+func (s *MemoryDeliveryStore) expired(entry *memoryDeliveryEntry) bool {
+	return s.config.Now().Sub(entry.savedAt) > s.config.TTL
+}
+
+func (s *MemoryDeliveryStore) evictLocked() {
+	for s.order.Len() > 0 {
+		back := s.order.Back()
+		if s.order.Len() <= s.config.MaxEntries && !s.expired(back.Value.(*memoryDeliveryEntry)) {
+			return
+		}
+		s.removeLocked(back)
+	}
+}
+
+func (s *MemoryDeliveryStore) removeLocked(element *list.Element) {
+	entry := s.order.Remove(element).(*memoryDeliveryEntry)
+	delete(s.entries, entry.record.Delivery.ID)
+}
+
+// PostgresDeliveryStore persists deliveries to webhook_deliveries. Webhooks and events are
+// loaded from their own tables on Get, so both must already be persisted.
+type PostgresDeliveryStore struct {
+	db *sql.DB
+}
+
+// NewPostgresDeliveryStore creates a new Postgres-backed delivery store
+func NewPostgresDeliveryStore(db *sql.DB) *PostgresDeliveryStore {
+	return &PostgresDeliveryStore{db: db}
+}
+
+// Save upserts a delivery into webhook_deliveries
+func (s *PostgresDeliveryStore) Save(ctx context.Context, record *DeliveryRecord) error {
+	if record == nil || record.Delivery == nil || record.Delivery.ID == "" {
+		return errors.New("delivery record must have a delivery id")
+	}
+	d := record.Delivery
+	headers, err := marshalJSONColumn(d.Headers)
+	if err != nil {
+		return err
+	}
+	responseHeaders, err := marshalJSONColumn(d.ResponseHeaders)
+	if err != nil {
+		return err
+	}
+	payload := []byte(d.Payload)
+	if len(payload) == 0 {
+		payload = []byte("null")
+	}
+	var status sql.NullInt64
+	if d.StatusCode != 0 {
+		status = sql.NullInt64{Int64: int64(d.StatusCode), Valid: true}
+	}
+	// Subscription deliveries reference event_subscriptions rather than webhooks
+	webhookID := d.WebhookID
+	if d.SubscriptionID != "" {
+		webhookID = ""
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (
+			id, webhook_id, subscription_id, event_id, url, method, headers, payload, response_status, response_headers,
+			response_body, success, error_message, error_code, attempts, delivered_at, next_retry_at, created_at
+		) VALUES ($1, NULLIF($2, '')::uuid, NULLIF($3, '')::uuid, $4, $5, $6, $7, $8, $9, $10, $11, $12,
+			NULLIF($13, ''), NULLIF($14, ''), $15, $16, $17, $18)
+		ON CONFLICT (id) DO UPDATE SET
+			response_status = EXCLUDED.response_status,
+			response_headers = EXCLUDED.response_headers,
+			response_body = EXCLUDED.response_body,
+			success = EXCLUDED.success,
+			error_message = EXCLUDED.error_message,
+			error_code = EXCLUDED.error_code,
+			attempts = EXCLUDED.attempts,
+			delivered_at = EXCLUDED.delivered_at,
+			next_retry_at = EXCLUDED.next_retry_at`,
+		d.ID, webhookID, d.SubscriptionID, d.EventID, d.URL, d.Method, headers, payload, status, responseHeaders,
+		d.Response, d.Success, d.Error, string(d.ErrorCode), d.Attempts, d.DeliveredAt, d.NextRetryAt, d.CreatedAt,
+	)
+	return err
+}
+
+// Get loads a delivery with its webhook, or the subscription it was made for, and its event
+func (s *PostgresDeliveryStore) Get(ctx context.Context, deliveryID string) (*DeliveryRecord, error) {
+	delivery, err := s.getDelivery(ctx, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+
+	webhook, err := s.deliveryWebhook(ctx, delivery)
+	if err != nil {
+		return nil, err
+	}
+
+	event, err := scanEvent(s.db.QueryRowContext(ctx, `SELECT `+eventColumns+` FROM events WHERE id = $1`, delivery.EventID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("event %s not found", delivery.EventID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeliveryRecord{Delivery: delivery, Webhook: webhook, Event: event}, nil
+}
+
+func (s *PostgresDeliveryStore) deliveryWebhook(ctx context.Context, delivery *Delivery) (*Webhook, error) {
+	if delivery.SubscriptionID != "" {
+		subscription, err := scanSubscription(s.db.QueryRowContext(ctx,
+			`SELECT `+subscriptionColumns+` FROM event_subscriptions WHERE id = $1`, delivery.SubscriptionID))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("subscription %s: %w", delivery.SubscriptionID, ErrSubscriptionNotFound)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return subscriptionWebhook(subscription), nil
+	}
+	webhook, err := scanWebhook(s.db.QueryRowContext(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE id = $1`, delivery.WebhookID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("webhook %s: %w", delivery.WebhookID, ErrWebhookNotFound)
+	}
+	return webhook, err
+}
+
+func (s *PostgresDeliveryStore) getDelivery(ctx context.Context, deliveryID string) (*Delivery, error) {
+	rows, err := s.db.QueryContext(ctx, deliverySelect+` WHERE id = $1`, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries, err := scanDeliveries(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(deliveries) == 0 {
+		return nil, fmt.Errorf("delivery %s: %w", deliveryID, ErrDeliveryNotFound)
+	}
+	return deliveries[0], nil
+}
+
+// ListDeliveries returns deliveries for a webhook, newest first
+func (s *PostgresDeliveryStore) ListDeliveries(ctx context.Context, webhookID string, filter *DeliveryFilter) ([]*Delivery, error) {
+	return queryDeliveries(ctx, s.db, webhookID, filter)
+}
+
+// Prune deletes deliveries created before the cutoff and returns how many were removed
+func (s *PostgresDeliveryStore) Prune(ctx context.Context, before time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE created_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
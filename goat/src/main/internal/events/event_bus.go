@@ -0,0 +1,454 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBusChannel = "goat_events"
+	// maxNotifyPayload is the largest NOTIFY payload Postgres accepts; larger events are sent by ID
+	maxNotifyPayload        = 7999
+	defaultBusCatchUpWindow = 30 * time.Second
+	busCatchUpBatch         = 500
+)
+
+// PostgresNotification is a notification received on a LISTEN channel
+type PostgresNotification struct {
+	Channel string
+	Payload string
+}
+
+// PostgresListener holds a dedicated LISTEN connection. database/sql cannot receive
+// notifications, so deployments adapt their driver's listener, such as pq.Listener, to it.
+type PostgresListener interface {
+	// Listen starts listening on channel
+	Listen(channel string) error
+	// Notifications delivers received notifications. A nil notification means the connection was
+	// re-established and notifications sent in between may have been lost.
+	Notifications() <-chan *PostgresNotification
+	// Close closes the connection and the notifications channel
+	Close() error
+}
+
+// PostgresEventBusOption configures a PostgresEventBus
+type PostgresEventBusOption func(*PostgresEventBus)
+
+// WithBusChannel sets the LISTEN/NOTIFY channel; every node sharing events must use the same one
+func WithBusChannel(channel string) PostgresEventBusOption {
+	return func(b *PostgresEventBus) {
+		if channel != "" {
+			b.channel = channel
+		}
+	}
+}
+
+// WithBusCatchUpWindow sets how far before the newest event seen a catch-up starts reading, which
+// covers events whose transactions committed out of order. Defaults to 30 seconds.
+func WithBusCatchUpWindow(window time.Duration) PostgresEventBusOption {
+	return func(b *PostgresEventBus) {
+		if window > 0 {
+			b.catchUpWindow = window
+		}
+	}
+}
+
+// WithBusErrorHandler reports failures that have no caller to return to: handler errors for
+// events from other nodes, and failed loads and catch-ups. event is nil when no event applies.
+func WithBusErrorHandler(onError func(event *Event, err error)) PostgresEventBusOption {
+	return func(b *PostgresEventBus) {
+		b.onError = onError
+	}
+}
+
+// PostgresEventBus is an EventBus shared by every node using the same database. Publish persists
+// the event to the events table and notifies the other nodes in the same transaction. Events over
+// the NOTIFY payload limit are sent by ID and loaded from the table. After the listener reconnects,
+// the bus catches up from the events table so no event is lost in the gap. Handlers see each
+// event once per node.
+type PostgresEventBus struct {
+	db            *sql.DB
+	listener      PostgresListener
+	channel       string
+	node          string
+	catchUpWindow time.Duration
+	onError       func(event *Event, err error)
+
+	mu        sync.Mutex
+	handlers  map[string]busHandler
+	seen      map[string]time.Time
+	seenOrder []string // IDs in seen, in the order they were marked
+	highWater time.Time
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+type busHandler struct {
+	types   []EventType
+	handler EventHandler
+}
+
+// busMessage is the NOTIFY payload. Event is omitted when it would not fit.
+type busMessage struct {
+	Node      string    `json:"node"`
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Event     *Event    `json:"event,omitempty"`
+}
+
+// NewPostgresEventBus creates a bus persisting to db and receiving through listener
+func NewPostgresEventBus(db *sql.DB, listener PostgresListener, opts ...PostgresEventBusOption) *PostgresEventBus {
+	b := &PostgresEventBus{
+		db:            db,
+		listener:      listener,
+		channel:       defaultBusChannel,
+		node:          newUUID(),
+		catchUpWindow: defaultBusCatchUpWindow,
+		handlers:      make(map[string]busHandler),
+		seen:          make(map[string]time.Time),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Publish persists the event, notifies other nodes and runs this node's matching handlers.
+// Missing IDs, timestamps and priorities are filled in, and an IP that does not parse is moved to
+// Metadata["raw_ip"]. Publishing an ID that is already stored does nothing. Handler failures are
+// joined into the returned error; the event is still published.
+func (b *PostgresEventBus) Publish(ctx context.Context, event *Event) error {
+	if event == nil {
+		return errors.New("event cannot be nil")
+	}
+	if event.Type == "" {
+		return errors.New("event type is required")
+	}
+	tenantID, err := resolveTenant(ctx, event.TenantID)
+	if err != nil {
+		return err
+	}
+	event.TenantID = tenantID
+	if event.ID == "" {
+		event.ID = newUUID()
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now().UTC()
+	}
+	if event.Priority == "" {
+		event.Priority = PriorityNormal
+	}
+	keepRawIP(event)
+
+	createdAt, inserted, err := b.persist(ctx, event)
+	if err != nil || !inserted {
+		return err
+	}
+	if !b.markSeen(event.ID, createdAt) {
+		return nil
+	}
+	return b.dispatch(ctx, event)
+}
+
+// persist inserts the event and sends its notification, which Postgres delivers on commit
+func (b *PostgresEventBus) persist(ctx context.Context, event *Event) (time.Time, bool, error) {
+	data, err := marshalJSONColumn(event.Data)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	metadata, err := marshalJSONColumn(event.Metadata)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	defer tx.Rollback()
+
+	var createdAt time.Time
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO events (id, tenant_id, event_type, priority, timestamp, actor_id, user_id, session_id,
+			ip_address, user_agent, resource, action, result, data, metadata)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), (SELECT id FROM users WHERE id::text = $6), NULLIF($7, ''),
+			NULLIF($8, '')::inet, NULLIF($9, ''), NULLIF($10, ''), NULLIF($11, ''), NULLIF($12, ''), $13, $14)
+		ON CONFLICT (id) DO NOTHING
+		RETURNING created_at`,
+		event.ID, event.TenantID, string(event.Type), string(event.Priority), event.Timestamp, event.UserID,
+		event.SessionID, event.IP, event.UserAgent, event.Resource, event.Action, event.Result, data, metadata,
+	).Scan(&createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	payload, err := notifyPayload(busMessage{Node: b.node, ID: event.ID, CreatedAt: createdAt, Event: event})
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if _, err := tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, b.channel, payload); err != nil {
+		return time.Time{}, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return time.Time{}, false, err
+	}
+	return createdAt, true, nil
+}
+
+// keepRawIP moves an IP that Postgres cannot store as inet to Metadata["raw_ip"], so the event is
+// kept rather than rejected
+func keepRawIP(event *Event) {
+	if event.IP == "" || net.ParseIP(event.IP) != nil {
+		return
+	}
+	metadata := make(map[string]interface{}, len(event.Metadata)+1)
+	for k, v := range event.Metadata {
+		metadata[k] = v
+	}
+	metadata["raw_ip"] = event.IP
+	event.Metadata = metadata
+	event.IP = ""
+}
+
+// notifyPayload encodes a message, dropping the event when it would exceed the NOTIFY limit
+func notifyPayload(msg busMessage) (string, error) {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	if len(payload) <= maxNotifyPayload {
+		return string(payload), nil
+	}
+	msg.Event = nil
+	payload, err = json.Marshal(msg)
+	return string(payload), err
+}
+
+// Subscribe runs handler for every event of the given types, published on any node; no types means all
+func (b *PostgresEventBus) Subscribe(ctx context.Context, types []EventType, handler EventHandler) (string, error) {
+	if handler == nil {
+		return "", errors.New("handler cannot be nil")
+	}
+	id := newUUID()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[id] = busHandler{types: append([]EventType(nil), types...), handler: handler}
+	return id, nil
+}
+
+// Unsubscribe removes a handler
+func (b *PostgresEventBus) Unsubscribe(ctx context.Context, subscriptionID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.handlers[subscriptionID]; !ok {
+		return fmt.Errorf("subscription %s: %w", subscriptionID, ErrSubscriptionNotFound)
+	}
+	delete(b.handlers, subscriptionID)
+	return nil
+}
+
+// Start listens for other nodes' events until Stop is called or ctx is done
+func (b *PostgresEventBus) Start(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.cancel != nil {
+		return errors.New("event bus already started")
+	}
+	if err := b.listener.Listen(b.channel); err != nil {
+		return err
+	}
+	if b.highWater.IsZero() {
+		// Catch-ups start from here; events from before the bus started are not replayed.
+		if err := b.db.QueryRowContext(ctx, `SELECT NOW()`).Scan(&b.highWater); err != nil {
+			return err
+		}
+	}
+	ctx, b.cancel = context.WithCancel(ctx)
+	b.done = make(chan struct{})
+	go b.run(ctx, b.done)
+	return nil
+}
+
+// Stop stops listening and closes the listener
+func (b *PostgresEventBus) Stop(ctx context.Context) error {
+	b.mu.Lock()
+	cancel, done := b.cancel, b.done
+	b.cancel, b.done = nil, nil
+	b.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return b.listener.Close()
+}
+
+func (b *PostgresEventBus) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	notifications := b.listener.Notifications()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n, ok := <-notifications:
+			if !ok {
+				return
+			}
+			if n == nil {
+				if err := b.catchUp(ctx); err != nil {
+					b.reportError(nil, fmt.Errorf("catch up: %w", err))
+				}
+				continue
+			}
+			if n.Channel == b.channel {
+				b.receive(ctx, n.Payload)
+			}
+		}
+	}
+}
+
+// receive handles one notification, loading the event when it was sent by ID
+func (b *PostgresEventBus) receive(ctx context.Context, payload string) {
+	var msg busMessage
+	if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+		b.reportError(nil, fmt.Errorf("decode notification: %w", err))
+		return
+	}
+	if msg.Node == b.node {
+		return
+	}
+	event := msg.Event
+	if event == nil {
+		var err error
+		event, err = scanEvent(b.db.QueryRowContext(ctx, `SELECT `+eventColumns+` FROM events WHERE id = $1`, msg.ID))
+		if err != nil {
+			b.reportError(nil, fmt.Errorf("load event %s: %w", msg.ID, err))
+			return
+		}
+	}
+	b.deliver(ctx, event, msg.CreatedAt)
+}
+
+// catchUp delivers events stored since shortly before the newest one seen, skipping those already delivered
+func (b *PostgresEventBus) catchUp(ctx context.Context) error {
+	b.mu.Lock()
+	since := b.highWater.Add(-b.catchUpWindow)
+	b.mu.Unlock()
+
+	afterID := ""
+	for {
+		rows, err := b.db.QueryContext(ctx, `SELECT `+eventColumns+`, created_at FROM events
+			WHERE (created_at, id::text) > ($1, $2)
+			ORDER BY created_at ASC, id::text ASC
+			LIMIT $3`, since, afterID, busCatchUpBatch)
+		if err != nil {
+			return err
+		}
+		var (
+			batch   []*Event
+			created []time.Time
+		)
+		for rows.Next() {
+			var createdAt time.Time
+			event, err := scanEvent(appendScanner{row: rows, extra: []interface{}{&createdAt}})
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			batch = append(batch, event)
+			created = append(created, createdAt)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for i, event := range batch {
+			b.deliver(ctx, event, created[i])
+		}
+		if len(batch) < busCatchUpBatch {
+			return nil
+		}
+		since, afterID = created[len(created)-1], batch[len(batch)-1].ID
+	}
+}
+
+// deliver runs handlers for an event from another node unless it was already delivered
+func (b *PostgresEventBus) deliver(ctx context.Context, event *Event, createdAt time.Time) {
+	if !b.markSeen(event.ID, createdAt) {
+		return
+	}
+	if err := b.dispatch(ctx, event); err != nil {
+		b.reportError(event, err)
+	}
+}
+
+// markSeen records an event as delivered, returning false if it already was. Entries too old for
+// any catch-up to return are pruned from the oldest marked, so each call does constant work on average.
+func (b *PostgresEventBus) markSeen(eventID string, createdAt time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.seen[eventID]; ok {
+		return false
+	}
+	b.seen[eventID] = createdAt
+	b.seenOrder = append(b.seenOrder, eventID)
+	if createdAt.After(b.highWater) {
+		b.highWater = createdAt
+	}
+	cutoff := b.highWater.Add(-b.catchUpWindow)
+	pruned := 0
+	for pruned < len(b.seenOrder) && b.seen[b.seenOrder[pruned]].Before(cutoff) {
+		delete(b.seen, b.seenOrder[pruned])
+		pruned++
+	}
+	b.seenOrder = b.seenOrder[pruned:]
+	return true
+}
+
+func (b *PostgresEventBus) dispatch(ctx context.Context, event *Event) error {
+	b.mu.Lock()
+	var handlers []EventHandler
+	for _, h := range b.handlers {
+		if MatchesEventTypes(h.types, event.Type) {
+			handlers = append(handlers, h.handler)
+		}
+	}
+	b.mu.Unlock()
+
+	var errs []error
+	for _, handler := range handlers {
+		if err := handler(ctx, cloneEvent(event)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (b *PostgresEventBus) reportError(event *Event, err error) {
+	if b.onError != nil {
+		b.onError(event, err)
+	}
+}
+
+// appendScanner scans extra trailing columns after the ones its caller asks for
+type appendScanner struct {
+	row   rowScanner
+	extra []interface{}
+}
+
+func (s appendScanner) Scan(dest ...interface{}) error {
+	return s.row.Scan(append(dest, s.extra...)...)
+}
@@ -0,0 +1,533 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultEventHistory   = 10000
+	defaultStreamBuffer   = 64
+	defaultConsumerBatch  = 10
+	maxConsumerBatch      = 500
+	defaultEventPageLimit = 100
+)
+
+// ErrEventNotFound is returned when an event is not in the service's history
+var ErrEventNotFound = errors.New("event not found")
+
+// EventServiceOption configures a DefaultEventService
+type EventServiceOption func(*DefaultEventService)
+
+// WithSubscriptionStore replaces where subscriptions are kept; use a PostgresSubscriptionStore for durable consumer groups
+func WithSubscriptionStore(store SubscriptionStore) EventServiceOption {
+	return func(s *DefaultEventService) {
+		if store != nil {
+			s.subscriptions = store
+		}
+	}
+}
+
+// WithConsumerStore replaces where queue messages and stream records are kept
+func WithConsumerStore(store ConsumerStore) EventServiceOption {
+	return func(s *DefaultEventService) {
+		if store != nil {
+			s.consumers = store
+		}
+	}
+}
+
+// WithSubscriptionDeliverer sends events for webhook subscriptions through deliverer.
+// Without one, webhook subscriptions are stored but not delivered to.
+func WithSubscriptionDeliverer(deliverer WebhookDeliverer) EventServiceOption {
+	return func(s *DefaultEventService) {
+		s.deliverer = deliverer
+	}
+}
+
+// WithSubscriptionRedactor redacts events for queue and stream subscriptions. Webhook subscriptions
+// are redacted by the deliverer, which needs its own WithRedactor.
+func WithSubscriptionRedactor(redactor *Redactor) EventServiceOption {
+	return func(s *DefaultEventService) {
+		s.redactor = redactor
+	}
+}
+
+// WithEventHistory keeps the last n published events for GetEvents, GetEvent and replay
+func WithEventHistory(n int) EventServiceOption {
+	return func(s *DefaultEventService) {
+		if n > 0 {
+			s.maxHistory = n
+		}
+	}
+}
+
+// WithEventTypeRegistry makes Publish reject events whose type is not in registry, or is past its
+// deprecation date, with ErrUnknownEventType or ErrEventTypeRetired. A nil registry means
+// DefaultEventTypeRegistry. Without this option any type is accepted.
+func WithEventTypeRegistry(registry *EventTypeRegistry) EventServiceOption {
+	return func(s *DefaultEventService) {
+		if registry == nil {
+			registry = defaultEventTypes
+		}
+		s.eventTypes = registry
+	}
+}
+
+// WithEventBus also publishes every recorded event to bus, so that other nodes sharing it see the
+// event. Subscribe Relay to the bus to receive theirs.
+func WithEventBus(bus EventBus) EventServiceOption {
+	return func(s *DefaultEventService) {
+		s.bus = bus
+	}
+}
+
+// DefaultEventService publishes events to subscriptions and live streams. Recent events are kept
+// in memory; subscriptions and consumer state live in the configured stores.
+type DefaultEventService struct {
+	subscriptions SubscriptionStore
+	consumers     ConsumerStore
+	deliverer     WebhookDeliverer
+	redactor      *Redactor
+	eventTypes    *EventTypeRegistry
+	bus           EventBus
+	maxHistory    int
+
+	mu        sync.RWMutex
+	history   []*Event
+	byID      map[string]*Event
+	listeners map[*eventListener]struct{}
+}
+
+type eventListener struct {
+	filter *EventFilter
+	query  *Query
+	ch     chan *Event
+}
+
+// NewDefaultEventService creates an event service with in-memory stores unless options replace them
+func NewDefaultEventService(opts ...EventServiceOption) *DefaultEventService {
+	s := &DefaultEventService{
+		subscriptions: NewInMemorySubscriptionStore(),
+		consumers:     NewInMemoryConsumerStore(nil),
+		maxHistory:    defaultEventHistory,
+		byID:          make(map[string]*Event),
+		listeners:     make(map[*eventListener]struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Publish records the event and fans it out to live streams and every matching active subscription
+// of the event's tenant, which is taken from ctx. Missing IDs, timestamps and priorities are filled in.
+// With an event bus the event is published there first, and nothing is recorded or delivered when
+// that fails. Failures of individual subscriptions are joined into the returned error; the event is
+// still recorded.
+func (s *DefaultEventService) Publish(ctx context.Context, event *Event) error {
+	if event == nil {
+		return errors.New("event cannot be nil")
+	}
+	if event.Type == "" {
+		return errors.New("event type is required")
+	}
+	if s.eventTypes != nil {
+		if err := s.eventTypes.checkPublish(event.Type); err != nil {
+			return err
+		}
+	}
+	tenantID, err := resolveTenant(ctx, event.TenantID)
+	if err != nil {
+		return err
+	}
+	event.TenantID = tenantID
+	if event.ID == "" {
+		event.ID = newUUID()
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now().UTC()
+	}
+	if event.Priority == "" {
+		event.Priority = PriorityNormal
+	}
+
+	if s.bus != nil {
+		if err := s.bus.Publish(ctx, cloneEvent(event)); err != nil {
+			return fmt.Errorf("event bus: %w", err)
+		}
+	}
+	s.mu.Lock()
+	// Relay may already have recorded the event if it is subscribed to the bus.
+	if _, ok := s.byID[event.ID]; !ok {
+		s.recordLocked(cloneEvent(event))
+	}
+	s.mu.Unlock()
+
+	var errs []error
+	subscriptions, err := s.subscriptions.ListSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+	for _, subscription := range subscriptions {
+		if !subscription.Active || subscription.TenantID != event.TenantID || !MatchesEventTypes(subscription.Events, event.Type) {
+			continue
+		}
+		matched, err := MatchFilters(event, subscription.Filters)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("subscription %s: %w", subscription.ID, err))
+			continue
+		}
+		query, err := parseOptionalQuery(subscription.Query)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("subscription %s: %w", subscription.ID, err))
+			continue
+		}
+		if !matched || !query.Match(event) {
+			continue
+		}
+		if err := s.PublishToSubscription(ctx, subscription, event); err != nil {
+			errs = append(errs, fmt.Errorf("subscription %s: %w", subscription.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Relay records an event published on another node and hands it to live streams. Subscriptions
+// are not fanned out to again, as the publishing node already did so. Events already recorded
+// are ignored, so Relay can be subscribed to an EventBus that also carries local events.
+func (s *DefaultEventService) Relay(ctx context.Context, event *Event) error {
+	if event == nil {
+		return errors.New("event cannot be nil")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.byID[event.ID]; !ok {
+		s.recordLocked(cloneEvent(event))
+	}
+	return nil
+}
+
+// recordLocked appends an event to the history and sends it to matching streams
+func (s *DefaultEventService) recordLocked(stored *Event) {
+	s.history = append(s.history, stored)
+	s.byID[stored.ID] = stored
+	if over := len(s.history) - s.maxHistory; over > 0 {
+		for _, old := range s.history[:over] {
+			delete(s.byID, old.ID)
+		}
+		s.history = append([]*Event(nil), s.history[over:]...)
+	}
+	for listener := range s.listeners {
+		if matchesEventFilter(stored, listener.filter) && listener.query.Match(stored) {
+			select {
+			case listener.ch <- cloneEvent(stored):
+			default:
+				// Slow readers miss events rather than block publishers.
+			}
+		}
+	}
+}
+
+// PublishToSubscription hands an event to one subscription without checking its event types or filters
+func (s *DefaultEventService) PublishToSubscription(ctx context.Context, subscription *Subscription, event *Event) error {
+	switch subscription.Type {
+	case "", SubscriptionWebhook:
+		if s.deliverer == nil {
+			return nil
+		}
+		_, err := s.deliverer.Deliver(ctx, subscriptionWebhook(subscription), event)
+		return err
+	case SubscriptionQueue:
+		redacted, err := s.redactor.Redact(event, subscription.Redaction)
+		if err != nil {
+			return err
+		}
+		return s.consumers.Enqueue(ctx, subscription.ID, redacted)
+	case SubscriptionStream:
+		redacted, err := s.redactor.Redact(event, subscription.Redaction)
+		if err != nil {
+			return err
+		}
+		_, err = s.consumers.Append(ctx, subscription.ID, redacted)
+		return err
+	default:
+		return fmt.Errorf("unknown subscription type %q", subscription.Type)
+	}
+}
+
+// Subscribe validates and stores a subscription in the caller's tenant, which then acts as a named
+// consumer group. An empty type means webhook.
+func (s *DefaultEventService) Subscribe(ctx context.Context, subscription *Subscription) error {
+	if err := ValidateSubscription(subscription); err != nil {
+		return err
+	}
+	tenantID, err := resolveTenant(ctx, subscription.TenantID)
+	if err != nil {
+		return err
+	}
+	subscription.TenantID = tenantID
+	if subscription.Type == "" {
+		subscription.Type = SubscriptionWebhook
+	}
+	if subscription.ID == "" {
+		subscription.ID = newUUID()
+	}
+	if subscription.CreatedAt.IsZero() {
+		subscription.CreatedAt = time.Now().UTC()
+	}
+	return s.subscriptions.CreateSubscription(ctx, subscription)
+}
+
+// Unsubscribe removes a subscription along with its queued messages, stream records and cursors
+func (s *DefaultEventService) Unsubscribe(ctx context.Context, subscriptionID string) error {
+	if _, err := s.GetSubscription(ctx, subscriptionID); err != nil {
+		return err
+	}
+	if err := s.subscriptions.DeleteSubscription(ctx, subscriptionID); err != nil {
+		return err
+	}
+	return s.consumers.Drop(ctx, subscriptionID)
+}
+
+// GetSubscription retrieves a subscription by ID; other tenants' subscriptions are not found
+func (s *DefaultEventService) GetSubscription(ctx context.Context, subscriptionID string) (*Subscription, error) {
+	if _, err := callerTenant(ctx); err != nil {
+		return nil, err
+	}
+	subscription, err := s.subscriptions.GetSubscription(ctx, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	if !tenantVisible(ctx, subscription.TenantID) {
+		return nil, fmt.Errorf("subscription %s: %w", subscriptionID, ErrSubscriptionNotFound)
+	}
+	return subscription, nil
+}
+
+// ListSubscriptions lists the caller's subscriptions
+func (s *DefaultEventService) ListSubscriptions(ctx context.Context) ([]*Subscription, error) {
+	if _, err := callerTenant(ctx); err != nil {
+		return nil, err
+	}
+	subscriptions, err := s.subscriptions.ListSubscriptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	visible := subscriptions[:0]
+	for _, subscription := range subscriptions {
+		if tenantVisible(ctx, subscription.TenantID) {
+			visible = append(visible, subscription)
+		}
+	}
+	return visible, nil
+}
+
+// GetEvents returns the caller's recorded events matching the filter, oldest first
+func (s *DefaultEventService) GetEvents(ctx context.Context, filter *EventFilter) ([]*Event, error) {
+	filter, err := scopeEventFilter(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	query, err := filterQuery(filter)
+	if err != nil {
+		return nil, err
+	}
+	limit, offset := defaultEventPageLimit, 0
+	if filter != nil {
+		if filter.Limit > 0 {
+			limit = filter.Limit
+		}
+		if filter.Offset > 0 {
+			offset = filter.Offset
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := []*Event{}
+	for _, event := range s.history {
+		if !matchesEventFilter(event, filter) || !query.Match(event) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		result = append(result, cloneEvent(event))
+		if len(result) == limit {
+			break
+		}
+	}
+	return result, nil
+}
+
+// GetEvent retrieves a recorded event by ID
+func (s *DefaultEventService) GetEvent(ctx context.Context, eventID string) (*Event, error) {
+	if _, err := callerTenant(ctx); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	event, ok := s.byID[eventID]
+	if !ok || !tenantVisible(ctx, event.TenantID) {
+		return nil, fmt.Errorf("event %s: %w", eventID, ErrEventNotFound)
+	}
+	return cloneEvent(event), nil
+}
+
+// Stream sends the caller's events published after the call that match the filter until ctx is done.
+// Events are dropped for a reader that falls behind.
+func (s *DefaultEventService) Stream(ctx context.Context, filter *EventFilter) (<-chan *Event, error) {
+	filter, err := scopeEventFilter(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	query, err := filterQuery(filter)
+	if err != nil {
+		return nil, err
+	}
+	listener := &eventListener{filter: filter, query: query, ch: make(chan *Event, defaultStreamBuffer)}
+	s.mu.Lock()
+	s.listeners[listener] = struct{}{}
+	s.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		delete(s.listeners, listener)
+		close(listener.ch)
+		s.mu.Unlock()
+	}()
+	return listener.ch, nil
+}
+
+// Receive leases up to max messages of a queue subscription to consumer. Each message stays
+// hidden from other consumers until it is acked, nacked or the subscription's visibility timeout passes.
+func (s *DefaultEventService) Receive(ctx context.Context, subscriptionID, consumer string, max int) ([]*QueueMessage, error) {
+	subscription, err := s.consumerGroup(ctx, subscriptionID, SubscriptionQueue, consumer)
+	if err != nil {
+		return nil, err
+	}
+	visibility, err := subscriptionVisibilityTimeout(subscription)
+	if err != nil {
+		return nil, err
+	}
+	return s.consumers.Receive(ctx, subscriptionID, consumer, consumerBatch(max), visibility)
+}
+
+// Ack confirms a received queue message so it is not delivered again
+func (s *DefaultEventService) Ack(ctx context.Context, subscriptionID, receipt string) error {
+	if _, err := s.GetSubscription(ctx, subscriptionID); err != nil {
+		return err
+	}
+	return s.consumers.Ack(ctx, subscriptionID, receipt)
+}
+
+// Nack returns a received queue message to the queue, visible again after delay
+func (s *DefaultEventService) Nack(ctx context.Context, subscriptionID, receipt string, delay time.Duration) error {
+	if delay < 0 {
+		delay = 0
+	}
+	if _, err := s.GetSubscription(ctx, subscriptionID); err != nil {
+		return err
+	}
+	return s.consumers.Nack(ctx, subscriptionID, receipt, delay)
+}
+
+// ReadStream returns up to max records of a stream subscription after the consumer's cursor.
+// Reading does not move the cursor; call Commit with the last processed offset.
+func (s *DefaultEventService) ReadStream(ctx context.Context, subscriptionID, consumer string, max int) ([]*StreamRecord, error) {
+	if _, err := s.consumerGroup(ctx, subscriptionID, SubscriptionStream, consumer); err != nil {
+		return nil, err
+	}
+	return s.consumers.ReadStream(ctx, subscriptionID, consumer, consumerBatch(max))
+}
+
+// Commit moves a stream consumer's cursor to offset. Committing an earlier offset, such as 0, replays the stream.
+func (s *DefaultEventService) Commit(ctx context.Context, subscriptionID, consumer string, offset int64) error {
+	if _, err := s.consumerGroup(ctx, subscriptionID, SubscriptionStream, consumer); err != nil {
+		return err
+	}
+	return s.consumers.Commit(ctx, subscriptionID, consumer, offset)
+}
+
+func (s *DefaultEventService) consumerGroup(ctx context.Context, subscriptionID, subscriptionType, consumer string) (*Subscription, error) {
+	if consumer == "" {
+		return nil, errors.New("consumer name is required")
+	}
+	subscription, err := s.GetSubscription(ctx, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	if subscription.Type != subscriptionType {
+		return nil, fmt.Errorf("subscription %s is a %s subscription, not a %s", subscriptionID, subscription.Type, subscriptionType)
+	}
+	return subscription, nil
+}
+
+func consumerBatch(max int) int {
+	switch {
+	case max <= 0:
+		return defaultConsumerBatch
+	case max > maxConsumerBatch:
+		return maxConsumerBatch
+	default:
+		return max
+	}
+}
+
+// filterQuery parses the filter's query, returning nil when there is none
+func filterQuery(filter *EventFilter) (*Query, error) {
+	if filter == nil {
+		return nil, nil
+	}
+	return parseOptionalQuery(filter.Query)
+}
+
+// matchesEventFilter applies an EventFilter's tenant, field, type, priority and time conditions;
+// the query and paging are ignored
+func matchesEventFilter(event *Event, filter *EventFilter) bool {
+	if filter == nil {
+		return true
+	}
+	if filter.TenantID != "" && filter.TenantID != event.TenantID {
+		return false
+	}
+	if !MatchesEventTypes(filter.Types, event.Type) {
+		return false
+	}
+	if len(filter.Priority) > 0 {
+		found := false
+		for _, priority := range filter.Priority {
+			if priority == event.Priority {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if filter.StartTime != nil && event.Timestamp.Before(*filter.StartTime) {
+		return false
+	}
+	if filter.EndTime != nil && event.Timestamp.After(*filter.EndTime) {
+		return false
+	}
+	if (filter.UserID != "" && filter.UserID != event.UserID) ||
+		(filter.SessionID != "" && filter.SessionID != event.SessionID) ||
+		(filter.Resource != "" && filter.Resource != event.Resource) {
+		return false
+	}
+	for key, want := range filter.Metadata {
+		got, ok := event.Metadata[key]
+		if !ok || !valuesEqual(got, want) {
+			return false
+		}
+	}
+	return true
+}
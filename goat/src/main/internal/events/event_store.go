@@ -0,0 +1,151 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// PostgresEventStore reads recorded events from the events table, compiling filters and
+// queries to parameterized SQL
+type PostgresEventStore struct {
+	db *sql.DB
+}
+
+// NewPostgresEventStore creates a new Postgres-backed event store
+func NewPostgresEventStore(db *sql.DB) *PostgresEventStore {
+	return &PostgresEventStore{db: db}
+}
+
+// GetEvents returns the caller's events matching the filter, oldest first
+func (s *PostgresEventStore) GetEvents(ctx context.Context, filter *EventFilter) ([]*Event, error) {
+	filter, err := scopeEventFilter(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	where, args, err := eventFilterSQL(filter)
+	if err != nil {
+		return nil, err
+	}
+	limit, offset := defaultEventPageLimit, 0
+	if filter != nil {
+		if filter.Limit > 0 {
+			limit = filter.Limit
+		}
+		if filter.Offset > 0 {
+			offset = filter.Offset
+		}
+	}
+	args = append(args, limit, offset)
+
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`SELECT %s FROM events WHERE %s
+		ORDER BY timestamp ASC, id ASC LIMIT $%d OFFSET $%d`, eventColumns, where, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []*Event{}
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, event)
+	}
+	return result, rows.Err()
+}
+
+// GetEvent retrieves an event visible to the caller by ID
+func (s *PostgresEventStore) GetEvent(ctx context.Context, eventID string) (*Event, error) {
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	event, err := scanEvent(s.db.QueryRowContext(ctx, `SELECT `+eventColumns+` FROM events
+		WHERE id::text = $1 AND ($2 = '' OR tenant_id = $2)`, eventID, tenantID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("event %s: %w", eventID, ErrEventNotFound)
+	}
+	return event, err
+}
+
+// eventFilterSQL compiles a filter, including its query, to a WHERE clause; paging is ignored
+func eventFilterSQL(filter *EventFilter) (string, []interface{}, error) {
+	b := &querySQL{next: 1}
+	if filter == nil {
+		return "TRUE", nil, nil
+	}
+	var conditions []string
+	if filter.TenantID != "" {
+		conditions = append(conditions, "tenant_id = "+b.arg(filter.TenantID))
+	}
+	if types := eventTypesSQL(b, filter.Types); types != "" {
+		conditions = append(conditions, types)
+	}
+	if len(filter.Priority) > 0 {
+		priorities := make([]string, len(filter.Priority))
+		for i, priority := range filter.Priority {
+			priorities[i] = string(priority)
+		}
+		conditions = append(conditions, "priority = ANY("+b.arg(formatTextArray(priorities))+"::text[])")
+	}
+	if filter.StartTime != nil {
+		conditions = append(conditions, "timestamp >= "+b.arg(*filter.StartTime))
+	}
+	if filter.EndTime != nil {
+		conditions = append(conditions, "timestamp <= "+b.arg(*filter.EndTime))
+	}
+	if filter.UserID != "" {
+		conditions = append(conditions, "actor_id = "+b.arg(filter.UserID))
+	}
+	if filter.SessionID != "" {
+		conditions = append(conditions, "session_id = "+b.arg(filter.SessionID))
+	}
+	if filter.Resource != "" {
+		conditions = append(conditions, "resource = "+b.arg(filter.Resource))
+	}
+	if len(filter.Metadata) > 0 {
+		metadata, err := json.Marshal(filter.Metadata)
+		if err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, "metadata @> "+b.arg(string(metadata))+"::jsonb")
+	}
+	query, err := filterQuery(filter)
+	if err != nil {
+		return "", nil, err
+	}
+	if query != nil {
+		conditions = append(conditions, "("+query.Expr.sql(b)+")")
+	}
+	if len(conditions) == 0 {
+		return "TRUE", b.args, nil
+	}
+	return strings.Join(conditions, " AND "), b.args, nil
+}
+
+// eventTypesSQL compiles event type patterns as MatchesEventTypes applies them, returning an
+// empty string when every type matches
+func eventTypesSQL(b *querySQL, patterns []EventType) string {
+	for _, pattern := range patterns {
+		if pattern == "*" {
+			return ""
+		}
+	}
+	var alternatives []string
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(string(pattern), ".*"); ok {
+			alternatives = append(alternatives, "event_type LIKE "+b.arg(likePrefix(prefix+".")))
+			continue
+		}
+		alternatives = append(alternatives, "event_type = "+b.arg(string(pattern)))
+	}
+	if len(alternatives) == 0 {
+		return ""
+	}
+	return "(" + strings.Join(alternatives, " OR ") + ")"
+}
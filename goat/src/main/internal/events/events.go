@@ -73,6 +73,11 @@ const (
 	EventSSOProviderRemoved EventType = "sso.provider.removed"
 )
 
+// IsKnownEventType reports whether the event type is in the default event type registry
+func IsKnownEventType(eventType EventType) bool {
+	return defaultEventTypes.IsRegistered(eventType)
+}
+
 // Priority represents event priority
 type Priority string
 
@@ -86,6 +91,7 @@ const (
 // Event represents a system event
 type Event struct {
 	ID        string                 `json:"id" db:"id"`
+	TenantID  string                 `json:"tenant_id,omitempty" db:"tenant_id"`
 	Type      EventType              `json:"type" db:"type"`
 	Priority  Priority               `json:"priority" db:"priority"`
 	Timestamp time.Time              `json:"timestamp" db:"timestamp"`
@@ -102,19 +108,30 @@ type Event struct {
 
 // Webhook represents a webhook configuration
 type Webhook struct {
-	ID            string            `json:"id" db:"id"`
-	Name          string            `json:"name" db:"name"`
-	URL           string            `json:"url" db:"url"`
-	Events        []EventType       `json:"events" db:"events"`
-	Headers       map[string]string `json:"headers,omitempty" db:"headers"`
-	Secret        string            `json:"-" db:"secret"` // For HMAC signing
-	Active        bool              `json:"active" db:"active"`
-	RetryConfig   *RetryConfig      `json:"retry_config,omitempty" db:"retry_config"`
-	Filters       []Filter          `json:"filters,omitempty" db:"filters"`
-	CreatedAt     time.Time         `json:"created_at" db:"created_at"`
-	UpdatedAt     time.Time         `json:"updated_at" db:"updated_at"`
-	LastTriggered *time.Time        `json:"last_triggered,omitempty" db:"last_triggered"`
-	FailureCount  int               `json:"failure_count" db:"failure_count"`
+	ID                 string                    `json:"id" db:"id"`
+	TenantID           string                    `json:"tenant_id,omitempty" db:"tenant_id"`
+	Name               string                    `json:"name" db:"name"`
+	URL                string                    `json:"url" db:"url"`
+	Events             []EventType               `json:"events" db:"events"`
+	Headers            map[string]string         `json:"headers,omitempty" db:"headers"`
+	Secret             string                    `json:"-" db:"secret"` // For HMAC signing
+	Auth               *WebhookAuth              `json:"auth,omitempty" db:"auth"`
+	Active             bool                      `json:"active" db:"active"`
+	RetryConfig        *RetryConfig              `json:"retry_config,omitempty" db:"retry_config"`
+	Filters            []Filter                  `json:"filters,omitempty" db:"filters"`
+	Format             PayloadFormat             `json:"format,omitempty" db:"format"`
+	Encryption         *WebhookEncryption        `json:"encryption,omitempty" db:"encryption"`
+	Redaction          *RedactionPolicy          `json:"redaction,omitempty" db:"redaction"`
+	VerifyOwnership    bool                      `json:"verify_ownership,omitempty" db:"verify_ownership"`
+	VerificationStatus WebhookVerificationStatus `json:"verification_status,omitempty" db:"verification_status"`
+	VerifiedAt         *time.Time                `json:"verified_at,omitempty" db:"verified_at"`
+	CreatedAt          time.Time                 `json:"created_at" db:"created_at"`
+	UpdatedAt          time.Time                 `json:"updated_at" db:"updated_at"`
+	LastTriggered      *time.Time                `json:"last_triggered,omitempty" db:"last_triggered"`
+	FailureCount       int                       `json:"failure_count" db:"failure_count"`
+	// SubscriptionID is set on the webhook describing a webhook subscription, whose deliveries
+	// are recorded against the subscription
+	SubscriptionID string `json:"-" db:"-"`
 }
 
 // RetryConfig represents webhook retry configuration
@@ -136,12 +153,16 @@ type Filter struct {
 // Subscription represents an event subscription
 type Subscription struct {
 	ID          string                 `json:"id" db:"id"`
+	TenantID    string                 `json:"tenant_id,omitempty" db:"tenant_id"`
 	Name        string                 `json:"name" db:"name"`
 	Type        string                 `json:"type" db:"type"` // webhook, stream, queue
 	Events      []EventType            `json:"events" db:"events"`
 	Destination string                 `json:"destination" db:"destination"`
 	Config      map[string]interface{} `json:"config,omitempty" db:"config"`
 	Filters     []Filter               `json:"filters,omitempty" db:"filters"`
+	Query       string                 `json:"query,omitempty" db:"query"`
+	Format      PayloadFormat          `json:"format,omitempty" db:"format"`
+	Redaction   *RedactionPolicy       `json:"redaction,omitempty" db:"redaction"`
 	Active      bool                   `json:"active" db:"active"`
 	CreatedAt   time.Time              `json:"created_at" db:"created_at"`
 }
@@ -187,12 +208,13 @@ type WebhookService interface {
 	// TestWebhook tests a webhook with sample data
 	TestWebhook(ctx context.Context, webhookID string) (*WebhookTestResult, error)
 
-	// GetDeliveryHistory gets webhook delivery history
-	GetDeliveryHistory(ctx context.Context, webhookID string) ([]*Delivery, error)
+	// GetDeliveryHistory gets webhook delivery history, newest first
+	GetDeliveryHistory(ctx context.Context, webhookID string, filter *DeliveryFilter) ([]*Delivery, error)
 }
 
 // EventFilter represents filters for querying events
 type EventFilter struct {
+	TenantID  string                 `json:"tenant_id,omitempty"`
 	Types     []EventType            `json:"types,omitempty"`
 	Priority  []Priority             `json:"priority,omitempty"`
 	StartTime *time.Time             `json:"start_time,omitempty"`
@@ -201,27 +223,62 @@ type EventFilter struct {
 	SessionID string                 `json:"session_id,omitempty"`
 	Resource  string                 `json:"resource,omitempty"`
 	Metadata  map[string]interface{} `json:"metadata,omitempty"`
-	Limit     int                    `json:"limit,omitempty"`
-	Offset    int                    `json:"offset,omitempty"`
+	// Query is an event query, see ParseQuery, AND-ed with the other conditions
+	Query  string `json:"query,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+	Offset int    `json:"offset,omitempty"`
 }
 
 // Delivery represents a webhook delivery attempt
 type Delivery struct {
-	ID          string            `json:"id" db:"id"`
-	WebhookID   string            `json:"webhook_id" db:"webhook_id"`
-	EventID     string            `json:"event_id" db:"event_id"`
-	URL         string            `json:"url" db:"url"`
-	Method      string            `json:"method" db:"method"`
-	Headers     map[string]string `json:"headers" db:"headers"`
-	Payload     json.RawMessage   `json:"payload" db:"payload"`
-	Response    string            `json:"response,omitempty" db:"response"`
-	StatusCode  int               `json:"status_code" db:"status_code"`
-	Success     bool              `json:"success" db:"success"`
-	Error       string            `json:"error,omitempty" db:"error"`
-	Attempts    int               `json:"attempts" db:"attempts"`
-	DeliveredAt *time.Time        `json:"delivered_at,omitempty" db:"delivered_at"`
-	NextRetryAt *time.Time        `json:"next_retry_at,omitempty" db:"next_retry_at"`
-	CreatedAt   time.Time         `json:"created_at" db:"created_at"`
+	ID              string            `json:"id" db:"id"`
+	WebhookID       string            `json:"webhook_id" db:"webhook_id"`
+	SubscriptionID  string            `json:"subscription_id,omitempty" db:"subscription_id"`
+	EventID         string            `json:"event_id" db:"event_id"`
+	URL             string            `json:"url" db:"url"`
+	Method          string            `json:"method" db:"method"`
+	Headers         map[string]string `json:"headers" db:"headers"`
+	Payload         json.RawMessage   `json:"payload" db:"payload"`
+	Response        string            `json:"response,omitempty" db:"response"`
+	ResponseHeaders map[string]string `json:"response_headers,omitempty" db:"response_headers"`
+	StatusCode      int               `json:"status_code" db:"status_code"`
+	Success         bool              `json:"success" db:"success"`
+	Error           string            `json:"error,omitempty" db:"error"`
+	ErrorCode       DeliveryErrorCode `json:"error_code,omitempty" db:"error_code"`
+	Attempts        int               `json:"attempts" db:"attempts"`
+	DeliveredAt     *time.Time        `json:"delivered_at,omitempty" db:"delivered_at"`
+	NextRetryAt     *time.Time        `json:"next_retry_at,omitempty" db:"next_retry_at"`
+	CreatedAt       time.Time         `json:"created_at" db:"created_at"`
+}
+
+// DeliveryStatus represents the outcome of a delivery attempt
+type DeliveryStatus string
+
+const (
+	DeliveryStatusSucceeded DeliveryStatus = "succeeded"
+	DeliveryStatusRetrying  DeliveryStatus = "retrying"
+	DeliveryStatusFailed    DeliveryStatus = "failed"
+)
+
+// Status derives the delivery status from the recorded outcome
+func (d *Delivery) Status() DeliveryStatus {
+	switch {
+	case d.Success:
+		return DeliveryStatusSucceeded
+	case d.NextRetryAt != nil:
+		return DeliveryStatusRetrying
+	default:
+		return DeliveryStatusFailed
+	}
+}
+
+// DeliveryFilter represents filters for querying delivery history
+type DeliveryFilter struct {
+	Status    DeliveryStatus `json:"status,omitempty"`
+	StartTime *time.Time     `json:"start_time,omitempty"`
+	EndTime   *time.Time     `json:"end_time,omitempty"`
+	Limit     int            `json:"limit,omitempty"`
+	Offset    int            `json:"offset,omitempty"`
 }
 
 // WebhookTestResult represents the result of a webhook test
@@ -309,25 +366,37 @@ type TransformRule struct {
 	Config    map[string]interface{} `json:"config,omitempty"`
 }
 
+// defaultDeliveryTimeout bounds a single webhook request including redirects
+const defaultDeliveryTimeout = 30 * time.Second
+
+// maxResponseBodySize caps how much of a webhook response body is read and recorded
+const maxResponseBodySize = 1 << 20
+
 // DefaultWebhookDeliverer implements webhook delivery
 type DefaultWebhookDeliverer struct {
-	client       *http.Client
-	maxRetries   int
-	retryDelay   time.Duration
-	queue        chan *DeliveryTask
-	workers      int
-	wg           sync.WaitGroup
-	stopOnce     sync.Once
-	deliveries   map[string]*deliveryRecord
-	deliveriesMu sync.RWMutex
-	sequence     uint64
-	started      int32
-}
-
-type deliveryRecord struct {
-	delivery *Delivery
-	webhook  *Webhook
-	event    *Event
+	client      *http.Client
+	maxRetries  int
+	retryDelay  time.Duration
+	queue       chan *DeliveryTask
+	workers     int
+	wg          sync.WaitGroup
+	stopOnce    sync.Once
+	store       DeliveryStore
+	clock       Clock
+	newID       func() string
+	eventSource string
+	beforeSend  []BeforeSendHook
+	afterResp   []AfterResponseHook
+	retryMu     sync.Mutex
+	started     int32
+	clients     map[string]*webhookClient
+	clientsMu   sync.Mutex
+	deactivator WebhookDeactivator
+	jwks        *jwksCache
+	redactor    *Redactor
+	metrics     *Metrics
+	quotas      *TenantQuotas
+	untrack     func()
 }
 
 // DeliveryTask represents a webhook delivery task
@@ -339,22 +408,74 @@ type DeliveryTask struct {
 }
 
 // NewDefaultWebhookDeliverer creates a new webhook deliverer
-func NewDefaultWebhookDeliverer(workers int) *DefaultWebhookDeliverer {
+func NewDefaultWebhookDeliverer(workers int, opts ...DelivererOption) *DefaultWebhookDeliverer {
 	if workers <= 0 {
 		workers = 1
 	}
+	config := defaultDelivererConfig()
+	for _, opt := range opts {
+		opt(&config)
+	}
+	store := config.store
+	if store == nil {
+		store = NewMemoryDeliveryStore(MemoryDeliveryStoreConfig{Now: config.clock.Now})
+	}
+	queue := make(chan *DeliveryTask, config.queueSize)
+	untrackQueue := config.metrics.trackQueue(func() int { return len(queue) })
 	return &DefaultWebhookDeliverer{
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-		maxRetries: 3,
-		retryDelay: 1 * time.Second,
-		queue:      make(chan *DeliveryTask, 1000),
-		workers:    workers,
-		deliveries: make(map[string]*deliveryRecord),
+		client:      config.httpClient(),
+		maxRetries:  config.retry.MaxAttempts,
+		retryDelay:  config.retry.Delay,
+		queue:       queue,
+		workers:     workers,
+		store:       store,
+		clock:       config.clock,
+		newID:       config.newID,
+		eventSource: config.eventSource,
+		beforeSend:  config.beforeSend,
+		afterResp:   config.afterResponse,
+		clients:     make(map[string]*webhookClient),
+		deactivator: config.deactivator,
+		jwks:        newJWKSCache(config.clock),
+		redactor:    config.redactor,
+		metrics:     config.metrics,
+		quotas:      config.quotas,
+		untrack:     untrackQueue,
+	}
+}
+
+// SetSSRFPolicy replaces the policy guarding outbound requests, e.g. to allowlist internal consumers.
+// It must be called before Start.
+//
+// Deprecated: pass WithSSRFPolicy to NewDefaultWebhookDeliverer instead.
+func (d *DefaultWebhookDeliverer) SetSSRFPolicy(policy SSRFPolicy) {
+	timeout := defaultDeliveryTimeout
+	if d.client != nil && d.client.Timeout > 0 {
+		timeout = d.client.Timeout
+	}
+	d.client = NewGuardedHTTPClient(policy, timeout)
+	d.clientsMu.Lock()
+	d.clients = make(map[string]*webhookClient)
+	d.clientsMu.Unlock()
+}
+
+// SetDeliveryStore replaces where delivery attempts are recorded for history and retries.
+// It must be called before Start.
+//
+// Deprecated: pass WithDeliveryStore to NewDefaultWebhookDeliverer instead.
+func (d *DefaultWebhookDeliverer) SetDeliveryStore(store DeliveryStore) {
+	if store != nil {
+		d.store = store
 	}
 }
 
+// SetWebhookDeactivator registers the service used to disable webhooks that answer 410 Gone.
+// It exists alongside WithWebhookDeactivator because the webhook service usually needs the
+// deliverer first. It must be called before Start.
+func (d *DefaultWebhookDeliverer) SetWebhookDeactivator(deactivator WebhookDeactivator) {
+	d.deactivator = deactivator
+}
+
 // Deliver delivers an event to the specified webhook either synchronously or via the worker pool.
 func (d *DefaultWebhookDeliverer) Deliver(ctx context.Context, webhook *Webhook, event *Event) (*Delivery, error) {
 	if webhook == nil {
@@ -393,27 +514,33 @@ func (d *DefaultWebhookDeliverer) RetryDelivery(ctx context.Context, deliveryID
 		return nil, errors.New("delivery id cannot be empty")
 	}
 
-	d.deliveriesMu.Lock()
-	record, ok := d.deliveries[deliveryID]
-	if !ok {
-		d.deliveriesMu.Unlock()
-		return nil, fmt.Errorf("delivery %s not found", deliveryID)
+	// Serialize the read-check-save so concurrent retries cannot exceed maxRetries.
+	d.retryMu.Lock()
+	record, err := d.store.Get(ctx, deliveryID)
+	if err != nil {
+		d.retryMu.Unlock()
+		return nil, err
 	}
-	if record.delivery == nil || record.webhook == nil || record.event == nil {
-		d.deliveriesMu.Unlock()
+	if record.Delivery == nil || record.Webhook == nil || record.Event == nil {
+		d.retryMu.Unlock()
 		return nil, fmt.Errorf("delivery %s is missing metadata for retry", deliveryID)
 	}
-	if record.delivery.Attempts >= d.maxRetries {
-		d.deliveriesMu.Unlock()
-		return record.delivery, fmt.Errorf("max retries reached for delivery %s", deliveryID)
+	if record.Delivery.Attempts >= d.maxRetries {
+		d.retryMu.Unlock()
+		return record.Delivery, fmt.Errorf("max retries reached for delivery %s", deliveryID)
+	}
+	if !record.Delivery.Success && !record.Delivery.ErrorCode.Retryable() {
+		d.retryMu.Unlock()
+		return record.Delivery, fmt.Errorf("delivery %s is not retryable: %s", deliveryID, record.Delivery.ErrorCode)
+	}
+	record.Delivery.Attempts++
+	err = d.store.Save(ctx, record)
+	d.retryMu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to record retry of delivery %s: %w", deliveryID, err)
 	}
-	record.delivery.Attempts++
-	attempt := record.delivery.Attempts
-	webhook := record.webhook
-	event := record.event
-	d.deliveriesMu.Unlock()
 
-	delivery := d.deliver(ctx, &DeliveryTask{Webhook: webhook, Event: event, Attempt: attempt})
+	delivery := d.deliver(ctx, &DeliveryTask{Webhook: record.Webhook, Event: record.Event, Attempt: record.Delivery.Attempts})
 	return delivery, d.deliveryError(delivery)
 }
 
@@ -455,6 +582,7 @@ func (d *DefaultWebhookDeliverer) Stop() {
 	d.stopOnce.Do(func() {
 		atomic.StoreInt32(&d.started, 0)
 		close(d.queue)
+		d.untrack()
 	})
 	d.wg.Wait()
 }
@@ -484,137 +612,283 @@ func (d *DefaultWebhookDeliverer) deliver(ctx context.Context, task *DeliveryTas
 	}
 	if task.Webhook == nil || task.Event == nil {
 		delivery := &Delivery{
-			Attempts:    task.Attempt,
-			Success:     false,
-			Error:       "webhook or event is nil",
-			CreatedAt:   time.Now(),
-			NextRetryAt: d.nextRetryTime(task.Attempt),
+			Attempts:  task.Attempt,
+			CreatedAt: d.clock.Now(),
 		}
-		return d.finalizeDelivery(task, delivery, nil)
+		d.fail(delivery, task.Attempt, DeliveryErrorInvalidRequest, errors.New("webhook or event is nil"))
+		return d.finalizeDelivery(ctx, task, delivery, nil)
 	}
 	if task.Attempt <= 0 {
 		task.Attempt = 1
 	}
-
 	delivery := &Delivery{
-		WebhookID: task.Webhook.ID,
-		EventID:   task.Event.ID,
-		URL:       task.Webhook.URL,
-		Method:    "POST",
-		Attempts:  task.Attempt,
-		CreatedAt: time.Now(),
+		WebhookID:      task.Webhook.ID,
+		SubscriptionID: task.Webhook.SubscriptionID,
+		EventID:        task.Event.ID,
+		URL:            task.Webhook.URL,
+		Method:         "POST",
+		Attempts:       task.Attempt,
+		CreatedAt:      d.clock.Now(),
+	}
+	if task.Webhook.VerificationStatus == VerificationPending {
+		d.fail(delivery, task.Attempt, DeliveryErrorUnverified, ErrWebhookNotVerified)
+		return d.finalizeDelivery(ctx, task, delivery, nil)
+	}
+	if task.Webhook.TenantID != task.Event.TenantID {
+		d.fail(delivery, task.Attempt, DeliveryErrorInvalidRequest,
+			fmt.Errorf("event of tenant %q sent to webhook of tenant %q: %w", task.Event.TenantID, task.Webhook.TenantID, ErrTenantMismatch))
+		return d.finalizeDelivery(ctx, task, delivery, nil)
+	}
+	if ok, wait := d.quotas.reserveDelivery(task.Webhook.TenantID); !ok {
+		d.fail(delivery, task.Attempt, DeliveryErrorRateLimited,
+			fmt.Errorf("tenant %s delivery rate: %w", task.Webhook.TenantID, ErrTenantQuotaExceeded))
+		if delivery.NextRetryAt != nil {
+			next := d.clock.Now().Add(wait)
+			delivery.NextRetryAt = &next
+		}
+		return d.finalizeDelivery(ctx, task, delivery, nil)
 	}
 
-	payload, err := json.Marshal(task.Event)
+	req, payload, err := d.newRequest(ctx, task.Webhook, task.Event)
+	if payload != nil {
+		delivery.Payload = json.RawMessage(payload)
+	}
 	if err != nil {
-		delivery.Success = false
-		delivery.Error = err.Error()
-		delivery.NextRetryAt = d.nextRetryTime(task.Attempt)
-		return d.finalizeDelivery(task, delivery, nil)
+		d.fail(delivery, task.Attempt, DeliveryErrorInvalidRequest, err)
+		return d.finalizeDelivery(ctx, task, delivery, req)
 	}
-	delivery.Payload = json.RawMessage(payload)
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, task.Webhook.URL, bytes.NewReader(payload))
+	for _, hook := range d.beforeSend {
+		if err := hook(ctx, req, task.Webhook, task.Event); err != nil {
+			d.fail(delivery, task.Attempt, DeliveryErrorInvalidRequest, err)
+			return d.finalizeDelivery(ctx, task, delivery, req)
+		}
+	}
+
+	client, err := d.clientFor(task.Webhook)
+	if err != nil {
+		d.fail(delivery, task.Attempt, DeliveryErrorInvalidRequest, err)
+		return d.finalizeDelivery(ctx, task, delivery, req)
+	}
+
+	start := d.clock.Now()
+	done := d.metrics.startRequest()
+	resp, err := client.Do(req)
 	if err != nil {
-		delivery.Success = false
-		delivery.Error = err.Error()
-		delivery.NextRetryAt = d.nextRetryTime(task.Attempt)
-		return d.finalizeDelivery(task, delivery, nil)
+		done()
+		d.metrics.observeLatency(task.Webhook.ID, d.clock.Now().Sub(start))
+		d.fail(delivery, task.Attempt, classifyTransportError(err), err)
+		return d.finalizeDelivery(ctx, task, delivery, req)
+	}
+	defer resp.Body.Close()
+
+	body, readErr := io.ReadAll(io.LimitReader(resp.Body, maxResponseBodySize))
+	done()
+	d.metrics.observeLatency(task.Webhook.ID, d.clock.Now().Sub(start))
+	delivery.Response = string(body)
+	delivery.StatusCode = resp.StatusCode
+	delivery.ResponseHeaders = flattenHeaders(resp.Header)
+
+	switch {
+	case resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices:
+		code := classifyStatus(resp.StatusCode)
+		d.fail(delivery, task.Attempt, code, fmt.Errorf("unexpected response status %d", resp.StatusCode))
+		if code == DeliveryErrorRateLimited || resp.StatusCode == http.StatusServiceUnavailable {
+			d.applyRetryAfter(delivery, resp.Header.Get("Retry-After"))
+		}
+		if code == DeliveryErrorGone {
+			if err := d.deactivateWebhook(ctx, task.Webhook); err != nil {
+				delivery.Error = fmt.Sprintf("%s; failed to deactivate webhook: %v", delivery.Error, err)
+			}
+		}
+	case readErr != nil:
+		d.fail(delivery, task.Attempt, DeliveryErrorResponseRead, readErr)
+	default:
+		delivery.Success = true
+		now := d.clock.Now()
+		delivery.DeliveredAt = &now
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Webhook-ID", task.Webhook.ID)
-	req.Header.Set("X-Event-ID", task.Event.ID)
-	req.Header.Set("X-Event-Type", string(task.Event.Type))
+	for _, hook := range d.afterResp {
+		hook(ctx, resp, delivery)
+	}
+	return d.finalizeDelivery(ctx, task, delivery, req)
+}
+
+// fail records a failed attempt and schedules a retry when the failure is retryable
+func (d *DefaultWebhookDeliverer) fail(delivery *Delivery, attempt int, code DeliveryErrorCode, err error) {
+	delivery.Success = false
+	delivery.ErrorCode = code
+	delivery.Error = err.Error()
+	delivery.NextRetryAt = nil
+	if code.Retryable() {
+		delivery.NextRetryAt = d.nextRetryTime(attempt)
+	}
+}
 
-	for key, value := range task.Webhook.Headers {
+// applyRetryAfter replaces the scheduled retry with the receiver's Retry-After hint
+func (d *DefaultWebhookDeliverer) applyRetryAfter(delivery *Delivery, header string) {
+	if delivery.NextRetryAt == nil {
+		return
+	}
+	now := d.clock.Now()
+	delay, ok := parseRetryAfter(header, now)
+	if !ok {
+		return
+	}
+	next := now.Add(delay)
+	delivery.NextRetryAt = &next
+}
+
+// deactivateWebhook disables a webhook whose endpoint answered 410 Gone
+func (d *DefaultWebhookDeliverer) deactivateWebhook(ctx context.Context, webhook *Webhook) error {
+	if d.deactivator == nil || webhook.ID == "" {
+		return nil
+	}
+	// Deactivation is the deliverer's own decision, whoever published the event
+	return d.deactivator.DeactivateWebhook(WithOperator(ctx), webhook.ID)
+}
+
+// newRequest builds the signed HTTP request for an event and returns it with the encoded payload.
+func (d *DefaultWebhookDeliverer) newRequest(ctx context.Context, webhook *Webhook, event *Event) (*http.Request, []byte, error) {
+	redacted, err := d.redactor.Redact(event, webhook.Redaction)
+	if err != nil {
+		return nil, nil, fmt.Errorf("redact event: %w", err)
+	}
+	payload, header, err := encodePayload(webhook.Format, d.eventSource, redacted)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The body is what is sent and signed. An encrypted body is recorded as a JSON string
+	// so the stored payload stays valid JSON.
+	body := payload
+	if webhook.Encryption != nil {
+		token, err := d.encryptPayload(ctx, webhook.Encryption, payload, header.Get("Content-Type"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("encrypt payload: %w", err)
+		}
+		body = []byte(token)
+		if payload, err = json.Marshal(token); err != nil {
+			return nil, nil, err
+		}
+		header.Set("Content-Type", JWEContentType)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, payload, err
+	}
+
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("X-Webhook-ID", webhook.ID)
+	req.Header.Set("X-Event-ID", event.ID)
+	req.Header.Set("X-Event-Type", string(event.Type))
+
+	for key, value := range webhook.Headers {
 		if value == "" {
 			continue
 		}
 		req.Header.Set(key, value)
 	}
 
-	if task.Webhook.Secret != "" {
-		signature := d.generateSignature(payload, task.Webhook.Secret)
+	if webhook.Secret != "" {
+		signature := d.generateSignature(body, webhook.Secret)
 		req.Header.Set("X-Webhook-Signature", signature)
 	}
 
-	resp, err := d.client.Do(req)
+	return req, payload, nil
+}
+
+// Test sends a one-off request to the webhook without recording a delivery or scheduling retries.
+// Transport failures are reported in the result; an error is returned only if no request could be built.
+func (d *DefaultWebhookDeliverer) Test(ctx context.Context, webhook *Webhook, event *Event) (*WebhookTestResult, error) {
+	if webhook == nil {
+		return nil, errors.New("webhook cannot be nil")
+	}
+	if event == nil {
+		return nil, errors.New("event cannot be nil")
+	}
+	if webhook.VerificationStatus == VerificationPending {
+		return nil, fmt.Errorf("webhook %s: %w", webhook.ID, ErrWebhookNotVerified)
+	}
+
+	req, _, err := d.newRequest(ctx, webhook, event)
 	if err != nil {
-		delivery.Success = false
-		delivery.Error = err.Error()
-		delivery.NextRetryAt = d.nextRetryTime(task.Attempt)
-		return d.finalizeDelivery(task, delivery, req)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	body, readErr := io.ReadAll(io.LimitReader(resp.Body, 1<<20)) // limit to 1MB
-	if readErr != nil {
-		delivery.Error = readErr.Error()
+	client, err := d.clientFor(webhook)
+	if err != nil {
+		return nil, err
 	}
-	delivery.Response = string(body)
-	delivery.StatusCode = resp.StatusCode
-	success := resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices
-	if readErr != nil {
-		success = false
+
+	start := d.clock.Now()
+	resp, err := client.Do(req)
+	result := &WebhookTestResult{ResponseTime: d.clock.Now().Sub(start)}
+	if err != nil {
+		result.Error = err.Error()
+		return result, nil
 	}
-	delivery.Success = success
-	if delivery.Success {
-		now := time.Now()
-		delivery.DeliveredAt = &now
-	} else {
-		delivery.NextRetryAt = d.nextRetryTime(task.Attempt)
-		if delivery.Error == "" {
-			delivery.Error = fmt.Sprintf("unexpected response status %d", resp.StatusCode)
-		}
+	defer resp.Body.Close()
+
+	body, readErr := io.ReadAll(io.LimitReader(resp.Body, maxResponseBodySize))
+	result.ResponseTime = d.clock.Now().Sub(start)
+	result.StatusCode = resp.StatusCode
+	result.Headers = flattenHeaders(resp.Header)
+	result.Body = string(body)
+	result.Success = readErr == nil && resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices
+	if readErr != nil {
+		result.Error = readErr.Error()
+	} else if !result.Success {
+		result.Error = fmt.Sprintf("unexpected response status %d", resp.StatusCode)
 	}
+	return result, nil
+}
 
-	return d.finalizeDelivery(task, delivery, req)
+// ListDeliveries returns recorded deliveries for a webhook, newest first.
+func (d *DefaultWebhookDeliverer) ListDeliveries(ctx context.Context, webhookID string, filter *DeliveryFilter) ([]*Delivery, error) {
+	return d.store.ListDeliveries(ctx, webhookID, filter)
 }
 
-func (d *DefaultWebhookDeliverer) finalizeDelivery(task *DeliveryTask, delivery *Delivery, req *http.Request) *Delivery {
+func (d *DefaultWebhookDeliverer) finalizeDelivery(ctx context.Context, task *DeliveryTask, delivery *Delivery, req *http.Request) *Delivery {
 	if delivery == nil {
 		return nil
 	}
 	if delivery.DeliveredAt == nil && delivery.Success {
-		now := time.Now()
+		now := d.clock.Now()
 		delivery.DeliveredAt = &now
 	}
 	if req != nil {
 		delivery.Headers = flattenHeaders(req.Header)
 	}
-	d.ensureDeliveryID(delivery)
-	d.storeDelivery(task, delivery)
-	return delivery
-}
-
-func (d *DefaultWebhookDeliverer) ensureDeliveryID(delivery *Delivery) {
-	if delivery == nil || delivery.ID != "" {
-		return
+	if delivery.ID == "" {
+		delivery.ID = d.newID()
 	}
-	id := atomic.AddUint64(&d.sequence, 1)
-	delivery.ID = fmt.Sprintf("delivery-%d", id)
+	d.metrics.observeAttempt(delivery.WebhookID, delivery.StatusCode)
+	d.storeDelivery(ctx, task, delivery)
+	return delivery
 }
 
-func (d *DefaultWebhookDeliverer) storeDelivery(task *DeliveryTask, delivery *Delivery) {
-	if delivery == nil || delivery.ID == "" {
-		return
-	}
-	record := &deliveryRecord{
-		delivery: delivery,
-		webhook:  task.Webhook,
-		event:    task.Event,
+// storeDelivery records the attempt; a store failure is noted on the delivery rather than failing it
+func (d *DefaultWebhookDeliverer) storeDelivery(ctx context.Context, task *DeliveryTask, delivery *Delivery) {
+	record := &DeliveryRecord{Delivery: delivery, Webhook: task.Webhook, Event: task.Event}
+	if err := d.store.Save(ctx, record); err != nil {
+		note := fmt.Sprintf("failed to record delivery: %v", err)
+		if delivery.Error != "" {
+			note = delivery.Error + "; " + note
+		}
+		delivery.Error = note
 	}
-	d.deliveriesMu.Lock()
-	d.deliveries[delivery.ID] = record
-	d.deliveriesMu.Unlock()
 }
 
 func (d *DefaultWebhookDeliverer) nextRetryTime(attempt int) *time.Time {
 	if attempt >= d.maxRetries {
 		return nil
 	}
-	next := time.Now().Add(d.retryDelay)
+	next := d.clock.Now().Add(d.retryDelay)
 	return &next
 }
 
@@ -663,17 +937,30 @@ func (d *DefaultWebhookDeliverer) generateSignature(payload []byte, secret strin
 	return "sha256=" + hex.EncodeToString(h.Sum(nil))
 }
 
-// EventRouter routes events to appropriate handlers
+// EventRouter routes events to handlers registered by type and to the destinations of matching routing rules
 type EventRouter struct {
-	routes map[EventType][]EventHandler
-	mu     sync.RWMutex
+	routes         map[EventType][]EventHandler
+	rules          []*RoutingRule
+	dispatchers    map[DestinationType]RouteDispatcher
+	named          map[string]EventHandler
+	concurrency    int
+	handlerTimeout time.Duration
+	middleware     []HandlerMiddleware
+	mu             sync.RWMutex
 }
 
 // NewEventRouter creates a new event router
-func NewEventRouter() *EventRouter {
-	return &EventRouter{
-		routes: make(map[EventType][]EventHandler),
+func NewEventRouter(opts ...RouterOption) *EventRouter {
+	r := &EventRouter{
+		routes:      make(map[EventType][]EventHandler),
+		dispatchers: make(map[DestinationType]RouteDispatcher),
+		named:       make(map[string]EventHandler),
+		concurrency: 1,
+	}
+	for _, opt := range opts {
+		opt(r)
 	}
+	return r
 }
 
 // Register registers a handler for an event type
@@ -684,21 +971,26 @@ func (r *EventRouter) Register(eventType EventType, handler EventHandler) {
 	r.routes[eventType] = append(r.routes[eventType], handler)
 }
 
-// Route routes an event to registered handlers
+// Route routes an event to registered handlers and routing rule destinations. Handler
+// panics and timeouts become errors; all failures are returned together as a *RouteError.
 func (r *EventRouter) Route(ctx context.Context, event *Event) error {
 	r.mu.RLock()
 	handlers := r.routes[event.Type]
 	r.mu.RUnlock()
 
-	var errs []error
-	for _, handler := range handlers {
-		if err := handler(ctx, event); err != nil {
-			errs = append(errs, err)
-		}
+	tasks := make([]routeTask, 0, len(handlers))
+	for i, handler := range handlers {
+		tasks = append(tasks, routeTask{
+			info:    HandlerInfo{Name: fmt.Sprintf("%s[%d]", event.Type, i), EventType: event.Type},
+			handler: handler,
+		})
 	}
+	ruleTasks, errs := r.ruleTasks(event)
+	tasks = append(tasks, ruleTasks...)
+	errs = append(errs, r.runTasks(ctx, event, tasks)...)
 
 	if len(errs) > 0 {
-		return fmt.Errorf("handler errors: %v", errs)
+		return &RouteError{Errors: errs}
 	}
 
 	return nil
@@ -719,6 +1011,11 @@ type DeadLetterQueue interface {
 	Delete(ctx context.Context, deliveryID string) error
 }
 
+// DeadLetterQueueSizer is implemented by dead letter queues that can report how many deliveries they hold
+type DeadLetterQueueSizer interface {
+	Size(ctx context.Context) (int, error)
+}
+
 // EventAggregator aggregates events
 type EventAggregator interface {
 	// Aggregate aggregates events over a time window
@@ -730,13 +1027,15 @@ type EventAggregator interface {
 
 // AggregatedEvents represents aggregated events
 type AggregatedEvents struct {
-	Window    time.Duration       `json:"window"`
-	StartTime time.Time           `json:"start_time"`
-	EndTime   time.Time           `json:"end_time"`
-	Total     int64               `json:"total"`
-	ByType    map[EventType]int64 `json:"by_type"`
-	ByUser    map[string]int64    `json:"by_user"`
-	Trends    []Trend             `json:"trends"`
+	Window     time.Duration       `json:"window"`
+	StartTime  time.Time           `json:"start_time"`
+	EndTime    time.Time           `json:"end_time"`
+	Total      int64               `json:"total"`
+	Errors     int64               `json:"errors"`
+	ByType     map[EventType]int64 `json:"by_type"`
+	ByUser     map[string]int64    `json:"by_user"`
+	ByPriority map[Priority]int64  `json:"by_priority,omitempty"`
+	Trends     []Trend             `json:"trends"`
 }
 
 // EventStatistics represents event statistics
@@ -0,0 +1,234 @@
+package events
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Filter operators understood by MatchFilters
+const (
+	FilterEquals     = "eq"
+	FilterNotEquals  = "ne"
+	FilterIn         = "in"
+	FilterNotIn      = "not_in"
+	FilterContains   = "contains"
+	FilterStartsWith = "starts_with"
+	FilterEndsWith   = "ends_with"
+	FilterExists     = "exists"
+	FilterGreater    = "gt"
+	FilterGreaterEq  = "gte"
+	FilterLess       = "lt"
+	FilterLessEq     = "lte"
+)
+
+// MatchFilters reports whether the event satisfies every filter. Fields name event
+// attributes (type, priority, user_id, session_id, ip, user_agent, resource, action,
+// result) or dotted paths into data and metadata, e.g. "data.mfa.method".
+func MatchFilters(event *Event, filters []Filter) (bool, error) {
+	for _, filter := range filters {
+		ok, err := matchFilter(event, filter)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchFilter(event *Event, filter Filter) (bool, error) {
+	actual, found := EventField(event, filter.Field)
+	operator := filter.Operator
+	if operator == "" {
+		operator = FilterEquals
+	}
+
+	switch operator {
+	case FilterExists:
+		want := true
+		if b, ok := filter.Value.(bool); ok {
+			want = b
+		}
+		return found == want, nil
+	case FilterEquals:
+		return found && valuesEqual(actual, filter.Value), nil
+	case FilterNotEquals:
+		return !found || !valuesEqual(actual, filter.Value), nil
+	case FilterIn, FilterNotIn:
+		values, ok := listValues(filter.Value)
+		if !ok {
+			return false, fmt.Errorf("filter %s: %s requires a list value", filter.Field, operator)
+		}
+		in := false
+		for _, v := range values {
+			if found && valuesEqual(actual, v) {
+				in = true
+				break
+			}
+		}
+		return in == (operator == FilterIn), nil
+	case FilterContains, FilterStartsWith, FilterEndsWith:
+		if !found {
+			return false, nil
+		}
+		s, want := fmt.Sprint(actual), fmt.Sprint(filter.Value)
+		switch operator {
+		case FilterContains:
+			if list, ok := listValues(actual); ok {
+				for _, item := range list {
+					if valuesEqual(item, filter.Value) {
+						return true, nil
+					}
+				}
+				return false, nil
+			}
+			return strings.Contains(s, want), nil
+		case FilterStartsWith:
+			return strings.HasPrefix(s, want), nil
+		default:
+			return strings.HasSuffix(s, want), nil
+		}
+	case FilterGreater, FilterGreaterEq, FilterLess, FilterLessEq:
+		if !found {
+			return false, nil
+		}
+		a, aok := toFloat(actual)
+		b, bok := toFloat(filter.Value)
+		if !aok || !bok {
+			return false, fmt.Errorf("filter %s: %s requires numeric values", filter.Field, operator)
+		}
+		switch operator {
+		case FilterGreater:
+			return a > b, nil
+		case FilterGreaterEq:
+			return a >= b, nil
+		case FilterLess:
+			return a < b, nil
+		default:
+			return a <= b, nil
+		}
+	default:
+		return false, fmt.Errorf("filter %s: unknown operator %q", filter.Field, operator)
+	}
+}
+
+// EventField resolves a filter field path against an event
+func EventField(event *Event, field string) (interface{}, bool) {
+	if event == nil {
+		return nil, false
+	}
+	root, rest, nested := strings.Cut(field, ".")
+	switch root {
+	case "data":
+		if !nested {
+			return event.Data, event.Data != nil
+		}
+		return lookupPath(event.Data, rest)
+	case "metadata":
+		if !nested {
+			return event.Metadata, event.Metadata != nil
+		}
+		return lookupPath(event.Metadata, rest)
+	}
+	if nested {
+		return nil, false
+	}
+
+	var value string
+	switch field {
+	case "id":
+		value = event.ID
+	case "type":
+		value = string(event.Type)
+	case "priority":
+		value = string(event.Priority)
+	case "user_id":
+		value = event.UserID
+	case "session_id":
+		value = event.SessionID
+	case "ip":
+		value = event.IP
+	case "user_agent":
+		value = event.UserAgent
+	case "resource":
+		value = event.Resource
+	case "action":
+		value = event.Action
+	case "result":
+		value = event.Result
+	default:
+		return nil, false
+	}
+	return value, value != ""
+}
+
+func lookupPath(m map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = m
+	for _, key := range strings.Split(path, ".") {
+		next, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = next[key]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// listValues converts any slice into []interface{}
+func listValues(v interface{}) ([]interface{}, bool) {
+	if list, ok := v.([]interface{}); ok {
+		return list, true
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice {
+		return nil, false
+	}
+	list := make([]interface{}, rv.Len())
+	for i := range list {
+		list[i] = rv.Index(i).Interface()
+	}
+	return list, true
+}
+
+// valuesEqual compares decoded JSON values, treating all numeric types alike
+func valuesEqual(a, b interface{}) bool {
+	if af, ok := toFloat(a); ok {
+		if bf, ok := toFloat(b); ok {
+			return af == bf
+		}
+	}
+	if as, ok := a.(string); ok {
+		if bs, ok := b.(string); ok {
+			return as == bs
+		}
+		return as == fmt.Sprint(b)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,179 @@
+package events
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"goat/internal/metrics"
+)
+
+// deadLetterSizeTimeout bounds reading dead letter queue sizes during a scrape
+const deadLetterSizeTimeout = 5 * time.Second
+
+// Metrics instruments webhook delivery, the dead letter queue and the event bus.
+// A nil *Metrics records nothing.
+type Metrics struct {
+	inFlight   *metrics.Gauge
+	attempts   *metrics.Counter
+	latency    *metrics.Histogram
+	busPublish *metrics.Histogram
+	busHandler *metrics.Histogram
+
+	queuesMu    sync.Mutex
+	queues      map[int]func() int
+	nextQueue   int
+	deadLetters []DeadLetterQueueSizer
+}
+
+// NewMetrics registers the events metrics in registry
+func NewMetrics(registry *metrics.Registry) *Metrics {
+	m := &Metrics{
+		inFlight: registry.NewGauge("goat_webhook_deliveries_in_flight",
+			"Webhook requests currently awaiting a response"),
+		attempts: registry.NewCounter("goat_webhook_delivery_attempts_total",
+			"Webhook delivery attempts by outcome; status_class is 2xx to 5xx, or error when no response was received",
+			"webhook_id", "status_class"),
+		latency: registry.NewHistogram("goat_webhook_delivery_duration_seconds",
+			"Time from sending a webhook request to reading its response", nil, "webhook_id"),
+		busPublish: registry.NewHistogram("goat_event_bus_publish_duration_seconds",
+			"Time taken to publish an event to the bus", nil, "event_type", "result"),
+		busHandler: registry.NewHistogram("goat_event_bus_handler_duration_seconds",
+			"Time taken by bus subscribers to handle an event", nil, "event_type", "result"),
+		queues: make(map[int]func() int),
+	}
+	registry.NewGaugeFunc("goat_webhook_queue_length",
+		"Delivery tasks waiting for a webhook worker", m.queueLength)
+	registry.NewGaugeFunc("goat_dead_letter_queue_size",
+		"Deliveries held in the dead letter queue", m.deadLetterSize)
+	return m
+}
+
+// trackQueue adds a deliverer's queue to the queue length gauge and returns the function that removes it
+func (m *Metrics) trackQueue(length func() int) func() {
+	if m == nil {
+		return func() {}
+	}
+	m.queuesMu.Lock()
+	defer m.queuesMu.Unlock()
+	id := m.nextQueue
+	m.nextQueue++
+	m.queues[id] = length
+	return func() {
+		m.queuesMu.Lock()
+		delete(m.queues, id)
+		m.queuesMu.Unlock()
+	}
+}
+
+func (m *Metrics) queueLength() float64 {
+	m.queuesMu.Lock()
+	defer m.queuesMu.Unlock()
+	total := 0
+	for _, length := range m.queues {
+		total += length()
+	}
+	return float64(total)
+}
+
+// startRequest marks a webhook request as in flight and returns the function that ends it
+func (m *Metrics) startRequest() func() {
+	if m == nil {
+		return func() {}
+	}
+	m.inFlight.Inc()
+	return func() { m.inFlight.Dec() }
+}
+
+// observeAttempt records the outcome of a delivery attempt; statusCode is 0 when no response was received
+func (m *Metrics) observeAttempt(webhookID string, statusCode int) {
+	if m == nil {
+		return
+	}
+	m.attempts.Inc(webhookID, statusClass(statusCode))
+}
+
+func (m *Metrics) observeLatency(webhookID string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.latency.Observe(duration.Seconds(), webhookID)
+}
+
+func statusClass(statusCode int) string {
+	if statusCode < 100 || statusCode > 599 {
+		return "error"
+	}
+	return strconv.Itoa(statusCode/100) + "xx"
+}
+
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// InstrumentDeadLetterQueue reports the size of dlq in the dead letter queue gauge, read from the
+// queue on each scrape. Queues that do not implement DeadLetterQueueSizer are not counted.
+func InstrumentDeadLetterQueue(dlq DeadLetterQueue, m *Metrics) DeadLetterQueue {
+	sizer, ok := dlq.(DeadLetterQueueSizer)
+	if m == nil || !ok {
+		return dlq
+	}
+	m.queuesMu.Lock()
+	m.deadLetters = append(m.deadLetters, sizer)
+	m.queuesMu.Unlock()
+	return dlq
+}
+
+// deadLetterSize sums the sizes of the instrumented dead letter queues, skipping any that fail
+func (m *Metrics) deadLetterSize() float64 {
+	m.queuesMu.Lock()
+	sizers := append([]DeadLetterQueueSizer(nil), m.deadLetters...)
+	m.queuesMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), deadLetterSizeTimeout)
+	defer cancel()
+	total := 0
+	for _, sizer := range sizers {
+		if size, err := sizer.Size(ctx); err == nil {
+			total += size
+		}
+	}
+	return float64(total)
+}
+
+// InstrumentEventBus times publishes on bus and the handlers subscribed through it
+func InstrumentEventBus(bus EventBus, m *Metrics) EventBus {
+	if m == nil {
+		return bus
+	}
+	return &instrumentedEventBus{EventBus: bus, metrics: m}
+}
+
+type instrumentedEventBus struct {
+	EventBus
+	metrics *Metrics
+}
+
+func (b *instrumentedEventBus) Publish(ctx context.Context, event *Event) error {
+	start := time.Now()
+	err := b.EventBus.Publish(ctx, event)
+	var eventType EventType
+	if event != nil {
+		eventType = event.Type
+	}
+	b.metrics.busPublish.Observe(time.Since(start).Seconds(), string(eventType), resultLabel(err))
+	return err
+}
+
+func (b *instrumentedEventBus) Subscribe(ctx context.Context, types []EventType, handler EventHandler) (string, error) {
+	return b.EventBus.Subscribe(ctx, types, func(ctx context.Context, event *Event) error {
+		start := time.Now()
+		err := handler(ctx, event)
+		b.metrics.busHandler.Observe(time.Since(start).Seconds(), string(event.Type), resultLabel(err))
+		return err
+	})
+}
@@ -0,0 +1,113 @@
+package events
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// unmarshalJSONColumn decodes a nullable JSONB column
+func unmarshalJSONColumn(data []byte, v interface{}) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+// marshalJSONColumn encodes a value for a nullable JSONB column, mapping empty values to NULL
+func marshalJSONColumn(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if string(data) == "null" {
+		return nil, nil
+	}
+	return data, nil
+}
+
+// formatTextArray encodes a string slice as a Postgres TEXT[] literal
+func formatTextArray(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		value = strings.ReplaceAll(value, `\`, `\\`)
+		value = strings.ReplaceAll(value, `"`, `\"`)
+		quoted[i] = `"` + value + `"`
+	}
+	return "{" + strings.Join(quoted, ",") + "}"
+}
+
+// parseTextArray decodes a Postgres TEXT[] literal into a string slice
+func parseTextArray(literal string) []string {
+	literal = strings.TrimSpace(literal)
+	if len(literal) < 2 || literal[0] != '{' || literal[len(literal)-1] != '}' {
+		return nil
+	}
+	body := literal[1 : len(literal)-1]
+	if body == "" {
+		return []string{}
+	}
+
+	var (
+		values  []string
+		current strings.Builder
+		quoted  bool
+		escaped bool
+	)
+	for _, r := range body {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			values = append(values, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	return append(values, current.String())
+}
+
+func eventTypesToStrings(types []EventType) []string {
+	result := make([]string, len(types))
+	for i, t := range types {
+		result[i] = string(t)
+	}
+	return result
+}
+
+func stringsToEventTypes(values []string) []EventType {
+	result := make([]EventType, len(values))
+	for i, v := range values {
+		result[i] = EventType(v)
+	}
+	return result
+}
+
+// eventColumns selects an events row in the order scanEvent expects
+const eventColumns = `id, tenant_id, event_type, priority, timestamp, COALESCE(actor_id, user_id::text, ''), COALESCE(session_id, ''),
+	COALESCE(host(ip_address), ''), COALESCE(user_agent, ''), COALESCE(resource, ''), COALESCE(action, ''),
+	COALESCE(result, ''), data, metadata`
+
+func scanEvent(row rowScanner) (*Event, error) {
+	var (
+		event    Event
+		data     []byte
+		metadata []byte
+	)
+	if err := row.Scan(&event.ID, &event.TenantID, &event.Type, &event.Priority, &event.Timestamp, &event.UserID, &event.SessionID,
+		&event.IP, &event.UserAgent, &event.Resource, &event.Action, &event.Result, &data, &metadata); err != nil {
+		return nil, err
+	}
+	if err := unmarshalJSONColumn(data, &event.Data); err != nil {
+		return nil, err
+	}
+	if err := unmarshalJSONColumn(metadata, &event.Metadata); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
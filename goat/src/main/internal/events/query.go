@@ -0,0 +1,631 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxQueryLength = 4096
+	maxQueryDepth  = 32
+)
+
+// ErrInvalidQuery is returned when an event query cannot be parsed
+var ErrInvalidQuery = errors.New("invalid event query")
+
+// QueryOp compares an event field with a value
+type QueryOp string
+
+const (
+	// QueryMatch is equality, except that a value ending in "*" matches by prefix
+	QueryMatch        QueryOp = ":"
+	QueryEquals       QueryOp = "="
+	QueryNotEquals    QueryOp = "!="
+	QueryGreater      QueryOp = ">"
+	QueryGreaterEqual QueryOp = ">="
+	QueryLess         QueryOp = "<"
+	QueryLessEqual    QueryOp = "<="
+)
+
+// queryColumns maps the event attributes a query may name to their events table expressions
+var queryColumns = map[string]string{
+	"id":         "id::text",
+	"type":       "event_type",
+	"priority":   "priority",
+	"user_id":    "actor_id",
+	"session_id": "session_id",
+	"ip":         "host(ip_address)",
+	"user_agent": "user_agent",
+	"resource":   "resource",
+	"action":     "action",
+	"result":     "result",
+	"timestamp":  "timestamp",
+}
+
+var priorityRank = map[Priority]int{PriorityLow: 0, PriorityNormal: 1, PriorityHigh: 2, PriorityCritical: 3}
+
+// Query is a parsed event query such as `type:security.* AND priority>=high AND data.country!=US`.
+//
+// Terms compare a field with a value: an event attribute (type, priority, timestamp, user_id,
+// session_id, ip, user_agent, resource, action, result, id) or a dotted path into data or metadata.
+// Terms combine with AND, OR, NOT and parentheses; adjacent terms are AND-ed. Bare values of
+// data and metadata fields are numbers, true or false when they parse as such; quote them to
+// compare as strings. Priorities order low < normal < high < critical, and timestamps are RFC 3339.
+type Query struct {
+	Expr QueryExpr
+}
+
+// QueryExpr is a node of a parsed query
+type QueryExpr interface {
+	// Match evaluates the expression against an event
+	Match(event *Event) bool
+	String() string
+	sql(b *querySQL) string
+}
+
+// QueryAnd matches when every operand matches
+type QueryAnd []QueryExpr
+
+// QueryOr matches when any operand matches
+type QueryOr []QueryExpr
+
+// QueryNot matches when its operand does not
+type QueryNot struct {
+	Expr QueryExpr
+}
+
+// QueryTerm compares one field with a value. Value is a string, float64, bool, Priority or time.Time.
+type QueryTerm struct {
+	Field string
+	Op    QueryOp
+	Value interface{}
+}
+
+// ParseQuery parses an event query. An empty query matches every event.
+func ParseQuery(text string) (*Query, error) {
+	if len(text) > maxQueryLength {
+		return nil, fmt.Errorf("%w: longer than %d characters", ErrInvalidQuery, maxQueryLength)
+	}
+	tokens, err := lexQuery(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens}
+	if len(tokens) == 0 {
+		return &Query{Expr: QueryAnd{}}, nil
+	}
+	expr, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidQuery, p.tokens[p.pos].text)
+	}
+	return &Query{Expr: expr}, nil
+}
+
+// Match reports whether the event satisfies the query. A nil query matches every event.
+func (q *Query) Match(event *Event) bool {
+	if q == nil {
+		return true
+	}
+	return q.Expr.Match(event)
+}
+
+// String returns the query in canonical form
+func (q *Query) String() string {
+	if q == nil {
+		return ""
+	}
+	return q.Expr.String()
+}
+
+// SQL compiles the query to a boolean expression over the events table. Values are passed as
+// parameters numbered from firstArg. Equality on data fields uses containment so the GIN index
+// on data can serve it.
+func (q *Query) SQL(firstArg int) (string, []interface{}) {
+	b := &querySQL{next: firstArg}
+	if q == nil {
+		return "TRUE", nil
+	}
+	return q.Expr.sql(b), b.args
+}
+
+// parseOptionalQuery parses text, returning nil when it is empty
+func parseOptionalQuery(text string) (*Query, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+	return ParseQuery(text)
+}
+
+func (e QueryAnd) Match(event *Event) bool {
+	for _, operand := range e {
+		if !operand.Match(event) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e QueryAnd) String() string { return joinQuery(e, " AND ") }
+
+func (e QueryAnd) sql(b *querySQL) string {
+	if len(e) == 0 {
+		return "TRUE"
+	}
+	return joinSQL(b, e, " AND ")
+}
+
+func (e QueryOr) Match(event *Event) bool {
+	for _, operand := range e {
+		if operand.Match(event) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e QueryOr) String() string { return joinQuery(e, " OR ") }
+
+func (e QueryOr) sql(b *querySQL) string { return joinSQL(b, e, " OR ") }
+
+func (e QueryNot) Match(event *Event) bool { return !e.Expr.Match(event) }
+
+func (e QueryNot) String() string { return "NOT " + groupQuery(e.Expr) }
+
+func (e QueryNot) sql(b *querySQL) string { return "NOT (" + e.Expr.sql(b) + ")" }
+
+func joinQuery(operands []QueryExpr, sep string) string {
+	parts := make([]string, len(operands))
+	for i, operand := range operands {
+		parts[i] = groupQuery(operand)
+	}
+	return strings.Join(parts, sep)
+}
+
+func groupQuery(expr QueryExpr) string {
+	switch e := expr.(type) {
+	case QueryAnd, QueryOr:
+		return "(" + e.String() + ")"
+	}
+	return expr.String()
+}
+
+func joinSQL(b *querySQL, operands []QueryExpr, sep string) string {
+	parts := make([]string, len(operands))
+	for i, operand := range operands {
+		parts[i] = "(" + operand.sql(b) + ")"
+	}
+	return strings.Join(parts, sep)
+}
+
+// Match evaluates the term. A missing field only satisfies !=.
+func (t QueryTerm) Match(event *Event) bool {
+	switch t.Field {
+	case "timestamp":
+		if event.Timestamp.IsZero() {
+			return t.Op == QueryNotEquals
+		}
+		return compareOrdered(t.Op, event.Timestamp.Compare(t.Value.(time.Time)))
+	case "priority":
+		rank, ok := priorityRank[event.Priority]
+		if !ok {
+			return t.Op == QueryNotEquals
+		}
+		return compareOrdered(t.Op, rank-priorityRank[t.Value.(Priority)])
+	}
+
+	actual, found := EventField(event, t.Field)
+	if !found {
+		return t.Op == QueryNotEquals
+	}
+	if prefix, ok := t.prefix(); ok {
+		s, isString := actual.(string)
+		return isString && strings.HasPrefix(s, prefix)
+	}
+	switch want := t.Value.(type) {
+	case float64:
+		_, isString := actual.(string)
+		got, ok := toFloat(actual)
+		if !ok || isString {
+			return t.Op == QueryNotEquals
+		}
+		return compareOrdered(t.Op, compareFloat(got, want))
+	case string:
+		got, ok := actual.(string)
+		if !ok {
+			return t.Op == QueryNotEquals
+		}
+		return compareOrdered(t.Op, strings.Compare(got, want))
+	default:
+		got, ok := actual.(bool)
+		equal := ok && got == t.Value.(bool)
+		return equal == (t.Op != QueryNotEquals)
+	}
+}
+
+// prefix returns the prefix a ":" term with a trailing "*" matches
+func (t QueryTerm) prefix() (string, bool) {
+	s, ok := t.Value.(string)
+	if t.Op != QueryMatch || !ok || !strings.HasSuffix(s, "*") {
+		return "", false
+	}
+	return strings.TrimSuffix(s, "*"), true
+}
+
+func (t QueryTerm) String() string {
+	var value string
+	switch v := t.Value.(type) {
+	case string:
+		value = v
+		if _, path := jsonPath(t.Field); v == "" || strings.ContainsAny(v, " \t\"()") || (path != nil && bareLiteral(v)) {
+			value = strconv.Quote(v)
+		}
+	case float64:
+		value = strconv.FormatFloat(v, 'g', -1, 64)
+	case time.Time:
+		value = v.Format(time.RFC3339Nano)
+	default:
+		value = fmt.Sprint(v)
+	}
+	return t.Field + string(t.Op) + value
+}
+
+func (t QueryTerm) sql(b *querySQL) string {
+	if root, path := jsonPath(t.Field); path != nil {
+		return "COALESCE(" + t.jsonSQL(b, root, path) + ", FALSE)"
+	}
+	column := queryColumns[t.Field]
+	switch {
+	case t.Field == "priority" && t.Op != QueryMatch && t.Op != QueryEquals && t.Op != QueryNotEquals:
+		var allowed []string
+		for _, priority := range []Priority{PriorityLow, PriorityNormal, PriorityHigh, PriorityCritical} {
+			if compareOrdered(t.Op, priorityRank[priority]-priorityRank[t.Value.(Priority)]) {
+				allowed = append(allowed, b.arg(string(priority)))
+			}
+		}
+		if len(allowed) == 0 {
+			return "FALSE"
+		}
+		return "COALESCE(" + column + " IN (" + strings.Join(allowed, ", ") + "), FALSE)"
+	case t.Op == QueryNotEquals:
+		return column + " IS DISTINCT FROM " + b.arg(sqlValue(t.Value))
+	}
+	if prefix, ok := t.prefix(); ok {
+		return "COALESCE(" + column + " LIKE " + b.arg(likePrefix(prefix)) + ", FALSE)"
+	}
+	return "COALESCE(" + column + " " + sqlOp(t.Op) + " " + b.arg(sqlValue(t.Value)) + ", FALSE)"
+}
+
+func (t QueryTerm) jsonSQL(b *querySQL, root string, path []string) string {
+	pathArg := b.arg(formatTextArray(path))
+	field := root + " #> " + pathArg + "::text[]"
+	text := root + " #>> " + pathArg + "::text[]"
+	if prefix, ok := t.prefix(); ok {
+		return "jsonb_typeof(" + field + ") = 'string' AND " + text + " LIKE " + b.arg(likePrefix(prefix))
+	}
+	value, _ := json.Marshal(t.Value)
+	switch t.Op {
+	case QueryMatch, QueryEquals:
+		containment := t.Value
+		for i := len(path) - 1; i >= 0; i-- {
+			containment = map[string]interface{}{path[i]: containment}
+		}
+		contained, _ := json.Marshal(containment)
+		return root + " @> " + b.arg(string(contained)) + "::jsonb AND " + field + " = " + b.arg(string(value)) + "::jsonb"
+	case QueryNotEquals:
+		return field + " IS DISTINCT FROM " + b.arg(string(value)) + "::jsonb"
+	}
+	if number, ok := t.Value.(float64); ok {
+		return "jsonb_typeof(" + field + ") = 'number' AND (" + text + ")::numeric " + sqlOp(t.Op) + " " + b.arg(number)
+	}
+	return "jsonb_typeof(" + field + `) = 'string' AND ` + text + ` COLLATE "C" ` + sqlOp(t.Op) + " " + b.arg(t.Value)
+}
+
+type querySQL struct {
+	next int
+	args []interface{}
+}
+
+func (b *querySQL) arg(value interface{}) string {
+	b.args = append(b.args, value)
+	placeholder := fmt.Sprintf("$%d", b.next)
+	b.next++
+	return placeholder
+}
+
+func sqlOp(op QueryOp) string {
+	if op == QueryMatch {
+		return "="
+	}
+	return string(op)
+}
+
+func sqlValue(value interface{}) interface{} {
+	if priority, ok := value.(Priority); ok {
+		return string(priority)
+	}
+	return value
+}
+
+func likePrefix(prefix string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(prefix) + "%"
+}
+
+// jsonPath splits "data.a.b" into the column and path; path is nil for event attributes
+func jsonPath(field string) (string, []string) {
+	root, rest, nested := strings.Cut(field, ".")
+	if !nested || (root != "data" && root != "metadata") {
+		return "", nil
+	}
+	return root, strings.Split(rest, ".")
+}
+
+func compareOrdered(op QueryOp, cmp int) bool {
+	switch op {
+	case QueryMatch, QueryEquals:
+		return cmp == 0
+	case QueryNotEquals:
+		return cmp != 0
+	case QueryGreater:
+		return cmp > 0
+	case QueryGreaterEqual:
+		return cmp >= 0
+	case QueryLess:
+		return cmp < 0
+	default:
+		return cmp <= 0
+	}
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// bareLiteral reports whether an unquoted value would parse as a number or boolean
+func bareLiteral(s string) bool {
+	if s == "true" || s == "false" {
+		return true
+	}
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+type queryToken struct {
+	kind string // "(", ")", "AND", "OR", "NOT" or "term"
+	text string
+	term QueryTerm
+}
+
+func lexQuery(text string) ([]queryToken, error) {
+	var tokens []queryToken
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == '(' || c == ')':
+			tokens = append(tokens, queryToken{kind: string(c), text: string(c)})
+			i++
+			continue
+		}
+
+		start := i
+		for i < len(text) && isFieldChar(text[i]) {
+			i++
+		}
+		word := text[start:i]
+		switch strings.ToUpper(word) {
+		case "AND", "OR", "NOT":
+			if i == len(text) || !isOpChar(text[i]) {
+				tokens = append(tokens, queryToken{kind: strings.ToUpper(word), text: word})
+				continue
+			}
+		}
+		if word == "" {
+			return nil, fmt.Errorf("%w: unexpected %q at offset %d", ErrInvalidQuery, text[i:i+1], i)
+		}
+
+		opStart := i
+		for i < len(text) && isOpChar(text[i]) {
+			i++
+		}
+		op := QueryOp(text[opStart:i])
+		switch op {
+		case QueryMatch, QueryEquals, QueryNotEquals, QueryGreater, QueryGreaterEqual, QueryLess, QueryLessEqual:
+		default:
+			return nil, fmt.Errorf("%w: expected an operator after %q", ErrInvalidQuery, word)
+		}
+
+		raw, quoted, next, err := lexQueryValue(text, i)
+		if err != nil {
+			return nil, err
+		}
+		i = next
+		term, err := newQueryTerm(word, op, raw, quoted)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, queryToken{kind: "term", text: text[start:i], term: term})
+	}
+	return tokens, nil
+}
+
+func lexQueryValue(text string, i int) (value string, quoted bool, next int, err error) {
+	if i < len(text) && text[i] == '"' {
+		end := i + 1
+		for end < len(text) && text[end] != '"' {
+			if text[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(text) {
+			return "", false, 0, fmt.Errorf("%w: unterminated string", ErrInvalidQuery)
+		}
+		value, err := strconv.Unquote(text[i : end+1])
+		if err != nil {
+			return "", false, 0, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+		}
+		return value, true, end + 1, nil
+	}
+	start := i
+	for i < len(text) && !strings.ContainsRune(" \t\n\r()", rune(text[i])) {
+		i++
+	}
+	if i == start {
+		return "", false, 0, fmt.Errorf("%w: missing value at offset %d", ErrInvalidQuery, start)
+	}
+	return text[start:i], false, i, nil
+}
+
+func newQueryTerm(field string, op QueryOp, raw string, quoted bool) (QueryTerm, error) {
+	term := QueryTerm{Field: field, Op: op, Value: raw}
+	ordering := op == QueryGreater || op == QueryGreaterEqual || op == QueryLess || op == QueryLessEqual
+
+	if _, path := jsonPath(field); path != nil {
+		for _, key := range path {
+			if key == "" {
+				return term, fmt.Errorf("%w: invalid field %q", ErrInvalidQuery, field)
+			}
+		}
+		if !quoted {
+			if raw == "true" || raw == "false" {
+				term.Value = raw == "true"
+			} else if number, err := strconv.ParseFloat(raw, 64); err == nil {
+				term.Value = number
+			}
+		}
+		if _, isBool := term.Value.(bool); isBool && ordering {
+			return term, fmt.Errorf("%w: %s%s cannot compare booleans", ErrInvalidQuery, field, op)
+		}
+		return term, nil
+	}
+
+	if _, ok := queryColumns[field]; !ok {
+		return term, fmt.Errorf("%w: unknown field %q", ErrInvalidQuery, field)
+	}
+	switch field {
+	case "priority":
+		priority := Priority(raw)
+		if _, ok := priorityRank[priority]; !ok {
+			return term, fmt.Errorf("%w: unknown priority %q", ErrInvalidQuery, raw)
+		}
+		term.Value = priority
+	case "timestamp":
+		at, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			return term, fmt.Errorf("%w: timestamp must be RFC 3339, got %q", ErrInvalidQuery, raw)
+		}
+		term.Value = at
+	default:
+		if ordering {
+			return term, fmt.Errorf("%w: %s only supports :, = and !=", ErrInvalidQuery, field)
+		}
+	}
+	return term, nil
+}
+
+func isFieldChar(c byte) bool {
+	return c == '_' || c == '.' || c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func isOpChar(c byte) bool {
+	return c == ':' || c == '=' || c == '!' || c == '<' || c == '>'
+}
+
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+func (p *queryParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos].kind
+	}
+	return ""
+}
+
+func (p *queryParser) parseOr(depth int) (QueryExpr, error) {
+	if depth > maxQueryDepth {
+		return nil, fmt.Errorf("%w: nested more than %d levels", ErrInvalidQuery, maxQueryDepth)
+	}
+	var operands QueryOr
+	for {
+		operand, err := p.parseAnd(depth)
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+		if p.peek() != "OR" {
+			break
+		}
+		p.pos++
+	}
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return operands, nil
+}
+
+func (p *queryParser) parseAnd(depth int) (QueryExpr, error) {
+	var operands QueryAnd
+	for {
+		operand, err := p.parseUnary(depth)
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+		next := p.peek()
+		if next == "AND" {
+			p.pos++
+		} else if next != "term" && next != "(" && next != "NOT" {
+			break
+		}
+	}
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return operands, nil
+}
+
+func (p *queryParser) parseUnary(depth int) (QueryExpr, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected end of query", ErrInvalidQuery)
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+	switch token.kind {
+	case "NOT":
+		operand, err := p.parseUnary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return QueryNot{Expr: operand}, nil
+	case "(":
+		expr, err := p.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("%w: missing closing parenthesis", ErrInvalidQuery)
+		}
+		p.pos++
+		return expr, nil
+	case "term":
+		return token.term, nil
+	}
+	return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidQuery, token.text)
+}
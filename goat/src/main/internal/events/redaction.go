@@ -0,0 +1,419 @@
+package events
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// RedactionAction is what a redaction rule does to the values it selects
+type RedactionAction string
+
+const (
+	// RedactDrop removes the value; detected matches are replaced with "[redacted:<detector>]"
+	RedactDrop RedactionAction = "drop"
+	// RedactMask keeps the last four characters of values of eight or more characters and
+	// the domain of email addresses, replacing the rest with '*'
+	RedactMask RedactionAction = "mask"
+	// RedactHash replaces the value with a salted HMAC-SHA256, so equal values stay joinable
+	RedactHash RedactionAction = "hash"
+	// RedactTruncateIP zeroes the host part of IP addresses (/24 for IPv4, /48 for IPv6); other values are masked
+	RedactTruncateIP RedactionAction = "truncate_ip"
+)
+
+// Built-in detectors for RedactionRule.Detect
+const (
+	DetectEmail = "email"
+	DetectPhone = "phone"
+	DetectToken = "token"
+)
+
+var detectors = map[string]*regexp.Regexp{
+	DetectEmail: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+	DetectPhone: regexp.MustCompile(`(?:\+\d{1,3}[\s.\-]?)?\(?\b\d{3}\)?[\s.\-]?\d{3}[\s.\-]?\d{4}\b|\+\d{8,15}\b`),
+	DetectToken: regexp.MustCompile(`eyJ[\w-]+\.[\w-]+\.[\w-]+|(?i:bearer\s+)[\w\-.~+/]{16,}=*|\b(?:sk|pk|rk)_(?:live|test)_[A-Za-z0-9]{16,}\b|\b(?:ghp|gho|xox[abp])[_-][A-Za-z0-9-]{16,}\b|\b[A-Fa-f0-9]{40,}\b`),
+}
+
+// redactableFields are the fixed Event fields a rule may target besides data.* and metadata.* paths
+var redactableFields = map[string]bool{
+	"ip": true, "user_agent": true, "user_id": true, "session_id": true, "resource": true, "action": true, "result": true,
+}
+
+// RedactionRule selects values by Field, by Detect, or by both, and applies Action to them.
+// Field is a fixed field such as "ip" or a path such as "data.user.email"; a "*" segment matches
+// every key or list element. Detect finds emails, phone numbers or tokens inside strings,
+// anywhere in data and metadata, or under Field when it is set.
+type RedactionRule struct {
+	Field  string          `json:"field,omitempty"`
+	Detect string          `json:"detect,omitempty"`
+	Action RedactionAction `json:"action"`
+}
+
+// RedactionPolicy is the ordered list of rules applied to events before they reach a destination
+type RedactionPolicy struct {
+	Rules []RedactionRule `json:"rules"`
+}
+
+// SaltProvider returns the salt for hashing an event's values, typically looked up by the
+// event's tenant so hashes cannot be correlated across tenants
+type SaltProvider func(event *Event) ([]byte, error)
+
+// ValidateRedactionPolicy checks that every rule has a known action, field and detector
+func ValidateRedactionPolicy(policy *RedactionPolicy) error {
+	if policy == nil {
+		return nil
+	}
+	for i, rule := range policy.Rules {
+		switch rule.Action {
+		case RedactDrop, RedactMask, RedactHash, RedactTruncateIP:
+		default:
+			return fmt.Errorf("redaction rule %d: unknown action %q", i, rule.Action)
+		}
+		if rule.Field == "" && rule.Detect == "" {
+			return fmt.Errorf("redaction rule %d: field or detect is required", i)
+		}
+		if rule.Detect != "" && detectors[rule.Detect] == nil {
+			return fmt.Errorf("redaction rule %d: unknown detector %q", i, rule.Detect)
+		}
+		if rule.Field != "" && !redactableFields[rule.Field] {
+			root, rest, nested := strings.Cut(rule.Field, ".")
+			if !nested || rest == "" || (root != "data" && root != "metadata") {
+				return fmt.Errorf("redaction rule %d: field %s cannot be redacted", i, rule.Field)
+			}
+		}
+	}
+	return nil
+}
+
+// Redactor applies a baseline policy to every event and a destination's own policy on top,
+// so minimization is enforced no matter where events are sent
+type Redactor struct {
+	baseline *RedactionPolicy
+	salt     SaltProvider
+}
+
+// NewRedactor creates a redactor. baseline may be nil; salt is required only by hash rules.
+func NewRedactor(baseline *RedactionPolicy, salt SaltProvider) (*Redactor, error) {
+	if err := ValidateRedactionPolicy(baseline); err != nil {
+		return nil, err
+	}
+	return &Redactor{baseline: baseline, salt: salt}, nil
+}
+
+// Redact returns a redacted copy of the event, or the event itself when no rules apply.
+// A nil Redactor applies only the destination policy.
+func (r *Redactor) Redact(event *Event, policy *RedactionPolicy) (*Event, error) {
+	var (
+		baseline *RedactionPolicy
+		salt     SaltProvider
+	)
+	if r != nil {
+		baseline, salt = r.baseline, r.salt
+	}
+	var rules []RedactionRule
+	if baseline != nil {
+		rules = append(rules, baseline.Rules...)
+	}
+	if policy != nil {
+		rules = append(rules, policy.Rules...)
+	}
+	if len(rules) == 0 {
+		return event, nil
+	}
+
+	// Rules only walk generic maps and slices, so typed values such as map[string]string are
+	// normalized first; a value that cannot be normalized fails the event rather than leaking
+	copied := *event
+	out := &copied
+	for _, m := range []*map[string]interface{}{&out.Data, &out.Metadata} {
+		if *m == nil {
+			continue
+		}
+		normalized, err := normalizeJSONValue(*m)
+		if err != nil {
+			return nil, fmt.Errorf("redact event %s: %w", event.ID, err)
+		}
+		*m, _ = normalized.(map[string]interface{})
+	}
+	apply := &redaction{event: event, saltFn: salt}
+	for _, rule := range rules {
+		if err := apply.rule(out, rule); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+type redaction struct {
+	event  *Event
+	saltFn SaltProvider
+	salt   []byte
+	salted bool
+}
+
+func (r *redaction) rule(out *Event, rule RedactionRule) error {
+	transform := func(value interface{}) (interface{}, bool, error) {
+		return r.value(value, rule.Action)
+	}
+	if rule.Detect != "" {
+		pattern := detectors[rule.Detect]
+		if pattern == nil {
+			return fmt.Errorf("unknown detector %q", rule.Detect)
+		}
+		transform = func(value interface{}) (interface{}, bool, error) {
+			v, err := r.detect(value, pattern, rule.Detect, rule.Action)
+			return v, true, err
+		}
+	}
+
+	if rule.Field == "" {
+		for _, m := range []map[string]interface{}{out.Data, out.Metadata} {
+			for key, value := range m {
+				v, _, err := transform(value)
+				if err != nil {
+					return err
+				}
+				m[key] = v
+			}
+		}
+		return nil
+	}
+
+	if ptr := fixedRedactionField(out, rule.Field); ptr != nil {
+		if *ptr == "" {
+			return nil
+		}
+		v, keep, err := transform(*ptr)
+		if err != nil {
+			return err
+		}
+		s, _ := v.(string)
+		if !keep {
+			s = ""
+		}
+		*ptr = s
+		return nil
+	}
+
+	root, rest, _ := strings.Cut(rule.Field, ".")
+	m := out.Data
+	if root == "metadata" {
+		m = out.Metadata
+	}
+	if m == nil {
+		return nil
+	}
+	_, err := redactPath(m, strings.Split(rest, "."), transform)
+	return err
+}
+
+func fixedRedactionField(event *Event, field string) *string {
+	switch field {
+	case "ip":
+		return &event.IP
+	case "user_agent":
+		return &event.UserAgent
+	case "user_id":
+		return &event.UserID
+	case "session_id":
+		return &event.SessionID
+	case "resource":
+		return &event.Resource
+	case "action":
+		return &event.Action
+	case "result":
+		return &event.Result
+	}
+	return nil
+}
+
+type redactFunc func(value interface{}) (interface{}, bool, error)
+
+// redactPath applies fn to the values at keys below v, returning the new value of v
+func redactPath(v interface{}, keys []string, fn redactFunc) (interface{}, error) {
+	key, last := keys[0], len(keys) == 1
+	switch node := v.(type) {
+	case map[string]interface{}:
+		targets := []string{key}
+		if key == "*" {
+			targets = targets[:0]
+			for k := range node {
+				targets = append(targets, k)
+			}
+		}
+		for _, k := range targets {
+			child, ok := node[k]
+			if !ok {
+				continue
+			}
+			if last {
+				value, keep, err := fn(child)
+				if err != nil {
+					return nil, err
+				}
+				if keep {
+					node[k] = value
+				} else {
+					delete(node, k)
+				}
+				continue
+			}
+			value, err := redactPath(child, keys[1:], fn)
+			if err != nil {
+				return nil, err
+			}
+			node[k] = value
+		}
+		return node, nil
+	case []interface{}:
+		if key != "*" {
+			return node, nil
+		}
+		kept := node[:0]
+		for _, child := range node {
+			if last {
+				value, keep, err := fn(child)
+				if err != nil {
+					return nil, err
+				}
+				if keep {
+					kept = append(kept, value)
+				}
+				continue
+			}
+			value, err := redactPath(child, keys[1:], fn)
+			if err != nil {
+				return nil, err
+			}
+			kept = append(kept, value)
+		}
+		return kept, nil
+	default:
+		return v, nil
+	}
+}
+
+// value redacts a whole value, returning false when it should be removed
+func (r *redaction) value(value interface{}, action RedactionAction) (interface{}, bool, error) {
+	s, isString := value.(string)
+	if !isString {
+		if action == RedactDrop {
+			return nil, false, nil
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, false, err
+		}
+		s = string(encoded)
+	}
+	switch action {
+	case RedactDrop:
+		return nil, false, nil
+	case RedactMask:
+		return maskValue(s), true, nil
+	case RedactHash:
+		hashed, err := r.hash(s)
+		return hashed, err == nil, err
+	case RedactTruncateIP:
+		return truncateIP(s), true, nil
+	default:
+		return nil, false, fmt.Errorf("unknown redaction action %q", action)
+	}
+}
+
+// detect redacts detector matches inside strings, walking into maps and lists
+func (r *redaction) detect(value interface{}, pattern *regexp.Regexp, detector string, action RedactionAction) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		var firstErr error
+		redacted := pattern.ReplaceAllStringFunc(v, func(match string) string {
+			if action == RedactDrop {
+				return "[redacted:" + detector + "]"
+			}
+			out, _, err := r.value(match, action)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return match
+			}
+			return out.(string)
+		})
+		return redacted, firstErr
+	case map[string]interface{}:
+		for key, child := range v {
+			redacted, err := r.detect(child, pattern, detector, action)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = redacted
+		}
+		return v, nil
+	case []interface{}:
+		for i, child := range v {
+			redacted, err := r.detect(child, pattern, detector, action)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = redacted
+		}
+		return v, nil
+	default:
+		return value, nil
+	}
+}
+
+func (r *redaction) hash(s string) (string, error) {
+	if !r.salted {
+		r.salted = true
+		if r.saltFn != nil {
+			salt, err := r.saltFn(r.event)
+			if err != nil {
+				return "", fmt.Errorf("redaction salt: %w", err)
+			}
+			r.salt = salt
+		}
+	}
+	if len(r.salt) == 0 {
+		return "", errors.New("hash redaction requires a salt")
+	}
+	mac := hmac.New(sha256.New, r.salt)
+	mac.Write([]byte(s))
+	return "sha256:" + hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+func maskValue(s string) string {
+	if at := strings.LastIndex(s, "@"); at > 0 && detectors[DetectEmail].MatchString(s) {
+		return strings.Repeat("*", len([]rune(s[:at]))) + s[at:]
+	}
+	runes := []rune(s)
+	keep := 0
+	if len(runes) >= 8 {
+		keep = 4
+	}
+	return strings.Repeat("*", len(runes)-keep) + string(runes[len(runes)-keep:])
+}
+
+func truncateIP(s string) string {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return maskValue(s)
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
+
+func cloneRedactionPolicy(policy *RedactionPolicy) *RedactionPolicy {
+	if policy == nil {
+		return nil
+	}
+	return &RedactionPolicy{Rules: append([]RedactionRule(nil), policy.Rules...)}
+}
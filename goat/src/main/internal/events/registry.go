@@ -0,0 +1,301 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrUnknownEventType is returned when publishing a type that is not registered
+	ErrUnknownEventType = errors.New("unknown event type")
+	// ErrEventTypeRetired is returned when publishing a type past its deprecation date
+	ErrEventTypeRetired = errors.New("event type is past its deprecation date")
+)
+
+// eventTypeName matches dot separated lowercase segments such as "user.password.changed"
+var eventTypeName = regexp.MustCompile(`^[a-z][a-z0-9_]*(\.[a-z][a-z0-9_]*)+$`)
+
+// EventTypeDefinition describes an event type for consumers
+type EventTypeDefinition struct {
+	Type        EventType              `json:"type"`
+	Description string                 `json:"description"`
+	Version     int                    `json:"version"`
+	Sample      map[string]interface{} `json:"sample,omitempty"`
+	// DeprecatedAt is when publishers stop emitting the type; ReplacedBy names its successor
+	DeprecatedAt *time.Time `json:"deprecated_at,omitempty"`
+	ReplacedBy   EventType  `json:"replaced_by,omitempty"`
+	// AuditTypes are the audit.EventType names recorded for the same occurrence
+	AuditTypes   []string  `json:"audit_types,omitempty"`
+	RegisteredBy string    `json:"registered_by,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Deprecated reports whether the type is deprecated as of now
+func (d *EventTypeDefinition) Deprecated(now time.Time) bool {
+	return d.DeprecatedAt != nil && !now.Before(*d.DeprecatedAt)
+}
+
+// AuditEventTypes maps audit.EventType names to the event types published for the same occurrence.
+// Audit logs keep their own names; consumers use this mapping to correlate the two.
+var AuditEventTypes = map[string]EventType{
+	"auth.login":           EventUserLogin,
+	"auth.logout":          EventUserLogout,
+	"auth.token.refresh":   EventTokenRefreshed,
+	"auth.token.revoke":    EventTokenRevoked,
+	"mfa.enroll":           EventMFAEnabled,
+	"mfa.verify":           EventMFAVerified,
+	"mfa.disable":          EventMFADisabled,
+	"user.password.change": EventUserPasswordChanged,
+	"user.profile.update":  EventUserUpdated,
+	"permission.grant":     EventPermissionGranted,
+	"permission.revoke":    EventPermissionRevoked,
+	"config.change":        EventConfigChanged,
+	"security.alert":       EventSecurityAlert,
+	"ratelimit.exceed":     EventRateLimitExceeded,
+	"sso.login":            EventSSOLogin,
+}
+
+// EventTypeForAudit returns the event type for an audit.EventType name
+func EventTypeForAudit(auditType string) (EventType, bool) {
+	eventType, ok := AuditEventTypes[auditType]
+	return eventType, ok
+}
+
+var builtinEventTypes = map[EventType]string{
+	EventUserLogin:           "A user signed in",
+	EventUserLogout:          "A user signed out",
+	EventUserLoginFailed:     "A sign-in attempt failed",
+	EventTokenCreated:        "An access token was issued",
+	EventTokenRefreshed:      "An access token was refreshed",
+	EventTokenRevoked:        "An access token was revoked",
+	EventTokenExpired:        "An access token expired",
+	EventMFAEnabled:          "A user enabled multi-factor authentication",
+	EventMFADisabled:         "A user disabled multi-factor authentication",
+	EventMFAVerified:         "A multi-factor challenge succeeded",
+	EventMFAFailed:           "A multi-factor challenge failed",
+	EventMFADeviceAdded:      "An MFA device was registered",
+	EventMFADeviceRemoved:    "An MFA device was removed",
+	EventUserCreated:         "A user account was created",
+	EventUserUpdated:         "A user profile was updated",
+	EventUserDeleted:         "A user account was deleted",
+	EventUserPasswordChanged: "A user changed their password",
+	EventUserEmailVerified:   "A user verified their email address",
+	EventRoleCreated:         "A role was created",
+	EventRoleUpdated:         "A role was updated",
+	EventRoleDeleted:         "A role was deleted",
+	EventPermissionGranted:   "A permission was granted",
+	EventPermissionRevoked:   "A permission was revoked",
+	EventSecurityAlert:       "A security alert was raised",
+	EventSuspiciousActivity:  "Suspicious activity was detected",
+	EventBruteForceDetected:  "A brute force attack was detected",
+	EventRateLimitExceeded:   "A client exceeded its rate limit",
+	EventIPBlocked:           "An IP address was blocked",
+	EventSystemStarted:       "The service started",
+	EventSystemStopped:       "The service stopped",
+	EventConfigChanged:       "Configuration was changed",
+	EventKeyRotated:          "A signing or encryption key was rotated",
+	EventBackupCompleted:     "A backup completed",
+	EventSSOLogin:            "A user signed in through SSO",
+	EventSSOLogout:           "A user signed out through SSO",
+	EventSSOProviderAdded:    "An SSO provider was added",
+	EventSSOProviderRemoved:  "An SSO provider was removed",
+}
+
+// EventTypeRegistry holds the event types services publish. It is safe for concurrent use
+// and implements http.Handler for consumer discovery.
+type EventTypeRegistry struct {
+	mu    sync.RWMutex
+	types map[EventType]*EventTypeDefinition
+	now   func() time.Time
+}
+
+// NewEventTypeRegistry creates a registry holding GOAT's built-in event types at version 1
+func NewEventTypeRegistry() *EventTypeRegistry {
+	r := &EventTypeRegistry{types: make(map[EventType]*EventTypeDefinition), now: time.Now}
+	audit := make(map[EventType][]string)
+	for auditType, eventType := range AuditEventTypes {
+		audit[eventType] = append(audit[eventType], auditType)
+	}
+	for eventType, description := range builtinEventTypes {
+		sort.Strings(audit[eventType])
+		r.types[eventType] = &EventTypeDefinition{
+			Type:         eventType,
+			Description:  description,
+			Version:      1,
+			AuditTypes:   audit[eventType],
+			RegisteredBy: "goat",
+		}
+	}
+	return r
+}
+
+var defaultEventTypes = NewEventTypeRegistry()
+
+// DefaultEventTypeRegistry returns the registry used to validate webhook and subscription event types
+func DefaultEventTypeRegistry() *EventTypeRegistry {
+	return defaultEventTypes
+}
+
+// Register adds an event type or updates it to a newer schema version. Re-registering the
+// current version updates its description, sample and deprecation; older versions are rejected.
+func (r *EventTypeRegistry) Register(def EventTypeDefinition) error {
+	if !eventTypeName.MatchString(string(def.Type)) {
+		return fmt.Errorf("invalid event type name %q", def.Type)
+	}
+	if strings.TrimSpace(def.Description) == "" {
+		return fmt.Errorf("event type %s: description is required", def.Type)
+	}
+	if def.Version < 1 {
+		return fmt.Errorf("event type %s: version must be at least 1", def.Type)
+	}
+	if def.ReplacedBy != "" && !eventTypeName.MatchString(string(def.ReplacedBy)) {
+		return fmt.Errorf("event type %s: invalid replaced_by %q", def.Type, def.ReplacedBy)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.types[def.Type]; ok {
+		if def.Version < existing.Version {
+			return fmt.Errorf("event type %s: version %d is older than registered version %d", def.Type, def.Version, existing.Version)
+		}
+		if len(def.AuditTypes) == 0 {
+			def.AuditTypes = existing.AuditTypes
+		}
+	}
+	def.UpdatedAt = r.now().UTC()
+	r.types[def.Type] = cloneEventTypeDefinition(&def)
+	return nil
+}
+
+// Deprecate sets the date after which the type should no longer be published
+func (r *EventTypeRegistry) Deprecate(eventType EventType, at time.Time, replacedBy EventType) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	def, ok := r.types[eventType]
+	if !ok {
+		return fmt.Errorf("event type %s: %w", eventType, ErrUnknownEventType)
+	}
+	if replacedBy != "" {
+		if _, ok := r.types[replacedBy]; !ok {
+			return fmt.Errorf("replacement %s: %w", replacedBy, ErrUnknownEventType)
+		}
+	}
+	at = at.UTC()
+	def.DeprecatedAt = &at
+	def.ReplacedBy = replacedBy
+	def.UpdatedAt = r.now().UTC()
+	return nil
+}
+
+// Get returns the definition of an event type
+func (r *EventTypeRegistry) Get(eventType EventType) (*EventTypeDefinition, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	def, ok := r.types[eventType]
+	if !ok {
+		return nil, false
+	}
+	return cloneEventTypeDefinition(def), true
+}
+
+// IsRegistered reports whether the event type is registered
+func (r *EventTypeRegistry) IsRegistered(eventType EventType) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.types[eventType]
+	return ok
+}
+
+// List returns every registered event type sorted by name
+func (r *EventTypeRegistry) List() []*EventTypeDefinition {
+	r.mu.RLock()
+	result := make([]*EventTypeDefinition, 0, len(r.types))
+	for _, def := range r.types {
+		result = append(result, cloneEventTypeDefinition(def))
+	}
+	r.mu.RUnlock()
+	sort.Slice(result, func(i, j int) bool { return result[i].Type < result[j].Type })
+	return result
+}
+
+// matchesPrefix reports whether any registered type starts with prefix followed by a dot
+func (r *EventTypeRegistry) matchesPrefix(prefix string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for eventType := range r.types {
+		if strings.HasPrefix(string(eventType), prefix+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPublish returns an error if the type is unknown or past its deprecation date
+func (r *EventTypeRegistry) checkPublish(eventType EventType) error {
+	def, ok := r.Get(eventType)
+	if !ok {
+		return fmt.Errorf("event type %s: %w", eventType, ErrUnknownEventType)
+	}
+	if def.Deprecated(r.now()) {
+		if def.ReplacedBy != "" {
+			return fmt.Errorf("event type %s: %w; publish %s instead", eventType, ErrEventTypeRetired, def.ReplacedBy)
+		}
+		return fmt.Errorf("event type %s: %w", eventType, ErrEventTypeRetired)
+	}
+	return nil
+}
+
+// ServeHTTP serves GET / with every event type and the audit mapping, and GET /{type} with one
+// type. Mount it with http.StripPrefix. ?deprecated=false leaves out types past their deprecation date.
+func (r *EventTypeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	if name := strings.Trim(req.URL.Path, "/"); name != "" {
+		def, ok := r.Get(EventType(name))
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("unknown event type %q", name)})
+			return
+		}
+		json.NewEncoder(w).Encode(def)
+		return
+	}
+
+	types := r.List()
+	if req.URL.Query().Get("deprecated") == "false" {
+		now := r.now()
+		current := types[:0]
+		for _, def := range types {
+			if !def.Deprecated(now) {
+				current = append(current, def)
+			}
+		}
+		types = current
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"event_types": types, "audit_mapping": AuditEventTypes})
+}
+
+func cloneEventTypeDefinition(def *EventTypeDefinition) *EventTypeDefinition {
+	clone := *def
+	clone.AuditTypes = append([]string(nil), def.AuditTypes...)
+	if def.Sample != nil {
+		clone.Sample = deepCopyValue(def.Sample).(map[string]interface{})
+	}
+	if def.DeprecatedAt != nil {
+		at := *def.DeprecatedAt
+		clone.DeprecatedAt = &at
+	}
+	return &clone
+}
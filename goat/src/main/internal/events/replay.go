@@ -0,0 +1,621 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// ReplayHeader marks replayed deliveries; its value is the replay job ID
+	ReplayHeader = "X-Webhook-Replay"
+
+	defaultReplayRate      = 10.0
+	defaultReplayBatchSize = 100
+)
+
+var (
+	// ErrReplayJobNotFound is returned when a replay job does not exist
+	ErrReplayJobNotFound = errors.New("replay job not found")
+	// ErrReplayJobFinished is returned when a completed or cancelled job is resumed or cancelled
+	ErrReplayJobFinished = errors.New("replay job already finished")
+)
+
+// ReplayStatus represents the state of a replay job
+type ReplayStatus string
+
+const (
+	ReplayPending   ReplayStatus = "pending"
+	ReplayRunning   ReplayStatus = "running"
+	ReplayPaused    ReplayStatus = "paused"
+	ReplayCompleted ReplayStatus = "completed"
+	ReplayFailed    ReplayStatus = "failed"
+	ReplayCancelled ReplayStatus = "cancelled"
+)
+
+// Finished reports whether a job in this status can no longer be resumed
+func (s ReplayStatus) Finished() bool {
+	return s == ReplayCompleted || s == ReplayCancelled
+}
+
+// ReplayTarget selects where replayed events are delivered; exactly one field must be set
+type ReplayTarget struct {
+	WebhookID      string `json:"webhook_id,omitempty"`
+	SubscriptionID string `json:"subscription_id,omitempty"`
+}
+
+// ReplayRequest describes which events to resend and where
+type ReplayRequest struct {
+	Target ReplayTarget `json:"target"`
+	Filter EventFilter  `json:"filter"`
+	// RatePerSecond caps delivery throughput, defaulting to 10
+	RatePerSecond float64 `json:"rate_per_second,omitempty"`
+}
+
+// ReplayJob tracks the progress of a replay. Cursor counts events read from the source,
+// so an interrupted job resumes at Filter.Offset + Cursor.
+type ReplayJob struct {
+	ID            string       `json:"id" db:"id"`
+	Target        ReplayTarget `json:"target" db:"target"`
+	Filter        EventFilter  `json:"filter" db:"filter"`
+	RatePerSecond float64      `json:"rate_per_second" db:"rate_per_second"`
+	Status        ReplayStatus `json:"status" db:"status"`
+	Cursor        int          `json:"cursor" db:"cursor_offset"`
+	Succeeded     int          `json:"succeeded" db:"succeeded"`
+	Failed        int          `json:"failed" db:"failed"`
+	Skipped       int          `json:"skipped" db:"skipped"`
+	LastEventID   string       `json:"last_event_id,omitempty" db:"last_event_id"`
+	Error         string       `json:"error,omitempty" db:"error"`
+	CreatedAt     time.Time    `json:"created_at" db:"created_at"`
+	StartedAt     *time.Time   `json:"started_at,omitempty" db:"started_at"`
+	CompletedAt   *time.Time   `json:"completed_at,omitempty" db:"completed_at"`
+	UpdatedAt     time.Time    `json:"updated_at" db:"updated_at"`
+}
+
+// ReplayJobStore persists replay jobs so they can be resumed
+type ReplayJobStore interface {
+	// SaveReplayJob inserts or replaces a job
+	SaveReplayJob(ctx context.Context, job *ReplayJob) error
+
+	// GetReplayJob retrieves a job by ID
+	GetReplayJob(ctx context.Context, jobID string) (*ReplayJob, error)
+
+	// ListReplayJobs lists jobs, newest first
+	ListReplayJobs(ctx context.Context) ([]*ReplayJob, error)
+}
+
+// SubscriptionLookup resolves subscriptions targeted by a replay
+type SubscriptionLookup interface {
+	// GetSubscription retrieves a subscription by ID
+	GetSubscription(ctx context.Context, subscriptionID string) (*Subscription, error)
+}
+
+// SubscriptionPublisher hands events to queue and stream subscriptions. A SubscriptionLookup that
+// also implements it, such as DefaultEventService, lets replays target those subscriptions.
+type SubscriptionPublisher interface {
+	PublishToSubscription(ctx context.Context, subscription *Subscription, event *Event) error
+}
+
+type replaySink struct {
+	tenantID string
+	patterns []EventType
+	send     func(ctx context.Context, event *Event) error
+}
+
+type runningReplay struct {
+	cancel    context.CancelFunc
+	cancelled bool
+}
+
+// ReplayService resends historical events from an EventService to a webhook or subscription
+type ReplayService struct {
+	events        EventService
+	webhooks      WebhookService
+	subscriptions SubscriptionLookup
+	deliverer     WebhookDeliverer
+	store         ReplayJobStore
+	batchSize     int
+	clock         Clock
+
+	mu      sync.Mutex
+	running map[string]*runningReplay
+}
+
+// NewReplayService creates a new replay service. subscriptions may be nil if only webhooks are replayed to.
+func NewReplayService(events EventService, webhooks WebhookService, subscriptions SubscriptionLookup, deliverer WebhookDeliverer, store ReplayJobStore) *ReplayService {
+	return &ReplayService{
+		events:        events,
+		webhooks:      webhooks,
+		subscriptions: subscriptions,
+		deliverer:     deliverer,
+		store:         store,
+		batchSize:     defaultReplayBatchSize,
+		clock:         SystemClock(),
+		running:       make(map[string]*runningReplay),
+	}
+}
+
+// SetClock sets the clock used to timestamp jobs and pin open-ended filters. It must be
+// called before any job is created.
+func (s *ReplayService) SetClock(clock Clock) {
+	if clock != nil {
+		s.clock = clock
+	}
+}
+
+// CreateReplay validates a request and records it as a pending job. An open-ended
+// EndTime is pinned to now so events published during the replay are not picked up.
+func (s *ReplayService) CreateReplay(ctx context.Context, req *ReplayRequest) (*ReplayJob, error) {
+	if req == nil {
+		return nil, errors.New("replay request cannot be nil")
+	}
+	if (req.Target.WebhookID == "") == (req.Target.SubscriptionID == "") {
+		return nil, errors.New("replay target must name exactly one of webhook_id or subscription_id")
+	}
+	if req.RatePerSecond < 0 {
+		return nil, errors.New("rate_per_second cannot be negative")
+	}
+	if _, err := parseOptionalQuery(req.Filter.Query); err != nil {
+		return nil, err
+	}
+	if _, err := s.resolveSink(ctx, "", req.Target); err != nil {
+		return nil, err
+	}
+
+	now := s.clock.Now().UTC()
+	job := &ReplayJob{
+		ID:            newUUID(),
+		Target:        req.Target,
+		Filter:        req.Filter,
+		RatePerSecond: req.RatePerSecond,
+		Status:        ReplayPending,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if job.RatePerSecond == 0 {
+		job.RatePerSecond = defaultReplayRate
+	}
+	if job.Filter.EndTime == nil {
+		job.Filter.EndTime = &now
+	}
+	if err := s.store.SaveReplayJob(ctx, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// StartReplay creates a job and runs it in the background
+func (s *ReplayService) StartReplay(ctx context.Context, req *ReplayRequest) (*ReplayJob, error) {
+	job, err := s.CreateReplay(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.launch(job.ID); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// ResumeReplay continues a paused or failed job in the background from its cursor
+func (s *ReplayService) ResumeReplay(ctx context.Context, jobID string) (*ReplayJob, error) {
+	job, err := s.store.GetReplayJob(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status.Finished() {
+		return job, fmt.Errorf("replay job %s is %s: %w", jobID, job.Status, ErrReplayJobFinished)
+	}
+	if err := s.launch(job.ID); err != nil {
+		return job, err
+	}
+	return job, nil
+}
+
+// launch registers the job as running before handing it to a goroutine, so a
+// CancelReplay issued straight after StartReplay reaches the run
+func (s *ReplayService) launch(jobID string) error {
+	// The job was checked against the caller when it was created; the run acts for every tenant
+	ctx, cancel := context.WithCancel(WithOperator(context.Background()))
+	run, err := s.register(jobID, cancel)
+	if err != nil {
+		cancel()
+		return err
+	}
+	go func() {
+		defer cancel()
+		// The outcome is recorded on the job.
+		_ = s.run(ctx, jobID, run)
+	}()
+	return nil
+}
+
+func (s *ReplayService) register(jobID string, cancel context.CancelFunc) (*runningReplay, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.running[jobID]; ok {
+		return nil, fmt.Errorf("replay job %s is already running", jobID)
+	}
+	run := &runningReplay{cancel: cancel}
+	s.running[jobID] = run
+	return run, nil
+}
+
+// GetReplayJob returns the current state of a job
+func (s *ReplayService) GetReplayJob(ctx context.Context, jobID string) (*ReplayJob, error) {
+	return s.store.GetReplayJob(ctx, jobID)
+}
+
+// ListReplayJobs lists jobs, newest first
+func (s *ReplayService) ListReplayJobs(ctx context.Context) ([]*ReplayJob, error) {
+	return s.store.ListReplayJobs(ctx)
+}
+
+// CancelReplay stops a running job or marks an idle one cancelled
+func (s *ReplayService) CancelReplay(ctx context.Context, jobID string) error {
+	s.mu.Lock()
+	if run, ok := s.running[jobID]; ok {
+		run.cancelled = true
+		run.cancel()
+		s.mu.Unlock()
+		return nil
+	}
+	s.mu.Unlock()
+
+	job, err := s.store.GetReplayJob(ctx, jobID)
+	if err != nil {
+		return err
+	}
+	if job.Status.Finished() {
+		return fmt.Errorf("replay job %s is %s: %w", jobID, job.Status, ErrReplayJobFinished)
+	}
+	now := s.clock.Now().UTC()
+	job.Status = ReplayCancelled
+	job.CompletedAt = &now
+	job.UpdatedAt = now
+	return s.store.SaveReplayJob(ctx, job)
+}
+
+// Run processes a job synchronously until it completes, fails, is cancelled or ctx ends.
+// A job interrupted by ctx is left paused and can be resumed.
+func (s *ReplayService) Run(ctx context.Context, jobID string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	run, err := s.register(jobID, cancel)
+	if err != nil {
+		return err
+	}
+	return s.run(ctx, jobID, run)
+}
+
+func (s *ReplayService) run(ctx context.Context, jobID string, run *runningReplay) error {
+	defer func() {
+		s.mu.Lock()
+		delete(s.running, jobID)
+		s.mu.Unlock()
+	}()
+
+	job, err := s.store.GetReplayJob(ctx, jobID)
+	if err != nil {
+		return err
+	}
+	if job.Status.Finished() {
+		return fmt.Errorf("replay job %s is %s: %w", jobID, job.Status, ErrReplayJobFinished)
+	}
+
+	// Progress is saved even after ctx ends so the job can be resumed.
+	saveCtx := context.WithoutCancel(ctx)
+	now := s.clock.Now().UTC()
+	job.Status = ReplayRunning
+	job.Error = ""
+	if job.StartedAt == nil {
+		job.StartedAt = &now
+	}
+	job.UpdatedAt = now
+	if err := s.store.SaveReplayJob(saveCtx, job); err != nil {
+		return err
+	}
+
+	runErr := s.process(ctx, job)
+
+	s.mu.Lock()
+	cancelled := run.cancelled
+	s.mu.Unlock()
+
+	now = s.clock.Now().UTC()
+	job.UpdatedAt = now
+	switch {
+	case cancelled:
+		job.Status = ReplayCancelled
+		job.CompletedAt = &now
+		runErr = nil
+	case runErr == nil:
+		job.Status = ReplayCompleted
+		job.CompletedAt = &now
+	case errors.Is(runErr, context.Canceled) || errors.Is(runErr, context.DeadlineExceeded):
+		job.Status = ReplayPaused
+	default:
+		job.Status = ReplayFailed
+		job.Error = runErr.Error()
+	}
+	if err := s.store.SaveReplayJob(saveCtx, job); err != nil && runErr == nil {
+		runErr = err
+	}
+	return runErr
+}
+
+func (s *ReplayService) process(ctx context.Context, job *ReplayJob) error {
+	sink, err := s.resolveSink(ctx, job.ID, job.Target)
+	if err != nil {
+		return err
+	}
+
+	interval := time.Duration(float64(time.Second) / job.RatePerSecond)
+	var lastSend time.Time
+	for {
+		filter := job.Filter
+		filter.TenantID = sink.tenantID
+		filter.Offset = job.Filter.Offset + job.Cursor
+		filter.Limit = s.batchSize
+		if job.Filter.Limit > 0 {
+			remaining := job.Filter.Limit - job.Cursor
+			if remaining <= 0 {
+				return nil
+			}
+			if remaining < filter.Limit {
+				filter.Limit = remaining
+			}
+		}
+
+		batch, err := s.events.GetEvents(ctx, &filter)
+		if err != nil {
+			return fmt.Errorf("failed to load events: %w", err)
+		}
+
+		for _, event := range batch {
+			if event.TenantID != sink.tenantID || !MatchesEventTypes(sink.patterns, event.Type) {
+				job.Skipped++
+			} else {
+				if wait := interval - time.Since(lastSend); !lastSend.IsZero() && wait > 0 {
+					timer := time.NewTimer(wait)
+					select {
+					case <-ctx.Done():
+						timer.Stop()
+						return ctx.Err()
+					case <-timer.C:
+					}
+				}
+				lastSend = time.Now()
+				if err := sink.send(ctx, event); err != nil {
+					// A send cut short by cancellation is retried on resume rather than counted.
+					if ctx.Err() != nil {
+						return ctx.Err()
+					}
+					job.Failed++
+				} else {
+					job.Succeeded++
+				}
+			}
+			job.Cursor++
+			job.LastEventID = event.ID
+		}
+
+		job.UpdatedAt = s.clock.Now().UTC()
+		if err := s.store.SaveReplayJob(context.WithoutCancel(ctx), job); err != nil {
+			return err
+		}
+		if len(batch) < filter.Limit {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// resolveSink looks up the replay target and returns how to deliver to it
+func (s *ReplayService) resolveSink(ctx context.Context, jobID string, target ReplayTarget) (*replaySink, error) {
+	if target.WebhookID != "" {
+		webhook, err := s.webhooks.GetWebhook(ctx, target.WebhookID)
+		if err != nil {
+			return nil, err
+		}
+		if !webhook.Active {
+			return nil, fmt.Errorf("webhook %s is inactive", webhook.ID)
+		}
+		return s.webhookSink(jobID, webhook), nil
+	}
+
+	if s.subscriptions == nil {
+		return nil, errors.New("subscription replay is not configured")
+	}
+	subscription, err := s.subscriptions.GetSubscription(ctx, target.SubscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	if !subscription.Active {
+		return nil, fmt.Errorf("subscription %s is inactive", subscription.ID)
+	}
+	switch subscription.Type {
+	case "", SubscriptionWebhook:
+		return s.webhookSink(jobID, subscriptionWebhook(subscription)), nil
+	case SubscriptionQueue, SubscriptionStream:
+		publisher, ok := s.subscriptions.(SubscriptionPublisher)
+		if !ok {
+			return nil, fmt.Errorf("replay to %q subscriptions is not configured", subscription.Type)
+		}
+		return &replaySink{
+			tenantID: subscription.TenantID,
+			patterns: subscription.Events,
+			send: func(ctx context.Context, event *Event) error {
+				return publisher.PublishToSubscription(ctx, subscription, event)
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("replay to %q subscriptions is not supported", subscription.Type)
+	}
+}
+
+func (s *ReplayService) webhookSink(jobID string, webhook *Webhook) *replaySink {
+	marked := cloneWebhook(webhook)
+	if marked.Headers == nil {
+		marked.Headers = make(map[string]string)
+	}
+	marked.Headers[ReplayHeader] = jobID
+	return &replaySink{
+		tenantID: webhook.TenantID,
+		patterns: webhook.Events,
+		send: func(ctx context.Context, event *Event) error {
+			_, err := s.deliverer.Deliver(ctx, marked, event)
+			return err
+		},
+	}
+}
+
+// InMemoryReplayJobStore keeps replay jobs in memory
+type InMemoryReplayJobStore struct {
+	mu   sync.RWMutex
+	jobs map[string]*ReplayJob
+}
+
+// NewInMemoryReplayJobStore creates a new in-memory replay job store
+func NewInMemoryReplayJobStore() *InMemoryReplayJobStore {
+	return &InMemoryReplayJobStore{jobs: make(map[string]*ReplayJob)}
+}
+
+// SaveReplayJob stores a copy of the job
+func (s *InMemoryReplayJobStore) SaveReplayJob(ctx context.Context, job *ReplayJob) error {
+	stored := *job
+	s.mu.Lock()
+	s.jobs[job.ID] = &stored
+	s.mu.Unlock()
+	return nil
+}
+
+// GetReplayJob returns a copy of the job
+func (s *InMemoryReplayJobStore) GetReplayJob(ctx context.Context, jobID string) (*ReplayJob, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	job, ok := s.jobs[jobID]
+	if !ok {
+		return nil, fmt.Errorf("replay job %s: %w", jobID, ErrReplayJobNotFound)
+	}
+	stored := *job
+	return &stored, nil
+}
+
+// ListReplayJobs lists jobs, newest first
+func (s *InMemoryReplayJobStore) ListReplayJobs(ctx context.Context) ([]*ReplayJob, error) {
+	s.mu.RLock()
+	jobs := make([]*ReplayJob, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		stored := *job
+		jobs = append(jobs, &stored)
+	}
+	s.mu.RUnlock()
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+	return jobs, nil
+}
+
+// PostgresReplayJobStore persists replay jobs to event_replay_jobs
+type PostgresReplayJobStore struct {
+	db *sql.DB
+}
+
+// NewPostgresReplayJobStore creates a new Postgres-backed replay job store
+func NewPostgresReplayJobStore(db *sql.DB) *PostgresReplayJobStore {
+	return &PostgresReplayJobStore{db: db}
+}
+
+const replayJobColumns = `id, COALESCE(webhook_id::text, ''), COALESCE(subscription_id::text, ''), filter, rate_per_second,
+	status, cursor_offset, succeeded, failed, skipped, COALESCE(last_event_id::text, ''), COALESCE(error_message, ''),
+	created_at, started_at, completed_at, updated_at`
+
+// SaveReplayJob upserts a job
+func (s *PostgresReplayJobStore) SaveReplayJob(ctx context.Context, job *ReplayJob) error {
+	filter, err := marshalJSONColumn(job.Filter)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO event_replay_jobs (
+			id, webhook_id, subscription_id, filter, rate_per_second, status, cursor_offset, succeeded, failed, skipped,
+			last_event_id, error_message, created_at, started_at, completed_at, updated_at
+		) VALUES ($1, NULLIF($2, '')::uuid, NULLIF($3, '')::uuid, $4, $5, $6, $7, $8, $9, $10,
+			NULLIF($11, '')::uuid, NULLIF($12, ''), $13, $14, $15, $16)
+		ON CONFLICT (id) DO UPDATE SET
+			status = EXCLUDED.status,
+			cursor_offset = EXCLUDED.cursor_offset,
+			succeeded = EXCLUDED.succeeded,
+			failed = EXCLUDED.failed,
+			skipped = EXCLUDED.skipped,
+			last_event_id = EXCLUDED.last_event_id,
+			error_message = EXCLUDED.error_message,
+			started_at = EXCLUDED.started_at,
+			completed_at = EXCLUDED.completed_at,
+			updated_at = EXCLUDED.updated_at`,
+		job.ID, job.Target.WebhookID, job.Target.SubscriptionID, filter, job.RatePerSecond, string(job.Status),
+		job.Cursor, job.Succeeded, job.Failed, job.Skipped, job.LastEventID, job.Error,
+		job.CreatedAt, job.StartedAt, job.CompletedAt, job.UpdatedAt,
+	)
+	return err
+}
+
+// GetReplayJob retrieves a job by ID
+func (s *PostgresReplayJobStore) GetReplayJob(ctx context.Context, jobID string) (*ReplayJob, error) {
+	job, err := scanReplayJob(s.db.QueryRowContext(ctx, `SELECT `+replayJobColumns+` FROM event_replay_jobs WHERE id = $1`, jobID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("replay job %s: %w", jobID, ErrReplayJobNotFound)
+	}
+	return job, err
+}
+
+// ListReplayJobs lists jobs, newest first
+func (s *PostgresReplayJobStore) ListReplayJobs(ctx context.Context) ([]*ReplayJob, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+replayJobColumns+` FROM event_replay_jobs ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []*ReplayJob{}
+	for rows.Next() {
+		job, err := scanReplayJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+func scanReplayJob(row rowScanner) (*ReplayJob, error) {
+	var (
+		job         ReplayJob
+		filter      []byte
+		status      string
+		startedAt   sql.NullTime
+		completedAt sql.NullTime
+	)
+	if err := row.Scan(&job.ID, &job.Target.WebhookID, &job.Target.SubscriptionID, &filter, &job.RatePerSecond,
+		&status, &job.Cursor, &job.Succeeded, &job.Failed, &job.Skipped, &job.LastEventID, &job.Error,
+		&job.CreatedAt, &startedAt, &completedAt, &job.UpdatedAt); err != nil {
+		return nil, err
+	}
+	job.Status = ReplayStatus(status)
+	if err := unmarshalJSONColumn(filter, &job.Filter); err != nil {
+		return nil, err
+	}
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if completedAt.Valid {
+		job.CompletedAt = &completedAt.Time
+	}
+	return &job, nil
+}
@@ -0,0 +1,236 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrHandlerPanic wraps a panic recovered from a handler
+	ErrHandlerPanic = errors.New("handler panicked")
+	// ErrHandlerTimeout is returned when a handler outlives its timeout
+	ErrHandlerTimeout = errors.New("handler timed out")
+)
+
+// HandlerInfo identifies a handler invocation to middleware and in errors
+type HandlerInfo struct {
+	// Name is "<event type>[<index>]" for handlers added with Register and
+	// "rule:<rule name>/<destination type>:<target>" for routing rule destinations
+	Name      string
+	EventType EventType
+}
+
+// HandlerMiddleware wraps a handler, e.g. for logging, metrics or retries
+type HandlerMiddleware func(info HandlerInfo, next EventHandler) EventHandler
+
+// HandlerError is the failure of a single handler during Route
+type HandlerError struct {
+	Handler string
+	EventID string
+	Err     error
+}
+
+func (e *HandlerError) Error() string {
+	return fmt.Sprintf("handler %s: %v", e.Handler, e.Err)
+}
+
+func (e *HandlerError) Unwrap() error {
+	return e.Err
+}
+
+// RouteError aggregates the handler failures of one Route call. It unwraps to every
+// HandlerError, so errors.Is and errors.As see all of them.
+type RouteError struct {
+	Errors []*HandlerError
+}
+
+func (e *RouteError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (e *RouteError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+// RouterOption configures an EventRouter
+type RouterOption func(*EventRouter)
+
+// WithConcurrency runs up to n handlers at once; the default of 1 runs them in registration order
+func WithConcurrency(n int) RouterOption {
+	return func(r *EventRouter) {
+		if n > 0 {
+			r.concurrency = n
+		}
+	}
+}
+
+// WithHandlerTimeout bounds each handler call, including its middleware such as retries.
+// Route stops waiting for a handler that ignores its context once the timeout passes.
+func WithHandlerTimeout(timeout time.Duration) RouterOption {
+	return func(r *EventRouter) {
+		if timeout > 0 {
+			r.handlerTimeout = timeout
+		}
+	}
+}
+
+// WithMiddleware appends handler middleware; the first middleware is the outermost
+func WithMiddleware(middleware ...HandlerMiddleware) RouterOption {
+	return func(r *EventRouter) {
+		r.middleware = append(r.middleware, middleware...)
+	}
+}
+
+// LoggingMiddleware reports handler failures through logf, e.g. log.Printf
+func LoggingMiddleware(logf func(format string, args ...interface{})) HandlerMiddleware {
+	return func(info HandlerInfo, next EventHandler) EventHandler {
+		return func(ctx context.Context, event *Event) error {
+			start := time.Now()
+			err := next(ctx, event)
+			if err != nil {
+				logf("event handler %s failed for event %s after %s: %v", info.Name, event.ID, time.Since(start), err)
+			}
+			return err
+		}
+	}
+}
+
+// MetricsMiddleware reports the duration and outcome of every handler call
+func MetricsMiddleware(observe func(info HandlerInfo, duration time.Duration, err error)) HandlerMiddleware {
+	return func(info HandlerInfo, next EventHandler) EventHandler {
+		return func(ctx context.Context, event *Event) error {
+			start := time.Now()
+			err := next(ctx, event)
+			observe(info, time.Since(start), err)
+			return err
+		}
+	}
+}
+
+// RetryMiddleware retries a failing handler up to attempts times in total, waiting backoff
+// between attempts and doubling it each time
+func RetryMiddleware(attempts int, backoff time.Duration) HandlerMiddleware {
+	return func(info HandlerInfo, next EventHandler) EventHandler {
+		return func(ctx context.Context, event *Event) error {
+			delay := backoff
+			var err error
+			for attempt := 1; ; attempt++ {
+				if err = next(ctx, event); err == nil || attempt >= attempts {
+					return err
+				}
+				timer := time.NewTimer(delay)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return err
+				case <-timer.C:
+				}
+				delay *= 2
+			}
+		}
+	}
+}
+
+type routeTask struct {
+	info    HandlerInfo
+	handler EventHandler
+	// event replaces the routed event for this task, e.g. after a rule's transformations
+	event *Event
+}
+
+// runTasks executes the tasks with the router's concurrency, timeout and middleware
+func (r *EventRouter) runTasks(ctx context.Context, event *Event, tasks []routeTask) []*HandlerError {
+	results := make([]error, len(tasks))
+	eventFor := func(i int) *Event {
+		if tasks[i].event != nil {
+			return tasks[i].event
+		}
+		return event
+	}
+	run := func(i int, event *Event) {
+		task := tasks[i]
+		handler := task.handler
+		for j := len(r.middleware) - 1; j >= 0; j-- {
+			handler = r.middleware[j](task.info, handler)
+		}
+		// Guard the whole chain so middleware panics are recovered and retries share the timeout
+		results[i] = r.guard(handler)(ctx, event)
+	}
+
+	if r.concurrency <= 1 || len(tasks) <= 1 {
+		for i := range tasks {
+			run(i, eventFor(i))
+		}
+	} else {
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, r.concurrency)
+		for i := range tasks {
+			sem <- struct{}{}
+			wg.Add(1)
+			// Each concurrent handler gets its own copy so writes to Data or Metadata do not race
+			go func(i int, event *Event) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				run(i, event)
+			}(i, cloneEvent(eventFor(i)))
+		}
+		wg.Wait()
+	}
+
+	var errs []*HandlerError
+	for i, err := range results {
+		if err != nil {
+			errs = append(errs, &HandlerError{Handler: tasks[i].info.Name, EventID: event.ID, Err: err})
+		}
+	}
+	return errs
+}
+
+// guard turns panics into errors and applies the handler timeout
+func (r *EventRouter) guard(handler EventHandler) EventHandler {
+	call := func(ctx context.Context, event *Event) (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("%w: %v\n%s", ErrHandlerPanic, p, debug.Stack())
+			}
+		}()
+		return handler(ctx, event)
+	}
+	if r.handlerTimeout <= 0 {
+		return call
+	}
+
+	return func(ctx context.Context, event *Event) error {
+		ctx, cancel := context.WithTimeout(ctx, r.handlerTimeout)
+		defer cancel()
+		done := make(chan error, 1)
+		go func() {
+			done <- call(ctx, event)
+		}()
+		select {
+		case err := <-done:
+			if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) && errors.Is(err, context.DeadlineExceeded) {
+				return fmt.Errorf("%w after %s: %v", ErrHandlerTimeout, r.handlerTimeout, err)
+			}
+			return err
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("%w after %s", ErrHandlerTimeout, r.handlerTimeout)
+			}
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DestinationType names where a routing rule sends matching events
+type DestinationType string
+
+const (
+	// DestinationWebhook delivers to the webhook whose ID is the target
+	DestinationWebhook DestinationType = "webhook"
+	// DestinationBus calls the handler registered under the target name with RegisterNamed
+	DestinationBus DestinationType = "bus"
+	// DestinationStream publishes to the stream named by the target
+	DestinationStream DestinationType = "stream"
+	// DestinationDLQ parks the event in the dead letter queue
+	DestinationDLQ DestinationType = "dlq"
+)
+
+// RouteDestination is one destination of a routing rule
+type RouteDestination struct {
+	Type   DestinationType        `json:"type"`
+	Target string                 `json:"target,omitempty"`
+	Config map[string]interface{} `json:"config,omitempty"`
+}
+
+// RoutingRule is a row of event_routing_rules. Rules are evaluated in descending priority;
+// every matching rule dispatches its transformed event to all of its destinations.
+type RoutingRule struct {
+	ID              string             `json:"id" db:"id"`
+	Name            string             `json:"name" db:"name"`
+	Priority        int                `json:"priority" db:"priority"`
+	EventTypes      []EventType        `json:"event_types,omitempty" db:"event_types"`
+	Conditions      []Filter           `json:"conditions,omitempty" db:"conditions"`
+	Destinations    []RouteDestination `json:"destinations" db:"destinations"`
+	Transformations []TransformRule    `json:"transformations,omitempty" db:"transformations"`
+	Active          bool               `json:"active" db:"active"`
+	CreatedAt       time.Time          `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time          `json:"updated_at" db:"updated_at"`
+}
+
+// Matches reports whether the rule applies to the event
+func (r *RoutingRule) Matches(event *Event) (bool, error) {
+	if !r.Active || !MatchesEventTypes(r.EventTypes, event.Type) {
+		return false, nil
+	}
+	return MatchFilters(event, r.Conditions)
+}
+
+// ValidateRoutingRule checks a rule's patterns and destinations
+func ValidateRoutingRule(rule *RoutingRule) error {
+	if rule == nil {
+		return errors.New("routing rule cannot be nil")
+	}
+	for _, eventType := range rule.EventTypes {
+		if !isValidEventPattern(eventType) {
+			return fmt.Errorf("rule %s: unknown event type %q", rule.Name, eventType)
+		}
+	}
+	if len(rule.Destinations) == 0 {
+		return fmt.Errorf("rule %s: at least one destination is required", rule.Name)
+	}
+	for _, dest := range rule.Destinations {
+		switch dest.Type {
+		case DestinationWebhook, DestinationBus, DestinationStream:
+			if dest.Target == "" {
+				return fmt.Errorf("rule %s: %s destination requires a target", rule.Name, dest.Type)
+			}
+		case DestinationDLQ:
+		default:
+			return fmt.Errorf("rule %s: unknown destination type %q", rule.Name, dest.Type)
+		}
+	}
+	return nil
+}
+
+// RouteDispatcher sends an event to one kind of destination
+type RouteDispatcher interface {
+	Dispatch(ctx context.Context, destination RouteDestination, event *Event) error
+}
+
+// RouteDispatcherFunc adapts a function to RouteDispatcher
+type RouteDispatcherFunc func(ctx context.Context, destination RouteDestination, event *Event) error
+
+// Dispatch calls f
+func (f RouteDispatcherFunc) Dispatch(ctx context.Context, destination RouteDestination, event *Event) error {
+	return f(ctx, destination, event)
+}
+
+// NewWebhookDispatcher delivers routed events to active webhooks
+func NewWebhookDispatcher(webhooks WebhookService, deliverer WebhookDeliverer) RouteDispatcher {
+	return RouteDispatcherFunc(func(ctx context.Context, destination RouteDestination, event *Event) error {
+		// Rules are shared across tenants, so the lookup is unscoped and a rule never sends one
+		// tenant's events to another's webhook.
+		webhook, err := webhooks.GetWebhook(WithOperator(ctx), destination.Target)
+		if err != nil {
+			return err
+		}
+		if !webhook.Active || webhook.TenantID != event.TenantID {
+			return nil
+		}
+		_, err = deliverer.Deliver(ctx, webhook, event)
+		return err
+	})
+}
+
+// NewDeadLetterDispatcher parks routed events in a dead letter queue
+func NewDeadLetterDispatcher(dlq DeadLetterQueue) RouteDispatcher {
+	return RouteDispatcherFunc(func(ctx context.Context, destination RouteDestination, event *Event) error {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		reason := "routed to dead letter queue"
+		if r, ok := destination.Config["reason"].(string); ok && r != "" {
+			reason = r
+		}
+		return dlq.Add(ctx, &Delivery{
+			ID:        newUUID(),
+			EventID:   event.ID,
+			Payload:   payload,
+			Error:     reason,
+			CreatedAt: time.Now(),
+		})
+	})
+}
+
+// RoutingRuleStore loads routing rules
+type RoutingRuleStore interface {
+	// ListRoutingRules returns the active rules
+	ListRoutingRules(ctx context.Context) ([]*RoutingRule, error)
+}
+
+// SetRules validates and atomically replaces the routing rules
+func (r *EventRouter) SetRules(rules []*RoutingRule) error {
+	sorted := make([]*RoutingRule, 0, len(rules))
+	for _, rule := range rules {
+		if err := ValidateRoutingRule(rule); err != nil {
+			return err
+		}
+		sorted = append(sorted, rule)
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority > sorted[j].Priority
+	})
+
+	r.mu.Lock()
+	r.rules = sorted
+	r.mu.Unlock()
+	return nil
+}
+
+// Rules returns the routing rules in evaluation order
+func (r *EventRouter) Rules() []*RoutingRule {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]*RoutingRule(nil), r.rules...)
+}
+
+// ReloadRules replaces the routing rules with those in the store. If any
+// rule is invalid the current rules are kept.
+func (r *EventRouter) ReloadRules(ctx context.Context, store RoutingRuleStore) error {
+	rules, err := store.ListRoutingRules(ctx)
+	if err != nil {
+		return err
+	}
+	return r.SetRules(rules)
+}
+
+// WatchRules reloads rules from the store every interval until ctx is done, so rule
+// changes take effect without a restart. Reload failures are passed to onError.
+func (r *EventRouter) WatchRules(ctx context.Context, store RoutingRuleStore, interval time.Duration, onError func(error)) error {
+	if err := r.ReloadRules(ctx, store); err != nil {
+		return err
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := r.ReloadRules(ctx, store); err != nil && onError != nil && ctx.Err() == nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// RegisterDestination sets the dispatcher for a destination type
+func (r *EventRouter) RegisterDestination(destType DestinationType, dispatcher RouteDispatcher) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dispatchers[destType] = dispatcher
+}
+
+// RegisterNamed registers a handler that bus destinations can target by name
+func (r *EventRouter) RegisterNamed(name string, handler EventHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.named[name] = handler
+}
+
+// ruleTasks builds a task per destination of every matching rule. Rules whose
+// conditions or transformations fail are reported as errors instead.
+func (r *EventRouter) ruleTasks(event *Event) ([]routeTask, []*HandlerError) {
+	r.mu.RLock()
+	rules := r.rules
+	r.mu.RUnlock()
+
+	var (
+		tasks []routeTask
+		errs  []*HandlerError
+	)
+	for _, rule := range rules {
+		name := "rule:" + rule.Name
+		matched, err := rule.Matches(event)
+		if err != nil {
+			errs = append(errs, &HandlerError{Handler: name, EventID: event.ID, Err: err})
+			continue
+		}
+		if !matched {
+			continue
+		}
+		routed := event
+		if len(rule.Transformations) > 0 {
+			if routed, err = ApplyTransformations(event, rule.Transformations); err != nil {
+				errs = append(errs, &HandlerError{Handler: name, EventID: event.ID, Err: err})
+				continue
+			}
+		}
+		for _, dest := range rule.Destinations {
+			dest := dest
+			tasks = append(tasks, routeTask{
+				info: HandlerInfo{Name: fmt.Sprintf("%s/%s:%s", name, dest.Type, dest.Target), EventType: event.Type},
+				handler: func(ctx context.Context, e *Event) error {
+					return r.dispatch(ctx, dest, e)
+				},
+				event: routed,
+			})
+		}
+	}
+	return tasks, errs
+}
+
+func (r *EventRouter) dispatch(ctx context.Context, dest RouteDestination, event *Event) error {
+	r.mu.RLock()
+	handler, named := r.named[dest.Target]
+	dispatcher, ok := r.dispatchers[dest.Type]
+	r.mu.RUnlock()
+
+	if dest.Type == DestinationBus {
+		if !named {
+			return fmt.Errorf("no handler registered as %q", dest.Target)
+		}
+		return handler(ctx, event)
+	}
+	if !ok {
+		return fmt.Errorf("no dispatcher registered for %s destinations", dest.Type)
+	}
+	return dispatcher.Dispatch(ctx, dest, event)
+}
+
+// PostgresRoutingRuleStore loads rules from event_routing_rules
+type PostgresRoutingRuleStore struct {
+	db *sql.DB
+}
+
+// NewPostgresRoutingRuleStore creates a new Postgres-backed routing rule store
+func NewPostgresRoutingRuleStore(db *sql.DB) *PostgresRoutingRuleStore {
+	return &PostgresRoutingRuleStore{db: db}
+}
+
+// ListRoutingRules returns active rules, highest priority first
+func (s *PostgresRoutingRuleStore) ListRoutingRules(ctx context.Context) ([]*RoutingRule, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, name, priority, COALESCE(event_types, '{}'), conditions, destinations, transformations,
+			active, created_at, updated_at
+		FROM event_routing_rules
+		WHERE active = TRUE
+		ORDER BY priority DESC, created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []*RoutingRule{}
+	for rows.Next() {
+		var (
+			rule            RoutingRule
+			eventTypes      string
+			conditions      []byte
+			destinations    []byte
+			transformations []byte
+		)
+		if err := rows.Scan(&rule.ID, &rule.Name, &rule.Priority, &eventTypes, &conditions, &destinations,
+			&transformations, &rule.Active, &rule.CreatedAt, &rule.UpdatedAt); err != nil {
+			return nil, err
+		}
+		rule.EventTypes = stringsToEventTypes(parseTextArray(eventTypes))
+		if err := unmarshalJSONColumn(conditions, &rule.Conditions); err != nil {
+			return nil, fmt.Errorf("rule %s conditions: %w", rule.ID, err)
+		}
+		if err := unmarshalJSONColumn(destinations, &rule.Destinations); err != nil {
+			return nil, fmt.Errorf("rule %s destinations: %w", rule.ID, err)
+		}
+		if err := unmarshalJSONColumn(transformations, &rule.Transformations); err != nil {
+			return nil, fmt.Errorf("rule %s transformations: %w", rule.ID, err)
+		}
+		rules = append(rules, &rule)
+	}
+	return rules, rows.Err()
+}
@@ -0,0 +1,235 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// ErrBlockedDestination is returned when an outbound request targets a disallowed address
+var ErrBlockedDestination = errors.New("destination address is not allowed")
+
+// defaultMaxRedirects bounds redirects followed by outbound webhook requests
+const defaultMaxRedirects = 3
+
+// blockedNetworks lists ranges that outbound webhooks may not reach unless allowlisted.
+// Loopback, link-local, RFC 1918/ULA, multicast and unspecified addresses are checked via net.IP helpers.
+var blockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",          // "this" network
+	"100.64.0.0/10",      // carrier-grade NAT, includes Alibaba metadata 100.100.100.200
+	"192.0.0.0/24",       // IETF protocol assignments
+	"192.0.2.0/24",       // TEST-NET-1
+	"198.18.0.0/15",      // benchmarking
+	"198.51.100.0/24",    // TEST-NET-2
+	"203.0.113.0/24",     // TEST-NET-3
+	"240.0.0.0/4",        // reserved, includes broadcast
+	"64:ff9b::/96",       // NAT64, may map onto internal IPv4
+	"2001:db8::/32",      // documentation
+	"fd00:ec2::254/128",  // AWS IPv6 metadata
+	"169.254.169.254/32", // cloud metadata, also covered by link-local
+)
+
+// IPResolver resolves host names to IP addresses
+type IPResolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// SSRFPolicy controls which destinations outbound webhook requests may reach
+type SSRFPolicy struct {
+	// AllowedCIDRs are explicitly permitted even when they fall inside a blocked range
+	AllowedCIDRs []*net.IPNet
+	// MaxRedirects bounds the redirects followed per request; zero disables redirects
+	MaxRedirects int
+	// Resolver overrides DNS resolution, mainly for tests
+	Resolver IPResolver
+	// DialTimeout bounds connection establishment
+	DialTimeout time.Duration
+}
+
+// DefaultSSRFPolicy blocks internal ranges, follows up to three redirects and has no allowlist
+func DefaultSSRFPolicy() SSRFPolicy {
+	return SSRFPolicy{
+		MaxRedirects: defaultMaxRedirects,
+		DialTimeout:  10 * time.Second,
+	}
+}
+
+// AllowCIDRs adds CIDR ranges to the policy allowlist
+func (p *SSRFPolicy) AllowCIDRs(cidrs ...string) error {
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR: %w", err)
+		}
+		p.AllowedCIDRs = append(p.AllowedCIDRs, network)
+	}
+	return nil
+}
+
+// IsAllowed reports whether the policy permits connecting to ip
+func (p SSRFPolicy) IsAllowed(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	for _, network := range p.AllowedCIDRs {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// GuardedDialer resolves host names itself and only connects to addresses allowed by its policy.
+// The dialed address is checked again at connect time, so a DNS answer that changes between
+// resolution and connection (DNS rebinding) cannot slip through.
+type GuardedDialer struct {
+	policy SSRFPolicy
+	dialer *net.Dialer
+}
+
+// NewGuardedDialer creates a new guarded dialer
+func NewGuardedDialer(policy SSRFPolicy) *GuardedDialer {
+	timeout := policy.DialTimeout
+	if timeout <= 0 {
+		timeout = DefaultSSRFPolicy().DialTimeout
+	}
+	g := &GuardedDialer{policy: policy}
+	g.dialer = &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+		Control:   g.control,
+	}
+	return g
+}
+
+// DialContext connects to the first allowed address the host resolves to
+func (g *GuardedDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := g.resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, ip := range ips {
+		if !g.policy.IsAllowed(ip) {
+			lastErr = fmt.Errorf("%w: %s resolves to %s", ErrBlockedDestination, host, ip)
+			continue
+		}
+		conn, err := g.dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no addresses found for %s", host)
+	}
+	return nil, lastErr
+}
+
+func (g *GuardedDialer) resolve(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	resolver := g.policy.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP)
+	}
+	return ips, nil
+}
+
+// control re-validates the socket address immediately before connect
+func (g *GuardedDialer) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if !g.policy.IsAllowed(ip) {
+		return fmt.Errorf("%w: %s", ErrBlockedDestination, address)
+	}
+	return nil
+}
+
+// NewGuardedTransport returns an HTTP transport that dials through a GuardedDialer.
+// Environment proxies are ignored because a proxy would connect on our behalf and bypass the check.
+func NewGuardedTransport(policy SSRFPolicy) *http.Transport {
+	return &http.Transport{
+		Proxy:                 nil,
+		DialContext:           NewGuardedDialer(policy).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// NewGuardedHTTPClient returns an HTTP client that enforces the SSRF policy on every hop
+func NewGuardedHTTPClient(policy SSRFPolicy, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:       timeout,
+		Transport:     NewGuardedTransport(policy),
+		CheckRedirect: redirectPolicy(policy.MaxRedirects),
+	}
+}
+
+// redirectPolicy limits redirects and refuses to leave http(s)
+func redirectPolicy(maxRedirects int) func(req *http.Request, via []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) > maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+		}
+		return nil
+	}
+}
+
+// noRedirects returns redirect responses to the caller instead of following them
+func noRedirects(req *http.Request, via []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(fmt.Sprintf("events: invalid built-in CIDR %q: %v", cidr, err))
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
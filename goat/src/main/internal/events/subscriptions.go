@@ -0,0 +1,674 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Subscription types
+const (
+	// SubscriptionWebhook posts matching events to the destination URL
+	SubscriptionWebhook = "webhook"
+	// SubscriptionStream appends matching events to a log that each consumer reads from its own cursor
+	SubscriptionStream = "stream"
+	// SubscriptionQueue load-balances matching events across competing consumers
+	SubscriptionQueue = "queue"
+)
+
+// defaultVisibilityTimeout hides a received queue message until it is acked, nacked or this passes
+const defaultVisibilityTimeout = 30 * time.Second
+
+var (
+	// ErrSubscriptionNotFound is returned when a subscription does not exist
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+	// ErrLeaseExpired is returned when acking or nacking with a receipt that is no longer current,
+	// usually because the visibility timeout passed and another consumer received the message
+	ErrLeaseExpired = errors.New("queue message lease expired")
+)
+
+// QueueMessage is an event leased to a consumer of a queue subscription
+type QueueMessage struct {
+	ID             string    `json:"id"`
+	SubscriptionID string    `json:"subscription_id"`
+	Event          *Event    `json:"event"`
+	Receipt        string    `json:"receipt"` // identifies this lease; pass it to Ack or Nack
+	Consumer       string    `json:"consumer"`
+	Attempts       int       `json:"attempts"`
+	VisibleAt      time.Time `json:"visible_at"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// StreamRecord is an event in a stream subscription's log. Offsets increase but may have gaps.
+type StreamRecord struct {
+	SubscriptionID string    `json:"subscription_id"`
+	Offset         int64     `json:"offset"`
+	Event          *Event    `json:"event"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// SubscriptionStore persists subscriptions
+type SubscriptionStore interface {
+	// CreateSubscription stores a new subscription
+	CreateSubscription(ctx context.Context, subscription *Subscription) error
+
+	// GetSubscription retrieves a subscription by ID
+	GetSubscription(ctx context.Context, subscriptionID string) (*Subscription, error)
+
+	// ListSubscriptions lists all subscriptions
+	ListSubscriptions(ctx context.Context) ([]*Subscription, error)
+
+	// DeleteSubscription removes a subscription
+	DeleteSubscription(ctx context.Context, subscriptionID string) error
+}
+
+// ConsumerStore persists the messages of queue subscriptions and the logs and cursors of stream subscriptions
+type ConsumerStore interface {
+	// Enqueue adds an event to a queue subscription
+	Enqueue(ctx context.Context, subscriptionID string, event *Event) error
+
+	// Receive leases up to max visible messages to consumer, hiding them for visibility
+	Receive(ctx context.Context, subscriptionID, consumer string, max int, visibility time.Duration) ([]*QueueMessage, error)
+
+	// Ack removes a leased message
+	Ack(ctx context.Context, subscriptionID, receipt string) error
+
+	// Nack releases a leased message, making it visible again after delay
+	Nack(ctx context.Context, subscriptionID, receipt string, delay time.Duration) error
+
+	// Append adds an event to a stream subscription and returns its offset
+	Append(ctx context.Context, subscriptionID string, event *Event) (int64, error)
+
+	// ReadStream returns up to max records after the consumer's committed offset
+	ReadStream(ctx context.Context, subscriptionID, consumer string, max int) ([]*StreamRecord, error)
+
+	// Commit sets the consumer's offset; committing a lower offset replays the records after it
+	Commit(ctx context.Context, subscriptionID, consumer string, offset int64) error
+
+	// Drop removes the messages, records and cursors of a subscription
+	Drop(ctx context.Context, subscriptionID string) error
+}
+
+// ValidateSubscription checks a subscription's name, type, event patterns, query and format.
+// Webhook subscriptions also need an absolute http(s) destination and a format that sends one
+// event per request.
+func ValidateSubscription(subscription *Subscription) error {
+	if subscription == nil {
+		return errors.New("subscription cannot be nil")
+	}
+	if strings.TrimSpace(subscription.Name) == "" {
+		return errors.New("subscription name is required")
+	}
+	switch subscription.Type {
+	case "", SubscriptionWebhook:
+		parsed, err := url.Parse(subscription.Destination)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+			return fmt.Errorf("webhook subscription destination must be an absolute http(s) url, got %q", subscription.Destination)
+		}
+		if subscription.Format == PayloadFormatCloudEventsBatch {
+			return errBatchFormatPerEvent
+		}
+	case SubscriptionStream, SubscriptionQueue:
+		if _, err := subscriptionVisibilityTimeout(subscription); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown subscription type %q", subscription.Type)
+	}
+	for _, eventType := range subscription.Events {
+		if !isValidEventPattern(eventType) {
+			return fmt.Errorf("unknown event type %q", eventType)
+		}
+	}
+	if _, err := parseOptionalQuery(subscription.Query); err != nil {
+		return err
+	}
+	if !subscription.Format.Valid() {
+		return fmt.Errorf("unknown payload format %q", subscription.Format)
+	}
+	return ValidateRedactionPolicy(subscription.Redaction)
+}
+
+// subscriptionVisibilityTimeout reads Config["visibility_timeout"], given in seconds or as a duration string
+func subscriptionVisibilityTimeout(subscription *Subscription) (time.Duration, error) {
+	switch value := subscription.Config["visibility_timeout"].(type) {
+	case nil:
+		return defaultVisibilityTimeout, nil
+	case float64:
+		if value > 0 {
+			return time.Duration(value * float64(time.Second)), nil
+		}
+	case int:
+		if value > 0 {
+			return time.Duration(value) * time.Second, nil
+		}
+	case string:
+		if timeout, err := time.ParseDuration(value); err == nil && timeout > 0 {
+			return timeout, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid visibility_timeout %v", subscription.Config["visibility_timeout"])
+}
+
+// subscriptionWebhook describes a webhook subscription as a Webhook for the deliverer. Its
+// deliveries are listed under the subscription ID.
+func subscriptionWebhook(subscription *Subscription) *Webhook {
+	webhook := &Webhook{
+		ID:             subscription.ID,
+		SubscriptionID: subscription.ID,
+		TenantID:       subscription.TenantID,
+		Name:           subscription.Name,
+		URL:            subscription.Destination,
+		Events:         subscription.Events,
+		Filters:        subscription.Filters,
+		Format:         subscription.Format,
+		Redaction:      subscription.Redaction,
+		Active:         true,
+	}
+	if secret, ok := subscription.Config["secret"].(string); ok {
+		webhook.Secret = secret
+	}
+	return webhook
+}
+
+func cloneSubscription(subscription *Subscription) *Subscription {
+	out := *subscription
+	out.Events = append([]EventType(nil), subscription.Events...)
+	out.Filters = append([]Filter(nil), subscription.Filters...)
+	if subscription.Config != nil {
+		out.Config = deepCopyValue(subscription.Config).(map[string]interface{})
+	}
+	out.Redaction = cloneRedactionPolicy(subscription.Redaction)
+	return &out
+}
+
+// InMemorySubscriptionStore keeps subscriptions in memory
+type InMemorySubscriptionStore struct {
+	mu            sync.RWMutex
+	subscriptions map[string]*Subscription
+}
+
+// NewInMemorySubscriptionStore creates a new in-memory subscription store
+func NewInMemorySubscriptionStore() *InMemorySubscriptionStore {
+	return &InMemorySubscriptionStore{subscriptions: make(map[string]*Subscription)}
+}
+
+// CreateSubscription stores a copy of the subscription
+func (s *InMemorySubscriptionStore) CreateSubscription(ctx context.Context, subscription *Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscriptions[subscription.ID] = cloneSubscription(subscription)
+	return nil
+}
+
+// GetSubscription returns a copy of the subscription
+func (s *InMemorySubscriptionStore) GetSubscription(ctx context.Context, subscriptionID string) (*Subscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	subscription, ok := s.subscriptions[subscriptionID]
+	if !ok {
+		return nil, fmt.Errorf("subscription %s: %w", subscriptionID, ErrSubscriptionNotFound)
+	}
+	return cloneSubscription(subscription), nil
+}
+
+// ListSubscriptions lists subscriptions ordered by creation time
+func (s *InMemorySubscriptionStore) ListSubscriptions(ctx context.Context) ([]*Subscription, error) {
+	s.mu.RLock()
+	result := make([]*Subscription, 0, len(s.subscriptions))
+	for _, subscription := range s.subscriptions {
+		result = append(result, cloneSubscription(subscription))
+	}
+	s.mu.RUnlock()
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].CreatedAt.Equal(result[j].CreatedAt) {
+			return result[i].ID < result[j].ID
+		}
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+// DeleteSubscription removes a subscription
+func (s *InMemorySubscriptionStore) DeleteSubscription(ctx context.Context, subscriptionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subscriptions[subscriptionID]; !ok {
+		return fmt.Errorf("subscription %s: %w", subscriptionID, ErrSubscriptionNotFound)
+	}
+	delete(s.subscriptions, subscriptionID)
+	return nil
+}
+
+// InMemoryConsumerStore keeps queue messages, stream records and cursors in memory
+type InMemoryConsumerStore struct {
+	clock   Clock
+	mu      sync.Mutex
+	queues  map[string][]*QueueMessage
+	streams map[string][]*StreamRecord
+	cursors map[string]map[string]int64
+}
+
+// NewInMemoryConsumerStore creates a new in-memory consumer store. A nil clock uses the system clock.
+func NewInMemoryConsumerStore(clock Clock) *InMemoryConsumerStore {
+	if clock == nil {
+		clock = SystemClock()
+	}
+	return &InMemoryConsumerStore{
+		clock:   clock,
+		queues:  make(map[string][]*QueueMessage),
+		streams: make(map[string][]*StreamRecord),
+		cursors: make(map[string]map[string]int64),
+	}
+}
+
+// Enqueue adds an event to the end of a queue
+func (s *InMemoryConsumerStore) Enqueue(ctx context.Context, subscriptionID string, event *Event) error {
+	now := s.clock.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queues[subscriptionID] = append(s.queues[subscriptionID], &QueueMessage{
+		ID:             newUUID(),
+		SubscriptionID: subscriptionID,
+		Event:          cloneEvent(event),
+		VisibleAt:      now,
+		CreatedAt:      now,
+	})
+	return nil
+}
+
+// Receive leases the oldest visible messages to consumer
+func (s *InMemoryConsumerStore) Receive(ctx context.Context, subscriptionID, consumer string, max int, visibility time.Duration) ([]*QueueMessage, error) {
+	now := s.clock.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var leased []*QueueMessage
+	for _, message := range s.queues[subscriptionID] {
+		if len(leased) >= max {
+			break
+		}
+		if message.VisibleAt.After(now) {
+			continue
+		}
+		message.Receipt = newUUID()
+		message.Consumer = consumer
+		message.Attempts++
+		message.VisibleAt = now.Add(visibility)
+		leased = append(leased, copyQueueMessage(message))
+	}
+	return leased, nil
+}
+
+// Ack removes the message leased under receipt
+func (s *InMemoryConsumerStore) Ack(ctx context.Context, subscriptionID, receipt string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queue := s.queues[subscriptionID]
+	for i, message := range queue {
+		if receipt != "" && message.Receipt == receipt {
+			s.queues[subscriptionID] = append(queue[:i:i], queue[i+1:]...)
+			return nil
+		}
+	}
+	return ErrLeaseExpired
+}
+
+// Nack makes the message leased under receipt visible again after delay
+func (s *InMemoryConsumerStore) Nack(ctx context.Context, subscriptionID, receipt string, delay time.Duration) error {
+	now := s.clock.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, message := range s.queues[subscriptionID] {
+		if receipt != "" && message.Receipt == receipt {
+			message.Receipt = ""
+			message.Consumer = ""
+			message.VisibleAt = now.Add(delay)
+			return nil
+		}
+	}
+	return ErrLeaseExpired
+}
+
+// Append adds an event to a stream, numbering records from 1
+func (s *InMemoryConsumerStore) Append(ctx context.Context, subscriptionID string, event *Event) (int64, error) {
+	now := s.clock.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := s.streams[subscriptionID]
+	offset := int64(len(records) + 1)
+	s.streams[subscriptionID] = append(records, &StreamRecord{
+		SubscriptionID: subscriptionID,
+		Offset:         offset,
+		Event:          cloneEvent(event),
+		CreatedAt:      now,
+	})
+	return offset, nil
+}
+
+// ReadStream returns up to max records after the consumer's committed offset
+func (s *InMemoryConsumerStore) ReadStream(ctx context.Context, subscriptionID, consumer string, max int) ([]*StreamRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := s.streams[subscriptionID]
+	start := s.cursors[subscriptionID][consumer]
+	if start >= int64(len(records)) {
+		return nil, nil
+	}
+	end := start + int64(max)
+	if end > int64(len(records)) {
+		end = int64(len(records))
+	}
+	result := make([]*StreamRecord, 0, end-start)
+	for _, record := range records[start:end] {
+		copied := *record
+		copied.Event = cloneEvent(record.Event)
+		result = append(result, &copied)
+	}
+	return result, nil
+}
+
+// Commit sets the consumer's offset
+func (s *InMemoryConsumerStore) Commit(ctx context.Context, subscriptionID, consumer string, offset int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if offset < 0 || offset > int64(len(s.streams[subscriptionID])) {
+		return fmt.Errorf("offset %d is outside stream %s", offset, subscriptionID)
+	}
+	if s.cursors[subscriptionID] == nil {
+		s.cursors[subscriptionID] = make(map[string]int64)
+	}
+	s.cursors[subscriptionID][consumer] = offset
+	return nil
+}
+
+// Drop removes everything stored for a subscription
+func (s *InMemoryConsumerStore) Drop(ctx context.Context, subscriptionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.queues, subscriptionID)
+	delete(s.streams, subscriptionID)
+	delete(s.cursors, subscriptionID)
+	return nil
+}
+
+func copyQueueMessage(message *QueueMessage) *QueueMessage {
+	copied := *message
+	copied.Event = cloneEvent(message.Event)
+	return &copied
+}
+
+// PostgresSubscriptionStore persists subscriptions to event_subscriptions
+type PostgresSubscriptionStore struct {
+	db *sql.DB
+}
+
+// NewPostgresSubscriptionStore creates a new Postgres-backed subscription store
+func NewPostgresSubscriptionStore(db *sql.DB) *PostgresSubscriptionStore {
+	return &PostgresSubscriptionStore{db: db}
+}
+
+const subscriptionColumns = `id, tenant_id, name, subscription_type, events, destination, config, filters,
+	COALESCE(query, ''), COALESCE(format, ''), redaction, active, created_at`
+
+// CreateSubscription inserts a subscription
+func (s *PostgresSubscriptionStore) CreateSubscription(ctx context.Context, subscription *Subscription) error {
+	config, err := marshalJSONColumn(subscription.Config)
+	if err != nil {
+		return err
+	}
+	filters, err := marshalJSONColumn(subscription.Filters)
+	if err != nil {
+		return err
+	}
+	redaction, err := marshalJSONColumn(subscription.Redaction)
+	if err != nil {
+		return err
+	}
+	return s.db.QueryRowContext(ctx, `
+		INSERT INTO event_subscriptions (id, name, subscription_type, events, destination, config, filters, format, redaction, active, tenant_id, query)
+		VALUES ($1, $2, $3, $4::text[], $5, $6, $7, NULLIF($8, ''), $9, $10, $11, NULLIF($12, ''))
+		RETURNING created_at`,
+		subscription.ID, subscription.Name, subscription.Type, formatTextArray(eventTypesToStrings(subscription.Events)),
+		subscription.Destination, config, filters, string(subscription.Format), redaction, subscription.Active, subscription.TenantID,
+		subscription.Query,
+	).Scan(&subscription.CreatedAt)
+}
+
+// GetSubscription retrieves a subscription by ID
+func (s *PostgresSubscriptionStore) GetSubscription(ctx context.Context, subscriptionID string) (*Subscription, error) {
+	subscription, err := scanSubscription(s.db.QueryRowContext(ctx,
+		`SELECT `+subscriptionColumns+` FROM event_subscriptions WHERE id = $1`, subscriptionID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("subscription %s: %w", subscriptionID, ErrSubscriptionNotFound)
+	}
+	return subscription, err
+}
+
+// ListSubscriptions lists subscriptions ordered by creation time
+func (s *PostgresSubscriptionStore) ListSubscriptions(ctx context.Context) ([]*Subscription, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+subscriptionColumns+` FROM event_subscriptions ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []*Subscription{}
+	for rows.Next() {
+		subscription, err := scanSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, subscription)
+	}
+	return result, rows.Err()
+}
+
+// DeleteSubscription removes a subscription
+func (s *PostgresSubscriptionStore) DeleteSubscription(ctx context.Context, subscriptionID string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM event_subscriptions WHERE id = $1`, subscriptionID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("subscription %s: %w", subscriptionID, ErrSubscriptionNotFound)
+	}
+	return nil
+}
+
+func scanSubscription(row rowScanner) (*Subscription, error) {
+	var (
+		subscription Subscription
+		events       string
+		config       []byte
+		filters      []byte
+		redaction    []byte
+	)
+	if err := row.Scan(&subscription.ID, &subscription.TenantID, &subscription.Name, &subscription.Type, &events, &subscription.Destination,
+		&config, &filters, &subscription.Query, &subscription.Format, &redaction, &subscription.Active, &subscription.CreatedAt); err != nil {
+		return nil, err
+	}
+	subscription.Events = stringsToEventTypes(parseTextArray(events))
+	if err := unmarshalJSONColumn(config, &subscription.Config); err != nil {
+		return nil, err
+	}
+	if err := unmarshalJSONColumn(filters, &subscription.Filters); err != nil {
+		return nil, err
+	}
+	if err := unmarshalJSONColumn(redaction, &subscription.Redaction); err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// PostgresConsumerStore persists queue messages to event_queue_messages and stream records and cursors
+// to event_stream_records and event_stream_cursors. Competing consumers lease rows with SKIP LOCKED.
+type PostgresConsumerStore struct {
+	db *sql.DB
+}
+
+// NewPostgresConsumerStore creates a new Postgres-backed consumer store
+func NewPostgresConsumerStore(db *sql.DB) *PostgresConsumerStore {
+	return &PostgresConsumerStore{db: db}
+}
+
+// Enqueue inserts a queue message
+func (s *PostgresConsumerStore) Enqueue(ctx context.Context, subscriptionID string, event *Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO event_queue_messages (id, subscription_id, event) VALUES ($1, $2, $3)`,
+		newUUID(), subscriptionID, payload)
+	return err
+}
+
+// Receive leases the oldest visible messages to consumer
+func (s *PostgresConsumerStore) Receive(ctx context.Context, subscriptionID, consumer string, max int, visibility time.Duration) ([]*QueueMessage, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE event_queue_messages SET
+			receipt = gen_random_uuid(),
+			consumer = $2,
+			attempts = attempts + 1,
+			visible_at = NOW() + $4 * INTERVAL '1 millisecond'
+		WHERE id IN (
+			SELECT id FROM event_queue_messages
+			WHERE subscription_id = $1 AND visible_at <= NOW()
+			ORDER BY created_at, id
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, subscription_id, event, receipt, consumer, attempts, visible_at, created_at`,
+		subscriptionID, consumer, max, visibility.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*QueueMessage
+	for rows.Next() {
+		var (
+			message QueueMessage
+			payload []byte
+		)
+		if err := rows.Scan(&message.ID, &message.SubscriptionID, &payload, &message.Receipt, &message.Consumer,
+			&message.Attempts, &message.VisibleAt, &message.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(payload, &message.Event); err != nil {
+			return nil, fmt.Errorf("queue message %s: %w", message.ID, err)
+		}
+		result = append(result, &message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+// Ack deletes the message leased under receipt
+func (s *PostgresConsumerStore) Ack(ctx context.Context, subscriptionID, receipt string) error {
+	result, err := s.db.ExecContext(ctx, `
+		DELETE FROM event_queue_messages WHERE subscription_id = $1 AND receipt = $2`,
+		subscriptionID, receipt)
+	return leaseResult(result, err)
+}
+
+// Nack makes the message leased under receipt visible again after delay
+func (s *PostgresConsumerStore) Nack(ctx context.Context, subscriptionID, receipt string, delay time.Duration) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE event_queue_messages SET
+			receipt = NULL, consumer = NULL, visible_at = NOW() + $3 * INTERVAL '1 millisecond'
+		WHERE subscription_id = $1 AND receipt = $2`,
+		subscriptionID, receipt, delay.Milliseconds())
+	return leaseResult(result, err)
+}
+
+func leaseResult(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrLeaseExpired
+	}
+	return nil
+}
+
+// Append inserts a stream record
+func (s *PostgresConsumerStore) Append(ctx context.Context, subscriptionID string, event *Event) (int64, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+	var offset int64
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO event_stream_records (subscription_id, event) VALUES ($1, $2)
+		RETURNING stream_offset`,
+		subscriptionID, payload).Scan(&offset)
+	return offset, err
+}
+
+// ReadStream returns up to max records after the consumer's committed offset
+func (s *PostgresConsumerStore) ReadStream(ctx context.Context, subscriptionID, consumer string, max int) ([]*StreamRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT stream_offset, event, created_at FROM event_stream_records
+		WHERE subscription_id = $1 AND stream_offset > COALESCE((
+			SELECT committed_offset FROM event_stream_cursors WHERE subscription_id = $1 AND consumer = $2
+		), 0)
+		ORDER BY stream_offset
+		LIMIT $3`,
+		subscriptionID, consumer, max)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*StreamRecord
+	for rows.Next() {
+		record := StreamRecord{SubscriptionID: subscriptionID}
+		var payload []byte
+		if err := rows.Scan(&record.Offset, &payload, &record.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(payload, &record.Event); err != nil {
+			return nil, fmt.Errorf("stream record %d: %w", record.Offset, err)
+		}
+		result = append(result, &record)
+	}
+	return result, rows.Err()
+}
+
+// Commit upserts the consumer's offset
+func (s *PostgresConsumerStore) Commit(ctx context.Context, subscriptionID, consumer string, offset int64) error {
+	if offset < 0 {
+		return fmt.Errorf("offset %d is outside stream %s", offset, subscriptionID)
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO event_stream_cursors (subscription_id, consumer, committed_offset) VALUES ($1, $2, $3)
+		ON CONFLICT (subscription_id, consumer) DO UPDATE SET
+			committed_offset = EXCLUDED.committed_offset,
+			updated_at = NOW()`,
+		subscriptionID, consumer, offset)
+	return err
+}
+
+// Drop deletes everything stored for a subscription
+func (s *PostgresConsumerStore) Drop(ctx context.Context, subscriptionID string) error {
+	for _, table := range []string{"event_queue_messages", "event_stream_records", "event_stream_cursors"} {
+		if _, err := s.db.ExecContext(ctx, `DELETE FROM `+table+` WHERE subscription_id = $1`, subscriptionID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
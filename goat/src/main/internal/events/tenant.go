@@ -0,0 +1,222 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrTenantMismatch is returned when a resource names a tenant other than the caller's
+	ErrTenantMismatch = errors.New("tenant does not match the caller's tenant")
+	// ErrTenantQuotaExceeded is returned when creating a resource would exceed its tenant's quota
+	ErrTenantQuotaExceeded = errors.New("tenant quota exceeded")
+	// ErrTenantRequired is returned when a context acts for neither a tenant nor an operator
+	ErrTenantRequired = errors.New("context has no tenant and is not an operator")
+)
+
+type tenantContextKey struct{}
+
+// callerScope is who a context acts for: one tenant, or an operator for every tenant
+type callerScope struct {
+	tenantID string
+	operator bool
+}
+
+// WithTenant returns a context acting on behalf of tenantID. Events published with it belong to
+// the tenant, and lookups made with it only see the tenant's events, webhooks and subscriptions.
+// An empty tenantID leaves the context unscoped, so it creates and sees nothing, even if ctx was
+// an operator context.
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, callerScope{tenantID: tenantID})
+}
+
+// WithOperator returns a context acting for every tenant, for administrative and system work.
+// A context with neither WithTenant nor WithOperator creates nothing and sees nothing.
+func WithOperator(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, callerScope{operator: true})
+}
+
+// TenantFromContext returns the tenant set by WithTenant
+func TenantFromContext(ctx context.Context) (string, bool) {
+	scope, _ := ctx.Value(tenantContextKey{}).(callerScope)
+	return scope.tenantID, scope.tenantID != ""
+}
+
+// IsOperator reports whether ctx was marked by WithOperator
+func IsOperator(ctx context.Context) bool {
+	scope, _ := ctx.Value(tenantContextKey{}).(callerScope)
+	return scope.operator
+}
+
+// resolveTenant returns the tenant a new event, webhook or subscription belongs to. Tenant
+// callers may only create resources in their own tenant; operators may name any tenant.
+func resolveTenant(ctx context.Context, tenantID string) (string, error) {
+	if IsOperator(ctx) {
+		return tenantID, nil
+	}
+	caller, ok := TenantFromContext(ctx)
+	if !ok {
+		return "", ErrTenantRequired
+	}
+	if tenantID != "" && tenantID != caller {
+		return "", fmt.Errorf("tenant %s: %w", tenantID, ErrTenantMismatch)
+	}
+	return caller, nil
+}
+
+// tenantVisible reports whether a resource of tenantID is visible to the caller
+func tenantVisible(ctx context.Context, tenantID string) bool {
+	if IsOperator(ctx) {
+		return true
+	}
+	caller, ok := TenantFromContext(ctx)
+	return ok && caller == tenantID
+}
+
+// callerTenant returns the caller's tenant, or an empty string for operators, which Postgres
+// queries treat as matching every tenant. Contexts without either are refused.
+func callerTenant(ctx context.Context) (string, error) {
+	if IsOperator(ctx) {
+		return "", nil
+	}
+	caller, ok := TenantFromContext(ctx)
+	if !ok {
+		return "", ErrTenantRequired
+	}
+	return caller, nil
+}
+
+// scopeEventFilter returns filter limited to the caller's tenant, or an error if it asks for another
+// tenant or the caller has no scope
+func scopeEventFilter(ctx context.Context, filter *EventFilter) (*EventFilter, error) {
+	if IsOperator(ctx) {
+		return filter, nil
+	}
+	caller, ok := TenantFromContext(ctx)
+	if !ok {
+		return nil, ErrTenantRequired
+	}
+	scoped := EventFilter{}
+	if filter != nil {
+		if filter.TenantID != "" && filter.TenantID != caller {
+			return nil, fmt.Errorf("tenant %s: %w", filter.TenantID, ErrTenantMismatch)
+		}
+		scoped = *filter
+	}
+	scoped.TenantID = caller
+	return &scoped, nil
+}
+
+// TenantQuota limits what one tenant may use. Zero values mean unlimited.
+type TenantQuota struct {
+	// MaxWebhooks caps how many webhooks the tenant may register
+	MaxWebhooks int `json:"max_webhooks,omitempty"`
+	// DeliveriesPerSecond caps the tenant's sustained webhook delivery rate
+	DeliveriesPerSecond float64 `json:"deliveries_per_second,omitempty"`
+	// DeliveryBurst is how many deliveries may be sent at once before the rate applies; defaults to one second's worth
+	DeliveryBurst int `json:"delivery_burst,omitempty"`
+}
+
+// TenantQuotas holds a default quota and per-tenant overrides, and meters delivery throughput
+// with a token bucket per tenant. Resources without a tenant are not limited. A nil
+// *TenantQuotas limits nothing.
+type TenantQuotas struct {
+	clock     Clock
+	mu        sync.Mutex
+	defaults  TenantQuota
+	overrides map[string]TenantQuota
+	buckets   map[string]*tokenBucket
+}
+
+// NewTenantQuotas creates quotas applying defaults to every tenant. A nil clock uses the system clock.
+func NewTenantQuotas(defaults TenantQuota, clock Clock) *TenantQuotas {
+	if clock == nil {
+		clock = SystemClock()
+	}
+	return &TenantQuotas{
+		clock:     clock,
+		defaults:  defaults,
+		overrides: make(map[string]TenantQuota),
+		buckets:   make(map[string]*tokenBucket),
+	}
+}
+
+// SetQuota replaces the quota of one tenant
+func (q *TenantQuotas) SetQuota(tenantID string, quota TenantQuota) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.overrides[tenantID] = quota
+	delete(q.buckets, tenantID)
+}
+
+// Quota returns the quota that applies to a tenant
+func (q *TenantQuotas) Quota(tenantID string) TenantQuota {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.quotaLocked(tenantID)
+}
+
+func (q *TenantQuotas) quotaLocked(tenantID string) TenantQuota {
+	if quota, ok := q.overrides[tenantID]; ok {
+		return quota
+	}
+	return q.defaults
+}
+
+// checkWebhookCount returns ErrTenantQuotaExceeded if a tenant already holding count webhooks may not add another
+func (q *TenantQuotas) checkWebhookCount(tenantID string, count int) error {
+	if q == nil || tenantID == "" {
+		return nil
+	}
+	if max := q.Quota(tenantID).MaxWebhooks; max > 0 && count >= max {
+		return fmt.Errorf("tenant %s may register at most %d webhooks: %w", tenantID, max, ErrTenantQuotaExceeded)
+	}
+	return nil
+}
+
+// reserveDelivery takes a delivery token for the tenant. When none is left it returns false and
+// how long until one is.
+func (q *TenantQuotas) reserveDelivery(tenantID string) (bool, time.Duration) {
+	if q == nil || tenantID == "" {
+		return true, 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	quota := q.quotaLocked(tenantID)
+	if quota.DeliveriesPerSecond <= 0 {
+		return true, 0
+	}
+	bucket, ok := q.buckets[tenantID]
+	if !ok {
+		burst := float64(quota.DeliveryBurst)
+		if burst <= 0 {
+			burst = math.Max(1, math.Ceil(quota.DeliveriesPerSecond))
+		}
+		bucket = &tokenBucket{rate: quota.DeliveriesPerSecond, capacity: burst, tokens: burst, updated: q.clock.Now()}
+		q.buckets[tenantID] = bucket
+	}
+	return bucket.take(q.clock.Now())
+}
+
+type tokenBucket struct {
+	rate     float64
+	capacity float64
+	tokens   float64
+	updated  time.Time
+}
+
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.capacity, b.tokens+elapsed*b.rate)
+		b.updated = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
@@ -0,0 +1,186 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Transformation operations understood by ApplyTransformations
+const (
+	TransformSet    = "set"
+	TransformRemove = "remove"
+	TransformRename = "rename"
+	TransformCopy   = "copy"
+)
+
+// ApplyTransformations returns a copy of the event with the rules applied in order; the input is not modified.
+// set writes Value to Field, remove deletes Field, and rename and copy move or duplicate Field to the
+// path given in Value. Only data.* and metadata.* paths can be removed, renamed or copied.
+func ApplyTransformations(event *Event, rules []TransformRule) (*Event, error) {
+	out := cloneEvent(event)
+	for _, rule := range rules {
+		switch rule.Operation {
+		case TransformSet:
+			if err := setEventField(out, rule.Field, rule.Value); err != nil {
+				return nil, err
+			}
+		case TransformRemove:
+			if err := removeEventField(out, rule.Field); err != nil {
+				return nil, err
+			}
+		case TransformRename, TransformCopy:
+			target, ok := rule.Value.(string)
+			if !ok || target == "" {
+				return nil, fmt.Errorf("transform %s of %s requires a target path", rule.Operation, rule.Field)
+			}
+			value, found := EventField(out, rule.Field)
+			if !found {
+				continue
+			}
+			if err := setEventField(out, target, deepCopyValue(value)); err != nil {
+				return nil, err
+			}
+			if rule.Operation == TransformRename {
+				if err := removeEventField(out, rule.Field); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unknown transform operation %q", rule.Operation)
+		}
+	}
+	return out, nil
+}
+
+func setEventField(event *Event, field string, value interface{}) error {
+	root, rest, nested := strings.Cut(field, ".")
+	if nested && (root == "data" || root == "metadata") {
+		if root == "data" {
+			if event.Data == nil {
+				event.Data = make(map[string]interface{})
+			}
+			return setPath(event.Data, rest, value)
+		}
+		if event.Metadata == nil {
+			event.Metadata = make(map[string]interface{})
+		}
+		return setPath(event.Metadata, rest, value)
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("transform set of %s requires a string value", field)
+	}
+	switch field {
+	case "type":
+		event.Type = EventType(s)
+	case "priority":
+		event.Priority = Priority(s)
+	case "resource":
+		event.Resource = s
+	case "action":
+		event.Action = s
+	case "result":
+		event.Result = s
+	default:
+		return fmt.Errorf("field %s cannot be transformed", field)
+	}
+	return nil
+}
+
+func removeEventField(event *Event, field string) error {
+	root, rest, nested := strings.Cut(field, ".")
+	if !nested || (root != "data" && root != "metadata") {
+		return fmt.Errorf("field %s cannot be removed", field)
+	}
+	m := event.Data
+	if root == "metadata" {
+		m = event.Metadata
+	}
+	keys := strings.Split(rest, ".")
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := m[key].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		m = next
+	}
+	delete(m, keys[len(keys)-1])
+	return nil
+}
+
+func setPath(m map[string]interface{}, path string, value interface{}) error {
+	keys := strings.Split(path, ".")
+	for _, key := range keys[:len(keys)-1] {
+		switch next := m[key].(type) {
+		case map[string]interface{}:
+			m = next
+		case nil:
+			created := make(map[string]interface{})
+			m[key] = created
+			m = created
+		default:
+			return fmt.Errorf("path %s crosses non-object value at %q", path, key)
+		}
+	}
+	m[keys[len(keys)-1]] = value
+	return nil
+}
+
+// cloneEvent copies an event deeply enough that transformations cannot alter the original
+func cloneEvent(event *Event) *Event {
+	out := *event
+	if event.Data != nil {
+		out.Data = deepCopyValue(event.Data).(map[string]interface{})
+	}
+	if event.Metadata != nil {
+		out.Metadata = deepCopyValue(event.Metadata).(map[string]interface{})
+	}
+	return &out
+}
+
+func deepCopyValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			out[k] = deepCopyValue(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(value))
+		for i, item := range value {
+			out[i] = deepCopyValue(item)
+		}
+		return out
+	case nil, string, bool, float64, json.Number:
+		return v
+	default:
+		// Typed maps, slices and structs are copied as their JSON form so rules can walk them
+		switch reflect.ValueOf(v).Kind() {
+		case reflect.Map, reflect.Slice, reflect.Array, reflect.Struct, reflect.Pointer:
+			if normalized, err := normalizeJSONValue(v); err == nil {
+				return normalized
+			}
+		}
+		return v
+	}
+}
+
+// normalizeJSONValue converts v to the generic maps, slices and json.Numbers it decodes to
+func normalizeJSONValue(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var out interface{}
+	if err := decoder.Decode(&out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
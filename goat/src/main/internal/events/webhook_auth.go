@@ -0,0 +1,552 @@
+package events
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenExpiryMargin refreshes OAuth2 tokens slightly before they expire
+const tokenExpiryMargin = 30 * time.Second
+
+// WebhookAuth configures how outbound requests authenticate to a webhook endpoint.
+// MTLS may be combined with one of Basic, Bearer or OAuth2. Secret material is never
+// marshalled to JSON.
+type WebhookAuth struct {
+	Basic  *BasicAuth               `json:"basic,omitempty"`
+	Bearer *BearerAuth              `json:"bearer,omitempty"`
+	OAuth2 *OAuth2ClientCredentials `json:"oauth2,omitempty"`
+	MTLS   *MTLSConfig              `json:"mtls,omitempty"`
+}
+
+// BasicAuth sends HTTP basic credentials
+type BasicAuth struct {
+	Username string `json:"username"`
+	Password string `json:"-"`
+}
+
+// BearerAuth sends a static bearer token
+type BearerAuth struct {
+	Token string `json:"-"`
+}
+
+// OAuth2ClientCredentials obtains access tokens with the client credentials grant
+type OAuth2ClientCredentials struct {
+	TokenURL     string   `json:"token_url"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"-"`
+	Scopes       []string `json:"scopes,omitempty"`
+	Audience     string   `json:"audience,omitempty"`
+	// CredentialsInBody sends client_id and client_secret as form fields instead of basic auth
+	CredentialsInBody bool `json:"credentials_in_body,omitempty"`
+}
+
+// MTLSConfig presents a client certificate and optionally pins the server CA
+type MTLSConfig struct {
+	ClientCertPEM string `json:"client_cert_pem"`
+	ClientKeyPEM  string `json:"-"`
+	// CACertPEM, when set, replaces the system roots so only this CA is trusted
+	CACertPEM  string `json:"ca_cert_pem,omitempty"`
+	ServerName string `json:"server_name,omitempty"`
+}
+
+// validateWebhookAuth checks that an auth configuration is complete and unambiguous
+func validateWebhookAuth(auth *WebhookAuth, webhookURL string) error {
+	if auth == nil {
+		return nil
+	}
+	schemes := 0
+	if auth.Basic != nil {
+		schemes++
+		if auth.Basic.Username == "" {
+			return errors.New("basic auth requires a username")
+		}
+	}
+	if auth.Bearer != nil {
+		schemes++
+		if auth.Bearer.Token == "" {
+			return errors.New("bearer auth requires a token")
+		}
+	}
+	if auth.OAuth2 != nil {
+		schemes++
+		tokenURL, err := url.Parse(auth.OAuth2.TokenURL)
+		if err != nil || tokenURL.Scheme != "https" || tokenURL.Host == "" {
+			return errors.New("oauth2 token_url must be an absolute https url")
+		}
+		if auth.OAuth2.ClientID == "" || auth.OAuth2.ClientSecret == "" {
+			return errors.New("oauth2 requires client_id and client_secret")
+		}
+	}
+	if schemes > 1 {
+		return errors.New("only one of basic, bearer or oauth2 auth may be configured")
+	}
+	if auth.MTLS != nil {
+		if !strings.HasPrefix(strings.ToLower(webhookURL), "https://") {
+			return errors.New("mtls requires an https webhook url")
+		}
+		if _, err := auth.MTLS.tlsConfig(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MTLSConfig) tlsConfig() (*tls.Config, error) {
+	cert, err := tls.X509KeyPair([]byte(m.ClientCertPEM), []byte(m.ClientKeyPEM))
+	if err != nil {
+		return nil, fmt.Errorf("invalid mtls client certificate: %w", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ServerName:   m.ServerName,
+		MinVersion:   tls.VersionTLS12,
+	}
+	if m.CACertPEM != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(m.CACertPEM)) {
+			return nil, errors.New("invalid mtls ca certificate")
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// webhookAuthRecord is the storage form of WebhookAuth, including secret material
+type webhookAuthRecord struct {
+	BasicUsername      string   `json:"basic_username,omitempty"`
+	BasicPassword      string   `json:"basic_password,omitempty"`
+	BearerToken        string   `json:"bearer_token,omitempty"`
+	HasBasic           bool     `json:"has_basic,omitempty"`
+	HasBearer          bool     `json:"has_bearer,omitempty"`
+	OAuth2TokenURL     string   `json:"oauth2_token_url,omitempty"`
+	OAuth2ClientID     string   `json:"oauth2_client_id,omitempty"`
+	OAuth2ClientSecret string   `json:"oauth2_client_secret,omitempty"`
+	OAuth2Scopes       []string `json:"oauth2_scopes,omitempty"`
+	OAuth2Audience     string   `json:"oauth2_audience,omitempty"`
+	OAuth2InBody       bool     `json:"oauth2_credentials_in_body,omitempty"`
+	MTLSCertPEM        string   `json:"mtls_client_cert_pem,omitempty"`
+	MTLSKeyPEM         string   `json:"mtls_client_key_pem,omitempty"`
+	MTLSCACertPEM      string   `json:"mtls_ca_cert_pem,omitempty"`
+	MTLSServerName     string   `json:"mtls_server_name,omitempty"`
+}
+
+func (a *WebhookAuth) record() webhookAuthRecord {
+	var r webhookAuthRecord
+	if a.Basic != nil {
+		r.HasBasic = true
+		r.BasicUsername = a.Basic.Username
+		r.BasicPassword = a.Basic.Password
+	}
+	if a.Bearer != nil {
+		r.HasBearer = true
+		r.BearerToken = a.Bearer.Token
+	}
+	if o := a.OAuth2; o != nil {
+		r.OAuth2TokenURL = o.TokenURL
+		r.OAuth2ClientID = o.ClientID
+		r.OAuth2ClientSecret = o.ClientSecret
+		r.OAuth2Scopes = o.Scopes
+		r.OAuth2Audience = o.Audience
+		r.OAuth2InBody = o.CredentialsInBody
+	}
+	if m := a.MTLS; m != nil {
+		r.MTLSCertPEM = m.ClientCertPEM
+		r.MTLSKeyPEM = m.ClientKeyPEM
+		r.MTLSCACertPEM = m.CACertPEM
+		r.MTLSServerName = m.ServerName
+	}
+	return r
+}
+
+func (r webhookAuthRecord) auth() *WebhookAuth {
+	auth := &WebhookAuth{}
+	if r.HasBasic {
+		auth.Basic = &BasicAuth{Username: r.BasicUsername, Password: r.BasicPassword}
+	}
+	if r.HasBearer {
+		auth.Bearer = &BearerAuth{Token: r.BearerToken}
+	}
+	if r.OAuth2TokenURL != "" {
+		auth.OAuth2 = &OAuth2ClientCredentials{
+			TokenURL:          r.OAuth2TokenURL,
+			ClientID:          r.OAuth2ClientID,
+			ClientSecret:      r.OAuth2ClientSecret,
+			Scopes:            r.OAuth2Scopes,
+			Audience:          r.OAuth2Audience,
+			CredentialsInBody: r.OAuth2InBody,
+		}
+	}
+	if r.MTLSCertPEM != "" {
+		auth.MTLS = &MTLSConfig{
+			ClientCertPEM: r.MTLSCertPEM,
+			ClientKeyPEM:  r.MTLSKeyPEM,
+			CACertPEM:     r.MTLSCACertPEM,
+			ServerName:    r.MTLSServerName,
+		}
+	}
+	return auth
+}
+
+// marshalWebhookAuth encodes auth for the webhooks.auth column, secrets included
+func marshalWebhookAuth(auth *WebhookAuth) (interface{}, error) {
+	if auth == nil {
+		return nil, nil
+	}
+	return json.Marshal(auth.record())
+}
+
+// unmarshalWebhookAuth decodes the webhooks.auth column; an empty configuration decodes to nil
+func unmarshalWebhookAuth(data []byte) (*WebhookAuth, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var r webhookAuthRecord
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+	if auth := r.auth(); !auth.isEmpty() {
+		return auth, nil
+	}
+	return nil, nil
+}
+
+func (a *WebhookAuth) isEmpty() bool {
+	return a.Basic == nil && a.Bearer == nil && a.OAuth2 == nil && a.MTLS == nil
+}
+
+// missingSecrets reports whether a configured scheme came without its secret, as it does when
+// a webhook read from the API is sent back unchanged
+func (a *WebhookAuth) missingSecrets() bool {
+	if a == nil {
+		return false
+	}
+	return (a.Basic != nil && a.Basic.Password == "") ||
+		(a.Bearer != nil && a.Bearer.Token == "") ||
+		(a.OAuth2 != nil && a.OAuth2.ClientSecret == "") ||
+		(a.MTLS != nil && a.MTLS.ClientKeyPEM == "")
+}
+
+// mergeWebhookAuth applies an update to stored auth. A nil update keeps the stored configuration,
+// an empty one removes it, and a scheme whose secret is missing keeps the stored secret as long as
+// the identifying fields are unchanged.
+func mergeWebhookAuth(update, stored *WebhookAuth) *WebhookAuth {
+	if update == nil {
+		return cloneWebhookAuth(stored)
+	}
+	if update.isEmpty() {
+		return nil
+	}
+	merged := cloneWebhookAuth(update)
+	if stored == nil {
+		return merged
+	}
+	if b := merged.Basic; b != nil && b.Password == "" && stored.Basic != nil && stored.Basic.Username == b.Username {
+		b.Password = stored.Basic.Password
+	}
+	if b := merged.Bearer; b != nil && b.Token == "" && stored.Bearer != nil {
+		b.Token = stored.Bearer.Token
+	}
+	if o := merged.OAuth2; o != nil && o.ClientSecret == "" && stored.OAuth2 != nil &&
+		stored.OAuth2.TokenURL == o.TokenURL && stored.OAuth2.ClientID == o.ClientID {
+		o.ClientSecret = stored.OAuth2.ClientSecret
+	}
+	if m := merged.MTLS; m != nil && m.ClientKeyPEM == "" && stored.MTLS != nil && stored.MTLS.ClientCertPEM == m.ClientCertPEM {
+		m.ClientKeyPEM = stored.MTLS.ClientKeyPEM
+	}
+	return merged
+}
+
+// fingerprint identifies an auth configuration so cached transports are rebuilt when it changes
+func (a *WebhookAuth) fingerprint() string {
+	data, _ := json.Marshal(a.record())
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func cloneWebhookAuth(auth *WebhookAuth) *WebhookAuth {
+	if auth == nil {
+		return nil
+	}
+	return auth.record().auth()
+}
+
+// webhookClient caches the HTTP client built for one webhook's auth configuration
+type webhookClient struct {
+	fingerprint string
+	client      *http.Client
+}
+
+// clientFor returns the HTTP client used for a webhook, building and caching a per-webhook
+// client derived from the deliverer's base client when the webhook carries auth configuration.
+func (d *DefaultWebhookDeliverer) clientFor(webhook *Webhook) (*http.Client, error) {
+	if webhook.Auth == nil {
+		d.ForgetWebhook(webhook.ID)
+		return d.client, nil
+	}
+	fingerprint := webhook.Auth.fingerprint()
+
+	d.clientsMu.Lock()
+	defer d.clientsMu.Unlock()
+	if cached, ok := d.clients[webhook.ID]; ok && cached.fingerprint == fingerprint {
+		return cached.client, nil
+	}
+
+	client, err := buildAuthClient(d.client, webhook.Auth, d.clock)
+	if err != nil {
+		return nil, err
+	}
+	d.clients[webhook.ID] = &webhookClient{fingerprint: fingerprint, client: client}
+	return client, nil
+}
+
+// webhookAuthChanged reports whether two auth configurations differ, secrets included
+func webhookAuthChanged(a, b *WebhookAuth) bool {
+	if a == nil || b == nil {
+		return a != b
+	}
+	return a.fingerprint() != b.fingerprint()
+}
+
+// ForgetWebhook drops the client cached for a webhook. The webhook services call it when a
+// webhook is deleted or its auth changes.
+func (d *DefaultWebhookDeliverer) ForgetWebhook(webhookID string) {
+	d.clientsMu.Lock()
+	delete(d.clients, webhookID)
+	d.clientsMu.Unlock()
+}
+
+// webhookClientCache is implemented by deliverers that cache per-webhook clients
+type webhookClientCache interface {
+	ForgetWebhook(webhookID string)
+}
+
+// forgetWebhookClient drops a webhook's cached client when the tester keeps one
+func forgetWebhookClient(tester WebhookTester, webhookID string) {
+	if cache, ok := tester.(webhookClientCache); ok {
+		cache.ForgetWebhook(webhookID)
+	}
+}
+
+// buildAuthClient layers TLS client certificates and an Authorization header onto base. The
+// client does not follow redirects, so credentials only ever reach the configured endpoint.
+func buildAuthClient(base *http.Client, auth *WebhookAuth, clock Clock) (*http.Client, error) {
+	transport := base.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	// The token endpoint is reached through the same guarded transport, without the auth layer.
+	// A redirect could carry the client secret elsewhere, so it is not followed either.
+	tokenClient := &http.Client{Transport: transport, Timeout: base.Timeout, CheckRedirect: noRedirects}
+
+	if auth.MTLS != nil {
+		httpTransport, ok := transport.(*http.Transport)
+		if !ok {
+			return nil, errors.New("mtls requires the deliverer to use an *http.Transport")
+		}
+		tlsConfig, err := auth.MTLS.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		clone := httpTransport.Clone()
+		clone.TLSClientConfig = tlsConfig
+		transport = clone
+	}
+
+	var authorize authorizer
+	switch {
+	case auth.Basic != nil:
+		authorize = basicAuthorizer{username: auth.Basic.Username, password: auth.Basic.Password}
+	case auth.Bearer != nil:
+		authorize = staticAuthorizer("Bearer " + auth.Bearer.Token)
+	case auth.OAuth2 != nil:
+		authorize = &oauth2TokenSource{config: *auth.OAuth2, client: tokenClient, clock: clock}
+	}
+	if authorize != nil {
+		transport = &authTransport{base: transport, authorize: authorize}
+	}
+
+	client := *base
+	client.Transport = transport
+	client.CheckRedirect = noRedirects
+	return &client, nil
+}
+
+// authorizer sets credentials on an outbound request
+type authorizer interface {
+	authorize(req *http.Request) error
+}
+
+type basicAuthorizer struct {
+	username string
+	password string
+}
+
+func (a basicAuthorizer) authorize(req *http.Request) error {
+	req.SetBasicAuth(a.username, a.password)
+	return nil
+}
+
+type staticAuthorizer string
+
+func (a staticAuthorizer) authorize(req *http.Request) error {
+	req.Header.Set("Authorization", string(a))
+	return nil
+}
+
+// authTransport adds credentials to a copy of each request so they never reach delivery records
+type authTransport struct {
+	base      http.RoundTripper
+	authorize authorizer
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	authed := req.Clone(req.Context())
+	if err := t.authorize.authorize(authed); err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(authed)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		if source, ok := t.authorize.(*oauth2TokenSource); ok {
+			source.invalidate()
+		}
+	}
+	return resp, err
+}
+
+// oauth2TokenSource fetches and caches client credentials access tokens
+type oauth2TokenSource struct {
+	config OAuth2ClientCredentials
+	client *http.Client
+	clock  Clock
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+	// refreshing is closed when the token request in flight completes
+	refreshing chan struct{}
+}
+
+type oauth2TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func (s *oauth2TokenSource) authorize(req *http.Request) error {
+	token, err := s.accessToken(req.Context())
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrWebhookAuth, err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func (s *oauth2TokenSource) invalidate() {
+	s.mu.Lock()
+	s.token = ""
+	s.mu.Unlock()
+}
+
+// accessToken returns the cached token, refreshing it when missing or about to expire. Only one
+// refresh runs at a time and the lock is not held while it waits on the token endpoint.
+func (s *oauth2TokenSource) accessToken(ctx context.Context) (string, error) {
+	for {
+		s.mu.Lock()
+		if s.token != "" && (s.expiry.IsZero() || s.clock.Now().Add(tokenExpiryMargin).Before(s.expiry)) {
+			token := s.token
+			s.mu.Unlock()
+			return token, nil
+		}
+		if wait := s.refreshing; wait != nil {
+			s.mu.Unlock()
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
+		done := make(chan struct{})
+		s.refreshing = done
+		s.mu.Unlock()
+
+		token, expiry, err := s.fetchToken(ctx)
+
+		s.mu.Lock()
+		s.refreshing = nil
+		if err == nil {
+			s.token, s.expiry = token, expiry
+		}
+		s.mu.Unlock()
+		close(done)
+		return token, err
+	}
+}
+
+// fetchToken requests a new access token from the token endpoint
+func (s *oauth2TokenSource) fetchToken(ctx context.Context) (string, time.Time, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(s.config.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.config.Scopes, " "))
+	}
+	if s.config.Audience != "" {
+		form.Set("audience", s.config.Audience)
+	}
+	if s.config.CredentialsInBody {
+		form.Set("client_id", s.config.ClientID)
+		form.Set("client_secret", s.config.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if !s.config.CredentialsInBody {
+		req.SetBasicAuth(url.QueryEscape(s.config.ClientID), url.QueryEscape(s.config.ClientSecret))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("oauth2 token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBodySize))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("oauth2 token request failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("oauth2 token endpoint returned status %d", resp.StatusCode)
+	}
+
+	var token oauth2TokenResponse
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid oauth2 token response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", time.Time{}, errors.New("oauth2 token response did not include an access_token")
+	}
+	if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+		return "", time.Time{}, fmt.Errorf("unsupported oauth2 token type %q", token.TokenType)
+	}
+
+	var expiry time.Time
+	if token.ExpiresIn > 0 {
+		expiry = s.clock.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+	return token.AccessToken, expiry, nil
+}
@@ -0,0 +1,506 @@
+package events
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Key management algorithms supported for payload encryption. Content is always encrypted with A256GCM.
+const (
+	JWEAlgRSAOAEP256 = "RSA-OAEP-256"
+	JWEAlgECDHES     = "ECDH-ES"
+
+	jweEncA256GCM = "A256GCM"
+)
+
+// JWEContentType is the Content-Type of encrypted webhook bodies
+const JWEContentType = "application/jose"
+
+const (
+	jwksCacheTTL     = time.Hour
+	maxJWKSSize      = 256 << 10
+	minRSAKeyBits    = 2048
+	a256gcmKeyLength = 32
+)
+
+// WebhookEncryption encrypts webhook bodies as compact JWE for the partner's public key. Exactly one
+// of PublicKeyPEM, JWKS or JWKSURL supplies the key. With a key set, KeyID picks the key; otherwise
+// the first key suitable for Algorithm is used. The chosen key's ID is sent in the JWE kid header so
+// partners can rotate keys.
+type WebhookEncryption struct {
+	Algorithm    string          `json:"alg"`
+	KeyID        string          `json:"kid,omitempty"`
+	PublicKeyPEM string          `json:"public_key_pem,omitempty"`
+	JWKS         json.RawMessage `json:"jwks,omitempty"`
+	JWKSURL      string          `json:"jwks_url,omitempty"`
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+type jweHeader struct {
+	Alg string      `json:"alg"`
+	Enc string      `json:"enc"`
+	Kid string      `json:"kid,omitempty"`
+	Cty string      `json:"cty,omitempty"`
+	Epk *jsonWebKey `json:"epk,omitempty"`
+}
+
+func validateWebhookEncryption(enc *WebhookEncryption) error {
+	if enc == nil {
+		return nil
+	}
+	if enc.Algorithm != JWEAlgRSAOAEP256 && enc.Algorithm != JWEAlgECDHES {
+		return fmt.Errorf("unsupported encryption alg %q", enc.Algorithm)
+	}
+	sources := 0
+	for _, set := range []bool{enc.PublicKeyPEM != "", len(enc.JWKS) > 0, enc.JWKSURL != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return errors.New("encryption requires exactly one of public_key_pem, jwks or jwks_url")
+	}
+	if enc.JWKSURL != "" {
+		parsed, err := url.Parse(enc.JWKSURL)
+		if err != nil || parsed.Scheme != "https" || parsed.Hostname() == "" {
+			return fmt.Errorf("encryption jwks_url must be an absolute https url, got %q", enc.JWKSURL)
+		}
+		return nil
+	}
+	_, err := enc.staticKey()
+	return err
+}
+
+// staticKey resolves the key configured inline as PEM or JWKS
+func (e *WebhookEncryption) staticKey() (crypto.PublicKey, error) {
+	if e.PublicKeyPEM != "" {
+		block, _ := pem.Decode([]byte(e.PublicKeyPEM))
+		if block == nil {
+			return nil, errors.New("encryption public_key_pem is not PEM encoded")
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("encryption public key: %w", err)
+		}
+		return checkEncryptionKey(key, e.Algorithm)
+	}
+	var set jsonWebKeySet
+	if err := json.Unmarshal(e.JWKS, &set); err != nil {
+		return nil, fmt.Errorf("encryption jwks: %w", err)
+	}
+	key, _, err := selectJWK(set.Keys, e.Algorithm, e.KeyID)
+	return key, err
+}
+
+// checkEncryptionKey converts key to the form used for alg, rejecting mismatched or weak keys
+func checkEncryptionKey(key crypto.PublicKey, alg string) (crypto.PublicKey, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if alg != JWEAlgRSAOAEP256 {
+			return nil, fmt.Errorf("%s requires an EC or X25519 key, got RSA", alg)
+		}
+		if k.N.BitLen() < minRSAKeyBits {
+			return nil, fmt.Errorf("rsa encryption key must be at least %d bits", minRSAKeyBits)
+		}
+		return k, nil
+	case *ecdsa.PublicKey:
+		converted, err := k.ECDH()
+		if err != nil {
+			return nil, err
+		}
+		return checkEncryptionKey(converted, alg)
+	case *ecdh.PublicKey:
+		if alg != JWEAlgECDHES {
+			return nil, fmt.Errorf("%s requires an RSA key", alg)
+		}
+		return k, nil
+	default:
+		return nil, fmt.Errorf("unsupported encryption key type %T", key)
+	}
+}
+
+// selectJWK returns the key with the given ID, or the first key usable for alg if kid is empty
+func selectJWK(keys []jsonWebKey, alg, kid string) (crypto.PublicKey, string, error) {
+	for _, jwk := range keys {
+		if kid != "" && jwk.Kid != kid {
+			continue
+		}
+		if (jwk.Use != "" && jwk.Use != "enc") || (jwk.Alg != "" && jwk.Alg != alg) {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			if kid != "" {
+				return nil, "", err
+			}
+			continue
+		}
+		if key, err = checkEncryptionKey(key, alg); err != nil {
+			if kid != "" {
+				return nil, "", err
+			}
+			continue
+		}
+		return key, jwk.Kid, nil
+	}
+	if kid != "" {
+		return nil, "", fmt.Errorf("no encryption key with kid %q", kid)
+	}
+	return nil, "", fmt.Errorf("no encryption key usable for %s", alg)
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("jwk n: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("jwk e is invalid")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC", "OKP":
+		curve, size, err := jwkCurve(k.Crv)
+		if err != nil {
+			return nil, err
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != size {
+			return nil, errors.New("jwk x is invalid")
+		}
+		if curve == ecdh.X25519() {
+			return curve.NewPublicKey(x)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil || len(y) != size {
+			return nil, errors.New("jwk y is invalid")
+		}
+		return curve.NewPublicKey(append(append([]byte{4}, x...), y...))
+	default:
+		return nil, fmt.Errorf("unsupported jwk kty %q", k.Kty)
+	}
+}
+
+func jwkCurve(crv string) (ecdh.Curve, int, error) {
+	switch crv {
+	case "P-256":
+		return ecdh.P256(), 32, nil
+	case "P-384":
+		return ecdh.P384(), 48, nil
+	case "P-521":
+		return ecdh.P521(), 66, nil
+	case "X25519":
+		return ecdh.X25519(), 32, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported jwk crv %q", crv)
+	}
+}
+
+// ephemeralJWK describes an ephemeral ECDH public key for the epk header
+func ephemeralJWK(key *ecdh.PublicKey) *jsonWebKey {
+	raw := key.Bytes()
+	switch key.Curve() {
+	case ecdh.X25519():
+		return &jsonWebKey{Kty: "OKP", Crv: "X25519", X: base64.RawURLEncoding.EncodeToString(raw)}
+	default:
+		crv := map[ecdh.Curve]string{ecdh.P256(): "P-256", ecdh.P384(): "P-384", ecdh.P521(): "P-521"}[key.Curve()]
+		size := (len(raw) - 1) / 2
+		return &jsonWebKey{
+			Kty: "EC",
+			Crv: crv,
+			X:   base64.RawURLEncoding.EncodeToString(raw[1 : 1+size]),
+			Y:   base64.RawURLEncoding.EncodeToString(raw[1+size:]),
+		}
+	}
+}
+
+// EncryptCompactJWE encrypts plaintext with A256GCM for key, an *rsa.PublicKey for RSA-OAEP-256
+// or an EC or X25519 key for ECDH-ES. kid and cty are copied into the protected header when set.
+func EncryptCompactJWE(plaintext []byte, key crypto.PublicKey, alg, kid, cty string) (string, error) {
+	key, err := checkEncryptionKey(key, alg)
+	if err != nil {
+		return "", err
+	}
+	header := jweHeader{Alg: alg, Enc: jweEncA256GCM, Kid: kid, Cty: cty}
+
+	var cek, encryptedKey []byte
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		cek = make([]byte, a256gcmKeyLength)
+		if _, err := io.ReadFull(rand.Reader, cek); err != nil {
+			return "", err
+		}
+		if encryptedKey, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, k, cek, nil); err != nil {
+			return "", err
+		}
+	case *ecdh.PublicKey:
+		ephemeral, err := k.Curve().GenerateKey(rand.Reader)
+		if err != nil {
+			return "", err
+		}
+		shared, err := ephemeral.ECDH(k)
+		if err != nil {
+			return "", err
+		}
+		cek = concatKDF(shared, jweEncA256GCM, a256gcmKeyLength)
+		header.Epk = ephemeralJWK(ephemeral.PublicKey())
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	protected := base64.RawURLEncoding.EncodeToString(headerJSON)
+
+	gcm, err := newA256GCM(cek)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nil, iv, plaintext, []byte(protected))
+	ciphertext, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+
+	return strings.Join([]string{
+		protected,
+		base64.RawURLEncoding.EncodeToString(encryptedKey),
+		base64.RawURLEncoding.EncodeToString(iv),
+		base64.RawURLEncoding.EncodeToString(ciphertext),
+		base64.RawURLEncoding.EncodeToString(tag),
+	}, "."), nil
+}
+
+// DecryptCompactJWE decrypts a token produced by EncryptCompactJWE. key is an *rsa.PrivateKey,
+// *ecdsa.PrivateKey or *ecdh.PrivateKey matching the token's alg.
+func DecryptCompactJWE(token string, key crypto.PrivateKey) ([]byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 {
+		return nil, errors.New("jwe must have five parts")
+	}
+	decoded := make([][]byte, 5)
+	for i, part := range parts {
+		b, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil {
+			return nil, fmt.Errorf("jwe part %d: %w", i, err)
+		}
+		decoded[i] = b
+	}
+	var header jweHeader
+	if err := json.Unmarshal(decoded[0], &header); err != nil {
+		return nil, fmt.Errorf("jwe header: %w", err)
+	}
+	if header.Enc != jweEncA256GCM {
+		return nil, fmt.Errorf("unsupported jwe enc %q", header.Enc)
+	}
+	if k, ok := key.(*ecdsa.PrivateKey); ok {
+		converted, err := k.ECDH()
+		if err != nil {
+			return nil, err
+		}
+		key = converted
+	}
+
+	var cek []byte
+	switch header.Alg {
+	case JWEAlgRSAOAEP256:
+		k, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s requires an RSA private key", header.Alg)
+		}
+		var err error
+		if cek, err = rsa.DecryptOAEP(sha256.New(), nil, k, decoded[1], nil); err != nil {
+			return nil, err
+		}
+	case JWEAlgECDHES:
+		k, ok := key.(*ecdh.PrivateKey)
+		if !ok || header.Epk == nil {
+			return nil, fmt.Errorf("%s requires an EC private key and an epk header", header.Alg)
+		}
+		epk, err := header.Epk.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("jwe epk: %w", err)
+		}
+		ephemeral, ok := epk.(*ecdh.PublicKey)
+		if !ok {
+			return nil, errors.New("jwe epk is not an ECDH key")
+		}
+		shared, err := k.ECDH(ephemeral)
+		if err != nil {
+			return nil, err
+		}
+		cek = concatKDF(shared, jweEncA256GCM, a256gcmKeyLength)
+	default:
+		return nil, fmt.Errorf("unsupported jwe alg %q", header.Alg)
+	}
+
+	gcm, err := newA256GCM(cek)
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded[2]) != gcm.NonceSize() {
+		return nil, errors.New("jwe iv has the wrong length")
+	}
+	return gcm.Open(nil, decoded[2], append(decoded[3], decoded[4]...), []byte(parts[0]))
+}
+
+func newA256GCM(cek []byte) (cipher.AEAD, error) {
+	if len(cek) != a256gcmKeyLength {
+		return nil, errors.New("jwe content key has the wrong length")
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// concatKDF derives the ECDH-ES content key (RFC 7518 section 4.6.2) with empty apu and apv.
+// keyLen is at most the SHA-256 output size, so one round suffices.
+func concatKDF(shared []byte, enc string, keyLen int) []byte {
+	h := sha256.New()
+	var buf [4]byte
+	writeUint32 := func(v uint32) {
+		binary.BigEndian.PutUint32(buf[:], v)
+		h.Write(buf[:])
+	}
+	writeUint32(1)
+	h.Write(shared)
+	writeUint32(uint32(len(enc)))
+	h.Write([]byte(enc))
+	writeUint32(0) // apu
+	writeUint32(0) // apv
+	writeUint32(uint32(keyLen * 8))
+	return h.Sum(nil)[:keyLen]
+}
+
+// jwksCache caches key sets fetched from partners' jwks_url
+type jwksCache struct {
+	clock   Clock
+	mu      sync.Mutex
+	entries map[string]jwksEntry
+}
+
+type jwksEntry struct {
+	keys      []jsonWebKey
+	fetchedAt time.Time
+}
+
+func newJWKSCache(clock Clock) *jwksCache {
+	return &jwksCache{clock: clock, entries: make(map[string]jwksEntry)}
+}
+
+// key returns the encryption key from the set at jwksURL. The set is refetched when it is
+// older than the cache TTL or does not contain the requested kid, so rotated keys are picked up.
+func (c *jwksCache) key(ctx context.Context, client *http.Client, jwksURL, alg, kid string) (crypto.PublicKey, string, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[jwksURL]
+	c.mu.Unlock()
+
+	if ok && c.clock.Now().Sub(entry.fetchedAt) < jwksCacheTTL {
+		if key, keyID, err := selectJWK(entry.keys, alg, kid); err == nil {
+			return key, keyID, nil
+		}
+	}
+	keys, err := fetchJWKS(ctx, client, jwksURL)
+	if err != nil {
+		return nil, "", err
+	}
+	c.mu.Lock()
+	c.entries[jwksURL] = jwksEntry{keys: keys, fetchedAt: c.clock.Now()}
+	c.mu.Unlock()
+	return selectJWK(keys, alg, kid)
+}
+
+func fetchJWKS(ctx context.Context, client *http.Client, jwksURL string) ([]jsonWebKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch jwks: unexpected status %d", resp.StatusCode)
+	}
+	var set jsonWebKeySet
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxJWKSSize)).Decode(&set); err != nil {
+		return nil, fmt.Errorf("fetch jwks: %w", err)
+	}
+	return set.Keys, nil
+}
+
+// encryptPayload wraps a payload of the given content type in a compact JWE for the webhook's key
+func (d *DefaultWebhookDeliverer) encryptPayload(ctx context.Context, enc *WebhookEncryption, payload []byte, contentType string) (string, error) {
+	var (
+		key   crypto.PublicKey
+		keyID = enc.KeyID
+		err   error
+	)
+	switch {
+	case enc.JWKSURL != "":
+		key, keyID, err = d.jwks.key(ctx, d.client, enc.JWKSURL, enc.Algorithm, enc.KeyID)
+	case len(enc.JWKS) > 0:
+		var set jsonWebKeySet
+		if err = json.Unmarshal(enc.JWKS, &set); err == nil {
+			key, keyID, err = selectJWK(set.Keys, enc.Algorithm, enc.KeyID)
+		}
+	default:
+		key, err = enc.staticKey()
+	}
+	if err != nil {
+		return "", err
+	}
+	return EncryptCompactJWE(payload, key, enc.Algorithm, keyID, contentType)
+}
+
+func cloneWebhookEncryption(enc *WebhookEncryption) *WebhookEncryption {
+	if enc == nil {
+		return nil
+	}
+	clone := *enc
+	clone.JWKS = append(json.RawMessage(nil), enc.JWKS...)
+	return &clone
+}
@@ -0,0 +1,1012 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDeliveryHistoryLimit = 50
+	maxDeliveryHistoryLimit     = 500
+)
+
+// ErrWebhookNotFound is returned when a webhook does not exist
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// WebhookTester sends a one-off request to a webhook without recording a delivery
+type WebhookTester interface {
+	// Test sends the event to the webhook and reports the response
+	Test(ctx context.Context, webhook *Webhook, event *Event) (*WebhookTestResult, error)
+}
+
+// DeliveryHistoryReader lists recorded webhook deliveries
+type DeliveryHistoryReader interface {
+	// ListDeliveries returns deliveries for a webhook, newest first
+	ListDeliveries(ctx context.Context, webhookID string, filter *DeliveryFilter) ([]*Delivery, error)
+}
+
+// ValidateWebhook checks that a webhook has a name, an absolute http(s) URL and known event types.
+// Event patterns ending in ".*", and "*" on its own, are accepted as wildcards.
+func ValidateWebhook(webhook *Webhook) error {
+	if webhook == nil {
+		return errors.New("webhook cannot be nil")
+	}
+	if strings.TrimSpace(webhook.Name) == "" {
+		return errors.New("webhook name is required")
+	}
+
+	parsed, err := url.Parse(webhook.URL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook url: %w", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("invalid webhook url: scheme must be http or https, got %q", parsed.Scheme)
+	}
+	if parsed.Hostname() == "" {
+		return errors.New("invalid webhook url: host is required")
+	}
+	if parsed.User != nil {
+		return errors.New("invalid webhook url: credentials must not be embedded in the url")
+	}
+
+	if len(webhook.Events) == 0 {
+		return errors.New("webhook must subscribe to at least one event type")
+	}
+	for _, eventType := range webhook.Events {
+		if !isValidEventPattern(eventType) {
+			return fmt.Errorf("unknown event type %q", eventType)
+		}
+	}
+
+	if err := validateWebhookAuth(webhook.Auth, webhook.URL); err != nil {
+		return err
+	}
+
+	if !webhook.Format.Valid() {
+		return fmt.Errorf("unknown payload format %q", webhook.Format)
+	}
+	if webhook.Format == PayloadFormatCloudEventsBatch {
+		return errBatchFormatPerEvent
+	}
+
+	if err := validateWebhookEncryption(webhook.Encryption); err != nil {
+		return err
+	}
+
+	if err := ValidateRedactionPolicy(webhook.Redaction); err != nil {
+		return err
+	}
+
+	if rc := webhook.RetryConfig; rc != nil {
+		if rc.MaxRetries < 0 {
+			return errors.New("retry max_retries cannot be negative")
+		}
+		if rc.InitialDelay < 0 || rc.MaxDelay < 0 || rc.Timeout < 0 {
+			return errors.New("retry delays and timeout cannot be negative")
+		}
+		if rc.MaxDelay > 0 && rc.InitialDelay > rc.MaxDelay {
+			return errors.New("retry initial_delay cannot exceed max_delay")
+		}
+		if rc.Multiplier != 0 && rc.Multiplier < 1 {
+			return errors.New("retry multiplier must be at least 1")
+		}
+	}
+	return nil
+}
+
+func isValidEventPattern(eventType EventType) bool {
+	if eventType == "*" {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(string(eventType), ".*"); ok {
+		return defaultEventTypes.matchesPrefix(prefix)
+	}
+	return IsKnownEventType(eventType)
+}
+
+// MatchesEventTypes reports whether eventType matches any of the patterns, honoring
+// ".*" and "*" wildcards. An empty pattern list matches every event type.
+func MatchesEventTypes(patterns []EventType, eventType EventType) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if pattern == "*" || pattern == eventType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(string(pattern), ".*"); ok && strings.HasPrefix(string(eventType), prefix+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// NewTestEvent builds the synthetic event sent by TestWebhook
+func NewTestEvent(webhook *Webhook) *Event {
+	eventType := EventType("webhook.test")
+	for _, candidate := range webhook.Events {
+		if IsKnownEventType(candidate) {
+			eventType = candidate
+			break
+		}
+	}
+	return &Event{
+		ID:        newUUID(),
+		TenantID:  webhook.TenantID,
+		Type:      eventType,
+		Priority:  PriorityLow,
+		Timestamp: time.Now().UTC(),
+		Action:    "test",
+		Result:    "success",
+		Data: map[string]interface{}{
+			"test":       true,
+			"webhook_id": webhook.ID,
+		},
+		Metadata: map[string]interface{}{
+			"source": "goat",
+			"test":   true,
+		},
+	}
+}
+
+// runWebhookTest sends a synthetic event to the webhook
+func runWebhookTest(ctx context.Context, tester WebhookTester, webhook *Webhook) (*Event, *WebhookTestResult, error) {
+	if tester == nil {
+		return nil, nil, errors.New("webhook tester is not configured")
+	}
+	event := NewTestEvent(webhook)
+	result, err := tester.Test(ctx, webhook, event)
+	if err != nil {
+		return event, nil, err
+	}
+	return event, result, nil
+}
+
+// filterDeliveries applies the webhook and delivery filters, sorts newest first and paginates
+func filterDeliveries(deliveries []*Delivery, webhookID string, filter *DeliveryFilter) []*Delivery {
+	if filter == nil {
+		filter = &DeliveryFilter{}
+	}
+	matched := make([]*Delivery, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		if delivery == nil {
+			continue
+		}
+		if webhookID != "" && delivery.WebhookID != webhookID {
+			continue
+		}
+		if filter.Status != "" && delivery.Status() != filter.Status {
+			continue
+		}
+		if filter.StartTime != nil && delivery.CreatedAt.Before(*filter.StartTime) {
+			continue
+		}
+		if filter.EndTime != nil && !delivery.CreatedAt.Before(*filter.EndTime) {
+			continue
+		}
+		matched = append(matched, delivery)
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		if !matched[i].CreatedAt.Equal(matched[j].CreatedAt) {
+			return matched[i].CreatedAt.After(matched[j].CreatedAt)
+		}
+		return matched[i].ID > matched[j].ID
+	})
+
+	limit, offset := deliveryPage(filter)
+	if offset >= len(matched) {
+		return []*Delivery{}
+	}
+	matched = matched[offset:]
+	if len(matched) > limit {
+		matched = matched[:limit]
+	}
+	return matched
+}
+
+func deliveryPage(filter *DeliveryFilter) (limit, offset int) {
+	limit = defaultDeliveryHistoryLimit
+	if filter != nil {
+		if filter.Limit > 0 {
+			limit = filter.Limit
+		}
+		if filter.Offset > 0 {
+			offset = filter.Offset
+		}
+	}
+	if limit > maxDeliveryHistoryLimit {
+		limit = maxDeliveryHistoryLimit
+	}
+	return limit, offset
+}
+
+func cloneWebhook(webhook *Webhook) *Webhook {
+	clone := *webhook
+	clone.Events = append([]EventType(nil), webhook.Events...)
+	clone.Filters = append([]Filter(nil), webhook.Filters...)
+	if webhook.Headers != nil {
+		clone.Headers = make(map[string]string, len(webhook.Headers))
+		for k, v := range webhook.Headers {
+			clone.Headers[k] = v
+		}
+	}
+	clone.Auth = cloneWebhookAuth(webhook.Auth)
+	clone.Encryption = cloneWebhookEncryption(webhook.Encryption)
+	clone.Redaction = cloneRedactionPolicy(webhook.Redaction)
+	if webhook.RetryConfig != nil {
+		rc := *webhook.RetryConfig
+		clone.RetryConfig = &rc
+	}
+	if webhook.LastTriggered != nil {
+		t := *webhook.LastTriggered
+		clone.LastTriggered = &t
+	}
+	if webhook.VerifiedAt != nil {
+		t := *webhook.VerifiedAt
+		clone.VerifiedAt = &t
+	}
+	return &clone
+}
+
+// verifyOnSave runs the ownership handshake for a webhook that was just saved as pending.
+// A failed handshake leaves it pending rather than failing the save; VerifyWebhook retries it.
+func verifyOnSave(ctx context.Context, verify func(context.Context, string) (*Webhook, error), webhook *Webhook) {
+	if webhook.VerificationStatus != VerificationPending {
+		return
+	}
+	if verified, err := verify(ctx, webhook.ID); err == nil {
+		webhook.VerificationStatus = verified.VerificationStatus
+		webhook.VerifiedAt = verified.VerifiedAt
+	}
+}
+
+// InMemoryWebhookService implements WebhookService backed by process memory
+type InMemoryWebhookService struct {
+	tester   WebhookTester
+	history  DeliveryHistoryReader
+	verifier WebhookVerifier
+	quotas   *TenantQuotas
+	webhooks map[string]*Webhook
+	mu       sync.RWMutex
+}
+
+// NewInMemoryWebhookService creates a new in-memory webhook service.
+// DefaultWebhookDeliverer satisfies both tester and history.
+func NewInMemoryWebhookService(tester WebhookTester, history DeliveryHistoryReader) *InMemoryWebhookService {
+	return &InMemoryWebhookService{
+		tester:   tester,
+		history:  history,
+		webhooks: make(map[string]*Webhook),
+	}
+}
+
+// SetWebhookVerifier registers the verifier used for webhooks with VerifyOwnership set.
+// Without one such webhooks stay pending. It must be called before the service is used.
+func (s *InMemoryWebhookService) SetWebhookVerifier(verifier WebhookVerifier) {
+	s.verifier = verifier
+}
+
+// SetTenantQuotas limits how many webhooks each tenant may create. It must be called before the service is used.
+func (s *InMemoryWebhookService) SetTenantQuotas(quotas *TenantQuotas) {
+	s.quotas = quotas
+}
+
+// CreateWebhook validates and stores a new webhook in the caller's tenant, assigning its ID and
+// timestamps. Webhooks with VerifyOwnership set are stored pending and then challenged.
+func (s *InMemoryWebhookService) CreateWebhook(ctx context.Context, webhook *Webhook) error {
+	if err := ValidateWebhook(webhook); err != nil {
+		return err
+	}
+	tenantID, err := resolveTenant(ctx, webhook.TenantID)
+	if err != nil {
+		return err
+	}
+	webhook.TenantID = tenantID
+	now := time.Now().UTC()
+	if webhook.ID == "" {
+		webhook.ID = newUUID()
+	}
+	webhook.CreatedAt = now
+	webhook.UpdatedAt = now
+	webhook.VerificationStatus = verificationStatus(webhook, nil)
+	webhook.VerifiedAt = nil
+
+	s.mu.Lock()
+	if _, exists := s.webhooks[webhook.ID]; exists {
+		s.mu.Unlock()
+		return fmt.Errorf("webhook %s already exists", webhook.ID)
+	}
+	if s.quotas != nil && tenantID != "" {
+		count := 0
+		for _, existing := range s.webhooks {
+			if existing.TenantID == tenantID {
+				count++
+			}
+		}
+		if err := s.quotas.checkWebhookCount(tenantID, count); err != nil {
+			s.mu.Unlock()
+			return err
+		}
+	}
+	s.webhooks[webhook.ID] = cloneWebhook(webhook)
+	s.mu.Unlock()
+
+	verifyOnSave(ctx, s.VerifyWebhook, webhook)
+	return nil
+}
+
+// UpdateWebhook replaces an existing webhook; an empty secret keeps the stored one and the
+// tenant cannot change. Auth is merged as described on mergeWebhookAuth. Changing the URL of a
+// webhook with VerifyOwnership set makes it pending again.
+func (s *InMemoryWebhookService) UpdateWebhook(ctx context.Context, webhook *Webhook) error {
+	if _, err := callerTenant(ctx); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	existing, ok := s.webhooks[webhook.ID]
+	if !ok || !tenantVisible(ctx, existing.TenantID) {
+		s.mu.Unlock()
+		return fmt.Errorf("webhook %s: %w", webhook.ID, ErrWebhookNotFound)
+	}
+	webhook.Auth = mergeWebhookAuth(webhook.Auth, existing.Auth)
+	if err := ValidateWebhook(webhook); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	if webhook.Secret == "" {
+		webhook.Secret = existing.Secret
+	}
+	webhook.TenantID = existing.TenantID
+	webhook.CreatedAt = existing.CreatedAt
+	webhook.UpdatedAt = time.Now().UTC()
+	webhook.VerificationStatus = verificationStatus(webhook, existing)
+	webhook.VerifiedAt = nil
+	if webhook.VerificationStatus == VerificationVerified && existing.VerifiedAt != nil {
+		verifiedAt := *existing.VerifiedAt
+		webhook.VerifiedAt = &verifiedAt
+	}
+	authChanged := webhookAuthChanged(webhook.Auth, existing.Auth)
+	s.webhooks[webhook.ID] = cloneWebhook(webhook)
+	s.mu.Unlock()
+
+	if authChanged {
+		forgetWebhookClient(s.tester, webhook.ID)
+	}
+	verifyOnSave(ctx, s.VerifyWebhook, webhook)
+	return nil
+}
+
+// VerifyWebhook challenges the endpoint of a webhook pending verification and marks it verified
+// when the challenge is echoed. Webhooks that are not pending are returned unchanged.
+func (s *InMemoryWebhookService) VerifyWebhook(ctx context.Context, webhookID string) (*Webhook, error) {
+	webhook, err := s.GetWebhook(ctx, webhookID)
+	if err != nil || webhook.VerificationStatus != VerificationPending {
+		return webhook, err
+	}
+	if s.verifier == nil {
+		return webhook, errors.New("webhook verification is not configured")
+	}
+	if err := s.verifier.VerifyEndpoint(ctx, webhook); err != nil {
+		return webhook, fmt.Errorf("webhook %s: %w", webhookID, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.webhooks[webhookID]
+	if !ok {
+		return nil, fmt.Errorf("webhook %s: %w", webhookID, ErrWebhookNotFound)
+	}
+	// The URL may have changed while the challenge was in flight.
+	if stored.URL == webhook.URL && stored.VerificationStatus == VerificationPending {
+		now := time.Now().UTC()
+		stored.VerificationStatus = VerificationVerified
+		stored.VerifiedAt = &now
+	}
+	return cloneWebhook(stored), nil
+}
+
+// GetWebhook retrieves a webhook by ID; other tenants' webhooks are not found
+func (s *InMemoryWebhookService) GetWebhook(ctx context.Context, webhookID string) (*Webhook, error) {
+	if _, err := callerTenant(ctx); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	webhook, ok := s.webhooks[webhookID]
+	if !ok || !tenantVisible(ctx, webhook.TenantID) {
+		return nil, fmt.Errorf("webhook %s: %w", webhookID, ErrWebhookNotFound)
+	}
+	return cloneWebhook(webhook), nil
+}
+
+// ListWebhooks lists the caller's webhooks ordered by creation time
+func (s *InMemoryWebhookService) ListWebhooks(ctx context.Context) ([]*Webhook, error) {
+	if _, err := callerTenant(ctx); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	result := make([]*Webhook, 0, len(s.webhooks))
+	for _, webhook := range s.webhooks {
+		if tenantVisible(ctx, webhook.TenantID) {
+			result = append(result, cloneWebhook(webhook))
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].CreatedAt.Equal(result[j].CreatedAt) {
+			return result[i].CreatedAt.Before(result[j].CreatedAt)
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result, nil
+}
+
+// DeleteWebhook deletes a webhook
+func (s *InMemoryWebhookService) DeleteWebhook(ctx context.Context, webhookID string) error {
+	if _, err := callerTenant(ctx); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if webhook, ok := s.webhooks[webhookID]; !ok || !tenantVisible(ctx, webhook.TenantID) {
+		return fmt.Errorf("webhook %s: %w", webhookID, ErrWebhookNotFound)
+	}
+	delete(s.webhooks, webhookID)
+	forgetWebhookClient(s.tester, webhookID)
+	return nil
+}
+
+// DeactivateWebhook marks a webhook inactive
+func (s *InMemoryWebhookService) DeactivateWebhook(ctx context.Context, webhookID string) error {
+	if _, err := callerTenant(ctx); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	webhook, ok := s.webhooks[webhookID]
+	if !ok || !tenantVisible(ctx, webhook.TenantID) {
+		return fmt.Errorf("webhook %s: %w", webhookID, ErrWebhookNotFound)
+	}
+	webhook.Active = false
+	webhook.UpdatedAt = time.Now().UTC()
+	return nil
+}
+
+// TestWebhook sends a synthetic event to the webhook and reports the response
+func (s *InMemoryWebhookService) TestWebhook(ctx context.Context, webhookID string) (*WebhookTestResult, error) {
+	webhook, err := s.GetWebhook(ctx, webhookID)
+	if err != nil {
+		return nil, err
+	}
+	_, result, err := runWebhookTest(ctx, s.tester, webhook)
+	return result, err
+}
+
+// GetDeliveryHistory gets webhook delivery history, newest first
+func (s *InMemoryWebhookService) GetDeliveryHistory(ctx context.Context, webhookID string, filter *DeliveryFilter) ([]*Delivery, error) {
+	if _, err := s.GetWebhook(ctx, webhookID); err != nil {
+		return nil, err
+	}
+	if s.history == nil {
+		return []*Delivery{}, nil
+	}
+	return s.history.ListDeliveries(ctx, webhookID, filter)
+}
+
+// PostgresWebhookService implements WebhookService over the webhooks, webhook_deliveries
+// and webhook_test_results tables
+type PostgresWebhookService struct {
+	db       *sql.DB
+	tester   WebhookTester
+	verifier WebhookVerifier
+	quotas   *TenantQuotas
+}
+
+// NewPostgresWebhookService creates a new Postgres-backed webhook service
+func NewPostgresWebhookService(db *sql.DB, tester WebhookTester) *PostgresWebhookService {
+	return &PostgresWebhookService{db: db, tester: tester}
+}
+
+// SetWebhookVerifier registers the verifier used for webhooks with VerifyOwnership set.
+// Without one such webhooks stay pending. It must be called before the service is used.
+func (s *PostgresWebhookService) SetWebhookVerifier(verifier WebhookVerifier) {
+	s.verifier = verifier
+}
+
+// SetTenantQuotas limits how many webhooks each tenant may create. It must be called before the service is used.
+func (s *PostgresWebhookService) SetTenantQuotas(quotas *TenantQuotas) {
+	s.quotas = quotas
+}
+
+const webhookColumns = `id, tenant_id, name, url, events, headers, secret, active,
+	retry_max_attempts, retry_initial_delay_ms, retry_max_delay_ms, retry_multiplier, timeout_seconds,
+	filters, auth, COALESCE(format, ''), encryption, redaction, verify_ownership, COALESCE(verification_status, ''), verified_at,
+	failure_count, last_triggered_at, created_at, updated_at`
+
+// CreateWebhook validates and inserts a new webhook in the caller's tenant, assigning its ID and
+// timestamps. Webhooks with VerifyOwnership set are stored pending and then challenged.
+func (s *PostgresWebhookService) CreateWebhook(ctx context.Context, webhook *Webhook) error {
+	if err := ValidateWebhook(webhook); err != nil {
+		return err
+	}
+	tenantID, err := resolveTenant(ctx, webhook.TenantID)
+	if err != nil {
+		return err
+	}
+	webhook.TenantID = tenantID
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if s.quotas != nil && tenantID != "" {
+		// There is no tenants row to lock, so concurrent creates for one tenant are serialized on an
+		// advisory lock held until commit, keeping the count and the insert consistent.
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('webhooks:' || $1))`, tenantID); err != nil {
+			return err
+		}
+		var count int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM webhooks WHERE tenant_id = $1`, tenantID).Scan(&count); err != nil {
+			return err
+		}
+		if err := s.quotas.checkWebhookCount(tenantID, count); err != nil {
+			return err
+		}
+	}
+	if webhook.ID == "" {
+		webhook.ID = newUUID()
+	}
+	webhook.VerificationStatus = verificationStatus(webhook, nil)
+	webhook.VerifiedAt = nil
+	headers, filters, auth, encryption, redaction, err := webhookJSONColumns(webhook)
+	if err != nil {
+		return err
+	}
+	maxAttempts, initialDelay, maxDelay, multiplier, timeout := retryColumns(webhook.RetryConfig)
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO webhooks (
+			id, name, url, events, headers, secret, active,
+			retry_max_attempts, retry_initial_delay_ms, retry_max_delay_ms, retry_multiplier, timeout_seconds,
+			filters, auth, format, encryption, redaction, verify_ownership, verification_status, tenant_id
+		) VALUES ($1, $2, $3, $4::text[], $5, NULLIF($6, ''), $7, $8, $9, $10, $11, $12, $13, $14, NULLIF($15, ''), $16, $17,
+			$18, NULLIF($19, ''), $20)
+		RETURNING created_at, updated_at`,
+		webhook.ID, webhook.Name, webhook.URL, formatTextArray(eventTypesToStrings(webhook.Events)),
+		headers, webhook.Secret, webhook.Active,
+		maxAttempts, initialDelay, maxDelay, multiplier, timeout,
+		filters, auth, string(webhook.Format), encryption, redaction,
+		webhook.VerifyOwnership, string(webhook.VerificationStatus), webhook.TenantID,
+	).Scan(&webhook.CreatedAt, &webhook.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	verifyOnSave(ctx, s.VerifyWebhook, webhook)
+	return nil
+}
+
+// UpdateWebhook updates an existing webhook; an empty secret keeps the stored one and the
+// tenant cannot change. Auth is merged as described on mergeWebhookAuth. Changing the URL of a
+// webhook with VerifyOwnership set makes it pending again.
+func (s *PostgresWebhookService) UpdateWebhook(ctx context.Context, webhook *Webhook) error {
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return err
+	}
+	// A nil auth is kept by COALESCE below; only missing secrets need the stored configuration.
+	if webhook.Auth.missingSecrets() {
+		existing, err := s.GetWebhook(ctx, webhook.ID)
+		if err != nil {
+			return err
+		}
+		webhook.Auth = mergeWebhookAuth(webhook.Auth, existing.Auth)
+	}
+	if err := ValidateWebhook(webhook); err != nil {
+		return err
+	}
+	headers, filters, auth, encryption, redaction, err := webhookJSONColumns(webhook)
+	if err != nil {
+		return err
+	}
+	maxAttempts, initialDelay, maxDelay, multiplier, timeout := retryColumns(webhook.RetryConfig)
+
+	// Expressions on the right of SET see the row as it was before the update, so the
+	// verification state is kept only while verify_ownership stays on and the URL is unchanged.
+	var verifiedAt sql.NullTime
+	var authData []byte
+	err = s.db.QueryRowContext(ctx, `
+		UPDATE webhooks SET
+			name = $2, url = $3, events = $4::text[], headers = $5,
+			secret = COALESCE(NULLIF($6, ''), secret), active = $7,
+			retry_max_attempts = $8, retry_initial_delay_ms = $9, retry_max_delay_ms = $10,
+			retry_multiplier = $11, timeout_seconds = $12, filters = $13, auth = COALESCE($14, auth), format = NULLIF($15, ''),
+			encryption = $16, redaction = $17, verify_ownership = $18,
+			verification_status = CASE
+				WHEN NOT $18 THEN NULL
+				WHEN verify_ownership AND url = $3 THEN verification_status
+				ELSE 'pending_verification' END,
+			verified_at = CASE WHEN $18 AND verify_ownership AND url = $3 THEN verified_at END
+		WHERE id = $1 AND ($19 = '' OR tenant_id = $19)
+		RETURNING tenant_id, created_at, updated_at, COALESCE(verification_status, ''), verified_at, auth`,
+		webhook.ID, webhook.Name, webhook.URL, formatTextArray(eventTypesToStrings(webhook.Events)),
+		headers, webhook.Secret, webhook.Active,
+		maxAttempts, initialDelay, maxDelay, multiplier, timeout,
+		filters, auth, string(webhook.Format), encryption, redaction, webhook.VerifyOwnership, tenantID,
+	).Scan(&webhook.TenantID, &webhook.CreatedAt, &webhook.UpdatedAt, &webhook.VerificationStatus, &verifiedAt, &authData)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("webhook %s: %w", webhook.ID, ErrWebhookNotFound)
+	}
+	if err != nil {
+		return err
+	}
+	if webhook.Auth, err = unmarshalWebhookAuth(authData); err != nil {
+		return err
+	}
+	forgetWebhookClient(s.tester, webhook.ID)
+	webhook.VerifiedAt = nil
+	if verifiedAt.Valid {
+		webhook.VerifiedAt = &verifiedAt.Time
+	}
+	verifyOnSave(ctx, s.VerifyWebhook, webhook)
+	return nil
+}
+
+// VerifyWebhook challenges the endpoint of a webhook pending verification and marks it verified
+// when the challenge is echoed. Webhooks that are not pending are returned unchanged.
+func (s *PostgresWebhookService) VerifyWebhook(ctx context.Context, webhookID string) (*Webhook, error) {
+	webhook, err := s.GetWebhook(ctx, webhookID)
+	if err != nil || webhook.VerificationStatus != VerificationPending {
+		return webhook, err
+	}
+	if s.verifier == nil {
+		return webhook, errors.New("webhook verification is not configured")
+	}
+	if err := s.verifier.VerifyEndpoint(ctx, webhook); err != nil {
+		return webhook, fmt.Errorf("webhook %s: %w", webhookID, err)
+	}
+
+	// The URL may have changed while the challenge was in flight.
+	_, err = s.db.ExecContext(ctx, `
+		UPDATE webhooks SET verification_status = 'verified', verified_at = NOW()
+		WHERE id = $1 AND url = $2 AND verification_status = 'pending_verification'`,
+		webhookID, webhook.URL)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetWebhook(ctx, webhookID)
+}
+
+// GetWebhook retrieves a webhook by ID; other tenants' webhooks are not found
+func (s *PostgresWebhookService) GetWebhook(ctx context.Context, webhookID string) (*Webhook, error) {
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	row := s.db.QueryRowContext(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE id = $1 AND ($2 = '' OR tenant_id = $2)`,
+		webhookID, tenantID)
+	webhook, err := scanWebhook(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("webhook %s: %w", webhookID, ErrWebhookNotFound)
+	}
+	return webhook, err
+}
+
+// ListWebhooks lists the caller's webhooks ordered by creation time
+func (s *PostgresWebhookService) ListWebhooks(ctx context.Context) ([]*Webhook, error) {
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE $1 = '' OR tenant_id = $1
+		ORDER BY created_at ASC, id ASC`, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []*Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, webhook)
+	}
+	return result, rows.Err()
+}
+
+// DeleteWebhook deletes a webhook; deliveries and test results cascade
+func (s *PostgresWebhookService) DeleteWebhook(ctx context.Context, webhookID string) error {
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1 AND ($2 = '' OR tenant_id = $2)`,
+		webhookID, tenantID)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("webhook %s: %w", webhookID, ErrWebhookNotFound)
+	}
+	forgetWebhookClient(s.tester, webhookID)
+	return nil
+}
+
+// DeactivateWebhook marks a webhook inactive
+func (s *PostgresWebhookService) DeactivateWebhook(ctx context.Context, webhookID string) error {
+	tenantID, err := callerTenant(ctx)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE webhooks SET active = FALSE, last_failure_at = NOW()
+		WHERE id = $1 AND ($2 = '' OR tenant_id = $2)`, webhookID, tenantID)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("webhook %s: %w", webhookID, ErrWebhookNotFound)
+	}
+	return nil
+}
+
+// TestWebhook sends a synthetic event to the webhook and persists the result to webhook_test_results
+func (s *PostgresWebhookService) TestWebhook(ctx context.Context, webhookID string) (*WebhookTestResult, error) {
+	webhook, err := s.GetWebhook(ctx, webhookID)
+	if err != nil {
+		return nil, err
+	}
+	event, result, err := runWebhookTest(ctx, s.tester, webhook)
+	if err != nil {
+		return nil, err
+	}
+
+	testEvent, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	responseHeaders, err := marshalJSONColumn(result.Headers)
+	if err != nil {
+		return nil, err
+	}
+	var status sql.NullInt64
+	if result.StatusCode != 0 {
+		status = sql.NullInt64{Int64: int64(result.StatusCode), Valid: true}
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO webhook_test_results (
+			webhook_id, test_event, request_body, response_status, response_headers,
+			response_body, response_time_ms, success, error_message
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''))`,
+		webhook.ID, testEvent, string(testEvent), status, responseHeaders,
+		result.Body, result.ResponseTime.Milliseconds(), result.Success, result.Error,
+	)
+	if err != nil {
+		return result, fmt.Errorf("failed to persist webhook test result: %w", err)
+	}
+	return result, nil
+}
+
+// GetDeliveryHistory gets webhook delivery history from webhook_deliveries, newest first
+func (s *PostgresWebhookService) GetDeliveryHistory(ctx context.Context, webhookID string, filter *DeliveryFilter) ([]*Delivery, error) {
+	if _, err := s.GetWebhook(ctx, webhookID); err != nil {
+		return nil, err
+	}
+	return queryDeliveries(ctx, s.db, webhookID, filter)
+}
+
+// queryDeliveries selects deliveries from webhook_deliveries using the same semantics as filterDeliveries
+func queryDeliveries(ctx context.Context, db *sql.DB, webhookID string, filter *DeliveryFilter) ([]*Delivery, error) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	addArg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if webhookID != "" {
+		id := addArg(webhookID)
+		conditions = append(conditions, "(webhook_id = "+id+" OR subscription_id = "+id+")")
+	}
+	if filter != nil {
+		switch filter.Status {
+		case "":
+		case DeliveryStatusSucceeded:
+			conditions = append(conditions, "success = TRUE")
+		case DeliveryStatusRetrying:
+			conditions = append(conditions, "success = FALSE AND next_retry_at IS NOT NULL")
+		case DeliveryStatusFailed:
+			conditions = append(conditions, "success = FALSE AND next_retry_at IS NULL")
+		default:
+			return nil, fmt.Errorf("unknown delivery status %q", filter.Status)
+		}
+		if filter.StartTime != nil {
+			conditions = append(conditions, "created_at >= "+addArg(*filter.StartTime))
+		}
+		if filter.EndTime != nil {
+			conditions = append(conditions, "created_at < "+addArg(*filter.EndTime))
+		}
+	}
+
+	query := deliverySelect
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	limit, offset := deliveryPage(filter)
+	query += " ORDER BY created_at DESC, id DESC LIMIT " + addArg(limit) + " OFFSET " + addArg(offset)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanDeliveries(rows)
+}
+
+// deliverySelect lists subscription deliveries under their subscription ID, as the deliverer records them
+const deliverySelect = `SELECT id, COALESCE(webhook_id, subscription_id), COALESCE(subscription_id::text, ''), event_id, url, method, headers, payload, COALESCE(response_body, ''),
+		response_headers, COALESCE(response_status, 0), success, COALESCE(error_message, ''),
+		COALESCE(error_code, ''), attempts, delivered_at, next_retry_at, created_at
+		FROM webhook_deliveries`
+
+func scanDeliveries(rows *sql.Rows) ([]*Delivery, error) {
+	result := []*Delivery{}
+	for rows.Next() {
+		var (
+			delivery        Delivery
+			headers         []byte
+			payload         []byte
+			responseHeaders []byte
+			errorCode       string
+			deliveredAt     sql.NullTime
+			nextRetryAt     sql.NullTime
+		)
+		if err := rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.SubscriptionID, &delivery.EventID, &delivery.URL, &delivery.Method,
+			&headers, &payload, &delivery.Response, &responseHeaders, &delivery.StatusCode, &delivery.Success,
+			&delivery.Error, &errorCode, &delivery.Attempts, &deliveredAt, &nextRetryAt, &delivery.CreatedAt); err != nil {
+			return nil, err
+		}
+		delivery.ErrorCode = DeliveryErrorCode(errorCode)
+		if err := unmarshalJSONColumn(headers, &delivery.Headers); err != nil {
+			return nil, err
+		}
+		if err := unmarshalJSONColumn(responseHeaders, &delivery.ResponseHeaders); err != nil {
+			return nil, err
+		}
+		delivery.Payload = json.RawMessage(payload)
+		if deliveredAt.Valid {
+			delivery.DeliveredAt = &deliveredAt.Time
+		}
+		if nextRetryAt.Valid {
+			delivery.NextRetryAt = &nextRetryAt.Time
+		}
+		result = append(result, &delivery)
+	}
+	return result, rows.Err()
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanWebhook(row rowScanner) (*Webhook, error) {
+	var (
+		webhook       Webhook
+		events        string
+		headers       []byte
+		secret        sql.NullString
+		maxAttempts   sql.NullInt64
+		initialDelay  sql.NullInt64
+		maxDelay      sql.NullInt64
+		multiplier    sql.NullFloat64
+		timeout       sql.NullInt64
+		filters       []byte
+		authData      []byte
+		encryption    []byte
+		redaction     []byte
+		verifiedAt    sql.NullTime
+		lastTriggered sql.NullTime
+	)
+	if err := row.Scan(&webhook.ID, &webhook.TenantID, &webhook.Name, &webhook.URL, &events, &headers, &secret, &webhook.Active,
+		&maxAttempts, &initialDelay, &maxDelay, &multiplier, &timeout,
+		&filters, &authData, &webhook.Format, &encryption, &redaction,
+		&webhook.VerifyOwnership, &webhook.VerificationStatus, &verifiedAt,
+		&webhook.FailureCount, &lastTriggered, &webhook.CreatedAt, &webhook.UpdatedAt); err != nil {
+		return nil, err
+	}
+	webhook.Events = stringsToEventTypes(parseTextArray(events))
+	webhook.Secret = secret.String
+	if err := unmarshalJSONColumn(headers, &webhook.Headers); err != nil {
+		return nil, err
+	}
+	if err := unmarshalJSONColumn(filters, &webhook.Filters); err != nil {
+		return nil, err
+	}
+	auth, err := unmarshalWebhookAuth(authData)
+	if err != nil {
+		return nil, err
+	}
+	webhook.Auth = auth
+	if err := unmarshalJSONColumn(encryption, &webhook.Encryption); err != nil {
+		return nil, err
+	}
+	if err := unmarshalJSONColumn(redaction, &webhook.Redaction); err != nil {
+		return nil, err
+	}
+	if maxAttempts.Valid {
+		webhook.RetryConfig = &RetryConfig{
+			MaxRetries:   int(maxAttempts.Int64),
+			InitialDelay: time.Duration(initialDelay.Int64) * time.Millisecond,
+			MaxDelay:     time.Duration(maxDelay.Int64) * time.Millisecond,
+			Multiplier:   multiplier.Float64,
+			Timeout:      time.Duration(timeout.Int64) * time.Second,
+		}
+	}
+	if lastTriggered.Valid {
+		webhook.LastTriggered = &lastTriggered.Time
+	}
+	if verifiedAt.Valid {
+		webhook.VerifiedAt = &verifiedAt.Time
+	}
+	return &webhook, nil
+}
+
+func webhookJSONColumns(webhook *Webhook) (headers, filters, auth, encryption, redaction interface{}, err error) {
+	if len(webhook.Headers) > 0 {
+		if headers, err = marshalJSONColumn(webhook.Headers); err != nil {
+			return nil, nil, nil, nil, nil, err
+		}
+	}
+	if len(webhook.Filters) > 0 {
+		if filters, err = marshalJSONColumn(webhook.Filters); err != nil {
+			return nil, nil, nil, nil, nil, err
+		}
+	}
+	if auth, err = marshalWebhookAuth(webhook.Auth); err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+	if encryption, err = marshalJSONColumn(webhook.Encryption); err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+	if redaction, err = marshalJSONColumn(webhook.Redaction); err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+	return headers, filters, auth, encryption, redaction, nil
+}
+
+func retryColumns(rc *RetryConfig) (maxAttempts, initialDelay, maxDelay sql.NullInt64, multiplier sql.NullFloat64, timeout sql.NullInt64) {
+	if rc == nil {
+		return
+	}
+	maxAttempts = sql.NullInt64{Int64: int64(rc.MaxRetries), Valid: true}
+	initialDelay = sql.NullInt64{Int64: rc.InitialDelay.Milliseconds(), Valid: true}
+	maxDelay = sql.NullInt64{Int64: rc.MaxDelay.Milliseconds(), Valid: true}
+	multiplier = sql.NullFloat64{Float64: rc.Multiplier, Valid: true}
+	timeout = sql.NullInt64{Int64: int64(rc.Timeout / time.Second), Valid: true}
+	return
+}
@@ -0,0 +1,471 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"goat/internal/audit"
+)
+
+const (
+	defaultHookTimeout = 2 * time.Second
+	maxHookTimeout     = 10 * time.Second
+	maxResponseBytes   = 64 << 10
+
+	// SignatureHeader carries the HMAC-SHA256 signature of an HTTP hook request
+	SignatureHeader = "X-Hook-Signature"
+	// TimestampHeader carries the Unix time the signature was made at
+	TimestampHeader = "X-Hook-Timestamp"
+	// PointHeader names the hook point of an HTTP hook request
+	PointHeader = "X-Hook-Point"
+)
+
+// ErrInvalidHook is returned when a hook configuration is rejected
+var ErrInvalidHook = errors.New("invalid hook")
+
+// Point is a place in the authentication flow where hooks run
+type Point string
+
+const (
+	PointPreLogin      Point = "pre_login"
+	PointPostLogin     Point = "post_login"
+	PointPreMFA        Point = "pre_mfa"
+	PointPreUserCreate Point = "pre_user_create"
+)
+
+// Valid reports whether p is a known hook point
+func (p Point) Valid() bool {
+	switch p {
+	case PointPreLogin, PointPostLogin, PointPreMFA, PointPreUserCreate:
+		return true
+	}
+	return false
+}
+
+// Action is a hook's decision
+type Action string
+
+const (
+	// ActionAllow lets the flow continue unchanged
+	ActionAllow Action = "allow"
+	// ActionDeny stops the flow; later hooks are not run
+	ActionDeny Action = "deny"
+	// ActionModify lets the flow continue with the returned claims and attributes merged in
+	ActionModify Action = "modify"
+)
+
+// FailurePolicy decides what a hook that errors or times out means
+type FailurePolicy string
+
+const (
+	// FailClosed treats a failed hook as a deny
+	FailClosed FailurePolicy = "closed"
+	// FailOpen skips a failed hook
+	FailOpen FailurePolicy = "open"
+)
+
+// Request describes the authentication step a hook is asked about
+type Request struct {
+	ID        string    `json:"id"`
+	Point     Point     `json:"point"`
+	Timestamp time.Time `json:"timestamp"`
+	UserID    string    `json:"user_id,omitempty"`
+	Username  string    `json:"username,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	// Claims are the token claims so far, including those added by earlier hooks
+	Claims map[string]interface{} `json:"claims,omitempty"`
+	// Attributes are the point's data, e.g. the profile of a user about to be created
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// Decision is a hook's answer
+type Decision struct {
+	Action Action `json:"action"`
+	Reason string `json:"reason,omitempty"`
+	// Claims and Attributes are merged into the request when Action is modify
+	Claims     map[string]interface{} `json:"claims,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// Handler is an in-process hook
+type Handler func(ctx context.Context, req *Request) (*Decision, error)
+
+// Hook is a configured hook. It calls Handler when set, and otherwise POSTs the request to URL.
+type Hook struct {
+	Name   string  `json:"name"`
+	Points []Point `json:"points"`
+	URL    string  `json:"url,omitempty"`
+	// Secret signs HTTP requests; see SignatureHeader
+	Secret  string        `json:"-"`
+	Handler Handler       `json:"-"`
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// FailurePolicy defaults to FailClosed
+	FailurePolicy FailurePolicy `json:"failure_policy,omitempty"`
+}
+
+// Evaluation records how one hook of a chain answered
+type Evaluation struct {
+	Hook       string        `json:"hook"`
+	Action     Action        `json:"action,omitempty"`
+	Reason     string        `json:"reason,omitempty"`
+	Duration   time.Duration `json:"duration"`
+	Error      string        `json:"error,omitempty"`
+	FailedOpen bool          `json:"failed_open,omitempty"`
+}
+
+// Result is the outcome of a hook chain. Action is allow or deny.
+type Result struct {
+	Action      Action                 `json:"action"`
+	Reason      string                 `json:"reason,omitempty"`
+	DeniedBy    string                 `json:"denied_by,omitempty"`
+	Claims      map[string]interface{} `json:"claims,omitempty"`
+	Attributes  map[string]interface{} `json:"attributes,omitempty"`
+	Evaluations []Evaluation           `json:"evaluations"`
+}
+
+// Allowed reports whether the flow may continue
+func (r *Result) Allowed() bool {
+	return r.Action != ActionDeny
+}
+
+// Option configures a Runner
+type Option func(*Runner)
+
+// WithHTTPClient sends HTTP hook requests through client; redirects are never followed
+func WithHTTPClient(client *http.Client) Option {
+	return func(r *Runner) {
+		if client != nil {
+			c := *client
+			c.CheckRedirect = noRedirects
+			r.client = &c
+		}
+	}
+}
+
+// Runner runs the hooks registered for a point in registration order
+type Runner struct {
+	client *http.Client
+	logger *audit.AuditLogger
+
+	mu    sync.RWMutex
+	hooks []Hook
+}
+
+// NewRunner creates a runner auditing every chain through logger; a nil logger audits nothing
+func NewRunner(logger *audit.AuditLogger, opts ...Option) *Runner {
+	r := &Runner{
+		client: &http.Client{CheckRedirect: noRedirects},
+		logger: logger,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+func noRedirects(*http.Request, []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+// Register adds a hook after those already registered
+func (r *Runner) Register(hook Hook) error {
+	if hook.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidHook)
+	}
+	if len(hook.Points) == 0 {
+		return fmt.Errorf("%w: hook %s has no points", ErrInvalidHook, hook.Name)
+	}
+	for _, point := range hook.Points {
+		if !point.Valid() {
+			return fmt.Errorf("%w: hook %s has unknown point %q", ErrInvalidHook, hook.Name, point)
+		}
+	}
+	if hook.Handler == nil && hook.URL == "" {
+		return fmt.Errorf("%w: hook %s needs a handler or a URL", ErrInvalidHook, hook.Name)
+	}
+	if hook.Timeout < 0 || hook.Timeout > maxHookTimeout {
+		return fmt.Errorf("%w: hook %s timeout must be at most %s", ErrInvalidHook, hook.Name, maxHookTimeout)
+	}
+	if hook.Timeout == 0 {
+		hook.Timeout = defaultHookTimeout
+	}
+	switch hook.FailurePolicy {
+	case "":
+		hook.FailurePolicy = FailClosed
+	case FailClosed, FailOpen:
+	default:
+		return fmt.Errorf("%w: hook %s has unknown failure policy %q", ErrInvalidHook, hook.Name, hook.FailurePolicy)
+	}
+	hook.Points = append([]Point(nil), hook.Points...)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.hooks {
+		if existing.Name == hook.Name {
+			return fmt.Errorf("%w: hook %s is already registered", ErrInvalidHook, hook.Name)
+		}
+	}
+	r.hooks = append(r.hooks, hook)
+	return nil
+}
+
+// Run runs the hooks for point. A deny, or a failure of a fail-closed hook, stops the chain;
+// modify decisions are merged into the request seen by later hooks and into the result. The
+// chain is audited once it finishes, unless no hook is registered for point. An audit failure is
+// returned as the error, but the result is still valid.
+func (r *Runner) Run(ctx context.Context, point Point, req *Request) (*Result, error) {
+	if !point.Valid() {
+		return nil, fmt.Errorf("unknown hook point %q", point)
+	}
+	if req == nil {
+		req = &Request{}
+	}
+	current := *req
+	current.Point = point
+	if current.ID == "" {
+		current.ID = newRequestID()
+	}
+	if current.Timestamp.IsZero() {
+		current.Timestamp = time.Now().UTC()
+	}
+	current.Claims = copyMap(req.Claims)
+	current.Attributes = copyMap(req.Attributes)
+
+	result := &Result{Action: ActionAllow, Evaluations: []Evaluation{}}
+	hooks := r.hooksFor(point)
+	for _, hook := range hooks {
+		start := time.Now()
+		decision, err := r.call(ctx, hook, &current)
+		evaluation := Evaluation{Hook: hook.Name, Duration: time.Since(start)}
+		if err != nil {
+			evaluation.Error = err.Error()
+			if hook.FailurePolicy == FailOpen {
+				evaluation.FailedOpen = true
+				result.Evaluations = append(result.Evaluations, evaluation)
+				continue
+			}
+			evaluation.Action = ActionDeny
+			result.Evaluations = append(result.Evaluations, evaluation)
+			result.Action, result.DeniedBy, result.Reason = ActionDeny, hook.Name, "hook failed"
+			break
+		}
+		evaluation.Action, evaluation.Reason = decision.Action, decision.Reason
+		result.Evaluations = append(result.Evaluations, evaluation)
+		if decision.Action == ActionDeny {
+			result.Action, result.DeniedBy, result.Reason = ActionDeny, hook.Name, decision.Reason
+			break
+		}
+		if decision.Action == ActionModify {
+			current.Claims = mergeMap(current.Claims, decision.Claims)
+			current.Attributes = mergeMap(current.Attributes, decision.Attributes)
+		}
+	}
+	result.Claims, result.Attributes = current.Claims, current.Attributes
+	if len(hooks) == 0 {
+		return result, nil
+	}
+	return result, r.audit(ctx, &current, result)
+}
+
+func (r *Runner) hooksFor(point Point) []Hook {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var matched []Hook
+	for _, hook := range r.hooks {
+		for _, p := range hook.Points {
+			if p == point {
+				matched = append(matched, hook)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// call runs one hook within its timeout. Hooks that ignore ctx are abandoned when it expires, so
+// they get their own copy of req rather than the one Run keeps merging into.
+func (r *Runner) call(ctx context.Context, hook Hook, req *Request) (*Decision, error) {
+	ctx, cancel := context.WithTimeout(ctx, hook.Timeout)
+	defer cancel()
+	req = cloneRequest(req)
+
+	type answer struct {
+		decision *Decision
+		err      error
+	}
+	done := make(chan answer, 1)
+	go func() {
+		var a answer
+		if hook.Handler != nil {
+			a.decision, a.err = hook.Handler(ctx, req)
+		} else {
+			a.decision, a.err = r.post(ctx, hook, req)
+		}
+		done <- a
+	}()
+
+	var a answer
+	select {
+	case a = <-done:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("timed out after %s", hook.Timeout)
+	}
+	if a.err != nil {
+		return nil, a.err
+	}
+	if a.decision == nil {
+		return nil, errors.New("hook returned no decision")
+	}
+	switch a.decision.Action {
+	case ActionAllow, ActionDeny, ActionModify:
+		return a.decision, nil
+	}
+	return nil, fmt.Errorf("hook returned unknown action %q", a.decision.Action)
+}
+
+// post sends the request to an HTTP hook, which must answer 2xx with a JSON Decision
+func (r *Runner) post(ctx context.Context, hook Hook, req *Request) (*Decision, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set(PointHeader, string(req.Point))
+	httpReq.Header.Set(TimestampHeader, timestamp)
+	if hook.Secret != "" {
+		httpReq.Header.Set(SignatureHeader, Sign(hook.Secret, timestamp, body))
+	}
+
+	resp, err := r.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("hook answered HTTP %d", resp.StatusCode)
+	}
+	var decision Decision
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(&decision); err != nil {
+		return nil, fmt.Errorf("decode decision: %w", err)
+	}
+	return &decision, nil
+}
+
+// Sign returns the signature of an HTTP hook request: an HMAC-SHA256 over the timestamp, a dot
+// and the body, formatted as "sha256=<hex>"
+func Sign(secret, timestamp string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(body)
+	return "sha256=" + hex.EncodeToString(h.Sum(nil))
+}
+
+// Verify checks the signature headers of an HTTP hook request, rejecting timestamps more than
+// tolerance away from now
+func Verify(secret string, header http.Header, body []byte, tolerance time.Duration, now time.Time) error {
+	timestamp := header.Get(TimestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("missing or malformed hook timestamp")
+	}
+	if skew := now.Sub(time.Unix(unix, 0)); skew > tolerance || skew < -tolerance {
+		return errors.New("hook timestamp outside tolerance")
+	}
+	if !hmac.Equal([]byte(header.Get(SignatureHeader)), []byte(Sign(secret, timestamp, body))) {
+		return errors.New("hook signature mismatch")
+	}
+	return nil
+}
+
+func (r *Runner) audit(ctx context.Context, req *Request, result *Result) error {
+	if r.logger == nil {
+		return nil
+	}
+	severity := audit.SeverityInfo
+	if result.Action == ActionDeny {
+		severity = audit.SeverityWarning
+	}
+	evaluations := make([]interface{}, len(result.Evaluations))
+	for i, e := range result.Evaluations {
+		evaluation := map[string]interface{}{
+			"hook":        e.Hook,
+			"action":      string(e.Action),
+			"duration_ms": e.Duration.Milliseconds(),
+		}
+		if e.Reason != "" {
+			evaluation["reason"] = e.Reason
+		}
+		if e.Error != "" {
+			evaluation["error"] = e.Error
+			evaluation["failed_open"] = e.FailedOpen
+		}
+		evaluations[i] = evaluation
+	}
+	details := map[string]interface{}{
+		"request_id": req.ID,
+		"hooks":      evaluations,
+	}
+	if result.DeniedBy != "" {
+		details["denied_by"] = result.DeniedBy
+		details["reason"] = result.Reason
+	}
+	return r.logger.LogHookDecision(ctx, string(req.Point), req.UserID, req.IP, req.UserAgent, string(result.Action), severity, details)
+}
+
+func cloneRequest(req *Request) *Request {
+	clone := *req
+	clone.Claims = copyMap(req.Claims)
+	clone.Attributes = copyMap(req.Attributes)
+	return &clone
+}
+
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
+
+func mergeMap(dst, src map[string]interface{}) map[string]interface{} {
+	if len(src) == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = make(map[string]interface{}, len(src))
+	}
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,349 @@
+// Package inspect implements a local webhook receiver that shows what GOAT delivers
+package inspect
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"goat/internal/events"
+)
+
+// maxBodySize bounds how much of a request body is read
+const maxBodySize = 10 << 20
+
+// Signature results reported for each request
+const (
+	SignatureValid   = "valid"
+	SignatureInvalid = "invalid"
+	SignatureMissing = "missing"
+	SignatureSkipped = "not checked"
+)
+
+// Config configures a Receiver
+type Config struct {
+	// Secret verifies X-Webhook-Signature; requests with a bad or missing signature get 401
+	Secret string
+	// OutputDir, when set, receives one JSON file per request
+	OutputDir string
+	// Statuses are returned to successive requests; the last one repeats. Empty means 200.
+	Statuses []int
+	// Latencies delay successive responses; the last one repeats
+	Latencies []time.Duration
+	// DecryptionKey decrypts application/jose payloads
+	DecryptionKey crypto.PrivateKey
+	// Out receives the pretty-printed requests
+	Out io.Writer
+	// Now defaults to time.Now
+	Now func() time.Time
+}
+
+// Request is what the receiver records about each request
+type Request struct {
+	Seq        int               `json:"seq"`
+	ReceivedAt time.Time         `json:"received_at"`
+	Method     string            `json:"method"`
+	Path       string            `json:"path"`
+	Headers    map[string]string `json:"headers"`
+	Signature  string            `json:"signature"`
+	Status     int               `json:"status"`
+	Body       json.RawMessage   `json:"body,omitempty"`
+	RawBody    string            `json:"raw_body,omitempty"`
+	Decrypted  json.RawMessage   `json:"decrypted,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// Receiver is an http.Handler that verifies, prints and saves webhook requests and answers
+// with scripted status codes and latency
+type Receiver struct {
+	config Config
+	mu     sync.Mutex
+	seq    int
+}
+
+// NewReceiver creates a receiver, creating OutputDir if needed
+func NewReceiver(config Config) (*Receiver, error) {
+	if config.Out == nil {
+		config.Out = io.Discard
+	}
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+	if config.OutputDir != "" {
+		if err := os.MkdirAll(config.OutputDir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return &Receiver{config: config}, nil
+}
+
+// ServeHTTP handles one webhook request
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	r.seq++
+	seq := r.seq
+	r.mu.Unlock()
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxBodySize))
+	record := &Request{
+		Seq:        seq,
+		ReceivedAt: r.config.Now().UTC(),
+		Method:     req.Method,
+		Path:       req.URL.RequestURI(),
+		Headers:    flattenHeaders(req.Header),
+		Signature:  r.checkSignature(req.Header.Get("X-Webhook-Signature"), body),
+		Status:     scripted(r.config.Statuses, seq, http.StatusOK),
+	}
+	if err != nil {
+		record.Error = fmt.Sprintf("read body: %v", err)
+	}
+	setBody(record, body)
+
+	var response []byte
+	switch {
+	case record.Signature == SignatureInvalid || record.Signature == SignatureMissing:
+		record.Status = http.StatusUnauthorized
+	case req.Header.Get("X-Event-Type") == events.VerificationEventType:
+		// Answer ownership challenges so webhooks with verify_ownership can be registered locally.
+		var challenge events.VerificationChallenge
+		if json.Unmarshal(body, &challenge) == nil {
+			record.Status = http.StatusOK
+			response, _ = json.Marshal(map[string]string{"challenge": challenge.Challenge})
+		}
+	case strings.HasPrefix(req.Header.Get("Content-Type"), events.JWEContentType) && r.config.DecryptionKey != nil:
+		plaintext, err := events.DecryptCompactJWE(strings.TrimSpace(string(body)), r.config.DecryptionKey)
+		if err != nil {
+			record.Error = fmt.Sprintf("decrypt: %v", err)
+		} else if json.Valid(plaintext) {
+			record.Decrypted = plaintext
+		}
+	}
+
+	if err := r.save(record); err != nil {
+		record.Error = strings.TrimPrefix(record.Error+"; save: "+err.Error(), "; ")
+	}
+	r.print(record)
+
+	if delay := scripted(r.config.Latencies, seq, 0); delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return
+		}
+	}
+	if response != nil {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(record.Status)
+	w.Write(response)
+}
+
+func (r *Receiver) checkSignature(signature string, body []byte) string {
+	if r.config.Secret == "" {
+		return SignatureSkipped
+	}
+	if signature == "" {
+		return SignatureMissing
+	}
+	mac := hmac.New(sha256.New, []byte(r.config.Secret))
+	mac.Write(body)
+	if !hmac.Equal([]byte(signature), []byte("sha256="+hex.EncodeToString(mac.Sum(nil)))) {
+		return SignatureInvalid
+	}
+	return SignatureValid
+}
+
+func (r *Receiver) save(record *Request) error {
+	if r.config.OutputDir == "" {
+		return nil
+	}
+	name := fmt.Sprintf("%06d", record.Seq)
+	if id := sanitizeFileName(record.Headers["X-Event-Id"]); id != "" {
+		name += "-" + id
+	}
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(r.config.OutputDir, name+".json"), append(data, '\n'), 0o644)
+}
+
+func (r *Receiver) print(record *Request) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#%d %s %s %s -> %d\n", record.Seq, record.ReceivedAt.Format(time.RFC3339), record.Method, record.Path, record.Status)
+	keys := make([]string, 0, len(record.Headers))
+	for key := range record.Headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&b, "  %s: %s\n", key, record.Headers[key])
+	}
+	fmt.Fprintf(&b, "  signature: %s\n", record.Signature)
+	if record.Error != "" {
+		fmt.Fprintf(&b, "  error: %s\n", record.Error)
+	}
+	payload := []byte(record.RawBody)
+	if record.Body != nil {
+		payload = record.Body
+	}
+	if record.Decrypted != nil {
+		payload = record.Decrypted
+		b.WriteString("  decrypted payload:\n")
+	}
+	b.WriteString(indent(payload))
+	b.WriteString("\n\n")
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	io.WriteString(r.config.Out, b.String())
+}
+
+func setBody(record *Request, body []byte) {
+	if len(body) == 0 {
+		return
+	}
+	if json.Valid(body) {
+		record.Body = json.RawMessage(body)
+		return
+	}
+	record.RawBody = string(body)
+}
+
+func indent(payload []byte) string {
+	var out bytes.Buffer
+	if err := json.Indent(&out, payload, "  ", "  "); err != nil {
+		return "  " + string(payload)
+	}
+	return "  " + out.String()
+}
+
+func flattenHeaders(header http.Header) map[string]string {
+	result := make(map[string]string, len(header))
+	for key, values := range header {
+		result[key] = strings.Join(values, ",")
+	}
+	return result
+}
+
+func sanitizeFileName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// scripted returns the entry for the seq'th request, repeating the last entry
+func scripted[T any](script []T, seq int, fallback T) T {
+	if len(script) == 0 {
+		return fallback
+	}
+	if seq > len(script) {
+		return script[len(script)-1]
+	}
+	return script[seq-1]
+}
+
+// ParseStatusScript parses a comma separated list of status codes such as "503x3,200",
+// where NxK repeats N K times
+func ParseStatusScript(script string) ([]int, error) {
+	return parseScript(script, func(s string) (int, error) {
+		code, err := strconv.Atoi(s)
+		if err != nil || code < 100 || code > 599 {
+			return 0, fmt.Errorf("invalid status code %q", s)
+		}
+		return code, nil
+	})
+}
+
+// ParseLatencyScript parses a comma separated list of durations such as "0s,30s,0s" or "2sx3,0s"
+func ParseLatencyScript(script string) ([]time.Duration, error) {
+	return parseScript(script, func(s string) (time.Duration, error) {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return 0, fmt.Errorf("invalid latency %q", s)
+		}
+		return d, nil
+	})
+}
+
+func parseScript[T any](script string, parse func(string) (T, error)) ([]T, error) {
+	var out []T
+	for _, entry := range strings.Split(script, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		value, times := entry, 1
+		if i := strings.LastIndex(entry, "x"); i > 0 {
+			n, err := strconv.Atoi(entry[i+1:])
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid repeat count in %q", entry)
+			}
+			value, times = entry[:i], n
+		}
+		parsed, err := parse(value)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < times; i++ {
+			out = append(out, parsed)
+		}
+	}
+	return out, nil
+}
+
+// ParsePrivateKeyPEM parses a PKCS#8, PKCS#1 RSA or SEC 1 EC private key for decrypting payloads
+func ParsePrivateKeyPEM(data []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported private key type %q", block.Type)
+}
+
+// Serve runs the receiver on addr until ctx is cancelled
+func Serve(ctx context.Context, addr string, receiver *Receiver) error {
+	server := &http.Server{Addr: addr, Handler: receiver, ReadHeaderTimeout: 10 * time.Second}
+	errs := make(chan error, 1)
+	go func() { errs <- server.ListenAndServe() }()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return server.Shutdown(shutdown)
+	}
+}
@@ -0,0 +1,6 @@
+-- Migration: Add outbound authentication to webhooks for GOAT v2.0
+-- Version: 006
+-- Description: Stores per-webhook mTLS, OAuth2 client credentials, basic and bearer configuration
+
+-- Auth configuration including secret material (encrypted at rest, like webhooks.secret)
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS auth JSONB;
@@ -0,0 +1,7 @@
+-- Migration: Add structured error codes to webhook deliveries for GOAT v2.0
+-- Version: 007
+-- Description: Records why a delivery failed (dns_error, tls_error, rate_limited, gone, ...)
+
+ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS error_code VARCHAR(50);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_error_code ON webhook_deliveries(error_code);
@@ -0,0 +1,26 @@
+-- Migration: Add resumable event replay jobs for GOAT v2.0
+-- Version: 008
+-- Description: Tracks replays of historical events to a webhook or subscription
+
+CREATE TABLE IF NOT EXISTS event_replay_jobs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    webhook_id UUID REFERENCES webhooks(id) ON DELETE CASCADE,
+    subscription_id UUID REFERENCES event_subscriptions(id) ON DELETE CASCADE,
+    filter JSONB,
+    rate_per_second DOUBLE PRECISION NOT NULL DEFAULT 10,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('pending', 'running', 'paused', 'completed', 'failed', 'cancelled')),
+    cursor_offset INTEGER NOT NULL DEFAULT 0, -- Events read so far; resume point
+    succeeded INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    skipped INTEGER NOT NULL DEFAULT 0,
+    last_event_id UUID,
+    error_message TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    started_at TIMESTAMPTZ,
+    completed_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK ((webhook_id IS NULL) <> (subscription_id IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_event_replay_jobs_status ON event_replay_jobs(status);
+CREATE INDEX IF NOT EXISTS idx_event_replay_jobs_created_at ON event_replay_jobs(created_at DESC);
@@ -0,0 +1,9 @@
+-- Migration: Add payload formats to webhooks and subscriptions for GOAT v2.0
+-- Version: 009
+-- Description: Lets each webhook or subscription receive native Event JSON or CloudEvents 1.0 (structured or binary; queue and stream subscriptions may also read batches)
+
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS format VARCHAR(32)
+    CHECK (format IN ('native', 'cloudevents', 'cloudevents-binary'));
+
+ALTER TABLE event_subscriptions ADD COLUMN IF NOT EXISTS format VARCHAR(32)
+    CHECK (format IN ('native', 'cloudevents', 'cloudevents-binary', 'cloudevents-batch'));
@@ -0,0 +1,33 @@
+-- Migration: Add queue and stream consumer state for event subscriptions for GOAT v2.0
+-- Version: 010
+-- Description: Leased queue messages for "queue" subscriptions and per-consumer cursors over "stream" subscription logs
+
+CREATE TABLE IF NOT EXISTS event_queue_messages (
+    id UUID PRIMARY KEY,
+    subscription_id UUID NOT NULL,
+    event JSONB NOT NULL,
+    receipt UUID,
+    consumer VARCHAR(255),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    visible_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_event_queue_messages_visible ON event_queue_messages(subscription_id, visible_at, created_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_event_queue_messages_receipt ON event_queue_messages(receipt) WHERE receipt IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS event_stream_records (
+    subscription_id UUID NOT NULL,
+    stream_offset BIGSERIAL,
+    event JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (subscription_id, stream_offset)
+);
+
+CREATE TABLE IF NOT EXISTS event_stream_cursors (
+    subscription_id UUID NOT NULL,
+    consumer VARCHAR(255) NOT NULL,
+    committed_offset BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (subscription_id, consumer)
+);
@@ -0,0 +1,5 @@
+-- Migration: Add payload encryption settings to webhooks for GOAT v2.0
+-- Version: 011
+-- Description: Stores the JWE algorithm and partner public key, inline JWKS or JWKS URL used to encrypt webhook bodies
+
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS encryption JSONB;
@@ -0,0 +1,7 @@
+-- Migration: Add PII redaction policies to webhooks and subscriptions for GOAT v2.0
+-- Version: 012
+-- Description: Per-destination rules that drop, mask, hash or truncate event fields before they leave the system
+
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS redaction JSONB;
+
+ALTER TABLE event_subscriptions ADD COLUMN IF NOT EXISTS redaction JSONB;
@@ -0,0 +1,8 @@
+-- Migration: Add webhook endpoint ownership verification for GOAT v2.0
+-- Version: 013
+-- Description: Webhooks that opt in stay pending_verification until their endpoint echoes a signed challenge
+
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS verify_ownership BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS verification_status VARCHAR(32)
+    CHECK (verification_status IN ('pending_verification', 'verified'));
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS verified_at TIMESTAMPTZ;
@@ -0,0 +1,12 @@
+-- Migration: Add tenant isolation to events, webhooks and subscriptions for GOAT v2.0
+-- Version: 014
+-- Description: Events are only delivered to webhooks and subscriptions of the same tenant; an empty tenant_id is the untenanted deployment
+
+ALTER TABLE events ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(255) NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_events_tenant_timestamp ON events(tenant_id, timestamp DESC);
+
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(255) NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_webhooks_tenant_id ON webhooks(tenant_id);
+
+ALTER TABLE event_subscriptions ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(255) NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_event_subscriptions_tenant_id ON event_subscriptions(tenant_id);
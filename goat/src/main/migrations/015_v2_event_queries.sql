@@ -0,0 +1,7 @@
+-- Migration: Add event queries to subscriptions and index event metadata for GOAT v2.0
+-- Version: 015
+-- Description: Subscriptions may narrow their events with a query; metadata gets a GIN index so metadata filters and queries can use containment
+
+ALTER TABLE event_subscriptions ADD COLUMN IF NOT EXISTS query TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_events_metadata_gin ON events USING gin(metadata);
@@ -0,0 +1,5 @@
+-- Migration: Support the Postgres event bus for GOAT v2.0
+-- Version: 016
+-- Description: Nodes catch up on events they missed while their LISTEN connection was down by reading events in created_at order
+
+CREATE INDEX IF NOT EXISTS idx_events_created_at_id ON events(created_at, (id::text));
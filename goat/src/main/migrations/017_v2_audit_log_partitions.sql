@@ -0,0 +1,79 @@
+-- Migration: Partition audit_logs by month for GOAT v2.0
+-- Version: 017
+-- Description: Recreates audit_logs as a table range-partitioned on timestamp, so the monthly partitions 001 intended can be attached
+
+ALTER TABLE audit_logs RENAME TO audit_logs_unpartitioned;
+ALTER INDEX IF EXISTS audit_logs_pkey RENAME TO audit_logs_unpartitioned_pkey;
+DROP INDEX IF EXISTS idx_audit_logs_timestamp;
+DROP INDEX IF EXISTS idx_audit_logs_user_id;
+DROP INDEX IF EXISTS idx_audit_logs_event_type;
+DROP INDEX IF EXISTS idx_audit_logs_severity;
+DROP INDEX IF EXISTS idx_audit_logs_ip;
+DROP INDEX IF EXISTS idx_audit_logs_session_id;
+
+-- The partition key must be part of the primary key
+CREATE TABLE audit_logs (
+    id UUID NOT NULL DEFAULT gen_random_uuid(),
+    timestamp TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    event_type VARCHAR(100) NOT NULL,
+    severity VARCHAR(20) NOT NULL CHECK (severity IN ('info', 'warning', 'error', 'critical')),
+    user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    session_id VARCHAR(255),
+    ip INET,
+    user_agent TEXT,
+    resource VARCHAR(255),
+    action VARCHAR(100) NOT NULL,
+    result VARCHAR(50) NOT NULL,
+    details JSONB,
+    error_msg TEXT,
+    geo_location JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id, timestamp)
+) PARTITION BY RANGE (timestamp);
+
+CREATE INDEX idx_audit_logs_timestamp ON audit_logs(timestamp DESC);
+CREATE INDEX idx_audit_logs_user_id ON audit_logs(user_id);
+CREATE INDEX idx_audit_logs_event_type ON audit_logs(event_type);
+CREATE INDEX idx_audit_logs_severity ON audit_logs(severity);
+CREATE INDEX idx_audit_logs_ip ON audit_logs USING gist(ip inet_ops);
+CREATE INDEX idx_audit_logs_session_id ON audit_logs(session_id);
+
+DROP TABLE IF EXISTS audit_logs_template;
+DROP FUNCTION IF EXISTS create_monthly_audit_partition();
+
+-- Creates the partition holding the given day's month; the audit service calls it before writing to a month
+CREATE OR REPLACE FUNCTION create_monthly_audit_partition(month DATE)
+RETURNS void AS $$
+DECLARE
+    start_date date;
+    end_date date;
+    partition_name text;
+BEGIN
+    start_date := date_trunc('month', month)::date;
+    end_date := (start_date + interval '1 month')::date;
+    partition_name := 'audit_logs_' || to_char(start_date, 'YYYY_MM');
+
+    EXECUTE format('CREATE TABLE IF NOT EXISTS %I PARTITION OF audit_logs
+        FOR VALUES FROM (%L) TO (%L)', partition_name, start_date, end_date);
+END;
+$$ LANGUAGE plpgsql;
+
+-- Partitions for existing rows, the current month and the next
+DO $$
+DECLARE
+    month date;
+BEGIN
+    FOR month IN SELECT DISTINCT date_trunc('month', timestamp)::date FROM audit_logs_unpartitioned LOOP
+        PERFORM create_monthly_audit_partition(month);
+    END LOOP;
+    PERFORM create_monthly_audit_partition(CURRENT_DATE);
+    PERFORM create_monthly_audit_partition((CURRENT_DATE + interval '1 month')::date);
+END;
+$$;
+
+INSERT INTO audit_logs SELECT * FROM audit_logs_unpartitioned;
+DROP TABLE audit_logs_unpartitioned;
+
+-- Schedule next month's partition (requires pg_cron extension)
+-- Uncomment if pg_cron is available
+-- SELECT cron.schedule('create-audit-partitions', '0 0 25 * *', $$SELECT create_monthly_audit_partition((CURRENT_DATE + interval '1 month')::date)$$);
@@ -0,0 +1,54 @@
+-- Migration: Hash-chain audit_logs for GOAT v2.0
+-- Version: 018
+-- Description: Adds sequence numbers and chained hashes to audit_logs, the chain head row writers lock, and signed checkpoints
+
+ALTER TABLE audit_logs
+    ADD COLUMN IF NOT EXISTS sequence BIGINT,
+    ADD COLUMN IF NOT EXISTS prev_hash VARCHAR(64),
+    ADD COLUMN IF NOT EXISTS hash VARCHAR(64),
+    ADD COLUMN IF NOT EXISTS personal_data_salt VARCHAR(32),
+    ADD COLUMN IF NOT EXISTS personal_data_digest VARCHAR(64);
+
+-- Entries written before this migration have no sequence and are not part of the chain
+CREATE INDEX IF NOT EXISTS idx_audit_logs_sequence ON audit_logs(sequence) WHERE sequence IS NOT NULL;
+
+-- Single row holding the newest entry; writers lock it to append in order
+CREATE TABLE IF NOT EXISTS audit_chain_head (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    sequence BIGINT NOT NULL,
+    hash VARCHAR(64) NOT NULL
+);
+
+INSERT INTO audit_chain_head (id, sequence, hash)
+VALUES (TRUE, 0, repeat('0', 64))
+ON CONFLICT (id) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS audit_checkpoints (
+    sequence BIGINT NOT NULL,
+    hash VARCHAR(64) NOT NULL,
+    issued_at TIMESTAMPTZ NOT NULL,
+    key_id VARCHAR(100) NOT NULL,
+    signature TEXT NOT NULL,
+    PRIMARY KEY (sequence, key_id)
+);
+
+-- Deleting a user nulls user_id through the foreign key. Erase the rest of the entry's personal
+-- data with it, as DeleteUserData does, so the entry still verifies as anonymized.
+CREATE OR REPLACE FUNCTION erase_audit_personal_data()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.session_id := NULL;
+    NEW.ip := NULL;
+    NEW.user_agent := NULL;
+    NEW.geo_location := NULL;
+    NEW.personal_data_salt := NULL;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_logs_erase_personal_data ON audit_logs;
+CREATE TRIGGER audit_logs_erase_personal_data
+    BEFORE UPDATE OF user_id ON audit_logs
+    FOR EACH ROW
+    WHEN (OLD.user_id IS NOT NULL AND NEW.user_id IS NULL AND NEW.sequence IS NOT NULL)
+    EXECUTE FUNCTION erase_audit_personal_data();
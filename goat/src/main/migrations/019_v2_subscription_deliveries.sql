@@ -0,0 +1,10 @@
+-- Migration: Record webhook subscription deliveries for GOAT v2.0
+-- Version: 019
+-- Description: Lets webhook_deliveries reference an event subscription instead of a webhook, so deliveries to webhook subscriptions satisfy the foreign keys
+
+ALTER TABLE webhook_deliveries ALTER COLUMN webhook_id DROP NOT NULL;
+ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS subscription_id UUID REFERENCES event_subscriptions(id) ON DELETE CASCADE;
+ALTER TABLE webhook_deliveries ADD CONSTRAINT webhook_deliveries_target_check
+    CHECK ((webhook_id IS NULL) <> (subscription_id IS NULL));
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_subscription_id ON webhook_deliveries(subscription_id);
@@ -0,0 +1,23 @@
+-- Migration: Keep audit user IDs that are not registered users for GOAT v2.0
+-- Version: 020
+-- Description: Adds audit_logs.actor_id, a text copy of the user ID kept for every entry. user_id only references users that exist.
+
+ALTER TABLE audit_logs ADD COLUMN IF NOT EXISTS actor_id TEXT;
+
+UPDATE audit_logs SET actor_id = user_id::text WHERE actor_id IS NULL AND user_id IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_audit_logs_actor_id ON audit_logs(actor_id);
+
+-- Deleting a user nulls user_id through the foreign key; erase actor_id with the other personal data
+CREATE OR REPLACE FUNCTION erase_audit_personal_data()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.actor_id := NULL;
+    NEW.session_id := NULL;
+    NEW.ip := NULL;
+    NEW.user_agent := NULL;
+    NEW.geo_location := NULL;
+    NEW.personal_data_salt := NULL;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,9 @@
+-- Migration: Keep event user IDs that are not registered users for GOAT v2.0
+-- Version: 021
+-- Description: Adds events.actor_id, a text copy of the user ID kept for every event. user_id only references users that exist.
+
+ALTER TABLE events ADD COLUMN IF NOT EXISTS actor_id TEXT;
+
+UPDATE events SET actor_id = user_id::text WHERE actor_id IS NULL AND user_id IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_events_actor_id ON events(actor_id);
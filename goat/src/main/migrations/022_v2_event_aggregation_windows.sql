@@ -0,0 +1,12 @@
+-- Migration: Make event aggregation windows unique for GOAT v2.0
+-- Version: 022
+-- Description: Keeps one row per aggregation window and start, so rollups saved again after late events replace the earlier counts
+
+DELETE FROM event_aggregations older
+    USING event_aggregations newer
+    WHERE older.aggregation_window = newer.aggregation_window
+      AND older.window_start = newer.window_start
+      AND (older.created_at, older.id) < (newer.created_at, newer.id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_event_aggregations_window
+    ON event_aggregations(aggregation_window, window_start);
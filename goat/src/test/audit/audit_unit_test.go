@@ -0,0 +1,194 @@
+package audit_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"goat/internal/audit"
+)
+
+func seedLogs(t *testing.T, service audit.AuditService) time.Time {
+	t.Helper()
+	base := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, log := range []*audit.AuditLog{
+		{Timestamp: base, EventType: audit.EventTypeLogin, UserID: "u1", SessionID: "s1", IP: "10.0.0.5:5123", Resource: "session", Action: "login", Result: "success"},
+		{Timestamp: base.Add(time.Hour), EventType: audit.EventTypeLogin, Severity: audit.SeverityWarning, UserID: "u1", IP: "10.0.1.9", Action: "login", Result: "failure"},
+		{Timestamp: base.Add(2 * time.Hour), EventType: audit.EventTypeMFAVerify, UserID: "u2", IP: "2001:db8::1", Resource: "mfa", Action: "verify", Result: "success",
+			Details: map[string]interface{}{"method": "totp"}, GeoLocation: &audit.GeoLocation{Country: "FR"}},
+		{Timestamp: base.Add(3 * time.Hour), EventType: audit.EventTypeConfigChange, Severity: audit.SeverityCritical, Action: "update", Result: "success"},
+	} {
+		if err := service.Log(context.Background(), log); err != nil {
+			t.Fatalf("log returned error: %v", err)
+		}
+	}
+	return base
+}
+
+func ids(logs []*audit.AuditLog) []string {
+	result := make([]string, len(logs))
+	for i, log := range logs {
+		result[i] = string(log.EventType) + "@" + log.Timestamp.Format("15")
+	}
+	return result
+}
+
+func TestInMemoryAuditQueryTest(t *testing.T) {
+	t.Parallel()
+
+	var service audit.AuditService = audit.NewInMemoryAuditService()
+	base := seedLogs(t, service)
+	ctx := context.Background()
+
+	start, end := base.Add(30*time.Minute), base.Add(2*time.Hour)
+	for name, tc := range map[string]struct {
+		filter *audit.AuditFilter
+		want   []string
+	}{
+		"all newest first": {nil, []string{"config.change@15", "mfa.verify@14", "auth.login@13", "auth.login@12"}},
+		"time range":       {&audit.AuditFilter{StartTime: &start, EndTime: &end}, []string{"mfa.verify@14", "auth.login@13"}},
+		"user":             {&audit.AuditFilter{UserID: "u1"}, []string{"auth.login@13", "auth.login@12"}},
+		"event types":      {&audit.AuditFilter{EventTypes: []audit.EventType{audit.EventTypeMFAVerify, audit.EventTypeConfigChange}}, []string{"config.change@15", "mfa.verify@14"}},
+		"severity":         {&audit.AuditFilter{Severity: []audit.Severity{audit.SeverityWarning, audit.SeverityCritical}}, []string{"config.change@15", "auth.login@13"}},
+		"ip address":       {&audit.AuditFilter{IP: "10.0.0.5"}, []string{"auth.login@12"}},
+		"ip block":         {&audit.AuditFilter{IP: "10.0.0.0/16"}, []string{"auth.login@13", "auth.login@12"}},
+		"ipv6":             {&audit.AuditFilter{IP: "2001:db8:0::1"}, []string{"mfa.verify@14"}},
+		"resource":         {&audit.AuditFilter{Resource: "mfa"}, []string{"mfa.verify@14"}},
+		"paging":           {&audit.AuditFilter{Limit: 2, Offset: 1}, []string{"mfa.verify@14", "auth.login@13"}},
+	} {
+		logs, err := service.Query(ctx, tc.filter)
+		if err != nil {
+			t.Fatalf("%s: query returned error: %v", name, err)
+		}
+		if got := ids(logs); fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Fatalf("%s: expected %v, got %v", name, tc.want, got)
+		}
+	}
+
+	logs, _ := service.Query(ctx, &audit.AuditFilter{Resource: "mfa"})
+	if logs[0].Details["method"] != "totp" || logs[0].GeoLocation == nil || logs[0].GeoLocation.Country != "FR" || logs[0].Severity != audit.SeverityInfo {
+		t.Fatalf("expected details, location and default severity to round-trip, got %+v", logs[0])
+	}
+	logs[0].Details["method"] = "tampered"
+	if again, _ := service.Query(ctx, &audit.AuditFilter{Resource: "mfa"}); again[0].Details["method"] != "totp" {
+		t.Fatalf("expected returned logs to be copies")
+	}
+	userLogs, err := service.GetUserLogs(ctx, "u1")
+	if err != nil || len(userLogs) != 2 || userLogs[0].IP != "10.0.1.9" || userLogs[1].IP != "10.0.0.5" {
+		t.Fatalf("expected u1 logs newest first with normalized IPs, got %+v (%v)", userLogs, err)
+	}
+
+	for _, invalid := range []*audit.AuditLog{
+		{Action: "login"},
+		{EventType: audit.EventTypeLogin},
+		{EventType: audit.EventTypeLogin, Action: "login", Severity: "fatal"},
+	} {
+		if err := service.Log(ctx, invalid); !errors.Is(err, audit.ErrInvalidAuditLog) {
+			t.Fatalf("expected %+v to be rejected, got %v", invalid, err)
+		}
+	}
+	forwarded := &audit.AuditLog{EventType: audit.EventTypeLogin, Action: "login", UserID: "svc-batch", IP: "203.0.113.9, 10.0.0.1"}
+	if err := service.Log(ctx, forwarded); err != nil {
+		t.Fatalf("expected an unparsable IP to be kept, got %v", err)
+	}
+	if forwarded.IP != "" || forwarded.Details["raw_ip"] != "203.0.113.9, 10.0.0.1" {
+		t.Fatalf("expected the raw IP to move to the details, got ip=%q details=%v", forwarded.IP, forwarded.Details)
+	}
+	if logs, _ := service.GetUserLogs(ctx, "svc-batch"); len(logs) != 1 {
+		t.Fatalf("expected entries for non-UUID user IDs to be stored, got %d", len(logs))
+	}
+	if _, err := service.Query(ctx, &audit.AuditFilter{IP: "bogus"}); err == nil {
+		t.Fatalf("expected an invalid IP filter to be rejected")
+	}
+}
+
+func TestInMemoryAuditUserDataTest(t *testing.T) {
+	t.Parallel()
+
+	service := audit.NewInMemoryAuditService()
+	seedLogs(t, service)
+	ctx := context.Background()
+	if err := service.CreateSecurityAlert(ctx, &audit.SecurityAlert{Type: "brute_force", UserID: "u1", Description: "5 failed logins"}); err != nil {
+		t.Fatalf("create alert returned error: %v", err)
+	}
+
+	report, err := service.ExportUserData(ctx, "u1")
+	if err != nil || report.Type != audit.ReportTypeGDPR || report.Status != audit.ReportStatusCompleted || report.UserID != "u1" {
+		t.Fatalf("unexpected export report %+v (%v)", report, err)
+	}
+	export, err := service.GetUserDataExport(ctx, report.ID)
+	if err != nil || len(export.AuditLogs) != 2 || len(export.SecurityAlerts) != 1 {
+		t.Fatalf("expected the export to hold u1's logs and alerts, got %+v (%v)", export, err)
+	}
+
+	if err := service.DeleteUserData(ctx, "u1"); err != nil {
+		t.Fatalf("delete returned error: %v", err)
+	}
+	if logs, _ := service.GetUserLogs(ctx, "u1"); len(logs) != 0 {
+		t.Fatalf("expected no logs to name u1 after deletion, got %d", len(logs))
+	}
+	if _, err := service.GetUserDataExport(ctx, report.ID); !errors.Is(err, audit.ErrReportNotFound) {
+		t.Fatalf("expected the export to be deleted, got %v", err)
+	}
+	logins, _ := service.Query(ctx, &audit.AuditFilter{EventTypes: []audit.EventType{audit.EventTypeLogin}})
+	if len(logins) != 2 || logins[0].IP != "" || logins[1].SessionID != "" || logins[1].Result != "success" {
+		t.Fatalf("expected anonymized logins to be kept, got %+v", logins)
+	}
+	deletions, _ := service.Query(ctx, &audit.AuditFilter{EventTypes: []audit.EventType{audit.EventTypeDataDeletion}})
+	if len(deletions) != 1 || deletions[0].UserID != "" || deletions[0].Details["logs_anonymized"] != 3 {
+		t.Fatalf("expected the deletion to be audited without the user, got %+v", deletions)
+	}
+}
+
+func TestInMemoryAuditReportsAndAlertsTest(t *testing.T) {
+	t.Parallel()
+
+	service := audit.NewInMemoryAuditService()
+	ctx := context.Background()
+	logger := audit.NewAuditLogger(service)
+	if err := logger.LogLogin(ctx, "u1", "s1", "192.0.2.1", "curl", false); err != nil {
+		t.Fatalf("log login returned error: %v", err)
+	}
+	if err := logger.LogLogin(ctx, "u2", "s2", "192.0.2.2", "curl", true); err != nil {
+		t.Fatalf("log login returned error: %v", err)
+	}
+
+	first := &audit.SecurityAlert{Type: "geo_anomaly", Severity: audit.SeverityCritical, Description: "login from new country", Actions: []string{"notify"}}
+	second := &audit.SecurityAlert{Type: "brute_force", Description: "many failures", Timestamp: time.Now().Add(time.Minute)}
+	for _, alert := range []*audit.SecurityAlert{first, second} {
+		if err := service.CreateSecurityAlert(ctx, alert); err != nil {
+			t.Fatalf("create alert returned error: %v", err)
+		}
+	}
+	if err := service.CreateSecurityAlert(ctx, &audit.SecurityAlert{Type: "x"}); err == nil {
+		t.Fatalf("expected an alert without description to be rejected")
+	}
+	alerts, err := service.GetSecurityAlerts(ctx)
+	if err != nil || len(alerts) != 2 || alerts[0].ID != second.ID || alerts[1].Actions[0] != "notify" {
+		t.Fatalf("expected open alerts newest first, got %+v (%v)", alerts, err)
+	}
+	if err := service.ResolveSecurityAlert(ctx, second.ID); err != nil {
+		t.Fatalf("resolve returned error: %v", err)
+	}
+	if err := service.ResolveSecurityAlert(ctx, "missing"); !errors.Is(err, audit.ErrAlertNotFound) {
+		t.Fatalf("expected unknown alert to be reported, got %v", err)
+	}
+	if alerts, _ := service.GetSecurityAlerts(ctx); len(alerts) != 1 || alerts[0].ID != first.ID {
+		t.Fatalf("expected only the unresolved alert, got %+v", alerts)
+	}
+
+	report, err := service.GenerateComplianceReport(ctx, audit.ReportTypeSecurity)
+	if err != nil || report.Status != audit.ReportStatusCompleted {
+		t.Fatalf("unexpected report %+v (%v)", report, err)
+	}
+	summary, err := service.GetReportSummary(ctx, report.ID)
+	if err != nil || summary.TotalLogs != 2 || summary.FailedResults != 1 || summary.UniqueUsers != 2 ||
+		summary.OpenAlerts != 1 || summary.LogsByEventType["auth.login"] != 2 || summary.LogsBySeverity[audit.SeverityWarning] != 1 {
+		t.Fatalf("unexpected summary %+v (%v)", summary, err)
+	}
+	if _, err := service.GenerateComplianceReport(ctx, "sox"); err == nil {
+		t.Fatalf("expected an unknown report type to be rejected")
+	}
+}
@@ -0,0 +1,194 @@
+package audit_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"testing"
+
+	"goat/internal/audit"
+)
+
+// chainExport logs n entries and returns the whole chain, decoded from JSON as the CLI reads it
+func chainExport(t *testing.T, service *audit.InMemoryAuditService, checkpointer *audit.Checkpointer, n int) *audit.ChainExport {
+	t.Helper()
+	ctx := context.Background()
+	for i := 0; i < n; i++ {
+		log := &audit.AuditLog{EventType: audit.EventTypeLogin, UserID: fmt.Sprintf("u%d", i%2), IP: "192.0.2.1",
+			Action: "login", Result: "success", Details: map[string]interface{}{"attempt": i, "tags": []string{"web"}}}
+		if err := service.Log(ctx, log); err != nil {
+			t.Fatalf("log returned error: %v", err)
+		}
+		if checkpointer != nil && i%3 == 2 {
+			if _, err := checkpointer.Checkpoint(ctx); err != nil {
+				t.Fatalf("checkpoint returned error: %v", err)
+			}
+		}
+	}
+	export, err := audit.ExportChain(ctx, service, 1, 0)
+	if err != nil {
+		t.Fatalf("export returned error: %v", err)
+	}
+	data, _ := json.Marshal(export)
+	decoded, err := audit.ParseChainExport(data)
+	if err != nil {
+		t.Fatalf("parse returned error: %v", err)
+	}
+	return decoded
+}
+
+func problems(report *audit.VerificationReport) string {
+	result := ""
+	for _, problem := range report.Problems {
+		result += fmt.Sprintf("%d:%s ", problem.Sequence, problem.Kind)
+	}
+	return result
+}
+
+func TestAuditChainTamperingTest(t *testing.T) {
+	t.Parallel()
+
+	service := audit.NewInMemoryAuditService()
+	export := chainExport(t, service, nil, 6)
+	if report := audit.VerifyEntries(export, nil); !report.Valid() || report.Entries != 6 || report.LastSequence != 6 {
+		t.Fatalf("expected an intact chain of 6 entries, got %+v", report)
+	}
+	if head, _ := service.ChainHead(context.Background()); head.Sequence != 6 || head.Hash != export.Entries[5].Hash {
+		t.Fatalf("expected the head to be the last entry, got %+v", head)
+	}
+
+	for name, tc := range map[string]struct {
+		tamper func(entries []*audit.AuditLog) []*audit.AuditLog
+		want   string
+	}{
+		"modified result":  {func(e []*audit.AuditLog) []*audit.AuditLog { e[2].Result = "failure"; return e }, "3:modified "},
+		"modified details": {func(e []*audit.AuditLog) []*audit.AuditLog { e[1].Details["attempt"] = 9.0; return e }, "2:modified "},
+		"modified user":    {func(e []*audit.AuditLog) []*audit.AuditLog { e[3].UserID = "u9"; return e }, "4:modified "},
+		"deleted entry":    {func(e []*audit.AuditLog) []*audit.AuditLog { return append(e[:2:2], e[3:]...) }, "3:gap "},
+		"deleted tail":     {func(e []*audit.AuditLog) []*audit.AuditLog { return e[:5] }, ""},
+		"swapped entries": {func(e []*audit.AuditLog) []*audit.AuditLog {
+			e[2], e[3] = e[3], e[2]
+			return e
+		}, "3:reordered "},
+		"duplicated entry": {func(e []*audit.AuditLog) []*audit.AuditLog { return append(e, e[4]) }, "5:reordered 5:duplicate "},
+		"rewritten chain": {func(e []*audit.AuditLog) []*audit.AuditLog {
+			e[1].Action = "logout"
+			e[1].Hash, _ = audit.ComputeHash(e[1])
+			return e
+		}, "3:broken_link "},
+		"forged genesis": {func(e []*audit.AuditLog) []*audit.AuditLog {
+			e[0].PrevHash = e[5].Hash
+			return e
+		}, "1:modified 1:broken_link "},
+	} {
+		export := chainExport(t, audit.NewInMemoryAuditService(), nil, 6)
+		export.Entries = tc.tamper(export.Entries)
+		if got := problems(audit.VerifyEntries(export, nil)); got != tc.want {
+			t.Fatalf("%s: expected problems %q, got %q", name, tc.want, got)
+		}
+	}
+
+	ranged := export.Range(3, 4)
+	if len(ranged.Entries) != 3 || ranged.Entries[0].Sequence != 2 {
+		t.Fatalf("expected the range to start with its anchor entry, got %d entries", len(ranged.Entries))
+	}
+	ranged.Entries[0].Hash = "bogus"
+	if got := problems(audit.VerifyEntries(ranged, nil)); got != "2:modified 3:broken_link " {
+		t.Fatalf("expected the anchor to be checked, got %q", got)
+	}
+}
+
+func TestAuditChainCheckpointsTest(t *testing.T) {
+	t.Parallel()
+
+	public, private, _ := ed25519.GenerateKey(rand.Reader)
+	service := audit.NewInMemoryAuditService()
+	ctx := context.Background()
+	checkpointer := audit.NewCheckpointer(service, "k1", private)
+	if cp, err := checkpointer.Checkpoint(ctx); cp != nil || err != nil {
+		t.Fatalf("expected no checkpoint of an empty chain, got %+v (%v)", cp, err)
+	}
+	export := chainExport(t, service, checkpointer, 7)
+	if cp, _ := checkpointer.Checkpoint(ctx); cp == nil || cp.Sequence != 7 {
+		t.Fatalf("expected a checkpoint of the new head, got %+v", cp)
+	}
+	if cp, _ := checkpointer.Checkpoint(ctx); cp != nil {
+		t.Fatalf("expected no checkpoint while the head is unchanged, got %+v", cp)
+	}
+	if len(export.Checkpoints) != 2 || export.Checkpoints[1].Sequence != 6 {
+		t.Fatalf("expected checkpoints at 3 and 6, got %+v", export.Checkpoints)
+	}
+
+	keys := map[string]ed25519.PublicKey{"k1": public}
+	if report := audit.VerifyEntries(export, keys); !report.Valid() || report.CheckpointsVerified != 2 {
+		t.Fatalf("expected both checkpoints to verify, got %+v", report)
+	}
+	if got := problems(audit.VerifyEntries(export, nil)); got != "3:bad_checkpoint 6:bad_checkpoint " {
+		t.Fatalf("expected checkpoints without a key to be reported, got %q", got)
+	}
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	if got := problems(audit.VerifyEntries(export, map[string]ed25519.PublicKey{"": other})); got != "3:bad_checkpoint 6:bad_checkpoint " {
+		t.Fatalf("expected checkpoints signed by another key to be rejected, got %q", got)
+	}
+
+	// Rewriting every hash from entry 5 on keeps the links intact but not the checkpoint at 6
+	prev := export.Entries[3].Hash
+	for _, entry := range export.Entries[4:] {
+		entry.Result = "failure"
+		entry.PrevHash = prev
+		entry.Hash, _ = audit.ComputeHash(entry)
+		prev = entry.Hash
+	}
+	if got := problems(audit.VerifyEntries(export, keys)); got != "6:checkpoint_mismatch " {
+		t.Fatalf("expected the rewrite to contradict the checkpoint, got %q", got)
+	}
+	truncated := &audit.ChainExport{Entries: export.Entries[:4], Checkpoints: export.Checkpoints}
+	if got := problems(audit.VerifyEntries(truncated, keys)); got != "5:gap " {
+		t.Fatalf("expected entries cut after a checkpoint to be reported, got %q", got)
+	}
+	export.Checkpoints[0].Hash = prev
+	if got := problems(audit.VerifyEntries(export, keys)); got != "3:bad_checkpoint 6:checkpoint_mismatch " {
+		t.Fatalf("expected an altered checkpoint to fail its signature, got %q", got)
+	}
+
+	der, _ := x509.MarshalPKIXPublicKey(public)
+	parsed, err := audit.ParsePublicKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil || !parsed.Equal(public) {
+		t.Fatalf("expected the PEM key to parse, got %v", err)
+	}
+}
+
+func TestAuditChainAnonymizationTest(t *testing.T) {
+	t.Parallel()
+
+	service := audit.NewInMemoryAuditService()
+	ctx := context.Background()
+	chainExport(t, service, nil, 4)
+	if err := service.DeleteUserData(ctx, "u1"); err != nil {
+		t.Fatalf("delete returned error: %v", err)
+	}
+	report, err := audit.VerifyChain(ctx, service, 1, 0, nil)
+	if err != nil || !report.Valid() || report.Entries != 5 || report.Anonymized != 2 {
+		t.Fatalf("expected erased entries to still verify, got %+v (%v)", report, err)
+	}
+
+	export, _ := audit.ExportChain(ctx, service, 1, 0)
+	for _, entry := range export.Entries {
+		if entry.EventType == audit.EventTypeLogin && entry.UserID == "" && entry.PersonalDataSalt != "" {
+			t.Fatalf("expected erased entries to lose their salt, got %+v", entry)
+		}
+	}
+	export.Entries[1].IP = "198.51.100.7"
+	if got := problems(audit.VerifyEntries(export, nil)); got != "2:modified " {
+		t.Fatalf("expected personal data added back to an erased entry to be reported, got %q", got)
+	}
+
+	from, err := audit.VerifyChain(ctx, service, 3, 4, nil)
+	if err != nil || !from.Valid() || from.FirstSequence != 2 || from.LastSequence != 4 {
+		t.Fatalf("expected a range to be verified from its anchor, got %+v (%v)", from, err)
+	}
+}
@@ -0,0 +1,273 @@
+package audit_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"goat/internal/audit"
+)
+
+// blockingAuditService holds every Log call until release is closed
+type blockingAuditService struct {
+	audit.AuditService
+	release chan struct{}
+}
+
+func (s *blockingAuditService) Log(ctx context.Context, log *audit.AuditLog) error {
+	<-s.release
+	return nil
+}
+
+func TestAuditMiddlewareRecordsRoutesTest(t *testing.T) {
+	t.Parallel()
+
+	service := audit.NewInMemoryAuditService()
+	proxies, err := audit.ParseTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("parse proxies returned error: %v", err)
+	}
+	middleware := audit.NewAuditMiddleware(audit.NewAuditLogger(service), audit.WithTrustedProxies(proxies))
+	if err := middleware.Route("POST /api/mfa/enroll", audit.Route{EventType: audit.EventTypeMFAEnroll, Resource: "mfa", Action: "enroll"}); err != nil {
+		t.Fatalf("route returned error: %v", err)
+	}
+	if err := middleware.Route("GET /api/audit/logs/{userId}", audit.Route{EventType: audit.EventTypeConfigChange, Resource: "audit_logs"}); err != nil {
+		t.Fatalf("route returned error: %v", err)
+	}
+	if err := middleware.Route("POST /api/mfa/enroll", audit.Route{EventType: audit.EventTypeMFAEnroll}); err == nil {
+		t.Fatalf("expected a duplicate route to be rejected")
+	}
+	if err := middleware.Route("GET /x", audit.Route{}); err == nil {
+		t.Fatalf("expected a route without event type to be rejected")
+	}
+	if err := middleware.Route("GET /{bad", audit.Route{Skip: true}); err == nil {
+		t.Fatalf("expected an invalid pattern to be rejected")
+	}
+	middleware.Start(context.Background())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/mfa/enroll", func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(audit.WithRequestUser(r.Context(), "u1", "s1"))
+		if user, session := audit.RequestUserFromContext(r.Context()); user != "u1" || session != "s1" {
+			t.Errorf("expected the handler to see its user, got %q %q", user, session)
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("GET /api/audit/logs/{userId}", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	})
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {})
+	handler := middleware.Handler(mux)
+
+	enroll := httptest.NewRequest(http.MethodPost, "/api/mfa/enroll", nil)
+	enroll.RemoteAddr = "10.1.2.3:4000"
+	enroll.Header.Set("X-Forwarded-For", "203.0.113.9, 10.9.9.9")
+	enroll.Header.Set("X-Request-ID", "req-1")
+	enroll.Header.Set("User-Agent", "client/1.0")
+	logs := httptest.NewRequest(http.MethodGet, "/api/audit/logs/secret-user", nil)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(httptest.NewRecorder(), enroll)
+	handler.ServeHTTP(recorder, logs)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	generatedID := recorder.Header().Get("X-Request-ID")
+	if generatedID == "" {
+		t.Fatalf("expected a request ID to be generated and returned")
+	}
+	middleware.Stop()
+
+	entries, _ := service.Query(context.Background(), nil)
+	if len(entries) != 2 {
+		t.Fatalf("expected only the two registered routes to be audited, got %d", len(entries))
+	}
+	byType := map[audit.EventType]*audit.AuditLog{}
+	for _, entry := range entries {
+		byType[entry.EventType] = entry
+	}
+	got := byType[audit.EventTypeMFAEnroll]
+	if got == nil || got.UserID != "u1" || got.SessionID != "s1" || got.IP != "203.0.113.9" || got.UserAgent != "client/1.0" ||
+		got.Resource != "mfa" || got.Action != "enroll" || got.Result != "success" || got.Details["status"] != http.StatusCreated ||
+		got.Details["route"] != "POST /api/mfa/enroll" || got.Details["request_id"] != "req-1" || got.Details["method"] != "POST" {
+		t.Fatalf("unexpected enroll entry %+v", got)
+	}
+	if _, ok := got.Details["latency_ms"].(float64); !ok {
+		t.Fatalf("expected a latency, got %+v", got.Details)
+	}
+	denied := byType[audit.EventTypeConfigChange]
+	if denied == nil || denied.Action != "GET" || denied.Result != "deny" || denied.Severity != audit.SeverityWarning ||
+		denied.Details["route"] != "GET /api/audit/logs/{userId}" || denied.Details["request_id"] != generatedID || denied.IP != "192.0.2.1" {
+		t.Fatalf("unexpected denied entry %+v", denied)
+	}
+}
+
+func TestAuditMiddlewareDoesNotBlockTest(t *testing.T) {
+	t.Parallel()
+
+	service := &blockingAuditService{release: make(chan struct{})}
+	var mu sync.Mutex
+	var dropped []error
+	middleware := audit.NewAuditMiddleware(audit.NewAuditLogger(service),
+		audit.WithDefaultRoute(audit.Route{EventType: audit.EventTypeConfigChange}),
+		audit.WithAuditQueue(1, 1),
+		audit.WithMiddlewareErrorHandler(func(log *audit.AuditLog, err error) {
+			mu.Lock()
+			dropped = append(dropped, err)
+			mu.Unlock()
+		}))
+	if err := middleware.Route("GET /healthz", audit.Route{Skip: true}); err != nil {
+		t.Fatalf("route returned error: %v", err)
+	}
+	middleware.Start(context.Background())
+	handler := middleware.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/panic" {
+			panic("boom")
+		}
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5; i++ {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/settings", nil))
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected requests to complete while audit storage is blocked")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("expected the handler panic to propagate")
+			}
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/panic", nil))
+	}()
+
+	// One entry is being stored and one waits in the queue; the rest were dropped
+	if n := middleware.Dropped(); n < 4 {
+		t.Fatalf("expected at least 4 dropped entries, got %d", n)
+	}
+	mu.Lock()
+	if len(dropped) == 0 || !errors.Is(dropped[0], audit.ErrAuditQueueFull) {
+		t.Fatalf("expected drops to be reported, got %v", dropped)
+	}
+	mu.Unlock()
+	close(service.release)
+	middleware.Stop()
+}
+
+// drainingAuditService holds every Log call until release is closed and records the context
+// state each entry was stored with
+type drainingAuditService struct {
+	audit.AuditService
+	release chan struct{}
+	mu      sync.Mutex
+	ctxErrs []error
+}
+
+func (s *drainingAuditService) Log(ctx context.Context, log *audit.AuditLog) error {
+	<-s.release
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ctxErrs = append(s.ctxErrs, ctx.Err())
+	return ctx.Err()
+}
+
+func TestAuditMiddlewareDrainsOnCancelTest(t *testing.T) {
+	t.Parallel()
+
+	service := &drainingAuditService{release: make(chan struct{})}
+	var mu sync.Mutex
+	var reported []error
+	middleware := audit.NewAuditMiddleware(audit.NewAuditLogger(service),
+		audit.WithDefaultRoute(audit.Route{EventType: audit.EventTypeConfigChange}),
+		audit.WithAuditQueue(4, 1),
+		audit.WithMiddlewareErrorHandler(func(log *audit.AuditLog, err error) {
+			mu.Lock()
+			reported = append(reported, err)
+			mu.Unlock()
+		}))
+	ctx, cancel := context.WithCancel(context.Background())
+	middleware.Start(ctx)
+	handler := middleware.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// The worker holds the first entry; the next two wait in the queue
+	served := 0
+	for ; served < 3; served++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/settings", nil))
+	}
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for middleware.Dropped() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected entries after cancellation to be refused")
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/settings", nil))
+		served++
+		time.Sleep(time.Millisecond)
+	}
+	close(service.release)
+	middleware.Stop()
+
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	if want := served - int(middleware.Dropped()); len(service.ctxErrs) != want || want < 3 {
+		t.Fatalf("expected every accepted entry to be stored after cancellation, got %d of %d", len(service.ctxErrs), want)
+	}
+	for _, err := range service.ctxErrs[1:] {
+		if err != nil {
+			t.Fatalf("expected drained entries to be stored with a live context, got %v", err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reported) == 0 || !errors.Is(reported[len(reported)-1], audit.ErrAuditQueueFull) {
+		t.Fatalf("expected entries after cancellation to be reported as dropped, got %v", reported)
+	}
+}
+
+func TestClientIPTest(t *testing.T) {
+	t.Parallel()
+
+	trusted, err := audit.ParseTrustedProxies("10.0.0.0/8", "192.0.2.10", "2001:db8::/32")
+	if err != nil {
+		t.Fatalf("parse proxies returned error: %v", err)
+	}
+	if _, err := audit.ParseTrustedProxies("proxy.internal"); err == nil {
+		t.Fatalf("expected a hostname to be rejected")
+	}
+	for name, tc := range map[string]struct {
+		remote  string
+		forward []string
+		realIP  string
+		want    string
+	}{
+		"direct client":             {"198.51.100.7:5000", nil, "", "198.51.100.7"},
+		"untrusted sender spoofing": {"198.51.100.7:5000", []string{"1.2.3.4"}, "5.6.7.8", "198.51.100.7"},
+		"trusted proxy":             {"10.0.0.1:80", []string{"203.0.113.5"}, "", "203.0.113.5"},
+		"client spoofing via proxy": {"10.0.0.1:80", []string{"1.2.3.4, 203.0.113.5"}, "", "203.0.113.5"},
+		"proxy chain":               {"192.0.2.10:443", []string{"203.0.113.5, 10.2.2.2", "10.3.3.3"}, "", "203.0.113.5"},
+		"all hops trusted":          {"10.0.0.1:80", []string{"10.4.4.4"}, "", "10.4.4.4"},
+		"malformed hop":             {"10.0.0.1:80", []string{"203.0.113.5, garbage"}, "", "10.0.0.1"},
+		"real ip header":            {"10.0.0.1:80", nil, "203.0.113.8", "203.0.113.8"},
+		"ipv6 proxy":                {"[2001:db8::1]:443", []string{"2001:0db9::0005, 2001:db8::2"}, "", "2001:db9::5"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tc.remote
+		for _, header := range tc.forward {
+			r.Header.Add("X-Forwarded-For", header)
+		}
+		if tc.realIP != "" {
+			r.Header.Set("X-Real-IP", tc.realIP)
+		}
+		if got := audit.ClientIP(r, trusted); got != tc.want {
+			t.Fatalf("%s: expected %s, got %s", name, tc.want, got)
+		}
+	}
+}
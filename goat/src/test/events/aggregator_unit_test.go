@@ -0,0 +1,268 @@
+package events_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	events "goat/internal/events"
+)
+
+// fakeAggregationStore upserts windows by size and start, as the Postgres store does
+type fakeAggregationStore struct {
+	mu    sync.Mutex
+	saved []*events.AggregatedEvents
+	saves int
+	delay time.Duration
+}
+
+func (s *fakeAggregationStore) SaveAggregation(ctx context.Context, agg *events.AggregatedEvents) error {
+	time.Sleep(s.delay)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saves++
+	for i, existing := range s.saved {
+		if existing.Window == agg.Window && existing.StartTime.Equal(agg.StartTime) {
+			s.saved[i] = agg
+			return nil
+		}
+	}
+	s.saved = append(s.saved, agg)
+	return nil
+}
+
+func (s *fakeAggregationStore) ListAggregations(ctx context.Context, start, end time.Time) ([]*events.AggregatedEvents, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.saved, nil
+}
+
+func TestWindowAggregatorTumblingAndStatisticsTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := base.Add(30 * time.Second)
+
+	aggregator := events.NewWindowAggregator(events.AggregatorConfig{
+		Mode:   events.WindowTumbling,
+		Window: time.Minute,
+		Bucket: 10 * time.Second,
+		Now:    func() time.Time { return now },
+	})
+
+	input := []*events.Event{
+		{Type: events.EventUserLogin, UserID: "alice", Priority: events.PriorityNormal, Timestamp: base.Add(1 * time.Second)},
+		{Type: events.EventUserLogin, UserID: "alice", Priority: events.PriorityNormal, Timestamp: base.Add(12 * time.Second)},
+		{Type: events.EventUserLoginFailed, UserID: "bob", Priority: events.PriorityHigh, Timestamp: base.Add(15 * time.Second)},
+		{Type: events.EventUserLogout, UserID: "alice", Priority: events.PriorityLow, Timestamp: base.Add(25 * time.Second), Result: "success"},
+		// Belongs to the previous window and must not be counted.
+		{Type: events.EventUserLogin, UserID: "carol", Priority: events.PriorityNormal, Timestamp: base.Add(-5 * time.Second)},
+	}
+	for _, event := range input {
+		if err := aggregator.Handle(ctx, event); err != nil {
+			t.Fatalf("handle returned error: %v", err)
+		}
+	}
+
+	agg, err := aggregator.Aggregate(ctx, 0)
+	if err != nil {
+		t.Fatalf("aggregate returned error: %v", err)
+	}
+	if !agg.StartTime.Equal(base) || !agg.EndTime.Equal(base.Add(time.Minute)) {
+		t.Fatalf("unexpected window bounds %s - %s", agg.StartTime, agg.EndTime)
+	}
+	if agg.Total != 4 {
+		t.Fatalf("expected 4 events in window, got %d", agg.Total)
+	}
+	if agg.ByType[events.EventUserLogin] != 2 {
+		t.Fatalf("expected 2 logins, got %d", agg.ByType[events.EventUserLogin])
+	}
+	if agg.ByUser["alice"] != 3 || agg.ByUser["bob"] != 1 {
+		t.Fatalf("unexpected per-user counts: %v", agg.ByUser)
+	}
+	if agg.Errors != 1 {
+		t.Fatalf("expected 1 error event, got %d", agg.Errors)
+	}
+	if len(agg.Trends) != 6 {
+		t.Fatalf("expected 6 trend buckets, got %d", len(agg.Trends))
+	}
+	if agg.Trends[0].Count != 1 || agg.Trends[1].Count != 2 || agg.Trends[2].Count != 1 {
+		t.Fatalf("unexpected trends: %+v", agg.Trends)
+	}
+
+	stats, err := aggregator.GetStatistics(ctx, &events.EventFilter{Types: []events.EventType{events.EventUserLogin, events.EventUserLoginFailed}})
+	if err != nil {
+		t.Fatalf("get statistics returned error: %v", err)
+	}
+	if stats.TotalEvents != 4 {
+		t.Fatalf("expected 4 matching events, got %d", stats.TotalEvents)
+	}
+	if stats.UniqueUsers != 3 {
+		t.Fatalf("expected 3 unique users, got %d", stats.UniqueUsers)
+	}
+	if stats.ErrorRate != 0.25 {
+		t.Fatalf("expected error rate 0.25, got %f", stats.ErrorRate)
+	}
+	if len(stats.TopEventTypes) == 0 || stats.TopEventTypes[0].Type != events.EventUserLogin || stats.TopEventTypes[0].Count != 3 {
+		t.Fatalf("unexpected top event types: %+v", stats.TopEventTypes)
+	}
+
+	if _, err := aggregator.GetStatistics(ctx, &events.EventFilter{Resource: "orders"}); !errors.Is(err, events.ErrUnsupportedFilter) {
+		t.Fatalf("expected unsupported filter error, got %v", err)
+	}
+}
+
+func TestWindowAggregatorSlidingTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := base.Add(65 * time.Second)
+
+	aggregator := events.NewWindowAggregator(events.AggregatorConfig{
+		Mode:   events.WindowSliding,
+		Window: time.Minute,
+		Bucket: 10 * time.Second,
+		Now:    func() time.Time { return now },
+	})
+
+	for _, offset := range []time.Duration{5 * time.Second, 15 * time.Second, 62 * time.Second} {
+		if err := aggregator.Handle(ctx, &events.Event{Type: events.EventTokenCreated, Timestamp: base.Add(offset)}); err != nil {
+			t.Fatalf("handle returned error: %v", err)
+		}
+	}
+
+	agg, err := aggregator.Aggregate(ctx, time.Minute)
+	if err != nil {
+		t.Fatalf("aggregate returned error: %v", err)
+	}
+	// The sliding window ends at the close of the current bucket (70s) and covers 10s-70s.
+	if agg.Total != 2 {
+		t.Fatalf("expected 2 events in sliding window, got %d", agg.Total)
+	}
+	if !agg.StartTime.Equal(base.Add(10 * time.Second)) {
+		t.Fatalf("unexpected sliding window start %s", agg.StartTime)
+	}
+}
+
+func TestWindowAggregatorFlushCompletedTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := base.Add(10 * time.Second)
+
+	aggregator := events.NewWindowAggregator(events.AggregatorConfig{
+		Window: time.Minute,
+		Bucket: 10 * time.Second,
+		Now:    func() time.Time { return now },
+	})
+	store := &fakeAggregationStore{}
+
+	if err := aggregator.Handle(ctx, &events.Event{Type: events.EventUserLogin, Timestamp: base.Add(time.Second)}); err != nil {
+		t.Fatalf("handle returned error: %v", err)
+	}
+	if err := aggregator.FlushCompleted(ctx, store); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+	if len(store.saved) != 0 {
+		t.Fatalf("expected open window not to be flushed, got %d", len(store.saved))
+	}
+
+	now = base.Add(2*time.Minute + time.Second)
+	if err := aggregator.FlushCompleted(ctx, store); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+	if len(store.saved) != 2 {
+		t.Fatalf("expected 2 completed windows, got %d", len(store.saved))
+	}
+	if store.saved[0].Total != 1 || store.saved[1].Total != 0 {
+		t.Fatalf("unexpected rollup totals %d, %d", store.saved[0].Total, store.saved[1].Total)
+	}
+
+	if err := aggregator.FlushCompleted(ctx, store); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+	if len(store.saved) != 2 {
+		t.Fatalf("expected flush to be idempotent, got %d windows", len(store.saved))
+	}
+}
+
+func TestWindowAggregatorFlushLateEventsTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := base.Add(time.Minute + time.Second)
+
+	aggregator := events.NewWindowAggregator(events.AggregatorConfig{
+		Window: time.Minute,
+		Bucket: 10 * time.Second,
+		Now:    func() time.Time { return now },
+	})
+	store := &fakeAggregationStore{}
+
+	if err := aggregator.Handle(ctx, &events.Event{Type: events.EventUserLogin, Timestamp: base.Add(time.Second)}); err != nil {
+		t.Fatalf("handle returned error: %v", err)
+	}
+	if err := aggregator.FlushCompleted(ctx, store); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+	if len(store.saved) != 1 || store.saved[0].Total != 1 {
+		t.Fatalf("expected the first window with one event, got %+v", store.saved)
+	}
+
+	if err := aggregator.Handle(ctx, &events.Event{Type: events.EventUserLogin, Timestamp: base.Add(30 * time.Second)}); err != nil {
+		t.Fatalf("handle returned error: %v", err)
+	}
+	if err := aggregator.FlushCompleted(ctx, store); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+	if len(store.saved) != 1 || store.saved[0].Total != 2 {
+		t.Fatalf("expected the late event to replace the window's counts, got %+v", store.saved)
+	}
+
+	saves := store.saves
+	if err := aggregator.FlushCompleted(ctx, store); err != nil {
+		t.Fatalf("flush returned error: %v", err)
+	}
+	if store.saves != saves {
+		t.Fatalf("expected a window to be saved again only after late events, got %d saves", store.saves-saves)
+	}
+}
+
+func TestWindowAggregatorConcurrentFlushTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	aggregator := events.NewWindowAggregator(events.AggregatorConfig{
+		Window: time.Minute,
+		Bucket: 10 * time.Second,
+		Now:    func() time.Time { return base.Add(10*time.Minute + time.Second) },
+	})
+	for i := 0; i < 10; i++ {
+		if err := aggregator.Handle(ctx, &events.Event{Type: events.EventUserLogin, Timestamp: base.Add(time.Duration(i) * time.Minute)}); err != nil {
+			t.Fatalf("handle returned error: %v", err)
+		}
+	}
+
+	store := &fakeAggregationStore{delay: time.Millisecond}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := aggregator.FlushCompleted(ctx, store); err != nil {
+				t.Errorf("flush returned error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(store.saved) != 10 || store.saves != 10 {
+		t.Fatalf("expected each window to be saved once, got %d windows in %d saves", len(store.saved), store.saves)
+	}
+}
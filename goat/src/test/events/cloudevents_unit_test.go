@@ -0,0 +1,209 @@
+package events_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	events "goat/internal/events"
+)
+
+func TestCloudEventsDeliveryFormatsTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var (
+		header http.Header
+		body   []byte
+	)
+	deliverer := events.NewDefaultWebhookDeliverer(0,
+		events.WithTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			header = req.Header.Clone()
+			body, _ = io.ReadAll(req.Body)
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("")), Header: make(http.Header)}, nil
+		})),
+		events.WithCloudEventSource("/goat/test"),
+	)
+
+	event := &events.Event{
+		ID:        "event-ce",
+		Type:      events.EventUserLogin,
+		Priority:  events.PriorityHigh,
+		Timestamp: time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC),
+		UserID:    "user-1",
+		Resource:  "sessions/42",
+		Data:      map[string]interface{}{"method": "password"},
+	}
+
+	tests := []struct {
+		format      events.PayloadFormat
+		contentType string
+	}{
+		{format: events.PayloadFormatCloudEvents, contentType: events.CloudEventsContentType},
+		{format: events.PayloadFormatCloudEventsBinary, contentType: "application/json"},
+	}
+	for _, tc := range tests {
+		webhook := &events.Webhook{ID: "ce", URL: "https://ce.example/hook", Format: tc.format, Secret: "s3cret"}
+		if _, err := deliverer.Deliver(ctx, webhook, event); err != nil {
+			t.Fatalf("%s: deliver returned error: %v", tc.format, err)
+		}
+		if got := header.Get("Content-Type"); got != tc.contentType {
+			t.Fatalf("%s: expected content type %q, got %q", tc.format, tc.contentType, got)
+		}
+		if header.Get("X-Webhook-Signature") == "" {
+			t.Fatalf("%s: expected payload to be signed", tc.format)
+		}
+		if tc.format == events.PayloadFormatCloudEventsBinary {
+			if header.Get("ce-type") != "user.login" || header.Get("ce-subject") != "sessions/42" {
+				t.Fatalf("expected ce-* attribute headers, got %v", header)
+			}
+			if header.Get("ce-userid") != "user-1" || string(body) != `{"method":"password"}` {
+				t.Fatalf("expected data body and extension headers, got %s %v", body, header)
+			}
+		}
+
+		parsed, err := events.ParseCloudEvents(header, body)
+		if err != nil {
+			t.Fatalf("%s: parse returned error: %v", tc.format, err)
+		}
+		if len(parsed) != 1 {
+			t.Fatalf("%s: expected one event, got %d", tc.format, len(parsed))
+		}
+		got := parsed[0]
+		if got.ID != event.ID || got.Type != event.Type || !got.Timestamp.Equal(event.Timestamp) ||
+			got.Resource != event.Resource || got.UserID != event.UserID || got.Priority != event.Priority {
+			t.Fatalf("%s: round trip lost fields: %+v", tc.format, got)
+		}
+		if got.Data["method"] != "password" || got.Metadata["source"] != "/goat/test" {
+			t.Fatalf("%s: unexpected data or source: %+v", tc.format, got)
+		}
+	}
+}
+
+func TestParseCloudEventsTest(t *testing.T) {
+	t.Parallel()
+
+	header := http.Header{"Content-Type": {events.CloudEventsBatchContentType}}
+	parsed, err := events.ParseCloudEvents(header, []byte(`[
+		{"specversion":"1.0","id":"a","source":"urn:partner","type":"partner.sync","tenant":"acme","data":{"n":1}},
+		{"specversion":"1.0","id":"b","source":"urn:partner","type":"partner.sync","data":[1,2]}
+	]`))
+	if err != nil {
+		t.Fatalf("parse returned error: %v", err)
+	}
+	if len(parsed) != 2 || parsed[0].Metadata["tenant"] != "acme" || parsed[0].Priority != events.PriorityNormal {
+		t.Fatalf("unexpected batch: %+v", parsed)
+	}
+	if values, ok := parsed[1].Data["value"].([]interface{}); !ok || len(values) != 2 {
+		t.Fatalf("expected non-object data to be wrapped, got %+v", parsed[1].Data)
+	}
+
+	header = http.Header{"Content-Type": {events.CloudEventsContentType}}
+	for ext, want := range map[string]events.Priority{`"critical"`: events.PriorityCritical, `"urgent"`: events.PriorityNormal, `5`: events.PriorityNormal} {
+		parsed, err := events.ParseCloudEvents(header, []byte(`{"specversion":"1.0","id":"p","source":"s","type":"t","priority":`+ext+`}`))
+		if err != nil || parsed[0].Priority != want {
+			t.Fatalf("expected priority %s to parse as %q, got %+v (%v)", ext, want, parsed, err)
+		}
+	}
+
+	if _, err := events.ParseCloudEvents(http.Header{"Content-Type": {"application/json"}}, []byte(`{}`)); !errors.Is(err, events.ErrNotCloudEvent) {
+		t.Fatalf("expected plain JSON to be reported as not a CloudEvent, got %v", err)
+	}
+	header = http.Header{"Content-Type": {events.CloudEventsContentType}}
+	if _, err := events.ParseCloudEvents(header, []byte(`{"specversion":"0.3","id":"a","source":"s","type":"t"}`)); err == nil {
+		t.Fatalf("expected unsupported specversion to be rejected")
+	}
+	if _, err := events.ParseCloudEvents(header, []byte(`{"specversion":"1.0","source":"s","type":"t"}`)); err == nil {
+		t.Fatalf("expected missing id to be rejected")
+	}
+
+	if err := events.ValidateWebhook(&events.Webhook{
+		Name:   "bad format",
+		URL:    "https://ce.example/hook",
+		Events: []events.EventType{events.EventUserLogin},
+		Format: "xml",
+	}); err == nil {
+		t.Fatalf("expected unknown payload format to be rejected")
+	}
+	if err := events.ValidateWebhook(&events.Webhook{
+		Name:   "batch",
+		URL:    "https://ce.example/hook",
+		Events: []events.EventType{events.EventUserLogin},
+		Format: events.PayloadFormatCloudEventsBatch,
+	}); err == nil {
+		t.Fatalf("expected batches to be rejected for webhooks, which receive one event per request")
+	}
+	if err := events.ValidateSubscription(&events.Subscription{
+		Name:        "batch",
+		Destination: "https://ce.example/hook",
+		Format:      events.PayloadFormatCloudEventsBatch,
+	}); err == nil {
+		t.Fatalf("expected batches to be rejected for webhook subscriptions")
+	}
+}
+
+func TestCloudEventsConsumerBatchTest(t *testing.T) {
+	t.Parallel()
+
+	service := events.NewDefaultEventService()
+	ctx := events.WithTenant(context.Background(), "acme")
+	queue := &events.Subscription{Name: "ce-queue", Type: events.SubscriptionQueue, Events: []events.EventType{"*"}, Format: events.PayloadFormatCloudEventsBatch, Active: true}
+	stream := &events.Subscription{Name: "ce-stream", Type: events.SubscriptionStream, Events: []events.EventType{"*"}, Format: events.PayloadFormatCloudEventsBatch, Active: true}
+	for _, subscription := range []*events.Subscription{queue, stream} {
+		if err := service.Subscribe(ctx, subscription); err != nil {
+			t.Fatalf("subscribe returned error: %v", err)
+		}
+	}
+	for _, id := range []string{"e1", "e2"} {
+		if err := service.Publish(ctx, &events.Event{ID: id, Type: events.EventUserLogin, UserID: "user-1"}); err != nil {
+			t.Fatalf("publish returned error: %v", err)
+		}
+	}
+
+	messages, err := service.Receive(ctx, queue.ID, "worker", 10)
+	if err != nil || len(messages) != 2 {
+		t.Fatalf("expected two messages, got %d (%v)", len(messages), err)
+	}
+	body, header, err := events.EncodeQueueMessages(queue.Format, "/goat/test", messages)
+	if err != nil {
+		t.Fatalf("encode returned error: %v", err)
+	}
+	if got := header.Get("Content-Type"); got != events.CloudEventsBatchContentType {
+		t.Fatalf("expected a cloudevents batch, got %q", got)
+	}
+	parsed, err := events.ParseCloudEvents(header, body)
+	if err != nil || len(parsed) != 2 {
+		t.Fatalf("expected both messages in one batch, got %d (%v)", len(parsed), err)
+	}
+	for i, event := range parsed {
+		if event.ID != messages[i].Event.ID || event.UserID != "user-1" || event.Metadata["attempts"] != float64(1) {
+			t.Fatalf("unexpected batch element: %+v", event)
+		}
+		receipt, _ := event.Metadata["receipt"].(string)
+		if err := service.Ack(ctx, queue.ID, receipt); err != nil {
+			t.Fatalf("expected the receipt extension to ack the message, got %v", err)
+		}
+	}
+
+	records, err := service.ReadStream(ctx, stream.ID, "siem", 10)
+	if err != nil || len(records) != 2 {
+		t.Fatalf("expected two records, got %d (%v)", len(records), err)
+	}
+	body, header, err = events.EncodeStreamRecords(stream.Format, "", records)
+	if err != nil {
+		t.Fatalf("encode returned error: %v", err)
+	}
+	parsed, err = events.ParseCloudEvents(header, body)
+	if err != nil || len(parsed) != 2 || parsed[1].Metadata["offset"] != float64(records[1].Offset) {
+		t.Fatalf("expected records with their offsets, got %+v (%v)", parsed, err)
+	}
+
+	body, header, err = events.EncodeStreamRecords(events.PayloadFormatNative, "", records)
+	if err != nil || header.Get("Content-Type") != "application/json" || !strings.HasPrefix(string(body), `[{"subscription_id"`) {
+		t.Fatalf("expected native records, got %s %v (%v)", body, header, err)
+	}
+}
@@ -0,0 +1,111 @@
+package events_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	events "goat/internal/events"
+)
+
+func TestDelivererOptionsTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	clock := &fakeClock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	var (
+		ids        int64
+		sent       int64
+		afterCodes []events.DeliveryErrorCode
+	)
+	deliverer := events.NewDefaultWebhookDeliverer(0,
+		events.WithTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			atomic.AddInt64(&sent, 1)
+			if req.Header.Get("X-Trace-ID") != "trace-1" {
+				t.Errorf("expected before-send hook header, got %q", req.Header.Get("X-Trace-ID"))
+			}
+			return &http.Response{
+				StatusCode: http.StatusBadGateway,
+				Body:       io.NopCloser(strings.NewReader("down")),
+				Header:     make(http.Header),
+			}, nil
+		})),
+		events.WithClock(clock),
+		events.WithRetryPolicy(events.RetryPolicy{MaxAttempts: 5, Delay: 2 * time.Minute}),
+		events.WithIDGenerator(func() string {
+			return fmt.Sprintf("delivery-%d", atomic.AddInt64(&ids, 1))
+		}),
+		events.WithBeforeSend(func(ctx context.Context, req *http.Request, webhook *events.Webhook, event *events.Event) error {
+			if webhook.ID == "blocked" {
+				return errors.New("webhook paused")
+			}
+			req.Header.Set("X-Trace-ID", "trace-1")
+			return nil
+		}),
+		events.WithAfterResponse(func(ctx context.Context, resp *http.Response, delivery *events.Delivery) {
+			afterCodes = append(afterCodes, delivery.ErrorCode)
+		}),
+	)
+
+	event := &events.Event{ID: "event-options", Type: events.EventUserLogin}
+	delivery, err := deliverer.Deliver(ctx, &events.Webhook{ID: "options", URL: "https://options.example/hook"}, event)
+	if err == nil {
+		t.Fatalf("expected delivery error for 502")
+	}
+	if delivery.ID != "delivery-1" {
+		t.Fatalf("expected generated delivery id, got %q", delivery.ID)
+	}
+	if !delivery.CreatedAt.Equal(clock.Now()) {
+		t.Fatalf("expected CreatedAt from injected clock, got %s", delivery.CreatedAt)
+	}
+	if delivery.NextRetryAt == nil || !delivery.NextRetryAt.Equal(clock.Now().Add(2*time.Minute)) {
+		t.Fatalf("expected retry scheduled by the retry policy, got %v", delivery.NextRetryAt)
+	}
+	if len(afterCodes) != 1 || afterCodes[0] != events.DeliveryErrorServer {
+		t.Fatalf("expected after-response hook to see the classified error, got %v", afterCodes)
+	}
+
+	blocked, err := deliverer.Deliver(ctx, &events.Webhook{ID: "blocked", URL: "https://options.example/hook"}, event)
+	if err == nil || blocked.ErrorCode != events.DeliveryErrorInvalidRequest {
+		t.Fatalf("expected before-send hook error to abort the delivery, got %v (%q)", err, blocked.ErrorCode)
+	}
+	if got := atomic.LoadInt64(&sent); got != 1 {
+		t.Fatalf("expected aborted delivery not to be sent, got %d requests", got)
+	}
+
+	for attempt := 2; attempt <= 5; attempt++ {
+		if _, err := deliverer.RetryDelivery(ctx, delivery.ID); err == nil {
+			t.Fatalf("expected retry %d to fail", attempt)
+		}
+	}
+	if _, err := deliverer.RetryDelivery(ctx, delivery.ID); err == nil || !strings.Contains(err.Error(), "max retries") {
+		t.Fatalf("expected max retries after 5 attempts, got %v", err)
+	}
+}
+
+func TestDelivererRetryPolicyDefaultsTest(t *testing.T) {
+	t.Parallel()
+
+	clock := &fakeClock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	deliverer := events.NewDefaultWebhookDeliverer(0,
+		events.WithTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: io.NopCloser(strings.NewReader("")), Header: make(http.Header)}, nil
+		})),
+		events.WithClock(clock),
+		events.WithRetryPolicy(events.RetryPolicy{MaxAttempts: 5}),
+	)
+
+	delivery, err := deliverer.Deliver(context.Background(), &events.Webhook{ID: "defaults", URL: "https://defaults.example/hook"}, &events.Event{ID: "event-defaults", Type: events.EventUserLogin})
+	if err == nil {
+		t.Fatalf("expected delivery error for 503")
+	}
+	if delivery.NextRetryAt == nil || delivery.NextRetryAt.Sub(clock.Now()) != time.Second {
+		t.Fatalf("expected the default 1s delay when only MaxAttempts is set, got %v", delivery.NextRetryAt)
+	}
+}
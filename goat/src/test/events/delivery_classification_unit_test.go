@@ -0,0 +1,169 @@
+package events_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	events "goat/internal/events"
+)
+
+func newStatusDeliverer(status int, header http.Header, opts ...events.DelivererOption) *events.DefaultWebhookDeliverer {
+	opts = append([]events.DelivererOption{
+		events.WithTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if header == nil {
+				header = make(http.Header)
+			}
+			return &http.Response{
+				Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+				StatusCode: status,
+				Body:       io.NopCloser(strings.NewReader("body")),
+				Header:     header,
+			}, nil
+		})),
+		events.WithRetryPolicy(events.RetryPolicy{Delay: time.Millisecond}),
+	}, opts...)
+	return events.NewDefaultWebhookDeliverer(0, opts...)
+}
+
+func TestDeliveryStatusClassificationTest(t *testing.T) {
+	t.Parallel()
+
+	httpDate := time.Now().Add(90 * time.Second).UTC().Format(http.TimeFormat)
+	tests := []struct {
+		name         string
+		status       int
+		header       http.Header
+		wantCode     events.DeliveryErrorCode
+		wantRetry    bool
+		minRetryWait time.Duration
+	}{
+		{name: "server error", status: http.StatusInternalServerError, wantCode: events.DeliveryErrorServer, wantRetry: true},
+		{name: "rate limited with seconds", status: http.StatusTooManyRequests, header: http.Header{"Retry-After": {"120"}}, wantCode: events.DeliveryErrorRateLimited, wantRetry: true, minRetryWait: 110 * time.Second},
+		{name: "unavailable with date", status: http.StatusServiceUnavailable, header: http.Header{"Retry-After": {httpDate}}, wantCode: events.DeliveryErrorServer, wantRetry: true, minRetryWait: 60 * time.Second},
+		{name: "bad request", status: http.StatusBadRequest, wantCode: events.DeliveryErrorClient},
+		{name: "unauthorized", status: http.StatusUnauthorized, wantCode: events.DeliveryErrorClient},
+		{name: "forbidden", status: http.StatusForbidden, wantCode: events.DeliveryErrorClient},
+		{name: "not found", status: http.StatusNotFound, wantCode: events.DeliveryErrorClient},
+		{name: "unprocessable", status: http.StatusUnprocessableEntity, wantCode: events.DeliveryErrorClient},
+		{name: "request timeout", status: http.StatusRequestTimeout, wantCode: events.DeliveryErrorUnexpected, wantRetry: true},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			deliverer := newStatusDeliverer(tc.status, tc.header)
+			delivery, err := deliverer.Deliver(context.Background(),
+				&events.Webhook{ID: "webhook-status", URL: "https://status.example/hook"},
+				&events.Event{ID: "event-status", Type: events.EventUserLogin})
+			if err == nil {
+				t.Fatalf("expected delivery error for status %d", tc.status)
+			}
+			if delivery.ErrorCode != tc.wantCode {
+				t.Fatalf("expected error code %q, got %q", tc.wantCode, delivery.ErrorCode)
+			}
+			if (delivery.NextRetryAt != nil) != tc.wantRetry {
+				t.Fatalf("expected retry scheduled = %v, got %v", tc.wantRetry, delivery.NextRetryAt)
+			}
+			if tc.minRetryWait > 0 && time.Until(*delivery.NextRetryAt) < tc.minRetryWait {
+				t.Fatalf("expected Retry-After to be honored, next retry in %s", time.Until(*delivery.NextRetryAt))
+			}
+			if delivery.ResponseHeaders == nil {
+				t.Fatalf("expected response headers to be recorded")
+			}
+			if !tc.wantRetry {
+				if _, err := deliverer.RetryDelivery(context.Background(), delivery.ID); err == nil {
+					t.Fatalf("expected non-retryable delivery to refuse retry")
+				}
+			}
+		})
+	}
+}
+
+func TestDeliveryGoneDeactivatesWebhookTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := events.WithOperator(context.Background())
+	deliverer := newStatusDeliverer(http.StatusGone, nil)
+	service := events.NewInMemoryWebhookService(deliverer, deliverer)
+	deliverer.SetWebhookDeactivator(service)
+
+	webhook := &events.Webhook{
+		Name:   "retired",
+		URL:    "https://retired.example/hook",
+		Events: []events.EventType{events.EventUserLogin},
+		Active: true,
+	}
+	if err := service.CreateWebhook(ctx, webhook); err != nil {
+		t.Fatalf("create returned error: %v", err)
+	}
+
+	delivery, err := deliverer.Deliver(ctx, webhook, &events.Event{ID: "event-gone", Type: events.EventUserLogin})
+	if err == nil {
+		t.Fatalf("expected delivery error for 410")
+	}
+	if delivery.ErrorCode != events.DeliveryErrorGone || delivery.NextRetryAt != nil {
+		t.Fatalf("expected non-retryable gone delivery, got code %q retry %v", delivery.ErrorCode, delivery.NextRetryAt)
+	}
+
+	stored, err := service.GetWebhook(ctx, webhook.ID)
+	if err != nil {
+		t.Fatalf("get returned error: %v", err)
+	}
+	if stored.Active {
+		t.Fatalf("expected webhook to be deactivated after 410")
+	}
+}
+
+func TestDeliveryTransportErrorClassificationTest(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		err      error
+		wantCode events.DeliveryErrorCode
+	}{
+		{name: "dns", err: &net.DNSError{Err: "no such host", Name: "missing.example", IsNotFound: true}, wantCode: events.DeliveryErrorDNS},
+		{name: "tls", err: x509.UnknownAuthorityError{}, wantCode: events.DeliveryErrorTLS},
+		{name: "tls verification", err: &tls.CertificateVerificationError{Err: x509.HostnameError{}}, wantCode: events.DeliveryErrorTLS},
+		{name: "tls record header", err: tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}, wantCode: events.DeliveryErrorTLS},
+		{name: "tls remote alert", err: &net.OpError{Op: "remote error", Err: errors.New("tls: handshake failure")}, wantCode: events.DeliveryErrorTLS},
+		{name: "tls-like message", err: errors.New("tls: looks like TLS but is not typed"), wantCode: events.DeliveryErrorNetwork},
+		{name: "timeout", err: context.DeadlineExceeded, wantCode: events.DeliveryErrorTimeout},
+		{name: "network", err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, wantCode: events.DeliveryErrorNetwork},
+		{name: "blocked", err: fmt.Errorf("%w: 10.0.0.1", events.ErrBlockedDestination), wantCode: events.DeliveryErrorBlocked},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			deliverer := events.NewDefaultWebhookDeliverer(0,
+				events.WithTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					return nil, tc.err
+				})),
+			)
+			delivery, err := deliverer.Deliver(context.Background(),
+				&events.Webhook{ID: "webhook-transport", URL: "https://transport.example/hook"},
+				&events.Event{ID: "event-transport", Type: events.EventUserLogin})
+			if err == nil {
+				t.Fatalf("expected delivery error")
+			}
+			if delivery.ErrorCode != tc.wantCode {
+				t.Fatalf("expected error code %q, got %q (%s)", tc.wantCode, delivery.ErrorCode, delivery.Error)
+			}
+			if delivery.ErrorCode.Retryable() != (delivery.NextRetryAt != nil) {
+				t.Fatalf("expected retry scheduling to follow retryability for %q", delivery.ErrorCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package events_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	events "goat/internal/events"
+)
+
+// fakeDeliveryDB answers the queries PostgresDeliveryStore makes, enforcing the foreign keys and
+// target check of webhook_deliveries as declared by migrations 005 and 019
+type fakeDeliveryDB struct {
+	mu            sync.Mutex
+	webhooks      map[string]bool
+	subscriptions map[string][]driver.Value
+	events        map[string][]driver.Value
+	deliveries    map[string][]driver.Value
+}
+
+var (
+	fakeDeliveryDBsMu sync.Mutex
+	fakeDeliveryDBs   = map[string]*fakeDeliveryDB{}
+)
+
+func init() {
+	sql.Register("fake-delivery-postgres", fakeDeliveryDriver{})
+}
+
+func openFakeDeliveryDB(t *testing.T) (*sql.DB, *fakeDeliveryDB) {
+	t.Helper()
+	fake := &fakeDeliveryDB{
+		webhooks:      map[string]bool{},
+		subscriptions: map[string][]driver.Value{},
+		events:        map[string][]driver.Value{},
+		deliveries:    map[string][]driver.Value{},
+	}
+	fakeDeliveryDBsMu.Lock()
+	fakeDeliveryDBs[t.Name()] = fake
+	fakeDeliveryDBsMu.Unlock()
+	db, err := sql.Open("fake-delivery-postgres", t.Name())
+	if err != nil {
+		t.Fatalf("open fake database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, fake
+}
+
+type fakeDeliveryDriver struct{}
+
+func (fakeDeliveryDriver) Open(name string) (driver.Conn, error) {
+	fakeDeliveryDBsMu.Lock()
+	defer fakeDeliveryDBsMu.Unlock()
+	fake, ok := fakeDeliveryDBs[name]
+	if !ok {
+		return nil, fmt.Errorf("no fake database %q", name)
+	}
+	return &fakeDeliveryConn{db: fake}, nil
+}
+
+type fakeDeliveryConn struct{ db *fakeDeliveryDB }
+
+func (c *fakeDeliveryConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepare is not supported")
+}
+func (c *fakeDeliveryConn) Close() error { return nil }
+func (c *fakeDeliveryConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+func (c *fakeDeliveryConn) ExecContext(ctx context.Context, query string, named []driver.NamedValue) (driver.Result, error) {
+	args := namedValues(named)
+	if !strings.Contains(query, "INSERT INTO webhook_deliveries") {
+		return nil, fmt.Errorf("unexpected exec: %s", query)
+	}
+	// NULLIF($2, '')::uuid and NULLIF($3, '')::uuid
+	webhookID, subscriptionID := nullIfEmpty(args[1]), nullIfEmpty(args[2])
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	if webhookID != nil && !c.db.webhooks[webhookID.(string)] {
+		return nil, fmt.Errorf(`insert violates foreign key "webhook_deliveries_webhook_id_fkey": webhook %v`, webhookID)
+	}
+	if subscriptionID != nil && c.db.subscriptions[subscriptionID.(string)] == nil {
+		return nil, fmt.Errorf(`insert violates foreign key "webhook_deliveries_subscription_id_fkey": subscription %v`, subscriptionID)
+	}
+	if (webhookID == nil) == (subscriptionID == nil) {
+		return nil, errors.New(`insert violates check constraint "webhook_deliveries_target_check"`)
+	}
+	row := append([]driver.Value(nil), args...)
+	row[1], row[2] = webhookID, subscriptionID
+	c.db.deliveries[args[0].(string)] = row
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeDeliveryConn) QueryContext(ctx context.Context, query string, named []driver.NamedValue) (driver.Rows, error) {
+	args := namedValues(named)
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	switch {
+	case strings.Contains(query, "FROM webhook_deliveries") && strings.Contains(query, "WHERE id = $1"):
+		rows := &fakeRows{}
+		if stored, ok := c.db.deliveries[args[0].(string)]; ok {
+			rows.values = append(rows.values, deliveryRow(stored))
+		}
+		return rows, nil
+	case strings.Contains(query, "FROM webhook_deliveries"):
+		var matched [][]driver.Value
+		for _, stored := range c.db.deliveries {
+			if stored[1] == args[0] || stored[2] == args[0] {
+				matched = append(matched, stored)
+			}
+		}
+		sort.Slice(matched, func(i, j int) bool { return matched[i][17].(time.Time).After(matched[j][17].(time.Time)) })
+		rows := &fakeRows{}
+		for _, stored := range matched {
+			rows.values = append(rows.values, deliveryRow(stored))
+		}
+		return rows, nil
+	case strings.Contains(query, "FROM event_subscriptions"):
+		return singleRow(c.db.subscriptions[args[0].(string)]), nil
+	case strings.Contains(query, "FROM events"):
+		return singleRow(c.db.events[args[0].(string)]), nil
+	case strings.Contains(query, "FROM webhooks"):
+		return &fakeRows{}, nil
+	}
+	return nil, fmt.Errorf("unexpected query: %s", query)
+}
+
+// deliveryRow projects a stored insert onto the columns of the store's delivery select
+func deliveryRow(stored []driver.Value) []driver.Value {
+	target, subscription := stored[1], stored[2]
+	if target == nil {
+		target = subscription
+	}
+	if subscription == nil {
+		subscription = ""
+	}
+	status := stored[8]
+	if status == nil {
+		status = int64(0)
+	}
+	return []driver.Value{stored[0], target, subscription, stored[3], stored[4], stored[5], stored[6], stored[7],
+		orEmpty(stored[10]), stored[9], status, stored[11], orEmpty(nullIfEmpty(stored[12])), orEmpty(nullIfEmpty(stored[13])),
+		stored[14], stored[15], stored[16], stored[17]}
+}
+
+func namedValues(named []driver.NamedValue) []driver.Value {
+	args := make([]driver.Value, len(named))
+	for i, value := range named {
+		args[i] = value.Value
+	}
+	return args
+}
+
+func nullIfEmpty(v driver.Value) driver.Value {
+	if s, ok := v.(string); ok && s == "" {
+		return nil
+	}
+	return v
+}
+
+func orEmpty(v driver.Value) driver.Value {
+	if v == nil {
+		return ""
+	}
+	return v
+}
+
+func singleRow(values []driver.Value) *fakeRows {
+	if values == nil {
+		return &fakeRows{}
+	}
+	return &fakeRows{values: [][]driver.Value{values}}
+}
+
+type fakeRows struct {
+	values [][]driver.Value
+	next   int
+}
+
+func (r *fakeRows) Columns() []string {
+	if len(r.values) == 0 {
+		return make([]string, 32)
+	}
+	return make([]string, len(r.values[0]))
+}
+func (r *fakeRows) Close() error { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.values) {
+		return io.EOF
+	}
+	copy(dest, r.values[r.next])
+	r.next++
+	return nil
+}
+
+func TestPostgresDeliveryStoreSubscriptionDeliveriesIT(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	db, fake := openFakeDeliveryDB(t)
+	const (
+		subscriptionID = "7b0f2b0e-8d6c-4b8e-9f62-0d7a1c2e3f40"
+		eventID        = "0c7e9c57-5c35-4f0f-8f5e-3e8b8f1d2a11"
+	)
+	created := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC)
+	fake.subscriptions[subscriptionID] = []driver.Value{subscriptionID, "", "billing", "webhook", "{user.login}",
+		"https://sub.example/hook", []byte(`{"secret":"s3cret"}`), nil, "", "", nil, true, created}
+	fake.events[eventID] = []driver.Value{eventID, "", "user.login", "normal", created, "", "", "", "", "", "", "", []byte(`{}`), nil}
+
+	var attempts int
+	deliverer := events.NewDefaultWebhookDeliverer(0,
+		events.WithTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			attempts++
+			status := http.StatusOK
+			if attempts == 1 {
+				status = http.StatusInternalServerError
+			}
+			return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader("")), Header: make(http.Header)}, nil
+		})),
+		events.WithDeliveryStore(events.NewPostgresDeliveryStore(db)),
+	)
+
+	webhook := &events.Webhook{ID: subscriptionID, SubscriptionID: subscriptionID, URL: "https://sub.example/hook", Active: true}
+	delivery, err := deliverer.Deliver(ctx, webhook, &events.Event{ID: eventID, Type: events.EventUserLogin})
+	if err == nil {
+		t.Fatalf("expected the first attempt to fail")
+	}
+	if strings.Contains(delivery.Error, "failed to record delivery") {
+		t.Fatalf("expected the subscription delivery to be stored, got %q", delivery.Error)
+	}
+	stored := fake.deliveries[delivery.ID]
+	if stored == nil || stored[1] != nil || stored[2] != subscriptionID {
+		t.Fatalf("expected the delivery to reference the subscription rather than a webhook, got %v", stored)
+	}
+
+	history, err := deliverer.ListDeliveries(ctx, subscriptionID, nil)
+	if err != nil || len(history) != 1 || history[0].SubscriptionID != subscriptionID || history[0].WebhookID != subscriptionID {
+		t.Fatalf("expected the delivery in the subscription's history, got %+v (%v)", history, err)
+	}
+
+	retried, err := deliverer.RetryDelivery(ctx, delivery.ID)
+	if err != nil || !retried.Success {
+		t.Fatalf("expected the retry to load the subscription and succeed, got %+v (%v)", retried, err)
+	}
+	if strings.Contains(retried.Error, "failed to record delivery") || retried.SubscriptionID != subscriptionID {
+		t.Fatalf("expected the retry to be stored against the subscription, got %+v", retried)
+	}
+}
@@ -0,0 +1,163 @@
+package events_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	events "goat/internal/events"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func saveDelivery(t *testing.T, store events.DeliveryStore, id string) {
+	t.Helper()
+	record := &events.DeliveryRecord{
+		Delivery: &events.Delivery{ID: id, WebhookID: "webhook-store", CreatedAt: time.Now()},
+		Webhook:  &events.Webhook{ID: "webhook-store"},
+		Event:    &events.Event{ID: "event-" + id},
+	}
+	if err := store.Save(context.Background(), record); err != nil {
+		t.Fatalf("save %s returned error: %v", id, err)
+	}
+}
+
+func TestMemoryDeliveryStoreEvictsLeastRecentlyUsedTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := events.NewMemoryDeliveryStore(events.MemoryDeliveryStoreConfig{MaxEntries: 3})
+	for i := 1; i <= 3; i++ {
+		saveDelivery(t, store, fmt.Sprintf("d%d", i))
+	}
+
+	// Touch d1 so d2 becomes the least recently used entry.
+	if _, err := store.Get(ctx, "d1"); err != nil {
+		t.Fatalf("get d1 returned error: %v", err)
+	}
+	saveDelivery(t, store, "d4")
+
+	if store.Len() != 3 {
+		t.Fatalf("expected store to stay bounded at 3 entries, got %d", store.Len())
+	}
+	if _, err := store.Get(ctx, "d2"); !errors.Is(err, events.ErrDeliveryNotFound) {
+		t.Fatalf("expected d2 to be evicted, got %v", err)
+	}
+	for _, id := range []string{"d1", "d3", "d4"} {
+		if _, err := store.Get(ctx, id); err != nil {
+			t.Fatalf("expected %s to be retained, got %v", id, err)
+		}
+	}
+}
+
+func TestMemoryDeliveryStoreExpiresEntriesTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store := events.NewMemoryDeliveryStore(events.MemoryDeliveryStoreConfig{TTL: time.Hour, Now: clock.Now})
+
+	saveDelivery(t, store, "old")
+	clock.Advance(45 * time.Minute)
+	saveDelivery(t, store, "new")
+	clock.Advance(30 * time.Minute)
+
+	if _, err := store.Get(ctx, "old"); !errors.Is(err, events.ErrDeliveryNotFound) {
+		t.Fatalf("expected expired delivery to be gone, got %v", err)
+	}
+	deliveries, err := store.ListDeliveries(ctx, "webhook-store", nil)
+	if err != nil {
+		t.Fatalf("list returned error: %v", err)
+	}
+	if len(deliveries) != 1 || deliveries[0].ID != "new" {
+		t.Fatalf("expected only the unexpired delivery, got %+v", deliveries)
+	}
+
+	clock.Advance(time.Hour)
+	saveDelivery(t, store, "latest")
+	if store.Len() != 1 {
+		t.Fatalf("expected expired entries to be evicted on save, got %d entries", store.Len())
+	}
+}
+
+func TestMemoryDeliveryStoreTruncatesResponsesTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := events.NewMemoryDeliveryStore(events.MemoryDeliveryStoreConfig{MaxResponseBytes: 8})
+	original := &events.Delivery{ID: "large", WebhookID: "webhook-store", Response: strings.Repeat("x", 32)}
+	if err := store.Save(ctx, &events.DeliveryRecord{Delivery: original}); err != nil {
+		t.Fatalf("save returned error: %v", err)
+	}
+	if len(original.Response) != 32 {
+		t.Fatalf("expected caller's delivery to be left intact")
+	}
+
+	record, err := store.Get(ctx, "large")
+	if err != nil {
+		t.Fatalf("get returned error: %v", err)
+	}
+	if record.Delivery.Response != "xxxxxxxx" {
+		t.Fatalf("expected response truncated to 8 bytes, got %q", record.Delivery.Response)
+	}
+}
+
+func TestDelivererRetriesThroughStoreTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := events.NewMemoryDeliveryStore(events.MemoryDeliveryStoreConfig{MaxEntries: 10})
+	deliverer := newStatusDeliverer(503, nil, events.WithDeliveryStore(store))
+
+	delivery, err := deliverer.Deliver(ctx,
+		&events.Webhook{ID: "webhook-store", URL: "https://store.example/hook"},
+		&events.Event{ID: "event-store", Type: events.EventUserLogin})
+	if err == nil {
+		t.Fatalf("expected delivery error for 503")
+	}
+	if _, err := store.Get(ctx, delivery.ID); err != nil {
+		t.Fatalf("expected delivery to be recorded in the store, got %v", err)
+	}
+
+	if _, err := deliverer.RetryDelivery(ctx, delivery.ID); err == nil {
+		t.Fatalf("expected retried delivery to fail again")
+	}
+	record, err := store.Get(ctx, delivery.ID)
+	if err != nil {
+		t.Fatalf("get returned error: %v", err)
+	}
+	if record.Delivery.Attempts != 2 {
+		t.Fatalf("expected retry to bump attempts to 2, got %d", record.Delivery.Attempts)
+	}
+
+	history, err := deliverer.ListDeliveries(ctx, "webhook-store", nil)
+	if err != nil {
+		t.Fatalf("list returned error: %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("expected original and retry in history, got %d", len(history))
+	}
+
+	if _, err := deliverer.RetryDelivery(ctx, "missing"); !errors.Is(err, events.ErrDeliveryNotFound) {
+		t.Fatalf("expected ErrDeliveryNotFound for unknown delivery, got %v", err)
+	}
+}
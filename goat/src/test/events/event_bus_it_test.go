@@ -0,0 +1,446 @@
+package events_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	events "goat/internal/events"
+)
+
+// fakeBusDB answers the statements PostgresEventBus runs. Inserts and notifications become
+// visible on commit, and each notification is sent to every listener, as in Postgres.
+type fakeBusDB struct {
+	mu        sync.Mutex
+	now       time.Time
+	users     map[string]bool
+	events    map[string][]driver.Value
+	created   map[string]time.Time
+	listeners []*fakeListener
+}
+
+var (
+	fakeBusDBsMu sync.Mutex
+	fakeBusDBs   = map[string]*fakeBusDB{}
+)
+
+func init() {
+	sql.Register("fake-bus-postgres", fakeBusDriver{})
+}
+
+func openFakeBusDB(t *testing.T) (*sql.DB, *fakeBusDB) {
+	t.Helper()
+	fake := &fakeBusDB{
+		now:     time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		users:   map[string]bool{},
+		events:  map[string][]driver.Value{},
+		created: map[string]time.Time{},
+	}
+	fakeBusDBsMu.Lock()
+	fakeBusDBs[t.Name()] = fake
+	fakeBusDBsMu.Unlock()
+	db, err := sql.Open("fake-bus-postgres", t.Name())
+	if err != nil {
+		t.Fatalf("open fake database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, fake
+}
+
+// row returns a stored event projected onto the bus's event columns
+func (db *fakeBusDB) row(id string) []driver.Value {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.events[id]
+}
+
+type fakeBusDriver struct{}
+
+func (fakeBusDriver) Open(name string) (driver.Conn, error) {
+	fakeBusDBsMu.Lock()
+	defer fakeBusDBsMu.Unlock()
+	fake, ok := fakeBusDBs[name]
+	if !ok {
+		return nil, fmt.Errorf("no fake database %q", name)
+	}
+	return &fakeBusConn{db: fake}, nil
+}
+
+type fakeBusConn struct {
+	db      *fakeBusDB
+	pending map[string][]driver.Value
+	created map[string]time.Time
+	notify  []*events.PostgresNotification
+}
+
+func (c *fakeBusConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepare is not supported")
+}
+func (c *fakeBusConn) Close() error { return nil }
+func (c *fakeBusConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeBusConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.pending, c.created, c.notify = map[string][]driver.Value{}, map[string]time.Time{}, nil
+	return c, nil
+}
+
+func (c *fakeBusConn) Commit() error {
+	c.db.mu.Lock()
+	for id, row := range c.pending {
+		c.db.events[id] = row
+		c.db.created[id] = c.created[id]
+	}
+	listeners := append([]*fakeListener(nil), c.db.listeners...)
+	c.db.mu.Unlock()
+	for _, n := range c.notify {
+		for _, listener := range listeners {
+			listener.send(n)
+		}
+	}
+	return c.Rollback()
+}
+
+func (c *fakeBusConn) Rollback() error {
+	c.pending, c.created, c.notify = nil, nil, nil
+	return nil
+}
+
+func (c *fakeBusConn) ExecContext(ctx context.Context, query string, named []driver.NamedValue) (driver.Result, error) {
+	args := namedValues(named)
+	if !strings.Contains(query, "pg_notify") {
+		return nil, fmt.Errorf("unexpected exec: %s", query)
+	}
+	c.notify = append(c.notify, &events.PostgresNotification{Channel: args[0].(string), Payload: args[1].(string)})
+	return driver.RowsAffected(0), nil
+}
+
+func (c *fakeBusConn) QueryContext(ctx context.Context, query string, named []driver.NamedValue) (driver.Rows, error) {
+	args := namedValues(named)
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	switch {
+	case strings.Contains(query, "INSERT INTO events"):
+		id := args[0].(string)
+		if _, ok := c.db.events[id]; ok {
+			return &fakeRows{}, nil
+		}
+		// NULLIF($8, '')::inet
+		if ip := args[7].(string); ip != "" && net.ParseIP(ip) == nil {
+			return nil, fmt.Errorf("invalid input syntax for type inet: %q", ip)
+		}
+		// A user ID cast to uuid would fail for unregistered or non-UUID users
+		if strings.Contains(query, "NULLIF($6, '')::uuid") && args[5] != "" && !c.db.users[args[5].(string)] {
+			return nil, fmt.Errorf(`insert violates foreign key "events_user_id_fkey": user %v`, args[5])
+		}
+		c.db.now = c.db.now.Add(time.Millisecond)
+		c.pending[id] = []driver.Value{id, args[1], args[2], args[3], args[4], args[5], args[6],
+			args[7], args[8], args[9], args[10], args[11], args[12], args[13]}
+		c.created[id] = c.db.now
+		return singleRow([]driver.Value{c.db.now}), nil
+	case strings.Contains(query, "SELECT NOW()"):
+		return singleRow([]driver.Value{c.db.now}), nil
+	case strings.Contains(query, "FROM events WHERE id = $1"):
+		return singleRow(c.db.events[args[0].(string)]), nil
+	case strings.Contains(query, "FROM events") && strings.Contains(query, "(created_at, id::text) >"):
+		since, afterID, limit := args[0].(time.Time), args[1].(string), int(args[2].(int64))
+		var ids []string
+		for id, created := range c.db.created {
+			if created.After(since) || (created.Equal(since) && id > afterID) {
+				ids = append(ids, id)
+			}
+		}
+		sort.Slice(ids, func(i, j int) bool {
+			a, b := c.db.created[ids[i]], c.db.created[ids[j]]
+			return a.Before(b) || (a.Equal(b) && ids[i] < ids[j])
+		})
+		if len(ids) > limit {
+			ids = ids[:limit]
+		}
+		rows := &fakeRows{}
+		for _, id := range ids {
+			rows.values = append(rows.values, append(append([]driver.Value(nil), c.db.events[id]...), c.db.created[id]))
+		}
+		return rows, nil
+	}
+	return nil, fmt.Errorf("unexpected query: %s", query)
+}
+
+// fakeListener stands in for a LISTEN connection. While paused it drops notifications, as a
+// dropped connection would.
+type fakeListener struct {
+	mu            sync.Mutex
+	channel       string
+	paused        bool
+	closed        bool
+	notifications chan *events.PostgresNotification
+}
+
+func newFakeListener(db *fakeBusDB) *fakeListener {
+	listener := &fakeListener{notifications: make(chan *events.PostgresNotification, 64)}
+	db.mu.Lock()
+	db.listeners = append(db.listeners, listener)
+	db.mu.Unlock()
+	return listener
+}
+
+func (l *fakeListener) Listen(channel string) error {
+	l.mu.Lock()
+	l.channel = channel
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *fakeListener) Notifications() <-chan *events.PostgresNotification { return l.notifications }
+
+func (l *fakeListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.closed {
+		l.closed = true
+		close(l.notifications)
+	}
+	return nil
+}
+
+func (l *fakeListener) send(n *events.PostgresNotification) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed || l.paused || (n != nil && n.Channel != l.channel) {
+		return
+	}
+	l.notifications <- n
+}
+
+func (l *fakeListener) setPaused(paused bool) {
+	l.mu.Lock()
+	l.paused = paused
+	l.mu.Unlock()
+}
+
+// reconnect signals a re-established connection with a nil notification
+func (l *fakeListener) reconnect() {
+	l.setPaused(false)
+	l.send(nil)
+}
+
+// busRecorder collects the events a bus handler receives
+type busRecorder struct {
+	mu       sync.Mutex
+	received []*events.Event
+	signal   chan string
+}
+
+func newBusRecorder() *busRecorder {
+	return &busRecorder{signal: make(chan string, 64)}
+}
+
+func (r *busRecorder) handle(ctx context.Context, event *events.Event) error {
+	r.mu.Lock()
+	r.received = append(r.received, event)
+	r.mu.Unlock()
+	r.signal <- event.ID
+	return nil
+}
+
+func (r *busRecorder) count(eventID string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, event := range r.received {
+		if event.ID == eventID {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *busRecorder) last(eventID string) *events.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.received) - 1; i >= 0; i-- {
+		if r.received[i].ID == eventID {
+			return r.received[i]
+		}
+	}
+	return nil
+}
+
+// await waits until the handler has seen eventID
+func (r *busRecorder) await(t *testing.T, eventID string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case id := <-r.signal:
+			if id == eventID {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for event %s", eventID)
+		}
+	}
+}
+
+func startFakeBus(t *testing.T, db *sql.DB, fake *fakeBusDB, opts ...events.PostgresEventBusOption) (*events.PostgresEventBus, *fakeListener, *busRecorder) {
+	t.Helper()
+	listener := newFakeListener(fake)
+	opts = append(opts, events.WithBusErrorHandler(func(event *events.Event, err error) {
+		t.Errorf("bus reported error: %v", err)
+	}))
+	bus := events.NewPostgresEventBus(db, listener, opts...)
+	recorder := newBusRecorder()
+	if _, err := bus.Subscribe(context.Background(), nil, recorder.handle); err != nil {
+		t.Fatalf("subscribe returned error: %v", err)
+	}
+	if err := bus.Start(context.Background()); err != nil {
+		t.Fatalf("start returned error: %v", err)
+	}
+	t.Cleanup(func() { bus.Stop(context.Background()) })
+	return bus, listener, recorder
+}
+
+func TestPostgresEventBusSharesEventsIT(t *testing.T) {
+	t.Parallel()
+
+	db, fake := openFakeBusDB(t)
+	fake.users["2b0e1c4e-9f5b-4d0e-8a47-3f1f6a3b2c11"] = true
+	nodeA, _, recorderA := startFakeBus(t, db, fake)
+	nodeB, _, recorderB := startFakeBus(t, db, fake)
+	ctx := events.WithTenant(context.Background(), "acme")
+
+	small := &events.Event{ID: "event-small", Type: events.EventUserLogin, UserID: "2b0e1c4e-9f5b-4d0e-8a47-3f1f6a3b2c11"}
+	if err := nodeA.Publish(ctx, small); err != nil {
+		t.Fatalf("publish returned error: %v", err)
+	}
+	recorderB.await(t, "event-small")
+
+	// Too large for NOTIFY, so node B loads it by ID. The user is not registered and the IP does
+	// not parse; both are kept rather than failing the publish.
+	large := &events.Event{
+		ID:     "event-large",
+		Type:   events.EventUserLogin,
+		UserID: "legacy-42",
+		IP:     "unknown",
+		Data:   map[string]interface{}{"blob": strings.Repeat("x", 10000)},
+	}
+	if err := nodeA.Publish(ctx, large); err != nil {
+		t.Fatalf("publish of a large event returned error: %v", err)
+	}
+	recorderB.await(t, "event-large")
+	got := recorderB.last("event-large")
+	if got.UserID != "legacy-42" || got.IP != "" || got.Metadata["raw_ip"] != "unknown" || got.TenantID != "acme" {
+		t.Fatalf("unexpected event loaded by ID: %+v", got)
+	}
+	if blob, _ := got.Data["blob"].(string); len(blob) != 10000 {
+		t.Fatalf("expected the large event's data to be loaded from the table, got %d bytes", len(blob))
+	}
+	if row := fake.row("event-large"); row[5] != "legacy-42" {
+		t.Fatalf("expected the user ID to be stored as the actor, got %v", row[5])
+	}
+
+	// Node A handles its own events once, on Publish, and skips its own notifications. A reply
+	// from node B arrives after those notifications, so they have been processed by then.
+	if err := nodeB.Publish(ctx, &events.Event{ID: "event-reply", Type: events.EventUserLogin}); err != nil {
+		t.Fatalf("publish returned error: %v", err)
+	}
+	recorderA.await(t, "event-reply")
+	for _, id := range []string{"event-small", "event-large"} {
+		if n := recorderA.count(id); n != 1 {
+			t.Fatalf("expected node A to handle %s once, got %d", id, n)
+		}
+		if n := recorderB.count(id); n != 1 {
+			t.Fatalf("expected node B to handle %s once, got %d", id, n)
+		}
+	}
+
+	if err := nodeA.Publish(ctx, &events.Event{ID: "event-small", Type: events.EventUserLogin}); err != nil {
+		t.Fatalf("republish returned error: %v", err)
+	}
+	if n := recorderA.count("event-small"); n != 1 {
+		t.Fatalf("expected a stored ID to be ignored, got %d deliveries", n)
+	}
+}
+
+func TestPostgresEventBusCatchUpIT(t *testing.T) {
+	t.Parallel()
+
+	db, fake := openFakeBusDB(t)
+	nodeA, _, _ := startFakeBus(t, db, fake)
+	_, listenerB, recorderB := startFakeBus(t, db, fake)
+	ctx := events.WithTenant(context.Background(), "acme")
+
+	if err := nodeA.Publish(ctx, &events.Event{ID: "event-before", Type: events.EventUserLogin}); err != nil {
+		t.Fatalf("publish returned error: %v", err)
+	}
+	recorderB.await(t, "event-before")
+
+	// Node B's connection drops; events published meanwhile never reach it by NOTIFY
+	listenerB.setPaused(true)
+	for i := 0; i < 3; i++ {
+		if err := nodeA.Publish(ctx, &events.Event{ID: fmt.Sprintf("event-gap-%d", i), Type: events.EventUserLogin}); err != nil {
+			t.Fatalf("publish returned error: %v", err)
+		}
+	}
+	listenerB.reconnect()
+	for i := 0; i < 3; i++ {
+		recorderB.await(t, fmt.Sprintf("event-gap-%d", i))
+	}
+	if n := recorderB.count("event-before"); n != 1 {
+		t.Fatalf("expected the catch-up to skip events already handled, got %d deliveries", n)
+	}
+}
+
+func TestPostgresEventBusSeenPruningIT(t *testing.T) {
+	t.Parallel()
+
+	db, fake := openFakeBusDB(t)
+	_, listener, recorder := startFakeBus(t, db, fake, events.WithBusCatchUpWindow(time.Minute))
+
+	// Notifications from another node, stamped by the test
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	notify := func(id string, createdAt time.Time) {
+		payload, err := json.Marshal(map[string]interface{}{
+			"node":       "other-node",
+			"id":         id,
+			"created_at": createdAt,
+			"event":      map[string]interface{}{"id": id, "type": "user.login", "tenant_id": "acme"},
+		})
+		if err != nil {
+			t.Fatalf("marshal notification: %v", err)
+		}
+		listener.send(&events.PostgresNotification{Channel: "goat_events", Payload: string(payload)})
+	}
+
+	notify("event-old", base.Add(time.Second))
+	recorder.await(t, "event-old")
+	notify("event-old", base.Add(time.Second))
+	notify("event-new", base.Add(2*time.Minute))
+	recorder.await(t, "event-new")
+	if n := recorder.count("event-old"); n != 1 {
+		t.Fatalf("expected a duplicate notification to be ignored, got %d deliveries", n)
+	}
+
+	// event-old is now older than any catch-up reads, so it is no longer remembered; event-new is
+	notify("event-new", base.Add(2*time.Minute))
+	notify("event-old", base.Add(time.Second))
+	recorder.await(t, "event-old")
+	notify("event-marker", base.Add(2*time.Minute))
+	recorder.await(t, "event-marker")
+	if n := recorder.count("event-old"); n != 2 {
+		t.Fatalf("expected the pruned event to be forgotten, got %d deliveries", n)
+	}
+	if n := recorder.count("event-new"); n != 1 {
+		t.Fatalf("expected a recent event to stay deduplicated, got %d deliveries", n)
+	}
+}
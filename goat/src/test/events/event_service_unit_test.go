@@ -0,0 +1,245 @@
+package events_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	events "goat/internal/events"
+)
+
+func TestEventServiceQueueSubscriptionTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := events.WithOperator(context.Background())
+	clock := &fakeClock{now: time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC)}
+	service := events.NewDefaultEventService(events.WithConsumerStore(events.NewInMemoryConsumerStore(clock)))
+
+	queue := &events.Subscription{
+		Name:   "billing-workers",
+		Type:   events.SubscriptionQueue,
+		Events: []events.EventType{"user.*"},
+		Config: map[string]interface{}{"visibility_timeout": "10s"},
+		Active: true,
+	}
+	if err := service.Subscribe(ctx, queue); err != nil {
+		t.Fatalf("subscribe returned error: %v", err)
+	}
+	for _, event := range []*events.Event{
+		{ID: "e1", Type: events.EventUserCreated},
+		{ID: "e2", Type: events.EventSecurityAlert},
+		{ID: "e3", Type: events.EventUserUpdated},
+	} {
+		if err := service.Publish(ctx, event); err != nil {
+			t.Fatalf("publish returned error: %v", err)
+		}
+	}
+
+	first, err := service.Receive(ctx, queue.ID, "worker-a", 1)
+	if err != nil || len(first) != 1 || first[0].Event.ID != "e1" {
+		t.Fatalf("expected worker-a to lease e1, got %+v (%v)", first, err)
+	}
+	second, err := service.Receive(ctx, queue.ID, "worker-b", 10)
+	if err != nil || len(second) != 1 || second[0].Event.ID != "e3" {
+		t.Fatalf("expected worker-b to get only the unleased e3, got %+v (%v)", second, err)
+	}
+	if err := service.Ack(ctx, queue.ID, second[0].Receipt); err != nil {
+		t.Fatalf("ack returned error: %v", err)
+	}
+
+	clock.Advance(11 * time.Second)
+	redelivered, err := service.Receive(ctx, queue.ID, "worker-b", 10)
+	if err != nil || len(redelivered) != 1 || redelivered[0].Event.ID != "e1" || redelivered[0].Attempts != 2 {
+		t.Fatalf("expected e1 to be redelivered after the visibility timeout, got %+v (%v)", redelivered, err)
+	}
+	if err := service.Ack(ctx, queue.ID, first[0].Receipt); !errors.Is(err, events.ErrLeaseExpired) {
+		t.Fatalf("expected stale receipt to be rejected, got %v", err)
+	}
+	if err := service.Nack(ctx, queue.ID, redelivered[0].Receipt, 5*time.Second); err != nil {
+		t.Fatalf("nack returned error: %v", err)
+	}
+	if msgs, _ := service.Receive(ctx, queue.ID, "worker-a", 10); len(msgs) != 0 {
+		t.Fatalf("expected nacked message to stay hidden for its delay, got %d", len(msgs))
+	}
+	clock.Advance(5 * time.Second)
+	if msgs, _ := service.Receive(ctx, queue.ID, "worker-a", 10); len(msgs) != 1 {
+		t.Fatalf("expected nacked message to become visible, got %d", len(msgs))
+	}
+
+	if _, err := service.ReadStream(ctx, queue.ID, "worker-a", 10); err == nil {
+		t.Fatalf("expected stream read of a queue subscription to fail")
+	}
+	if err := service.Unsubscribe(ctx, queue.ID); err != nil {
+		t.Fatalf("unsubscribe returned error: %v", err)
+	}
+	if _, err := service.Receive(ctx, queue.ID, "worker-a", 10); !errors.Is(err, events.ErrSubscriptionNotFound) {
+		t.Fatalf("expected removed subscription to be gone, got %v", err)
+	}
+}
+
+func TestEventServiceStreamSubscriptionTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := events.WithOperator(context.Background())
+	service := events.NewDefaultEventService()
+	stream := &events.Subscription{
+		Name:    "audit-stream",
+		Type:    events.SubscriptionStream,
+		Filters: []events.Filter{{Field: "priority", Operator: "in", Value: []string{"high", "critical"}}},
+		Active:  true,
+	}
+	if err := service.Subscribe(ctx, stream); err != nil {
+		t.Fatalf("subscribe returned error: %v", err)
+	}
+	for _, event := range []*events.Event{
+		{ID: "s1", Type: events.EventSecurityAlert, Priority: events.PriorityHigh},
+		{ID: "s2", Type: events.EventUserLogin},
+		{ID: "s3", Type: events.EventBruteForceDetected, Priority: events.PriorityCritical},
+	} {
+		if err := service.Publish(ctx, event); err != nil {
+			t.Fatalf("publish returned error: %v", err)
+		}
+	}
+
+	records, err := service.ReadStream(ctx, stream.ID, "siem", 10)
+	if err != nil || len(records) != 2 || records[0].Event.ID != "s1" || records[1].Event.ID != "s3" {
+		t.Fatalf("expected filtered stream records, got %+v (%v)", records, err)
+	}
+	if err := service.Commit(ctx, stream.ID, "siem", records[0].Offset); err != nil {
+		t.Fatalf("commit returned error: %v", err)
+	}
+	if records, _ := service.ReadStream(ctx, stream.ID, "siem", 10); len(records) != 1 || records[0].Event.ID != "s3" {
+		t.Fatalf("expected read to resume after the committed offset, got %+v", records)
+	}
+	if records, _ := service.ReadStream(ctx, stream.ID, "archiver", 10); len(records) != 2 {
+		t.Fatalf("expected independent cursor per consumer, got %d records", len(records))
+	}
+	if err := service.Commit(ctx, stream.ID, "siem", 0); err != nil {
+		t.Fatalf("commit returned error: %v", err)
+	}
+	if records, _ := service.ReadStream(ctx, stream.ID, "siem", 10); len(records) != 2 {
+		t.Fatalf("expected rewinding the cursor to replay the stream, got %d records", len(records))
+	}
+
+	history, err := service.GetEvents(ctx, &events.EventFilter{Types: []events.EventType{"security.*"}})
+	if err != nil || len(history) != 2 || history[0].ID != "s1" || history[1].ID != "s3" {
+		t.Fatalf("expected event history, got %+v (%v)", history, err)
+	}
+	if _, err := service.GetEvent(ctx, "missing"); !errors.Is(err, events.ErrEventNotFound) {
+		t.Fatalf("expected ErrEventNotFound, got %v", err)
+	}
+
+	if err := service.Subscribe(ctx, &events.Subscription{Name: "bad", Type: "email", Active: true}); err == nil {
+		t.Fatalf("expected unknown subscription type to be rejected")
+	}
+	if err := service.Subscribe(ctx, &events.Subscription{Name: "bad", Type: events.SubscriptionWebhook, Destination: "not a url"}); err == nil {
+		t.Fatalf("expected webhook subscription without a url to be rejected")
+	}
+}
+
+func TestReplayToQueueSubscriptionTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := events.WithOperator(context.Background())
+	service := events.NewDefaultEventService()
+	for _, id := range []string{"r1", "r2"} {
+		if err := service.Publish(ctx, &events.Event{ID: id, Type: events.EventUserCreated}); err != nil {
+			t.Fatalf("publish returned error: %v", err)
+		}
+	}
+	queue := &events.Subscription{Name: "late-joiner", Type: events.SubscriptionQueue, Active: true}
+	if err := service.Subscribe(ctx, queue); err != nil {
+		t.Fatalf("subscribe returned error: %v", err)
+	}
+
+	replays := events.NewReplayService(service, nil, service, nil, events.NewInMemoryReplayJobStore())
+	job, err := replays.CreateReplay(ctx, &events.ReplayRequest{
+		Target:        events.ReplayTarget{SubscriptionID: queue.ID},
+		RatePerSecond: 1000,
+	})
+	if err != nil {
+		t.Fatalf("create replay returned error: %v", err)
+	}
+	if err := replays.Run(ctx, job.ID); err != nil {
+		t.Fatalf("replay returned error: %v", err)
+	}
+	messages, err := service.Receive(ctx, queue.ID, "worker", 10)
+	if err != nil || len(messages) != 2 {
+		t.Fatalf("expected replayed events in the queue, got %d (%v)", len(messages), err)
+	}
+}
+
+func TestEventServiceEventBusRelayTest(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(events.WithOperator(context.Background()))
+	defer cancel()
+	bus := &fakeEventBus{}
+	service := events.NewDefaultEventService(events.WithEventBus(bus))
+	if _, err := bus.Subscribe(ctx, nil, service.Relay); err != nil {
+		t.Fatalf("subscribe returned error: %v", err)
+	}
+	stream, err := service.Stream(ctx, nil)
+	if err != nil {
+		t.Fatalf("stream returned error: %v", err)
+	}
+
+	if err := service.Publish(ctx, &events.Event{ID: "local", Type: events.EventUserLogin}); err != nil {
+		t.Fatalf("publish returned error: %v", err)
+	}
+	remote := &events.Event{ID: "remote", Type: events.EventUserLogout, Timestamp: time.Now().UTC()}
+	for i := 0; i < 2; i++ {
+		if err := service.Relay(ctx, remote); err != nil {
+			t.Fatalf("relay returned error: %v", err)
+		}
+	}
+
+	var got []string
+	for len(got) < 2 {
+		select {
+		case event := <-stream:
+			got = append(got, event.ID)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for streamed events, got %v", got)
+		}
+	}
+	if got[0] != "local" || got[1] != "remote" {
+		t.Fatalf("expected local then remote event, got %v", got)
+	}
+	select {
+	case event := <-stream:
+		t.Fatalf("expected each event to be streamed once, got %s again", event.ID)
+	default:
+	}
+	if _, err := service.GetEvent(ctx, "remote"); err != nil {
+		t.Fatalf("expected relayed event in history, got %v", err)
+	}
+}
+
+func TestEventServiceEventBusFailureTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := events.WithOperator(context.Background())
+	bus := &fakeEventBus{}
+	service := events.NewDefaultEventService(events.WithEventBus(bus))
+	if _, err := bus.Subscribe(ctx, nil, func(ctx context.Context, event *events.Event) error {
+		return errors.New("database unavailable")
+	}); err != nil {
+		t.Fatalf("subscribe returned error: %v", err)
+	}
+	queue := &events.Subscription{Name: "audit", Type: events.SubscriptionQueue, Active: true}
+	if err := service.Subscribe(ctx, queue); err != nil {
+		t.Fatalf("subscribe returned error: %v", err)
+	}
+
+	if err := service.Publish(ctx, &events.Event{ID: "unpersisted", Type: events.EventUserLogin}); err == nil {
+		t.Fatalf("expected the event bus failure to be returned")
+	}
+	if _, err := service.GetEvent(ctx, "unpersisted"); err == nil {
+		t.Fatalf("expected the event not to be recorded when the bus fails")
+	}
+	if messages, err := service.Receive(ctx, queue.ID, "worker", 1); err != nil || len(messages) != 0 {
+		t.Fatalf("expected nothing to be delivered when the bus fails, got %d (%v)", len(messages), err)
+	}
+}
@@ -31,9 +31,8 @@ func TestDefaultWebhookDelivererAsyncDeliveryE2E(t *testing.T) {
 		hits      int64
 	)
 
-	deliverer := events.NewDefaultWebhookDeliverer(1)
-	setDelivererClient(deliverer, &http.Client{
-		Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+	deliverer := events.NewDefaultWebhookDeliverer(1,
+		events.WithTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
 			atomic.AddInt64(&hits, 1)
 			mu.Lock()
 			signature = req.Header.Get("X-Webhook-Signature")
@@ -45,9 +44,9 @@ func TestDefaultWebhookDelivererAsyncDeliveryE2E(t *testing.T) {
 				Body:       io.NopCloser(strings.NewReader("{\"status\":\"ok\"}")),
 				Header:     make(http.Header),
 			}, nil
-		}),
-	})
-	setDelivererRetryDelay(deliverer, time.Millisecond)
+		})),
+		events.WithRetryPolicy(events.RetryPolicy{Delay: time.Millisecond}),
+	)
 
 	deliverer.Start(ctx)
 	defer deliverer.Stop()
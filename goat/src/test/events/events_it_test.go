@@ -19,9 +19,8 @@ func TestDefaultWebhookDelivererRetryDeliveryIT(t *testing.T) {
 	ctx := context.Background()
 
 	var hits int64
-	deliverer := events.NewDefaultWebhookDeliverer(0)
-	setDelivererClient(deliverer, &http.Client{
-		Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+	deliverer := events.NewDefaultWebhookDeliverer(0,
+		events.WithTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
 			attempt := atomic.AddInt64(&hits, 1)
 			body := "ok"
 			status := http.StatusOK
@@ -35,9 +34,9 @@ func TestDefaultWebhookDelivererRetryDeliveryIT(t *testing.T) {
 				Body:       io.NopCloser(strings.NewReader(body)),
 				Header:     make(http.Header),
 			}, nil
-		}),
-	})
-	setDelivererRetryDelay(deliverer, time.Millisecond)
+		})),
+		events.WithRetryPolicy(events.RetryPolicy{Delay: time.Millisecond}),
+	)
 
 	event := &events.Event{
 		ID:        "event-retry",
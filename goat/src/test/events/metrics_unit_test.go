@@ -0,0 +1,97 @@
+package events_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	events "goat/internal/events"
+	"goat/internal/metrics"
+)
+
+type fakeEventBus struct {
+	handlers []events.EventHandler
+}
+
+func (b *fakeEventBus) Publish(ctx context.Context, event *events.Event) error {
+	var errs []error
+	for _, handler := range b.handlers {
+		errs = append(errs, handler(ctx, event))
+	}
+	return errors.Join(errs...)
+}
+
+func (b *fakeEventBus) Subscribe(ctx context.Context, types []events.EventType, handler events.EventHandler) (string, error) {
+	b.handlers = append(b.handlers, handler)
+	return "sub", nil
+}
+
+func (b *fakeEventBus) Unsubscribe(ctx context.Context, subscriptionID string) error { return nil }
+func (b *fakeEventBus) Start(ctx context.Context) error                              { return nil }
+func (b *fakeEventBus) Stop(ctx context.Context) error                               { return nil }
+
+func TestEventMetricsTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	registry := metrics.NewRegistry()
+	m := events.NewMetrics(registry)
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	statuses := map[string]int{"/ok": http.StatusOK, "/missing": http.StatusNotFound, "/down": http.StatusBadGateway}
+	deliverer := events.NewDefaultWebhookDeliverer(1,
+		events.WithClock(clock),
+		events.WithMetrics(m),
+		events.WithTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			clock.Advance(300 * time.Millisecond)
+			status, ok := statuses[req.URL.Path]
+			if !ok {
+				return nil, errors.New("connection refused")
+			}
+			return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader("")), Header: make(http.Header)}, nil
+		})),
+	)
+	event := &events.Event{ID: "event-metrics", Type: events.EventUserLogin}
+	for _, path := range []string{"/ok", "/ok", "/missing", "/down", "/refused"} {
+		_, _ = deliverer.Deliver(ctx, &events.Webhook{ID: "wh-" + strings.TrimPrefix(path, "/"), URL: "https://partner.example" + path}, event)
+	}
+
+	// Entries already in the queue count, not only those added after instrumenting
+	dlq := events.InstrumentDeadLetterQueue(&fakeDeadLetterQueue{deliveries: []*events.Delivery{{ID: "d0"}}}, m)
+	_ = dlq.Add(ctx, &events.Delivery{ID: "d1"})
+	_ = dlq.Add(ctx, &events.Delivery{ID: "d2"})
+	_ = dlq.Delete(ctx, "d1")
+
+	bus := events.InstrumentEventBus(&fakeEventBus{}, m)
+	_, _ = bus.Subscribe(ctx, nil, func(ctx context.Context, event *events.Event) error { return nil })
+	_, _ = bus.Subscribe(ctx, nil, func(ctx context.Context, event *events.Event) error { return errors.New("boom") })
+	_ = bus.Publish(ctx, event)
+
+	var out strings.Builder
+	if err := registry.WriteText(&out); err != nil {
+		t.Fatalf("write returned error: %v", err)
+	}
+	for _, line := range []string{
+		`goat_webhook_delivery_attempts_total{webhook_id="wh-ok",status_class="2xx"} 2`,
+		`goat_webhook_delivery_attempts_total{webhook_id="wh-missing",status_class="4xx"} 1`,
+		`goat_webhook_delivery_attempts_total{webhook_id="wh-down",status_class="5xx"} 1`,
+		`goat_webhook_delivery_attempts_total{webhook_id="wh-refused",status_class="error"} 1`,
+		`goat_webhook_delivery_duration_seconds_bucket{webhook_id="wh-ok",le="0.25"} 0`,
+		`goat_webhook_delivery_duration_seconds_bucket{webhook_id="wh-ok",le="0.5"} 2`,
+		`goat_webhook_delivery_duration_seconds_count{webhook_id="wh-refused"} 1`,
+		"goat_webhook_deliveries_in_flight 0",
+		"goat_webhook_queue_length 0",
+		"goat_dead_letter_queue_size 2",
+		`goat_event_bus_publish_duration_seconds_count{event_type="user.login",result="error"} 1`,
+		`goat_event_bus_handler_duration_seconds_count{event_type="user.login",result="error"} 1`,
+		`goat_event_bus_handler_duration_seconds_count{event_type="user.login",result="success"} 1`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Fatalf("expected %q in exposition:\n%s", line, out.String())
+		}
+	}
+}
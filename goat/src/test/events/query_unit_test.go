@@ -0,0 +1,182 @@
+package events_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	events "goat/internal/events"
+)
+
+func TestQueryParseAndMatchTest(t *testing.T) {
+	t.Parallel()
+
+	query, err := events.ParseQuery(`type:security.* AND priority>=high AND data.country!=US`)
+	if err != nil {
+		t.Fatalf("parse returned error: %v", err)
+	}
+	if got := query.String(); got != `type:security.* AND priority>=high AND data.country!=US` {
+		t.Fatalf("unexpected canonical form %q", got)
+	}
+
+	event := &events.Event{
+		Type:     events.EventType("security.alert"),
+		Priority: events.PriorityCritical,
+		Data:     map[string]interface{}{"country": "FR"},
+	}
+	if !query.Match(event) {
+		t.Fatalf("expected critical security event outside US to match")
+	}
+	event.Data["country"] = "US"
+	if query.Match(event) {
+		t.Fatalf("expected US event not to match")
+	}
+	delete(event.Data, "country")
+	if !query.Match(event) {
+		t.Fatalf("expected missing data field to satisfy !=")
+	}
+	event.Priority = events.PriorityNormal
+	if query.Match(event) {
+		t.Fatalf("expected normal priority not to match priority>=high")
+	}
+}
+
+func TestQueryPrecedenceAndValuesTest(t *testing.T) {
+	t.Parallel()
+
+	query, err := events.ParseQuery(`NOT result:failure type:user.login OR (data.attempts>3 data.mfa=false)`)
+	if err != nil {
+		t.Fatalf("parse returned error: %v", err)
+	}
+	if got := query.String(); got != `(NOT result:failure AND type:user.login) OR (data.attempts>3 AND data.mfa=false)` {
+		t.Fatalf("unexpected canonical form %q", got)
+	}
+
+	login := &events.Event{Type: events.EventUserLogin, Result: "success"}
+	if !query.Match(login) {
+		t.Fatalf("expected successful login to match")
+	}
+	brute := &events.Event{Type: events.EventUserLogin, Result: "failure", Data: map[string]interface{}{"attempts": 5, "mfa": false}}
+	if !query.Match(brute) {
+		t.Fatalf("expected numeric and boolean data comparison to match")
+	}
+	brute.Data["attempts"] = "5"
+	if query.Match(brute) {
+		t.Fatalf("expected string data not to satisfy a numeric comparison")
+	}
+
+	quoted, err := events.ParseQuery(`data.code="42" AND timestamp>=2024-06-01T00:00:00Z`)
+	if err != nil {
+		t.Fatalf("parse returned error: %v", err)
+	}
+	event := &events.Event{Timestamp: time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC), Data: map[string]interface{}{"code": "42"}}
+	if !quoted.Match(event) {
+		t.Fatalf("expected quoted value to compare as a string")
+	}
+	event.Timestamp = time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC)
+	if quoted.Match(event) {
+		t.Fatalf("expected earlier event not to match the timestamp bound")
+	}
+}
+
+func TestQuerySQLTest(t *testing.T) {
+	t.Parallel()
+
+	query, err := events.ParseQuery(`type:security.* AND priority>=high AND data.country!=US`)
+	if err != nil {
+		t.Fatalf("parse returned error: %v", err)
+	}
+	clause, args := query.SQL(3)
+	for _, want := range []string{"event_type LIKE $3", "priority IN ($4, $5)", "data #> $6::text[] IS DISTINCT FROM $7::jsonb"} {
+		if !strings.Contains(clause, want) {
+			t.Fatalf("expected %q in %s", want, clause)
+		}
+	}
+	if len(args) != 5 || args[0] != "security.%" || args[1] != "high" || args[2] != "critical" || args[3] != `{"country"}` || args[4] != `"US"` {
+		t.Fatalf("unexpected args %#v", args)
+	}
+	if strings.Contains(clause, "US") || strings.Contains(clause, "security") {
+		t.Fatalf("expected values to be parameterized, got %s", clause)
+	}
+
+	equality, err := events.ParseQuery(`data.geo.country=FR`)
+	if err != nil {
+		t.Fatalf("parse returned error: %v", err)
+	}
+	clause, args = equality.SQL(1)
+	if !strings.Contains(clause, "data @> $2::jsonb") || args[1] != `{"geo":{"country":"FR"}}` {
+		t.Fatalf("expected data equality to use containment, got %s %#v", clause, args)
+	}
+}
+
+func TestQueryErrorsTest(t *testing.T) {
+	t.Parallel()
+
+	for _, text := range []string{
+		`type:`,
+		`unknown:value`,
+		`priority>=urgent`,
+		`timestamp>yesterday`,
+		`(type:user.login`,
+		`type:user.login AND`,
+		`type:user.login OR OR priority:high`,
+		`data.name="unterminated`,
+		strings.Repeat("(", 40) + "type:x" + strings.Repeat(")", 40),
+	} {
+		if _, err := events.ParseQuery(text); !errors.Is(err, events.ErrInvalidQuery) {
+			t.Fatalf("expected %q to be rejected, got %v", text, err)
+		}
+	}
+}
+
+func TestQueryEventServiceTest(t *testing.T) {
+	t.Parallel()
+
+	service := events.NewDefaultEventService()
+	ctx, cancel := context.WithCancel(events.WithOperator(context.Background()))
+	defer cancel()
+
+	if err := service.Subscribe(ctx, &events.Subscription{Name: "bad", Type: events.SubscriptionQueue, Events: []events.EventType{"*"}, Query: "priority>"}); !errors.Is(err, events.ErrInvalidQuery) {
+		t.Fatalf("expected invalid subscription query to be rejected, got %v", err)
+	}
+	queue := &events.Subscription{Name: "high", Type: events.SubscriptionQueue, Events: []events.EventType{"*"}, Query: "priority>=high", Active: true}
+	if err := service.Subscribe(ctx, queue); err != nil {
+		t.Fatalf("subscribe returned error: %v", err)
+	}
+	stream, err := service.Stream(ctx, &events.EventFilter{Query: "data.country!=US"})
+	if err != nil {
+		t.Fatalf("stream returned error: %v", err)
+	}
+
+	published := []*events.Event{
+		{ID: "e1", Type: events.EventUserLogin, Priority: events.PriorityLow, Data: map[string]interface{}{"country": "US"}},
+		{ID: "e2", Type: events.EventUserLogin, Priority: events.PriorityHigh, Data: map[string]interface{}{"country": "FR"}},
+	}
+	for _, event := range published {
+		if err := service.Publish(ctx, event); err != nil {
+			t.Fatalf("publish returned error: %v", err)
+		}
+	}
+
+	select {
+	case event := <-stream:
+		if event.ID != "e2" {
+			t.Fatalf("expected stream query to skip e1, got %s", event.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for streamed event")
+	}
+	messages, err := service.Receive(ctx, queue.ID, "worker", 10)
+	if err != nil || len(messages) != 1 || messages[0].Event.ID != "e2" {
+		t.Fatalf("expected subscription query to deliver only e2, got %+v (%v)", messages, err)
+	}
+	got, err := service.GetEvents(ctx, &events.EventFilter{Query: "priority<high"})
+	if err != nil || len(got) != 1 || got[0].ID != "e1" {
+		t.Fatalf("expected query filter to return e1, got %+v (%v)", got, err)
+	}
+	if _, err := service.GetEvents(ctx, &events.EventFilter{Query: "("}); !errors.Is(err, events.ErrInvalidQuery) {
+		t.Fatalf("expected invalid query to be rejected, got %v", err)
+	}
+}
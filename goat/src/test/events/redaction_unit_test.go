@@ -0,0 +1,215 @@
+package events_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	events "goat/internal/events"
+)
+
+func tenantSalt(event *events.Event) ([]byte, error) {
+	tenant, _ := event.Metadata["tenant"].(string)
+	if tenant == "" {
+		return nil, errors.New("event has no tenant")
+	}
+	return []byte("salt-" + tenant), nil
+}
+
+func TestRedactorTest(t *testing.T) {
+	t.Parallel()
+
+	redactor, err := events.NewRedactor(&events.RedactionPolicy{Rules: []events.RedactionRule{
+		{Field: "user_agent", Action: events.RedactDrop},
+		{Detect: events.DetectToken, Action: events.RedactDrop},
+	}}, tenantSalt)
+	if err != nil {
+		t.Fatalf("new redactor returned error: %v", err)
+	}
+	policy := &events.RedactionPolicy{Rules: []events.RedactionRule{
+		{Field: "ip", Action: events.RedactTruncateIP},
+		{Field: "user_id", Action: events.RedactHash},
+		{Field: "data.user.email", Action: events.RedactMask},
+		{Field: "data.devices.*.serial", Action: events.RedactDrop},
+		{Field: "data.notes", Detect: events.DetectPhone, Action: events.RedactMask},
+	}}
+
+	event := &events.Event{
+		ID:        "event-pii",
+		Type:      events.EventUserLogin,
+		IP:        "203.0.113.77",
+		UserAgent: "Mozilla/5.0",
+		UserID:    "user-42",
+		Data: map[string]interface{}{
+			"user":    map[string]interface{}{"email": "ada@example.com"},
+			"devices": []interface{}{map[string]interface{}{"serial": "SN-1", "os": "ios"}},
+			"notes":   "call +1 415-555-0100 after 2024-01-01",
+			"auth":    "header was Bearer abcdefghijklmnopqrstuvwxyz",
+		},
+		Metadata: map[string]interface{}{"tenant": "acme"},
+	}
+	out, err := redactor.Redact(event, policy)
+	if err != nil {
+		t.Fatalf("redact returned error: %v", err)
+	}
+
+	if out.IP != "203.0.113.0" || out.UserAgent != "" {
+		t.Fatalf("unexpected fixed fields: ip=%q ua=%q", out.IP, out.UserAgent)
+	}
+	if !strings.HasPrefix(out.UserID, "sha256:") || out.UserID == event.UserID {
+		t.Fatalf("expected hashed user id, got %q", out.UserID)
+	}
+	if email := out.Data["user"].(map[string]interface{})["email"]; email != "***@example.com" {
+		t.Fatalf("expected masked email, got %v", email)
+	}
+	if device := out.Data["devices"].([]interface{})[0].(map[string]interface{}); device["serial"] != nil || device["os"] != "ios" {
+		t.Fatalf("expected serial to be dropped from every device, got %v", device)
+	}
+	if notes := out.Data["notes"].(string); strings.Contains(notes, "555-0100") || !strings.Contains(notes, "2024-01-01") {
+		t.Fatalf("expected only the phone number to be masked, got %q", notes)
+	}
+	if auth := out.Data["auth"].(string); auth != "header was [redacted:token]" {
+		t.Fatalf("expected detected token to be dropped, got %q", auth)
+	}
+	if event.IP != "203.0.113.77" || event.Data["user"].(map[string]interface{})["email"] != "ada@example.com" {
+		t.Fatalf("expected the original event to be untouched")
+	}
+
+	other := *event
+	other.Metadata = map[string]interface{}{"tenant": "globex"}
+	otherOut, err := redactor.Redact(&other, policy)
+	if err != nil {
+		t.Fatalf("redact returned error: %v", err)
+	}
+	if otherOut.UserID == out.UserID {
+		t.Fatalf("expected per-tenant salts to give different hashes")
+	}
+	if _, err := (*events.Redactor)(nil).Redact(event, policy); err == nil {
+		t.Fatalf("expected hashing without a salt to fail")
+	}
+
+	for _, bad := range []events.RedactionRule{
+		{Field: "timestamp", Action: events.RedactDrop},
+		{Field: "data.x", Action: "scramble"},
+		{Detect: "ssn", Action: events.RedactMask},
+		{Action: events.RedactMask},
+	} {
+		if err := events.ValidateRedactionPolicy(&events.RedactionPolicy{Rules: []events.RedactionRule{bad}}); err == nil {
+			t.Fatalf("expected rule %+v to be rejected", bad)
+		}
+	}
+}
+
+func TestRedactorTypedValuesTest(t *testing.T) {
+	t.Parallel()
+
+	type contact struct {
+		Email string `json:"email"`
+	}
+	event := &events.Event{
+		ID:   "event-typed",
+		Type: events.EventUserLogin,
+		Data: map[string]interface{}{
+			"user":     map[string]string{"email": "alice@example.com"},
+			"cc":       []string{"bob@example.com", "carol@example.com"},
+			"contacts": []map[string]interface{}{{"email": "dave@example.com"}},
+			"owner":    &contact{Email: "erin@example.com"},
+			"count":    int64(9007199254740993),
+		},
+	}
+	policy := &events.RedactionPolicy{Rules: []events.RedactionRule{
+		{Field: "data.user.email", Action: events.RedactDrop},
+		{Field: "data.contacts.*.email", Action: events.RedactMask},
+		{Detect: events.DetectEmail, Action: events.RedactDrop},
+	}}
+	out, err := (*events.Redactor)(nil).Redact(event, policy)
+	if err != nil {
+		t.Fatalf("redact returned error: %v", err)
+	}
+	encoded, _ := json.Marshal(out.Data)
+	for _, leaked := range []string{"alice@", "bob@", "carol@", "dave@", "erin@"} {
+		if strings.Contains(string(encoded), leaked) {
+			t.Fatalf("expected %s to be redacted from typed values, got %s", leaked, encoded)
+		}
+	}
+	if !strings.Contains(string(encoded), `"count":9007199254740993`) {
+		t.Fatalf("expected large integers to keep their precision, got %s", encoded)
+	}
+	if event.Data["user"].(map[string]string)["email"] != "alice@example.com" {
+		t.Fatalf("expected the original event to be untouched")
+	}
+
+	unencodable := &events.Event{ID: "event-chan", Data: map[string]interface{}{"ch": make(chan int)}}
+	if _, err := (*events.Redactor)(nil).Redact(unencodable, policy); err == nil {
+		t.Fatalf("expected a value that cannot be walked to fail redaction")
+	}
+}
+
+func TestRedactionAtDestinationsTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := events.WithOperator(context.Background())
+	redactor, err := events.NewRedactor(&events.RedactionPolicy{Rules: []events.RedactionRule{
+		{Field: "ip", Action: events.RedactTruncateIP},
+		{Detect: events.DetectEmail, Action: events.RedactHash},
+	}}, tenantSalt)
+	if err != nil {
+		t.Fatalf("new redactor returned error: %v", err)
+	}
+
+	var body []byte
+	deliverer := events.NewDefaultWebhookDeliverer(0,
+		events.WithTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			body, _ = io.ReadAll(req.Body)
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("")), Header: make(http.Header)}, nil
+		})),
+		events.WithRedactor(redactor),
+	)
+	service := events.NewDefaultEventService(events.WithSubscriptionRedactor(redactor))
+	queue := &events.Subscription{
+		Name:      "analytics",
+		Type:      events.SubscriptionQueue,
+		Redaction: &events.RedactionPolicy{Rules: []events.RedactionRule{{Field: "user_id", Action: events.RedactDrop}}},
+		Active:    true,
+	}
+	if err := service.Subscribe(ctx, queue); err != nil {
+		t.Fatalf("subscribe returned error: %v", err)
+	}
+
+	event := &events.Event{
+		ID:       "event-dest",
+		Type:     events.EventUserCreated,
+		IP:       "2001:db8:1234:5678::1",
+		UserID:   "user-7",
+		Data:     map[string]interface{}{"contact": "grace@example.com"},
+		Metadata: map[string]interface{}{"tenant": "acme"},
+	}
+	if _, err := deliverer.Deliver(ctx, &events.Webhook{ID: "w", URL: "https://partner.example/hook"}, event); err != nil {
+		t.Fatalf("deliver returned error: %v", err)
+	}
+	var sent events.Event
+	if err := json.Unmarshal(body, &sent); err != nil {
+		t.Fatalf("invalid payload: %v", err)
+	}
+	if sent.IP != "2001:db8:1234::" || strings.Contains(string(body), "grace@example.com") || sent.UserID != "user-7" {
+		t.Fatalf("expected baseline redaction on the webhook payload, got %s", body)
+	}
+
+	if err := service.Publish(ctx, event); err != nil {
+		t.Fatalf("publish returned error: %v", err)
+	}
+	messages, err := service.Receive(ctx, queue.ID, "worker", 1)
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("expected one message, got %d (%v)", len(messages), err)
+	}
+	if got := messages[0].Event; got.UserID != "" || got.IP != "2001:db8:1234::" || got.Data["contact"] == "grace@example.com" {
+		t.Fatalf("expected baseline and subscription redaction on the queued event, got %+v", got)
+	}
+	if stored, _ := service.GetEvent(ctx, "event-dest"); stored.UserID != "user-7" {
+		t.Fatalf("expected stored history to keep the original event")
+	}
+}
@@ -0,0 +1,155 @@
+package events_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	events "goat/internal/events"
+)
+
+func TestEventTypeRegistryTest(t *testing.T) {
+	t.Parallel()
+
+	registry := events.NewEventTypeRegistry()
+	login, ok := registry.Get(events.EventUserLogin)
+	if !ok || login.Version != 1 || len(login.AuditTypes) != 1 || login.AuditTypes[0] != "auth.login" {
+		t.Fatalf("expected built-in user.login mapped to auth.login, got %+v", login)
+	}
+	if eventType, ok := events.EventTypeForAudit("ratelimit.exceed"); !ok || eventType != events.EventRateLimitExceeded {
+		t.Fatalf("expected ratelimit.exceed to map to %s, got %s", events.EventRateLimitExceeded, eventType)
+	}
+
+	invoice := events.EventTypeDefinition{
+		Type:         "billing.invoice.paid",
+		Description:  "An invoice was paid",
+		Version:      1,
+		Sample:       map[string]interface{}{"invoice_id": "inv_1", "amount": 1200},
+		RegisteredBy: "billing",
+	}
+	if err := registry.Register(invoice); err != nil {
+		t.Fatalf("register returned error: %v", err)
+	}
+	invoice.Version = 2
+	invoice.Sample["currency"] = "EUR"
+	if err := registry.Register(invoice); err != nil {
+		t.Fatalf("register v2 returned error: %v", err)
+	}
+	invoice.Version = 1
+	if err := registry.Register(invoice); err == nil {
+		t.Fatalf("expected an older version to be rejected")
+	}
+	if got, _ := registry.Get("billing.invoice.paid"); got.Version != 2 || got.Sample["currency"] != "EUR" {
+		t.Fatalf("expected version 2 with its sample, got %+v", got)
+	}
+	if err := registry.Register(events.EventTypeDefinition{Type: events.EventUserLogin, Description: "Signed in", Version: 2}); err != nil {
+		t.Fatalf("register returned error: %v", err)
+	}
+	if got, _ := registry.Get(events.EventUserLogin); len(got.AuditTypes) != 1 {
+		t.Fatalf("expected a new version to keep the audit mapping, got %+v", got)
+	}
+
+	for _, bad := range []events.EventTypeDefinition{
+		{Type: "Billing.Paid", Description: "x", Version: 1},
+		{Type: "billing", Description: "x", Version: 1},
+		{Type: "billing.*", Description: "x", Version: 1},
+		{Type: "billing.paid", Version: 1},
+		{Type: "billing.paid", Description: "x"},
+	} {
+		if err := registry.Register(bad); err == nil {
+			t.Fatalf("expected %+v to be rejected", bad)
+		}
+	}
+	if err := registry.Deprecate(events.EventUserLogin, time.Now(), "billing.unknown"); !errors.Is(err, events.ErrUnknownEventType) {
+		t.Fatalf("expected an unknown replacement to be rejected, got %v", err)
+	}
+
+	// Types registered in the default registry are accepted by webhook validation.
+	if err := events.DefaultEventTypeRegistry().Register(events.EventTypeDefinition{
+		Type: "inventory.item.added", Description: "An item was added to inventory", Version: 1,
+	}); err != nil {
+		t.Fatalf("register returned error: %v", err)
+	}
+	for _, pattern := range []events.EventType{"inventory.item.added", "inventory.*"} {
+		err := events.ValidateWebhook(&events.Webhook{Name: "inventory", URL: "https://example.com/hook", Events: []events.EventType{pattern}})
+		if err != nil {
+			t.Fatalf("expected %s to be a valid webhook event type, got %v", pattern, err)
+		}
+	}
+}
+
+func TestPublishEventTypeValidationTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := events.WithOperator(context.Background())
+	registry := events.NewEventTypeRegistry()
+	if err := registry.Register(events.EventTypeDefinition{Type: "legacy.signin", Description: "Old sign-in event", Version: 1}); err != nil {
+		t.Fatalf("register returned error: %v", err)
+	}
+	if err := registry.Deprecate("legacy.signin", time.Now().Add(-time.Hour), events.EventUserLogin); err != nil {
+		t.Fatalf("deprecate returned error: %v", err)
+	}
+	if err := registry.Register(events.EventTypeDefinition{Type: "legacy.signout", Description: "Old sign-out event", Version: 1}); err != nil {
+		t.Fatalf("register returned error: %v", err)
+	}
+	if err := registry.Deprecate("legacy.signout", time.Now().Add(24*time.Hour), events.EventUserLogout); err != nil {
+		t.Fatalf("deprecate returned error: %v", err)
+	}
+
+	lenient := events.NewDefaultEventService()
+	if err := lenient.Publish(ctx, &events.Event{Type: "made.up"}); err != nil {
+		t.Fatalf("expected unknown types to be accepted by default, got %v", err)
+	}
+
+	strict := events.NewDefaultEventService(events.WithEventTypeRegistry(registry))
+	if err := strict.Publish(ctx, &events.Event{Type: "made.up"}); !errors.Is(err, events.ErrUnknownEventType) {
+		t.Fatalf("expected ErrUnknownEventType, got %v", err)
+	}
+	if err := strict.Publish(ctx, &events.Event{Type: "legacy.signin"}); !errors.Is(err, events.ErrEventTypeRetired) {
+		t.Fatalf("expected ErrEventTypeRetired, got %v", err)
+	}
+	if err := strict.Publish(ctx, &events.Event{Type: "legacy.signout"}); err != nil {
+		t.Fatalf("expected a type deprecated in the future to be accepted, got %v", err)
+	}
+	if err := strict.Publish(ctx, &events.Event{Type: events.EventUserLogin}); err != nil {
+		t.Fatalf("publish returned error: %v", err)
+	}
+	if page, _ := strict.GetEvents(ctx, nil); len(page) != 2 {
+		t.Fatalf("expected rejected events not to be recorded, got %d", len(page))
+	}
+
+	server := http.StripPrefix("/api/events/types", registry)
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/events/types?deprecated=false", nil))
+	var list struct {
+		EventTypes   []events.EventTypeDefinition `json:"event_types"`
+		AuditMapping map[string]events.EventType  `json:"audit_mapping"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("unexpected list response %d: %s", rec.Code, rec.Body.String())
+	}
+	for _, def := range list.EventTypes {
+		if def.Type == "legacy.signin" {
+			t.Fatalf("expected retired types to be left out")
+		}
+	}
+	if len(list.EventTypes) != len(registry.List())-1 || list.AuditMapping["auth.login"] != events.EventUserLogin {
+		t.Fatalf("unexpected list: %d types", len(list.EventTypes))
+	}
+
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/events/types/legacy.signin", nil))
+	var def events.EventTypeDefinition
+	if err := json.Unmarshal(rec.Body.Bytes(), &def); err != nil || def.ReplacedBy != events.EventUserLogin || def.DeprecatedAt == nil {
+		t.Fatalf("unexpected type response: %s", rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/events/types/made.up", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown type, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,254 @@
+package events_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	events "goat/internal/events"
+)
+
+// fakeEventSource serves a fixed, time-ordered event history
+type fakeEventSource struct {
+	events.EventService
+	history []*events.Event
+}
+
+func (s *fakeEventSource) GetEvents(ctx context.Context, filter *events.EventFilter) ([]*events.Event, error) {
+	var matched []*events.Event
+	for _, event := range s.history {
+		if filter.EndTime != nil && !event.Timestamp.Before(*filter.EndTime) {
+			continue
+		}
+		if len(filter.Types) > 0 && !events.MatchesEventTypes(filter.Types, event.Type) {
+			continue
+		}
+		matched = append(matched, event)
+	}
+	if filter.Offset >= len(matched) {
+		return nil, nil
+	}
+	matched = matched[filter.Offset:]
+	if filter.Limit > 0 && filter.Limit < len(matched) {
+		matched = matched[:filter.Limit]
+	}
+	return matched, nil
+}
+
+func newReplayFixture(t *testing.T, send func(req *http.Request) int) (*events.ReplayService, *events.Webhook) {
+	t.Helper()
+
+	base := time.Now().Add(-24 * time.Hour)
+	source := &fakeEventSource{}
+	for i := 0; i < 6; i++ {
+		eventType := events.EventUserLogin
+		if i%3 == 2 {
+			eventType = events.EventMFAVerified
+		}
+		source.history = append(source.history, &events.Event{
+			ID:        fmt.Sprintf("event-%d", i),
+			Type:      eventType,
+			Timestamp: base.Add(time.Duration(i) * time.Minute),
+		})
+	}
+
+	deliverer := events.NewDefaultWebhookDeliverer(0,
+		events.WithTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			status := send(req)
+			return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader("")), Header: make(http.Header)}, nil
+		})),
+	)
+	webhooks := events.NewInMemoryWebhookService(deliverer, deliverer)
+	webhook := &events.Webhook{
+		Name:   "partner",
+		URL:    "https://partner.example/hook",
+		Events: []events.EventType{"user.*"},
+		Active: true,
+	}
+	if err := webhooks.CreateWebhook(events.WithOperator(context.Background()), webhook); err != nil {
+		t.Fatalf("create webhook returned error: %v", err)
+	}
+
+	replay := events.NewReplayService(source, webhooks, nil, deliverer, events.NewInMemoryReplayJobStore())
+	return replay, webhook
+}
+
+func TestReplayToWebhookTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := events.WithOperator(context.Background())
+	var (
+		mu      sync.Mutex
+		sent    []string
+		replays []string
+	)
+	replay, webhook := newReplayFixture(t, func(req *http.Request) int {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, req.Header.Get("X-Event-ID"))
+		replays = append(replays, req.Header.Get(events.ReplayHeader))
+		return http.StatusOK
+	})
+
+	job, err := replay.CreateReplay(ctx, &events.ReplayRequest{
+		Target:        events.ReplayTarget{WebhookID: webhook.ID},
+		RatePerSecond: 1000,
+	})
+	if err != nil {
+		t.Fatalf("create replay returned error: %v", err)
+	}
+	if err := replay.Run(ctx, job.ID); err != nil {
+		t.Fatalf("run returned error: %v", err)
+	}
+
+	job, err = replay.GetReplayJob(ctx, job.ID)
+	if err != nil {
+		t.Fatalf("get replay job returned error: %v", err)
+	}
+	if job.Status != events.ReplayCompleted || job.Succeeded != 4 || job.Skipped != 2 || job.Cursor != 6 {
+		t.Fatalf("unexpected job progress: %+v", job)
+	}
+	if strings.Join(sent, ",") != "event-0,event-1,event-3,event-4" {
+		t.Fatalf("expected only subscribed events in order, got %v", sent)
+	}
+	for _, header := range replays {
+		if header != job.ID {
+			t.Fatalf("expected replay header %q, got %q", job.ID, header)
+		}
+	}
+	if err := replay.Run(ctx, job.ID); !errors.Is(err, events.ErrReplayJobFinished) {
+		t.Fatalf("expected completed job to refuse another run, got %v", err)
+	}
+
+	if _, err := replay.CreateReplay(ctx, &events.ReplayRequest{}); err == nil {
+		t.Fatalf("expected replay without a target to be rejected")
+	}
+}
+
+func TestReplayPauseResumeAndCancelTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := events.WithOperator(context.Background())
+	var (
+		mu      sync.Mutex
+		sent    []string
+		reached = make(chan struct{})
+	)
+	runCtx, stop := context.WithCancel(ctx)
+	replay, webhook := newReplayFixture(t, func(req *http.Request) int {
+		mu.Lock()
+		sent = append(sent, req.Header.Get("X-Event-ID"))
+		count := len(sent)
+		mu.Unlock()
+		if count == 2 {
+			// Interrupt the run, as a shutdown would, once the second event is out.
+			stop()
+			close(reached)
+		}
+		return http.StatusOK
+	})
+
+	job, err := replay.CreateReplay(ctx, &events.ReplayRequest{
+		Target:        events.ReplayTarget{WebhookID: webhook.ID},
+		RatePerSecond: 1000,
+	})
+	if err != nil {
+		t.Fatalf("create replay returned error: %v", err)
+	}
+	if err := replay.Run(runCtx, job.ID); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected interrupted run to report cancellation, got %v", err)
+	}
+	<-reached
+
+	paused, err := replay.GetReplayJob(ctx, job.ID)
+	if err != nil {
+		t.Fatalf("get replay job returned error: %v", err)
+	}
+	if paused.Status != events.ReplayPaused || paused.Cursor == 0 || paused.Cursor >= 6 {
+		t.Fatalf("expected paused job with partial progress, got %+v", paused)
+	}
+
+	if err := replay.Run(ctx, job.ID); err != nil {
+		t.Fatalf("resumed run returned error: %v", err)
+	}
+	done, err := replay.GetReplayJob(ctx, job.ID)
+	if err != nil {
+		t.Fatalf("get replay job returned error: %v", err)
+	}
+	if done.Status != events.ReplayCompleted || done.Cursor != 6 || done.Succeeded+done.Skipped != 6 {
+		t.Fatalf("expected resumed job to finish the remaining events, got %+v", done)
+	}
+
+	mu.Lock()
+	seen := make(map[string]int)
+	for _, id := range sent {
+		seen[id]++
+	}
+	mu.Unlock()
+	for _, id := range []string{"event-0", "event-1", "event-3", "event-4"} {
+		if seen[id] == 0 {
+			t.Fatalf("expected %s to be delivered after resume, got %v", id, sent)
+		}
+	}
+
+	pending, err := replay.CreateReplay(ctx, &events.ReplayRequest{Target: events.ReplayTarget{WebhookID: webhook.ID}})
+	if err != nil {
+		t.Fatalf("create replay returned error: %v", err)
+	}
+	if err := replay.CancelReplay(ctx, pending.ID); err != nil {
+		t.Fatalf("cancel returned error: %v", err)
+	}
+	if _, err := replay.ResumeReplay(ctx, pending.ID); !errors.Is(err, events.ErrReplayJobFinished) {
+		t.Fatalf("expected cancelled job to refuse resume, got %v", err)
+	}
+}
+
+func TestReplayUsesClockTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := events.WithOperator(context.Background())
+	replay, webhook := newReplayFixture(t, func(req *http.Request) int { return http.StatusOK })
+	// The fixture's history starts a day ago, one event a minute; pin the clock between events 3 and 4
+	clock := &fakeClock{now: time.Now().Add(-24*time.Hour + 210*time.Second).UTC()}
+	replay.SetClock(clock)
+
+	job, err := replay.CreateReplay(ctx, &events.ReplayRequest{
+		Target:        events.ReplayTarget{WebhookID: webhook.ID},
+		RatePerSecond: 1000,
+	})
+	if err != nil {
+		t.Fatalf("create replay returned error: %v", err)
+	}
+	if !job.CreatedAt.Equal(clock.now) || job.Filter.EndTime == nil || !job.Filter.EndTime.Equal(clock.now) {
+		t.Fatalf("expected the job to be stamped and pinned with the clock, got %+v", job)
+	}
+	if err := replay.Run(ctx, job.ID); err != nil {
+		t.Fatalf("run returned error: %v", err)
+	}
+	job, err = replay.GetReplayJob(ctx, job.ID)
+	if err != nil {
+		t.Fatalf("get replay job returned error: %v", err)
+	}
+	if job.Cursor != 4 || job.StartedAt == nil || !job.StartedAt.Equal(clock.now) ||
+		job.CompletedAt == nil || !job.CompletedAt.Equal(clock.now) || !job.UpdatedAt.Equal(clock.now) {
+		t.Fatalf("expected clock timestamps and events up to the pinned end, got %+v", job)
+	}
+
+	idle, err := replay.CreateReplay(ctx, &events.ReplayRequest{Target: events.ReplayTarget{WebhookID: webhook.ID}})
+	if err != nil {
+		t.Fatalf("create replay returned error: %v", err)
+	}
+	clock.Advance(time.Minute)
+	if err := replay.CancelReplay(ctx, idle.ID); err != nil {
+		t.Fatalf("cancel returned error: %v", err)
+	}
+	if idle, _ = replay.GetReplayJob(ctx, idle.ID); idle.CompletedAt == nil || !idle.CompletedAt.Equal(clock.now) {
+		t.Fatalf("expected cancellation to be stamped with the clock, got %+v", idle)
+	}
+}
@@ -0,0 +1,234 @@
+package events_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	events "goat/internal/events"
+)
+
+func TestEventRouterResilientHandlersTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	errBoom := errors.New("boom")
+	router := events.NewEventRouter(
+		events.WithConcurrency(4),
+		events.WithHandlerTimeout(50*time.Millisecond),
+	)
+
+	var ran int64
+	router.Register(events.EventUserLogin, func(ctx context.Context, event *events.Event) error {
+		atomic.AddInt64(&ran, 1)
+		return nil
+	})
+	router.Register(events.EventUserLogin, func(ctx context.Context, event *events.Event) error {
+		panic("nil map")
+	})
+	router.Register(events.EventUserLogin, func(ctx context.Context, event *events.Event) error {
+		// Ignores its context entirely.
+		time.Sleep(time.Second)
+		return nil
+	})
+	router.Register(events.EventUserLogin, func(ctx context.Context, event *events.Event) error {
+		return errBoom
+	})
+
+	start := time.Now()
+	err := router.Route(ctx, &events.Event{ID: "event-resilient", Type: events.EventUserLogin})
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("expected slow handler not to block Route, took %s", elapsed)
+	}
+	if atomic.LoadInt64(&ran) != 1 {
+		t.Fatalf("expected healthy handler to run")
+	}
+
+	var routeErr *events.RouteError
+	if !errors.As(err, &routeErr) {
+		t.Fatalf("expected *RouteError, got %T: %v", err, err)
+	}
+	if len(routeErr.Errors) != 3 {
+		t.Fatalf("expected 3 handler errors, got %d: %v", len(routeErr.Errors), err)
+	}
+	byHandler := make(map[string]error)
+	for _, handlerErr := range routeErr.Errors {
+		if handlerErr.EventID != "event-resilient" {
+			t.Fatalf("expected event id on handler error, got %q", handlerErr.EventID)
+		}
+		byHandler[handlerErr.Handler] = handlerErr.Err
+	}
+	if !errors.Is(byHandler["user.login[1]"], events.ErrHandlerPanic) {
+		t.Fatalf("expected panic to be recovered as ErrHandlerPanic, got %v", byHandler["user.login[1]"])
+	}
+	if !errors.Is(byHandler["user.login[2]"], events.ErrHandlerTimeout) {
+		t.Fatalf("expected slow handler to time out, got %v", byHandler["user.login[2]"])
+	}
+	if !errors.Is(err, errBoom) {
+		t.Fatalf("expected aggregated error to unwrap to the handler error")
+	}
+}
+
+func TestEventRouterMiddlewareTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var (
+		mu       sync.Mutex
+		trace    []string
+		observed []string
+		logged   []string
+		calls    int
+	)
+	router := events.NewEventRouter(
+		events.WithMiddleware(
+			func(info events.HandlerInfo, next events.EventHandler) events.EventHandler {
+				return func(ctx context.Context, event *events.Event) error {
+					mu.Lock()
+					trace = append(trace, "outer:"+info.Name)
+					mu.Unlock()
+					return next(ctx, event)
+				}
+			},
+			events.MetricsMiddleware(func(info events.HandlerInfo, duration time.Duration, err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				observed = append(observed, info.Name)
+			}),
+			events.LoggingMiddleware(func(format string, args ...interface{}) {
+				mu.Lock()
+				defer mu.Unlock()
+				logged = append(logged, format)
+			}),
+			events.RetryMiddleware(3, time.Millisecond),
+		),
+	)
+	router.Register(events.EventUserCreated, func(ctx context.Context, event *events.Event) error {
+		calls++
+		if calls < 3 {
+			return errors.New("transient")
+		}
+		return nil
+	})
+
+	if err := router.Route(ctx, &events.Event{ID: "event-mw", Type: events.EventUserCreated}); err != nil {
+		t.Fatalf("expected retry middleware to recover, got %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 attempts, got %d", calls)
+	}
+	if strings.Join(trace, ",") != "outer:user.created[0]" || len(observed) != 1 || len(logged) != 0 {
+		t.Fatalf("unexpected middleware calls: trace=%v observed=%v logged=%v", trace, observed, logged)
+	}
+
+	calls = -10
+	if err := router.Route(ctx, &events.Event{ID: "event-mw-fail", Type: events.EventUserCreated}); err == nil {
+		t.Fatalf("expected exhausted retries to fail")
+	}
+	if len(logged) != 1 {
+		t.Fatalf("expected the failure to be logged once, got %d", len(logged))
+	}
+}
+
+func TestEventRouterGuardsMiddlewareAndIsolatesEventsTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	router := events.NewEventRouter(
+		events.WithConcurrency(4),
+		events.WithHandlerTimeout(50*time.Millisecond),
+		events.WithMiddleware(func(info events.HandlerInfo, next events.EventHandler) events.EventHandler {
+			return func(ctx context.Context, event *events.Event) error {
+				if event.Data["panic"] == info.Name {
+					panic("middleware")
+				}
+				return next(ctx, event)
+			}
+		}),
+	)
+	for i := 0; i < 4; i++ {
+		router.Register(events.EventUserLogin, func(ctx context.Context, event *events.Event) error {
+			// Concurrent handlers write to the event; the race detector flags a shared copy
+			event.Data["seen"] = true
+			event.Metadata["seen"] = true
+			return nil
+		})
+	}
+
+	event := &events.Event{
+		ID:       "event-isolated",
+		Type:     events.EventUserLogin,
+		Data:     map[string]interface{}{"panic": "user.login[3]"},
+		Metadata: map[string]interface{}{},
+	}
+	err := router.Route(ctx, event)
+	var routeErr *events.RouteError
+	if !errors.As(err, &routeErr) || len(routeErr.Errors) != 1 || !errors.Is(err, events.ErrHandlerPanic) {
+		t.Fatalf("expected the middleware panic to be recovered as one handler error, got %v", err)
+	}
+	if _, ok := event.Data["seen"]; ok {
+		t.Fatalf("expected concurrent handlers to receive copies of the event, got %+v", event.Data)
+	}
+}
+
+func TestEventRouterRuleDestinationsGetOwnEventsTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var (
+		mu        sync.Mutex
+		resources []string
+	)
+	router := events.NewEventRouter(
+		events.WithConcurrency(4),
+		events.WithMiddleware(func(info events.HandlerInfo, next events.EventHandler) events.EventHandler {
+			return func(ctx context.Context, event *events.Event) error {
+				mu.Lock()
+				resources = append(resources, event.Resource)
+				mu.Unlock()
+				return next(ctx, event)
+			}
+		}),
+	)
+	for _, name := range []string{"siem", "archive", "metrics"} {
+		router.RegisterNamed(name, func(ctx context.Context, event *events.Event) error {
+			// Concurrent destinations write to the event; the race detector flags a shared copy
+			event.Data["seen"] = true
+			event.Metadata["seen"] = true
+			return nil
+		})
+	}
+	err := router.SetRules([]*events.RoutingRule{{
+		Name:            "fan out",
+		Transformations: []events.TransformRule{{Operation: events.TransformSet, Field: "resource", Value: "routed"}},
+		Destinations: []events.RouteDestination{
+			{Type: events.DestinationBus, Target: "siem"},
+			{Type: events.DestinationBus, Target: "archive"},
+			{Type: events.DestinationBus, Target: "metrics"},
+		},
+		Active: true,
+	}})
+	if err != nil {
+		t.Fatalf("set rules returned error: %v", err)
+	}
+
+	event := &events.Event{ID: "event-fanout", Type: events.EventUserLogin, Data: map[string]interface{}{}, Metadata: map[string]interface{}{}}
+	if err := router.Route(ctx, event); err != nil {
+		t.Fatalf("route returned error: %v", err)
+	}
+	if len(resources) != 3 {
+		t.Fatalf("expected three destinations, got %v", resources)
+	}
+	for _, resource := range resources {
+		if resource != "routed" {
+			t.Fatalf("expected middleware to see the transformed event, got %v", resources)
+		}
+	}
+	if _, ok := event.Data["seen"]; ok || event.Resource != "" {
+		t.Fatalf("expected destinations to receive copies of the event, got %+v", event)
+	}
+}
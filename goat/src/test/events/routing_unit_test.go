@@ -0,0 +1,271 @@
+package events_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	events "goat/internal/events"
+)
+
+type fakeDeadLetterQueue struct {
+	events.DeadLetterQueue
+	mu         sync.Mutex
+	deliveries []*events.Delivery
+}
+
+func (q *fakeDeadLetterQueue) Add(ctx context.Context, delivery *events.Delivery) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.deliveries = append(q.deliveries, delivery)
+	return nil
+}
+
+func (q *fakeDeadLetterQueue) Delete(ctx context.Context, deliveryID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, delivery := range q.deliveries {
+		if delivery.ID == deliveryID {
+			q.deliveries = append(q.deliveries[:i], q.deliveries[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("delivery not found")
+}
+
+func (q *fakeDeadLetterQueue) Size(ctx context.Context) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.deliveries), nil
+}
+
+type fakeRuleStore struct {
+	mu    sync.Mutex
+	rules []*events.RoutingRule
+}
+
+func (s *fakeRuleStore) ListRoutingRules(ctx context.Context) ([]*events.RoutingRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rules, nil
+}
+
+func (s *fakeRuleStore) set(rules ...*events.RoutingRule) {
+	s.mu.Lock()
+	s.rules = rules
+	s.mu.Unlock()
+}
+
+func TestMatchFiltersTest(t *testing.T) {
+	t.Parallel()
+
+	event := &events.Event{
+		Type:     events.EventUserLoginFailed,
+		Priority: events.PriorityHigh,
+		IP:       "203.0.113.7",
+		Data: map[string]interface{}{
+			"attempts": float64(5),
+			"mfa":      map[string]interface{}{"method": "totp"},
+			"roles":    []interface{}{"admin", "auditor"},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		filter events.Filter
+		want   bool
+	}{
+		{name: "equals", filter: events.Filter{Field: "priority", Operator: "eq", Value: "high"}, want: true},
+		{name: "default operator", filter: events.Filter{Field: "type", Value: "user.login.failed"}, want: true},
+		{name: "not equals", filter: events.Filter{Field: "priority", Operator: "ne", Value: "high"}, want: false},
+		{name: "nested path", filter: events.Filter{Field: "data.mfa.method", Operator: "eq", Value: "totp"}, want: true},
+		{name: "numeric gte", filter: events.Filter{Field: "data.attempts", Operator: "gte", Value: 5}, want: true},
+		{name: "numeric lt", filter: events.Filter{Field: "data.attempts", Operator: "lt", Value: 3}, want: false},
+		{name: "in", filter: events.Filter{Field: "priority", Operator: "in", Value: []string{"high", "critical"}}, want: true},
+		{name: "not in", filter: events.Filter{Field: "priority", Operator: "not_in", Value: []interface{}{"high"}}, want: false},
+		{name: "list contains", filter: events.Filter{Field: "data.roles", Operator: "contains", Value: "admin"}, want: true},
+		{name: "starts with", filter: events.Filter{Field: "ip", Operator: "starts_with", Value: "203.0.113."}, want: true},
+		{name: "exists", filter: events.Filter{Field: "data.mfa", Operator: "exists"}, want: true},
+		{name: "missing field", filter: events.Filter{Field: "data.device", Operator: "eq", Value: "x"}, want: false},
+	}
+	for _, tc := range tests {
+		got, err := events.MatchFilters(event, []events.Filter{tc.filter})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		if got != tc.want {
+			t.Fatalf("%s: expected %v, got %v", tc.name, tc.want, got)
+		}
+	}
+
+	if _, err := events.MatchFilters(event, []events.Filter{{Field: "type", Operator: "like", Value: "x"}}); err == nil {
+		t.Fatalf("expected unknown operator to be rejected")
+	}
+}
+
+func TestApplyTransformationsTest(t *testing.T) {
+	t.Parallel()
+
+	original := &events.Event{
+		Type: events.EventUserCreated,
+		Data: map[string]interface{}{
+			"user": map[string]interface{}{"email": "ada@example.com", "name": "Ada"},
+		},
+	}
+	out, err := events.ApplyTransformations(original, []events.TransformRule{
+		{Operation: events.TransformRename, Field: "data.user.name", Value: "data.display_name"},
+		{Operation: events.TransformRemove, Field: "data.user.email"},
+		{Operation: events.TransformSet, Field: "metadata.routed", Value: true},
+		{Operation: events.TransformSet, Field: "resource", Value: "users"},
+	})
+	if err != nil {
+		t.Fatalf("transform returned error: %v", err)
+	}
+	if out.Data["display_name"] != "Ada" || out.Metadata["routed"] != true || out.Resource != "users" {
+		t.Fatalf("unexpected transformed event: %+v", out)
+	}
+	if _, ok := out.Data["user"].(map[string]interface{})["email"]; ok {
+		t.Fatalf("expected email to be removed")
+	}
+	if original.Data["user"].(map[string]interface{})["email"] != "ada@example.com" {
+		t.Fatalf("expected original event to be left intact")
+	}
+
+	if _, err := events.ApplyTransformations(original, []events.TransformRule{{Operation: "explode", Field: "type"}}); err == nil {
+		t.Fatalf("expected unknown operation to be rejected")
+	}
+}
+
+func TestEventRouterRulesTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	router := events.NewEventRouter()
+	dlq := &fakeDeadLetterQueue{}
+	router.RegisterDestination(events.DestinationDLQ, events.NewDeadLetterDispatcher(dlq))
+
+	var (
+		mu    sync.Mutex
+		calls []string
+	)
+	record := func(name string) events.EventHandler {
+		return func(ctx context.Context, event *events.Event) error {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, name+":"+event.Resource)
+			return nil
+		}
+	}
+	router.RegisterNamed("siem", record("siem"))
+	router.RegisterNamed("audit", record("audit"))
+	router.Register(events.EventSecurityAlert, record("legacy"))
+
+	err := router.SetRules([]*events.RoutingRule{
+		{
+			Name:         "everything security to audit",
+			Priority:     1,
+			EventTypes:   []events.EventType{"security.*"},
+			Destinations: []events.RouteDestination{{Type: events.DestinationBus, Target: "audit"}},
+			Active:       true,
+		},
+		{
+			Name:            "critical to siem",
+			Priority:        10,
+			EventTypes:      []events.EventType{"security.*"},
+			Conditions:      []events.Filter{{Field: "priority", Operator: "eq", Value: "critical"}},
+			Transformations: []events.TransformRule{{Operation: events.TransformSet, Field: "resource", Value: "siem"}},
+			Destinations: []events.RouteDestination{
+				{Type: events.DestinationBus, Target: "siem"},
+				{Type: events.DestinationDLQ},
+			},
+			Active: true,
+		},
+		{
+			Name:         "disabled",
+			Priority:     100,
+			Destinations: []events.RouteDestination{{Type: events.DestinationBus, Target: "siem"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("set rules returned error: %v", err)
+	}
+
+	if err := router.Route(ctx, &events.Event{ID: "e1", Type: events.EventSecurityAlert, Priority: events.PriorityCritical}); err != nil {
+		t.Fatalf("route returned error: %v", err)
+	}
+	if err := router.Route(ctx, &events.Event{ID: "e2", Type: events.EventBruteForceDetected, Priority: events.PriorityLow}); err != nil {
+		t.Fatalf("route returned error: %v", err)
+	}
+
+	mu.Lock()
+	got := strings.Join(calls, ",")
+	mu.Unlock()
+	if got != "legacy:,siem:siem,audit:,audit:" {
+		t.Fatalf("unexpected dispatch order: %s", got)
+	}
+	if len(dlq.deliveries) != 1 || dlq.deliveries[0].EventID != "e1" {
+		t.Fatalf("expected critical event in the DLQ, got %+v", dlq.deliveries)
+	}
+
+	err = router.Route(ctx, &events.Event{ID: "e3", Type: events.EventSecurityAlert, Priority: events.PriorityCritical})
+	if err != nil {
+		t.Fatalf("route returned error: %v", err)
+	}
+	if err := router.SetRules([]*events.RoutingRule{{
+		Name:         "bad",
+		Destinations: []events.RouteDestination{{Type: events.DestinationStream, Target: "audit-stream"}},
+		Active:       true,
+	}}); err != nil {
+		t.Fatalf("set rules returned error: %v", err)
+	}
+	if err := router.Route(ctx, &events.Event{ID: "e4", Type: events.EventUserLogin}); err == nil || !strings.Contains(err.Error(), "no dispatcher") {
+		t.Fatalf("expected unregistered destination to be reported, got %v", err)
+	}
+
+	if err := router.SetRules([]*events.RoutingRule{{Name: "no destinations", Active: true}}); err == nil {
+		t.Fatalf("expected rule without destinations to be rejected")
+	}
+	if rules := router.Rules(); len(rules) != 1 || rules[0].Name != "bad" {
+		t.Fatalf("expected invalid rules to leave the current rules in place, got %d rules", len(rules))
+	}
+}
+
+func TestEventRouterWatchRulesTest(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	router := events.NewEventRouter()
+	store := &fakeRuleStore{}
+	store.set(&events.RoutingRule{
+		Name:         "v1",
+		Destinations: []events.RouteDestination{{Type: events.DestinationDLQ}},
+		Active:       true,
+	})
+	if err := router.WatchRules(ctx, store, 5*time.Millisecond, nil); err != nil {
+		t.Fatalf("watch returned error: %v", err)
+	}
+	if rules := router.Rules(); len(rules) != 1 || rules[0].Name != "v1" {
+		t.Fatalf("expected initial rules to be loaded, got %+v", rules)
+	}
+
+	store.set(&events.RoutingRule{
+		Name:         "v2",
+		Destinations: []events.RouteDestination{{Type: events.DestinationDLQ}},
+		Active:       true,
+	})
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if rules := router.Rules(); len(rules) == 1 && rules[0].Name == "v2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected rules to hot-reload")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,166 @@
+package events_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	events "goat/internal/events"
+)
+
+type staticResolver map[string][]string
+
+func (r staticResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	var addrs []net.IPAddr
+	for _, ip := range r[host] {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	if len(addrs) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addrs, nil
+}
+
+func TestSSRFPolicyIsAllowedTest(t *testing.T) {
+	t.Parallel()
+
+	policy := events.DefaultSSRFPolicy()
+	if err := policy.AllowCIDRs("10.20.0.0/16"); err != nil {
+		t.Fatalf("allow cidrs returned error: %v", err)
+	}
+
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{ip: "93.184.216.34", allowed: true},
+		{ip: "2606:4700::6810:85e5", allowed: true},
+		{ip: "127.0.0.1", allowed: false},
+		{ip: "::1", allowed: false},
+		{ip: "::ffff:127.0.0.1", allowed: false},
+		{ip: "169.254.169.254", allowed: false},
+		{ip: "fe80::1", allowed: false},
+		{ip: "10.0.0.5", allowed: false},
+		{ip: "172.16.4.4", allowed: false},
+		{ip: "192.168.1.1", allowed: false},
+		{ip: "100.100.100.200", allowed: false},
+		{ip: "fd00:ec2::254", allowed: false},
+		{ip: "0.0.0.0", allowed: false},
+		{ip: "10.20.3.4", allowed: true},
+	}
+	for _, tc := range tests {
+		if got := policy.IsAllowed(net.ParseIP(tc.ip)); got != tc.allowed {
+			t.Fatalf("IsAllowed(%s) = %v, want %v", tc.ip, got, tc.allowed)
+		}
+	}
+}
+
+func TestGuardedHTTPClientIT(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/loop" {
+			http.Redirect(w, r, "/loop", http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+
+	t.Run("blocks loopback by default", func(t *testing.T) {
+		client := events.NewGuardedHTTPClient(events.DefaultSSRFPolicy(), 5*time.Second)
+		_, err := client.Get(server.URL)
+		if !errors.Is(err, events.ErrBlockedDestination) {
+			t.Fatalf("expected blocked destination error, got %v", err)
+		}
+	})
+
+	t.Run("blocks host resolving to metadata address", func(t *testing.T) {
+		policy := events.DefaultSSRFPolicy()
+		policy.Resolver = staticResolver{"metadata.attacker.example": {"169.254.169.254"}}
+		client := events.NewGuardedHTTPClient(policy, 5*time.Second)
+		_, err := client.Get("http://metadata.attacker.example/latest/meta-data/")
+		if !errors.Is(err, events.ErrBlockedDestination) {
+			t.Fatalf("expected blocked destination error, got %v", err)
+		}
+	})
+
+	t.Run("allowlist permits internal consumer", func(t *testing.T) {
+		policy := events.DefaultSSRFPolicy()
+		policy.Resolver = staticResolver{"internal.example": {"127.0.0.1"}}
+		if err := policy.AllowCIDRs("127.0.0.0/8"); err != nil {
+			t.Fatalf("allow cidrs returned error: %v", err)
+		}
+		client := events.NewGuardedHTTPClient(policy, 5*time.Second)
+		resp, err := client.Get("http://internal.example:" + port + "/")
+		if err != nil {
+			t.Fatalf("expected allowlisted request to succeed, got %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("limits redirects", func(t *testing.T) {
+		policy := events.DefaultSSRFPolicy()
+		policy.MaxRedirects = 2
+		if err := policy.AllowCIDRs("127.0.0.0/8"); err != nil {
+			t.Fatalf("allow cidrs returned error: %v", err)
+		}
+		client := events.NewGuardedHTTPClient(policy, 5*time.Second)
+		_, err := client.Get(server.URL + "/loop")
+		if err == nil || !strings.Contains(err.Error(), "stopped after 2 redirects") {
+			t.Fatalf("expected redirect limit error, got %v", err)
+		}
+	})
+
+	t.Run("deliverer refuses internal webhook", func(t *testing.T) {
+		deliverer := events.NewDefaultWebhookDeliverer(0)
+		delivery, err := deliverer.Deliver(context.Background(),
+			&events.Webhook{ID: "webhook-ssrf", URL: server.URL},
+			&events.Event{ID: "event-ssrf", Type: events.EventUserLogin})
+		if err == nil || delivery.Success {
+			t.Fatalf("expected delivery to an internal address to fail")
+		}
+		if !strings.Contains(delivery.Error, events.ErrBlockedDestination.Error()) {
+			t.Fatalf("expected blocked destination in delivery error, got %q", delivery.Error)
+		}
+	})
+}
+
+func TestDeprecatedDelivererSettersIT(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	deliverer := events.NewDefaultWebhookDeliverer(1, events.WithRetryPolicy(events.RetryPolicy{MaxAttempts: 1}))
+	webhook := &events.Webhook{ID: "wh-internal", URL: server.URL}
+	event := &events.Event{ID: "event-internal", Type: events.EventUserLogin}
+	if _, err := deliverer.Deliver(ctx, webhook, event); err == nil {
+		t.Fatalf("expected the default policy to block loopback")
+	}
+
+	policy := events.DefaultSSRFPolicy()
+	if err := policy.AllowCIDRs("127.0.0.1/32"); err != nil {
+		t.Fatalf("allow cidrs returned error: %v", err)
+	}
+	store := events.NewMemoryDeliveryStore(events.MemoryDeliveryStoreConfig{})
+	deliverer.SetSSRFPolicy(policy)
+	deliverer.SetDeliveryStore(store)
+	if _, err := deliverer.Deliver(ctx, webhook, event); err != nil {
+		t.Fatalf("expected the allowlisted policy to permit loopback, got %v", err)
+	}
+	deliveries, err := store.ListDeliveries(ctx, webhook.ID, nil)
+	if err != nil || len(deliveries) != 1 || deliveries[0].Status() != events.DeliveryStatusSucceeded {
+		t.Fatalf("expected the delivery to be recorded in the replaced store, got %v %+v", err, deliveries)
+	}
+}
@@ -0,0 +1,231 @@
+package events_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	events "goat/internal/events"
+)
+
+func TestTenantEventIsolationTest(t *testing.T) {
+	t.Parallel()
+
+	service := events.NewDefaultEventService()
+	acme := events.WithTenant(context.Background(), "acme")
+	globex := events.WithTenant(context.Background(), "globex")
+
+	acmeQueue := &events.Subscription{Name: "acme-logins", Type: events.SubscriptionQueue, Events: []events.EventType{events.EventUserLogin}, Active: true}
+	globexQueue := &events.Subscription{Name: "globex-logins", Type: events.SubscriptionQueue, Events: []events.EventType{events.EventUserLogin}, Active: true}
+	if err := service.Subscribe(acme, acmeQueue); err != nil {
+		t.Fatalf("subscribe returned error: %v", err)
+	}
+	if err := service.Subscribe(globex, globexQueue); err != nil {
+		t.Fatalf("subscribe returned error: %v", err)
+	}
+	if acmeQueue.TenantID != "acme" {
+		t.Fatalf("expected subscription tenant from context, got %q", acmeQueue.TenantID)
+	}
+
+	if err := service.Publish(acme, &events.Event{ID: "a1", Type: events.EventUserLogin}); err != nil {
+		t.Fatalf("publish returned error: %v", err)
+	}
+	if err := service.Publish(globex, &events.Event{ID: "g1", Type: events.EventUserLogin}); err != nil {
+		t.Fatalf("publish returned error: %v", err)
+	}
+	err := service.Publish(acme, &events.Event{ID: "x1", Type: events.EventUserLogin, TenantID: "globex"})
+	if !errors.Is(err, events.ErrTenantMismatch) {
+		t.Fatalf("expected publishing into another tenant to fail, got %v", err)
+	}
+
+	messages, err := service.Receive(acme, acmeQueue.ID, "worker", 10)
+	if err != nil || len(messages) != 1 || messages[0].Event.ID != "a1" || messages[0].Event.TenantID != "acme" {
+		t.Fatalf("expected acme queue to hold only a1, got %+v (%v)", messages, err)
+	}
+	if _, err := service.Receive(acme, globexQueue.ID, "worker", 10); !errors.Is(err, events.ErrSubscriptionNotFound) {
+		t.Fatalf("expected another tenant's queue to be hidden, got %v", err)
+	}
+	if err := service.Unsubscribe(acme, globexQueue.ID); !errors.Is(err, events.ErrSubscriptionNotFound) {
+		t.Fatalf("expected unsubscribing another tenant's queue to fail, got %v", err)
+	}
+
+	got, err := service.GetEvents(acme, nil)
+	if err != nil || len(got) != 1 || got[0].ID != "a1" {
+		t.Fatalf("expected acme to see only its event, got %+v (%v)", got, err)
+	}
+	if _, err := service.GetEvents(acme, &events.EventFilter{TenantID: "globex"}); !errors.Is(err, events.ErrTenantMismatch) {
+		t.Fatalf("expected filtering on another tenant to fail, got %v", err)
+	}
+	if _, err := service.GetEvent(acme, "g1"); !errors.Is(err, events.ErrEventNotFound) {
+		t.Fatalf("expected another tenant's event to be hidden, got %v", err)
+	}
+	all, err := service.GetEvents(events.WithOperator(context.Background()), &events.EventFilter{TenantID: "globex"})
+	if err != nil || len(all) != 1 || all[0].ID != "g1" {
+		t.Fatalf("expected operator to filter by tenant, got %+v (%v)", all, err)
+	}
+	subscriptions, err := service.ListSubscriptions(globex)
+	if err != nil || len(subscriptions) != 1 || subscriptions[0].ID != globexQueue.ID {
+		t.Fatalf("expected globex to list only its subscription, got %+v (%v)", subscriptions, err)
+	}
+}
+
+func TestTenantStreamIsolationTest(t *testing.T) {
+	t.Parallel()
+
+	service := events.NewDefaultEventService()
+	ctx, cancel := context.WithCancel(events.WithTenant(context.Background(), "acme"))
+	defer cancel()
+
+	stream, err := service.Stream(ctx, nil)
+	if err != nil {
+		t.Fatalf("stream returned error: %v", err)
+	}
+	if err := service.Publish(events.WithTenant(context.Background(), "globex"), &events.Event{ID: "g1", Type: events.EventUserLogin}); err != nil {
+		t.Fatalf("publish returned error: %v", err)
+	}
+	if err := service.Publish(events.WithTenant(context.Background(), "acme"), &events.Event{ID: "a1", Type: events.EventUserLogin}); err != nil {
+		t.Fatalf("publish returned error: %v", err)
+	}
+
+	select {
+	case event := <-stream:
+		if event.ID != "a1" {
+			t.Fatalf("expected only acme events on the stream, got %s", event.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for streamed event")
+	}
+}
+
+func TestTenantWebhookIsolationTest(t *testing.T) {
+	t.Parallel()
+
+	service := events.NewInMemoryWebhookService(nil, nil)
+	service.SetTenantQuotas(events.NewTenantQuotas(events.TenantQuota{MaxWebhooks: 1}, nil))
+	acme := events.WithTenant(context.Background(), "acme")
+	globex := events.WithTenant(context.Background(), "globex")
+
+	webhook := &events.Webhook{Name: "acme", URL: "https://acme.example.com/hook", Events: []events.EventType{events.EventUserLogin}, Active: true}
+	if err := service.CreateWebhook(acme, webhook); err != nil {
+		t.Fatalf("create returned error: %v", err)
+	}
+	if webhook.TenantID != "acme" {
+		t.Fatalf("expected webhook tenant from context, got %q", webhook.TenantID)
+	}
+	second := &events.Webhook{Name: "acme-2", URL: "https://acme.example.com/other", Events: []events.EventType{events.EventUserLogin}, Active: true}
+	if err := service.CreateWebhook(acme, second); !errors.Is(err, events.ErrTenantQuotaExceeded) {
+		t.Fatalf("expected webhook quota to be enforced, got %v", err)
+	}
+	foreign := &events.Webhook{Name: "sneaky", TenantID: "acme", URL: "https://globex.example.com/hook", Events: []events.EventType{events.EventUserLogin}}
+	if err := service.CreateWebhook(globex, foreign); !errors.Is(err, events.ErrTenantMismatch) {
+		t.Fatalf("expected creating a webhook in another tenant to fail, got %v", err)
+	}
+
+	if _, err := service.GetWebhook(globex, webhook.ID); !errors.Is(err, events.ErrWebhookNotFound) {
+		t.Fatalf("expected another tenant's webhook to be hidden, got %v", err)
+	}
+	if err := service.DeleteWebhook(globex, webhook.ID); !errors.Is(err, events.ErrWebhookNotFound) {
+		t.Fatalf("expected deleting another tenant's webhook to fail, got %v", err)
+	}
+	update := &events.Webhook{ID: webhook.ID, TenantID: "globex", Name: "renamed", URL: webhook.URL, Events: webhook.Events, Active: true}
+	if err := service.UpdateWebhook(acme, update); err != nil || update.TenantID != "acme" {
+		t.Fatalf("expected update to keep the tenant, got %q (%v)", update.TenantID, err)
+	}
+	listed, err := service.ListWebhooks(globex)
+	if err != nil || len(listed) != 0 {
+		t.Fatalf("expected globex to see no webhooks, got %+v (%v)", listed, err)
+	}
+	if listed, _ := service.ListWebhooks(events.WithOperator(context.Background())); len(listed) != 1 {
+		t.Fatalf("expected operator to see every webhook, got %d", len(listed))
+	}
+}
+
+func TestTenantRequiredTest(t *testing.T) {
+	t.Parallel()
+
+	service := events.NewDefaultEventService()
+	webhooks := events.NewInMemoryWebhookService(nil, nil)
+	unscoped := context.Background()
+	if err := service.Publish(events.WithTenant(context.Background(), "acme"), &events.Event{ID: "a1", Type: events.EventUserLogin}); err != nil {
+		t.Fatalf("publish returned error: %v", err)
+	}
+
+	for _, ctx := range []context.Context{unscoped, events.WithTenant(context.Background(), "")} {
+		if _, scoped := events.TenantFromContext(ctx); scoped || events.IsOperator(ctx) {
+			t.Fatalf("expected an empty tenant to leave the context unscoped")
+		}
+		if _, err := service.GetEvents(ctx, nil); !errors.Is(err, events.ErrTenantRequired) {
+			t.Fatalf("expected unscoped event reads to fail, got %v", err)
+		}
+		if _, err := service.GetEvent(ctx, "a1"); !errors.Is(err, events.ErrTenantRequired) {
+			t.Fatalf("expected unscoped event lookup to fail, got %v", err)
+		}
+		if _, err := service.Stream(ctx, nil); !errors.Is(err, events.ErrTenantRequired) {
+			t.Fatalf("expected unscoped streams to fail, got %v", err)
+		}
+		if err := service.Publish(ctx, &events.Event{ID: "x1", Type: events.EventUserLogin}); !errors.Is(err, events.ErrTenantRequired) {
+			t.Fatalf("expected unscoped publishes to fail, got %v", err)
+		}
+		webhook := &events.Webhook{Name: "anon", URL: "https://example.com/hook", Events: []events.EventType{events.EventUserLogin}}
+		if err := webhooks.CreateWebhook(ctx, webhook); !errors.Is(err, events.ErrTenantRequired) {
+			t.Fatalf("expected unscoped webhook creation to fail, got %v", err)
+		}
+		if _, err := webhooks.ListWebhooks(ctx); !errors.Is(err, events.ErrTenantRequired) {
+			t.Fatalf("expected unscoped webhook listing to fail, got %v", err)
+		}
+	}
+
+	operator := events.WithOperator(context.Background())
+	if !events.IsOperator(operator) {
+		t.Fatalf("expected operator context to be marked")
+	}
+	all, err := service.GetEvents(operator, nil)
+	if err != nil || len(all) != 1 || all[0].TenantID != "acme" {
+		t.Fatalf("expected operator to see every tenant's events, got %+v (%v)", all, err)
+	}
+	if events.IsOperator(events.WithTenant(operator, "acme")) {
+		t.Fatalf("expected a tenant scope to replace the operator marker")
+	}
+}
+
+func TestTenantDeliveryEnforcementTest(t *testing.T) {
+	t.Parallel()
+
+	clock := &fakeClock{now: time.Date(2024, 6, 1, 8, 0, 0, 0, time.UTC)}
+	quotas := events.NewTenantQuotas(events.TenantQuota{}, clock)
+	quotas.SetQuota("acme", events.TenantQuota{DeliveriesPerSecond: 1, DeliveryBurst: 2})
+	deliverer := newStatusDeliverer(http.StatusOK, nil, events.WithClock(clock), events.WithTenantQuotas(quotas),
+		events.WithRetryPolicy(events.RetryPolicy{MaxAttempts: 3}))
+
+	ctx := context.Background()
+	webhook := &events.Webhook{ID: "wh", TenantID: "acme", URL: "https://acme.example.com/hook", Active: true}
+
+	delivery, err := deliverer.Deliver(ctx, webhook, &events.Event{ID: "g1", TenantID: "globex", Type: events.EventUserLogin})
+	if err == nil || delivery.ErrorCode != events.DeliveryErrorInvalidRequest || delivery.NextRetryAt != nil {
+		t.Fatalf("expected cross-tenant delivery to be refused, got %+v (%v)", delivery, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := deliverer.Deliver(ctx, webhook, &events.Event{ID: "a", TenantID: "acme", Type: events.EventUserLogin}); err != nil {
+			t.Fatalf("expected delivery %d within burst to succeed, got %v", i, err)
+		}
+	}
+	delivery, err = deliverer.Deliver(ctx, webhook, &events.Event{ID: "a3", TenantID: "acme", Type: events.EventUserLogin})
+	if err == nil || delivery.ErrorCode != events.DeliveryErrorRateLimited {
+		t.Fatalf("expected delivery over the tenant rate to be rate limited, got %+v (%v)", delivery, err)
+	}
+	if delivery.NextRetryAt == nil || !delivery.NextRetryAt.Equal(clock.Now().Add(time.Second)) {
+		t.Fatalf("expected retry when the tenant has capacity, got %v", delivery.NextRetryAt)
+	}
+	if _, err := deliverer.Deliver(ctx, &events.Webhook{ID: "other", TenantID: "globex", URL: "https://globex.example.com/hook", Active: true},
+		&events.Event{ID: "g2", TenantID: "globex", Type: events.EventUserLogin}); err != nil {
+		t.Fatalf("expected other tenants to be unaffected, got %v", err)
+	}
+
+	clock.Advance(time.Second)
+	if _, err := deliverer.Deliver(ctx, webhook, &events.Event{ID: "a4", TenantID: "acme", Type: events.EventUserLogin}); err != nil {
+		t.Fatalf("expected delivery once the bucket refills, got %v", err)
+	}
+}
@@ -0,0 +1,391 @@
+package events_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	events "goat/internal/events"
+)
+
+func TestWebhookAuthHeadersIT(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu          sync.Mutex
+		authHeaders []string
+		tokenCalls  int64
+		rejectNext  int32
+	)
+	deliverer := events.NewDefaultWebhookDeliverer(0,
+		events.WithTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.URL.Host == "auth.partner.example" {
+				call := atomic.AddInt64(&tokenCalls, 1)
+				user, pass, ok := req.BasicAuth()
+				if !ok || user != "goat" || pass != "client-secret" {
+					return jsonResponse(http.StatusUnauthorized, `{"error":"invalid_client"}`), nil
+				}
+				if err := req.ParseForm(); err != nil || req.PostForm.Get("grant_type") != "client_credentials" || req.PostForm.Get("scope") != "events:write" {
+					return jsonResponse(http.StatusBadRequest, `{"error":"invalid_request"}`), nil
+				}
+				return jsonResponse(http.StatusOK, fmt.Sprintf(`{"access_token":"token-%d","token_type":"Bearer","expires_in":3600}`, call)), nil
+			}
+			mu.Lock()
+			authHeaders = append(authHeaders, req.Header.Get("Authorization"))
+			mu.Unlock()
+			if atomic.CompareAndSwapInt32(&rejectNext, 1, 0) {
+				return jsonResponse(http.StatusUnauthorized, `{}`), nil
+			}
+			return jsonResponse(http.StatusOK, `{}`), nil
+		})),
+	)
+
+	ctx := context.Background()
+	event := &events.Event{ID: "event-auth", Type: events.EventUserLogin}
+
+	bearer := &events.Webhook{ID: "bearer", URL: "https://bearer.example/hook", Auth: &events.WebhookAuth{Bearer: &events.BearerAuth{Token: "static-token"}}}
+	delivery, err := deliverer.Deliver(ctx, bearer, event)
+	if err != nil {
+		t.Fatalf("bearer delivery returned error: %v", err)
+	}
+	if _, ok := delivery.Headers["Authorization"]; ok {
+		t.Fatalf("expected credentials not to be recorded on the delivery")
+	}
+
+	basic := &events.Webhook{ID: "basic", URL: "https://basic.example/hook", Auth: &events.WebhookAuth{Basic: &events.BasicAuth{Username: "user", Password: "pass"}}}
+	if _, err := deliverer.Deliver(ctx, basic, event); err != nil {
+		t.Fatalf("basic delivery returned error: %v", err)
+	}
+
+	oauth := &events.Webhook{ID: "oauth", URL: "https://oauth.example/hook", Auth: &events.WebhookAuth{OAuth2: &events.OAuth2ClientCredentials{
+		TokenURL:     "https://auth.partner.example/oauth/token",
+		ClientID:     "goat",
+		ClientSecret: "client-secret",
+		Scopes:       []string{"events:write"},
+	}}}
+	for i := 0; i < 2; i++ {
+		if _, err := deliverer.Deliver(ctx, oauth, event); err != nil {
+			t.Fatalf("oauth delivery %d returned error: %v", i, err)
+		}
+	}
+	if got := atomic.LoadInt64(&tokenCalls); got != 1 {
+		t.Fatalf("expected cached token to be reused, got %d token requests", got)
+	}
+
+	atomic.StoreInt32(&rejectNext, 1)
+	if _, err := deliverer.Deliver(ctx, oauth, event); err == nil {
+		t.Fatalf("expected 401 to fail the delivery")
+	}
+	if _, err := deliverer.Deliver(ctx, oauth, event); err != nil {
+		t.Fatalf("oauth delivery after refresh returned error: %v", err)
+	}
+	if got := atomic.LoadInt64(&tokenCalls); got != 2 {
+		t.Fatalf("expected token refresh after 401, got %d token requests", got)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"Bearer static-token",
+		"Basic dXNlcjpwYXNz",
+		"Bearer token-1",
+		"Bearer token-1",
+		"Bearer token-1",
+		"Bearer token-2",
+	}
+	if strings.Join(authHeaders, "|") != strings.Join(want, "|") {
+		t.Fatalf("unexpected authorization headers:\n got %q\nwant %q", authHeaders, want)
+	}
+}
+
+func TestWebhookAuthMTLSIT(t *testing.T) {
+	t.Parallel()
+
+	clientCertPEM, clientKeyPEM, clientCert := generateClientCertificate(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 || r.TLS.PeerCertificates[0].Subject.CommonName != "goat-webhooks" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	serverCAPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	policy := events.DefaultSSRFPolicy()
+	if err := policy.AllowCIDRs("127.0.0.0/8"); err != nil {
+		t.Fatalf("allow cidrs returned error: %v", err)
+	}
+	deliverer := events.NewDefaultWebhookDeliverer(0, events.WithSSRFPolicy(policy))
+
+	webhook := &events.Webhook{
+		ID:     "mtls",
+		Name:   "mtls",
+		URL:    server.URL,
+		Events: []events.EventType{events.EventUserLogin},
+		Auth: &events.WebhookAuth{MTLS: &events.MTLSConfig{
+			ClientCertPEM: clientCertPEM,
+			ClientKeyPEM:  clientKeyPEM,
+			CACertPEM:     serverCAPEM,
+		}},
+	}
+	if err := events.ValidateWebhook(webhook); err != nil {
+		t.Fatalf("expected mtls webhook to validate, got %v", err)
+	}
+
+	delivery, err := deliverer.Deliver(context.Background(), webhook, &events.Event{ID: "event-mtls", Type: events.EventUserLogin})
+	if err != nil {
+		t.Fatalf("mtls delivery returned error: %v", err)
+	}
+	if delivery.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", delivery.StatusCode)
+	}
+
+	encoded, err := json.Marshal(webhook)
+	if err != nil {
+		t.Fatalf("failed to marshal webhook: %v", err)
+	}
+	if strings.Contains(string(encoded), "PRIVATE KEY") {
+		t.Fatalf("expected private key to be omitted from JSON, got %s", encoded)
+	}
+}
+
+func TestWebhookAuthRedirectIT(t *testing.T) {
+	t.Parallel()
+
+	var leaked int64
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			atomic.AddInt64(&leaked, 1)
+		}
+	}))
+	defer other.Close()
+	partner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, other.URL+"/collect", http.StatusTemporaryRedirect)
+	}))
+	defer partner.Close()
+
+	policy := events.DefaultSSRFPolicy()
+	if err := policy.AllowCIDRs("127.0.0.0/8"); err != nil {
+		t.Fatalf("allow cidrs returned error: %v", err)
+	}
+	deliverer := events.NewDefaultWebhookDeliverer(0,
+		events.WithSSRFPolicy(policy),
+		events.WithRetryPolicy(events.RetryPolicy{MaxAttempts: 1}),
+	)
+
+	for name, auth := range map[string]*events.WebhookAuth{
+		"basic":  {Basic: &events.BasicAuth{Username: "goat", Password: "hunter2"}},
+		"bearer": {Bearer: &events.BearerAuth{Token: "static-token"}},
+	} {
+		webhook := &events.Webhook{ID: "redirect-" + name, URL: partner.URL, Auth: auth}
+		delivery, _ := deliverer.Deliver(context.Background(), webhook, &events.Event{ID: "event-redirect", Type: events.EventUserLogin})
+		if delivery == nil || delivery.Success || delivery.StatusCode != http.StatusTemporaryRedirect {
+			t.Fatalf("%s: expected the redirect to be returned as a failed attempt, got %+v", name, delivery)
+		}
+	}
+	if n := atomic.LoadInt64(&leaked); n != 0 {
+		t.Fatalf("expected no credentials to reach the redirect target, got %d requests with Authorization", n)
+	}
+}
+
+func TestWebhookAuthUpdateRoundTripIT(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu          sync.Mutex
+		authHeaders []string
+	)
+	deliverer := events.NewDefaultWebhookDeliverer(0,
+		events.WithTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			mu.Lock()
+			authHeaders = append(authHeaders, req.Header.Get("Authorization"))
+			mu.Unlock()
+			return jsonResponse(http.StatusOK, `{}`), nil
+		})),
+	)
+	service := events.NewInMemoryWebhookService(deliverer, deliverer)
+	ctx := events.WithOperator(context.Background())
+	event := &events.Event{ID: "event-update", Type: events.EventUserLogin}
+
+	webhook := &events.Webhook{
+		Name:   "partner",
+		URL:    "https://partner.example/hook",
+		Events: []events.EventType{events.EventUserLogin},
+		Active: true,
+		Auth:   &events.WebhookAuth{Bearer: &events.BearerAuth{Token: "static-token"}},
+	}
+	if err := service.CreateWebhook(ctx, webhook); err != nil {
+		t.Fatalf("create webhook returned error: %v", err)
+	}
+	deliver := func() string {
+		t.Helper()
+		stored, err := service.GetWebhook(ctx, webhook.ID)
+		if err != nil {
+			t.Fatalf("get webhook returned error: %v", err)
+		}
+		if _, err := deliverer.Deliver(ctx, stored, event); err != nil {
+			t.Fatalf("delivery returned error: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return authHeaders[len(authHeaders)-1]
+	}
+	if got := deliver(); got != "Bearer static-token" {
+		t.Fatalf("unexpected authorization header %q", got)
+	}
+
+	// The API never returns secrets, so a webhook read and sent back has an empty token.
+	read, _ := service.GetWebhook(ctx, webhook.ID)
+	encoded, _ := json.Marshal(read)
+	var update events.Webhook
+	if err := json.Unmarshal(encoded, &update); err != nil {
+		t.Fatalf("decode webhook: %v", err)
+	}
+	update.ID = webhook.ID
+	update.Name = "renamed"
+	if err := service.UpdateWebhook(ctx, &update); err != nil {
+		t.Fatalf("update with redacted auth returned error: %v", err)
+	}
+	if got := deliver(); got != "Bearer static-token" {
+		t.Fatalf("expected the stored token to be kept, got %q", got)
+	}
+
+	update.Auth = nil
+	if err := service.UpdateWebhook(ctx, &update); err != nil {
+		t.Fatalf("update without auth returned error: %v", err)
+	}
+	if got := deliver(); got != "Bearer static-token" {
+		t.Fatalf("expected omitted auth to keep the stored configuration, got %q", got)
+	}
+
+	update.Auth = &events.WebhookAuth{Bearer: &events.BearerAuth{Token: "rotated-token"}}
+	if err := service.UpdateWebhook(ctx, &update); err != nil {
+		t.Fatalf("update with new token returned error: %v", err)
+	}
+	if got := deliver(); got != "Bearer rotated-token" {
+		t.Fatalf("expected the rotated token, got %q", got)
+	}
+
+	update.Auth = &events.WebhookAuth{}
+	if err := service.UpdateWebhook(ctx, &update); err != nil {
+		t.Fatalf("update clearing auth returned error: %v", err)
+	}
+	if got := deliver(); got != "" {
+		t.Fatalf("expected an empty auth to remove credentials, got %q", got)
+	}
+}
+
+func TestWebhookAuthTokenFetchUnlockedIT(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	var tokenCalls int64
+	deliverer := events.NewDefaultWebhookDeliverer(0,
+		events.WithTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.URL.Host == "auth.partner.example" {
+				atomic.AddInt64(&tokenCalls, 1)
+				<-release
+				return jsonResponse(http.StatusOK, `{"access_token":"slow-token","expires_in":3600}`), nil
+			}
+			return jsonResponse(http.StatusOK, `{}`), nil
+		})),
+	)
+	webhook := &events.Webhook{ID: "oauth-slow", URL: "https://oauth.example/hook", Auth: &events.WebhookAuth{OAuth2: &events.OAuth2ClientCredentials{
+		TokenURL:     "https://auth.partner.example/oauth/token",
+		ClientID:     "goat",
+		ClientSecret: "client-secret",
+	}}}
+	event := &events.Event{ID: "event-slow", Type: events.EventUserLogin}
+
+	first := make(chan error, 1)
+	go func() {
+		_, err := deliverer.Deliver(context.Background(), webhook, event)
+		first <- err
+	}()
+	for atomic.LoadInt64(&tokenCalls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A caller waiting on the refresh in flight gives up with its own context.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := deliverer.Deliver(ctx, webhook, event); err == nil {
+		t.Fatalf("expected the waiting delivery to fail when its context ends")
+	}
+
+	close(release)
+	if err := <-first; err != nil {
+		t.Fatalf("first delivery returned error: %v", err)
+	}
+	if got := atomic.LoadInt64(&tokenCalls); got != 1 {
+		t.Fatalf("expected a single token request, got %d", got)
+	}
+}
+
+func jsonResponse(status int, body string) *http.Response {
+	header := make(http.Header)
+	header.Set("Content-Type", "application/json")
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Header:     header,
+	}
+}
+
+func generateClientCertificate(t *testing.T) (certPEM, keyPEM string, cert *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "goat-webhooks"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	certPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	keyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	return certPEM, keyPEM, cert
+}
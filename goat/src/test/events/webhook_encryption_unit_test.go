@@ -0,0 +1,184 @@
+package events_test
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	events "goat/internal/events"
+)
+
+func jweHeader(t *testing.T, token string) map[string]interface{} {
+	t.Helper()
+	raw, err := base64.RawURLEncoding.DecodeString(strings.Split(token, ".")[0])
+	if err != nil {
+		t.Fatalf("invalid jwe header: %v", err)
+	}
+	var header map[string]interface{}
+	if err := json.Unmarshal(raw, &header); err != nil {
+		t.Fatalf("invalid jwe header: %v", err)
+	}
+	return header
+}
+
+func x25519JWKS(kid string, key *ecdh.PrivateKey) string {
+	return fmt.Sprintf(`{"keys":[{"kty":"OKP","crv":"X25519","use":"enc","kid":%q,"x":%q}]}`,
+		kid, base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()))
+}
+
+func TestWebhookPayloadEncryptionTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate rsa key: %v", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatalf("failed to marshal rsa key: %v", err)
+	}
+	rsaPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	var (
+		mu          sync.Mutex
+		body        []byte
+		header      http.Header
+		jwks        string
+		jwksFetches int64
+	)
+	deliverer := events.NewDefaultWebhookDeliverer(0,
+		events.WithTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if req.Method == http.MethodGet {
+				atomic.AddInt64(&jwksFetches, 1)
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(jwks)), Header: make(http.Header)}, nil
+			}
+			body, _ = io.ReadAll(req.Body)
+			header = req.Header.Clone()
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("")), Header: make(http.Header)}, nil
+		})),
+	)
+	event := &events.Event{ID: "event-jwe", Type: events.EventUserCreated, Data: map[string]interface{}{"email": "ada@example.com"}}
+
+	webhook := &events.Webhook{
+		ID:         "encrypted",
+		URL:        "https://partner.example/hook",
+		Secret:     "s3cret",
+		Encryption: &events.WebhookEncryption{Algorithm: events.JWEAlgRSAOAEP256, KeyID: "rsa-1", PublicKeyPEM: rsaPEM},
+	}
+	delivery, err := deliverer.Deliver(ctx, webhook, event)
+	if err != nil {
+		t.Fatalf("deliver returned error: %v", err)
+	}
+	if header.Get("Content-Type") != events.JWEContentType || strings.Count(string(body), ".") != 4 {
+		t.Fatalf("expected compact JWE body, got %q (%s)", body, header.Get("Content-Type"))
+	}
+	if strings.Contains(string(body), "ada@example.com") || strings.Contains(string(delivery.Payload), "ada@example.com") {
+		t.Fatalf("expected plaintext not to be sent or recorded")
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(body)
+	if header.Get("X-Webhook-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Fatalf("expected signature over the ciphertext")
+	}
+	if h := jweHeader(t, string(body)); h["alg"] != "RSA-OAEP-256" || h["enc"] != "A256GCM" || h["kid"] != "rsa-1" || h["cty"] != "application/json" {
+		t.Fatalf("unexpected jwe header: %v", h)
+	}
+	plaintext, err := events.DecryptCompactJWE(string(body), rsaKey)
+	if err != nil {
+		t.Fatalf("decrypt returned error: %v", err)
+	}
+	var decrypted events.Event
+	if err := json.Unmarshal(plaintext, &decrypted); err != nil || decrypted.ID != "event-jwe" {
+		t.Fatalf("expected decrypted event, got %s (%v)", plaintext, err)
+	}
+
+	// ECDH-ES with keys from a JWKS URL; rotating to a new kid refetches the set.
+	oldKey, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	newKey, _ := ecdh.X25519().GenerateKey(rand.Reader)
+	jwks = x25519JWKS("2024-01", oldKey)
+	webhook.Encryption = &events.WebhookEncryption{Algorithm: events.JWEAlgECDHES, JWKSURL: "https://partner.example/jwks.json"}
+	for i := 0; i < 2; i++ {
+		if _, err := deliverer.Deliver(ctx, webhook, event); err != nil {
+			t.Fatalf("deliver returned error: %v", err)
+		}
+	}
+	if got := atomic.LoadInt64(&jwksFetches); got != 1 {
+		t.Fatalf("expected the key set to be cached, got %d fetches", got)
+	}
+	if h := jweHeader(t, string(body)); h["kid"] != "2024-01" || h["epk"] == nil {
+		t.Fatalf("expected kid and epk in header, got %v", h)
+	}
+	if _, err := events.DecryptCompactJWE(string(body), oldKey); err != nil {
+		t.Fatalf("decrypt returned error: %v", err)
+	}
+
+	mu.Lock()
+	jwks = x25519JWKS("2024-06", newKey)
+	mu.Unlock()
+	webhook.Encryption.KeyID = "2024-06"
+	if _, err := deliverer.Deliver(ctx, webhook, event); err != nil {
+		t.Fatalf("deliver after rotation returned error: %v", err)
+	}
+	if _, err := events.DecryptCompactJWE(string(body), newKey); err != nil {
+		t.Fatalf("expected payload for the rotated key, got %v", err)
+	}
+	if _, err := events.DecryptCompactJWE(string(body), oldKey); err == nil {
+		t.Fatalf("expected the retired key not to decrypt")
+	}
+}
+
+func TestValidateWebhookEncryptionTest(t *testing.T) {
+	t.Parallel()
+
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("failed to generate rsa key: %v", err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&weak.PublicKey)
+	weakPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	x25519, _ := ecdh.X25519().GenerateKey(rand.Reader)
+
+	tests := []struct {
+		name       string
+		encryption *events.WebhookEncryption
+		valid      bool
+	}{
+		{name: "inline jwks", encryption: &events.WebhookEncryption{Algorithm: events.JWEAlgECDHES, JWKS: json.RawMessage(x25519JWKS("k1", x25519))}, valid: true},
+		{name: "jwks url", encryption: &events.WebhookEncryption{Algorithm: events.JWEAlgRSAOAEP256, JWKSURL: "https://partner.example/jwks"}, valid: true},
+		{name: "plain http jwks url", encryption: &events.WebhookEncryption{Algorithm: events.JWEAlgRSAOAEP256, JWKSURL: "http://partner.example/jwks"}},
+		{name: "weak rsa key", encryption: &events.WebhookEncryption{Algorithm: events.JWEAlgRSAOAEP256, PublicKeyPEM: weakPEM}},
+		{name: "key does not fit alg", encryption: &events.WebhookEncryption{Algorithm: events.JWEAlgRSAOAEP256, JWKS: json.RawMessage(x25519JWKS("k1", x25519))}},
+		{name: "unknown kid", encryption: &events.WebhookEncryption{Algorithm: events.JWEAlgECDHES, KeyID: "k2", JWKS: json.RawMessage(x25519JWKS("k1", x25519))}},
+		{name: "no key", encryption: &events.WebhookEncryption{Algorithm: events.JWEAlgECDHES}},
+		{name: "unknown alg", encryption: &events.WebhookEncryption{Algorithm: "dir", JWKSURL: "https://partner.example/jwks"}},
+	}
+	for _, tc := range tests {
+		err := events.ValidateWebhook(&events.Webhook{
+			Name:       "encrypted",
+			URL:        "https://partner.example/hook",
+			Events:     []events.EventType{events.EventUserCreated},
+			Encryption: tc.encryption,
+		})
+		if (err == nil) != tc.valid {
+			t.Fatalf("%s: expected valid=%v, got %v", tc.name, tc.valid, err)
+		}
+	}
+}
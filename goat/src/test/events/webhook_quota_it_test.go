@@ -0,0 +1,166 @@
+package events_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	events "goat/internal/events"
+)
+
+// fakeQuotaDB answers the statements PostgresWebhookService.CreateWebhook runs. Inserts become
+// visible on commit and advisory locks are held until the transaction ends, as in Postgres.
+type fakeQuotaDB struct {
+	mu       sync.Mutex
+	webhooks map[string]string
+	locks    map[string]*sync.Mutex
+}
+
+var (
+	fakeQuotaDBsMu sync.Mutex
+	fakeQuotaDBs   = map[string]*fakeQuotaDB{}
+)
+
+func init() {
+	sql.Register("fake-quota-postgres", fakeQuotaDriver{})
+}
+
+type fakeQuotaDriver struct{}
+
+func (fakeQuotaDriver) Open(name string) (driver.Conn, error) {
+	fakeQuotaDBsMu.Lock()
+	defer fakeQuotaDBsMu.Unlock()
+	fake, ok := fakeQuotaDBs[name]
+	if !ok {
+		return nil, fmt.Errorf("no fake database %q", name)
+	}
+	return &fakeQuotaConn{db: fake}, nil
+}
+
+type fakeQuotaConn struct {
+	db      *fakeQuotaDB
+	held    []*sync.Mutex
+	pending map[string]string
+}
+
+func (c *fakeQuotaConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepare is not supported")
+}
+func (c *fakeQuotaConn) Close() error { return nil }
+func (c *fakeQuotaConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeQuotaConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.pending = map[string]string{}
+	return c, nil
+}
+
+func (c *fakeQuotaConn) Commit() error {
+	c.db.mu.Lock()
+	for id, tenant := range c.pending {
+		c.db.webhooks[id] = tenant
+	}
+	c.db.mu.Unlock()
+	return c.Rollback()
+}
+
+func (c *fakeQuotaConn) Rollback() error {
+	c.pending = nil
+	for _, lock := range c.held {
+		lock.Unlock()
+	}
+	c.held = nil
+	return nil
+}
+
+func (c *fakeQuotaConn) ExecContext(ctx context.Context, query string, named []driver.NamedValue) (driver.Result, error) {
+	if !strings.Contains(query, "pg_advisory_xact_lock") {
+		return nil, fmt.Errorf("unexpected exec: %s", query)
+	}
+	key := namedValues(named)[0].(string)
+	c.db.mu.Lock()
+	lock, ok := c.db.locks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		c.db.locks[key] = lock
+	}
+	c.db.mu.Unlock()
+	lock.Lock()
+	c.held = append(c.held, lock)
+	return driver.RowsAffected(0), nil
+}
+
+func (c *fakeQuotaConn) QueryContext(ctx context.Context, query string, named []driver.NamedValue) (driver.Rows, error) {
+	args := namedValues(named)
+	switch {
+	case strings.Contains(query, "SELECT COUNT(*) FROM webhooks"):
+		// Widen the window between counting and inserting so unserialized creates would overlap.
+		time.Sleep(5 * time.Millisecond)
+		c.db.mu.Lock()
+		defer c.db.mu.Unlock()
+		var count int64
+		for _, tenant := range c.db.webhooks {
+			if tenant == args[0] {
+				count++
+			}
+		}
+		return singleRow([]driver.Value{count}), nil
+	case strings.Contains(query, "INSERT INTO webhooks"):
+		c.pending[args[0].(string)] = args[19].(string)
+		now := time.Now().UTC()
+		return singleRow([]driver.Value{now, now}), nil
+	}
+	return nil, fmt.Errorf("unexpected query: %s", query)
+}
+
+func TestPostgresWebhookQuotaConcurrentCreatesIT(t *testing.T) {
+	t.Parallel()
+
+	fakeQuotaDBsMu.Lock()
+	fakeQuotaDBs[t.Name()] = &fakeQuotaDB{webhooks: map[string]string{}, locks: map[string]*sync.Mutex{}}
+	fakeQuotaDBsMu.Unlock()
+	db, err := sql.Open("fake-quota-postgres", t.Name())
+	if err != nil {
+		t.Fatalf("open fake database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	service := events.NewPostgresWebhookService(db, nil)
+	service.SetTenantQuotas(events.NewTenantQuotas(events.TenantQuota{MaxWebhooks: 2}, nil))
+	acme := events.WithTenant(context.Background(), "acme")
+
+	const creates = 6
+	results := make(chan error, creates)
+	for i := 0; i < creates; i++ {
+		go func(i int) {
+			results <- service.CreateWebhook(acme, &events.Webhook{
+				Name:   fmt.Sprintf("acme-%d", i),
+				URL:    fmt.Sprintf("https://acme.example.com/hook/%d", i),
+				Events: []events.EventType{events.EventUserLogin},
+				Active: true,
+			})
+		}(i)
+	}
+	created, rejected := 0, 0
+	for i := 0; i < creates; i++ {
+		err := <-results
+		switch {
+		case err == nil:
+			created++
+		case errors.Is(err, events.ErrTenantQuotaExceeded):
+			rejected++
+		default:
+			t.Fatalf("create returned unexpected error: %v", err)
+		}
+	}
+	if created != 2 || rejected != creates-2 {
+		t.Fatalf("expected the quota to admit exactly 2 concurrent creates, got %d created and %d rejected", created, rejected)
+	}
+}
@@ -0,0 +1,219 @@
+package events_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	events "goat/internal/events"
+)
+
+func TestValidateWebhookTest(t *testing.T) {
+	t.Parallel()
+
+	valid := func() *events.Webhook {
+		return &events.Webhook{
+			Name:   "crm",
+			URL:    "https://hooks.example.com/goat",
+			Events: []events.EventType{events.EventUserLogin, "security.*"},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		mutate  func(*events.Webhook)
+		wantErr bool
+	}{
+		{name: "valid", mutate: func(*events.Webhook) {}},
+		{name: "missing name", mutate: func(w *events.Webhook) { w.Name = " " }, wantErr: true},
+		{name: "ftp scheme", mutate: func(w *events.Webhook) { w.URL = "ftp://hooks.example.com" }, wantErr: true},
+		{name: "relative url", mutate: func(w *events.Webhook) { w.URL = "/hooks" }, wantErr: true},
+		{name: "embedded credentials", mutate: func(w *events.Webhook) { w.URL = "https://u:p@hooks.example.com" }, wantErr: true},
+		{name: "no events", mutate: func(w *events.Webhook) { w.Events = nil }, wantErr: true},
+		{name: "unknown event", mutate: func(w *events.Webhook) { w.Events = []events.EventType{"user.teleported"} }, wantErr: true},
+		{name: "unknown wildcard", mutate: func(w *events.Webhook) { w.Events = []events.EventType{"billing.*"} }, wantErr: true},
+		{name: "catch-all", mutate: func(w *events.Webhook) { w.Events = []events.EventType{"*"} }},
+		{name: "bad multiplier", mutate: func(w *events.Webhook) { w.RetryConfig = &events.RetryConfig{Multiplier: 0.5} }, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			webhook := valid()
+			tc.mutate(webhook)
+			err := events.ValidateWebhook(webhook)
+			if tc.wantErr && err == nil {
+				t.Fatalf("expected validation error")
+			}
+			if !tc.wantErr && err != nil {
+				t.Fatalf("unexpected validation error: %v", err)
+			}
+		})
+	}
+}
+
+func TestInMemoryWebhookServiceCRUDTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := events.WithOperator(context.Background())
+	service := events.NewInMemoryWebhookService(nil, nil)
+
+	webhook := &events.Webhook{
+		Name:   "crm",
+		URL:    "https://hooks.example.com/goat",
+		Events: []events.EventType{events.EventUserCreated},
+		Secret: "initial-secret",
+		Active: true,
+	}
+	if err := service.CreateWebhook(ctx, webhook); err != nil {
+		t.Fatalf("create returned error: %v", err)
+	}
+	if webhook.ID == "" || webhook.CreatedAt.IsZero() {
+		t.Fatalf("expected id and timestamps to be assigned")
+	}
+
+	update := &events.Webhook{
+		ID:     webhook.ID,
+		Name:   "crm-v2",
+		URL:    "https://hooks.example.com/goat/v2",
+		Events: []events.EventType{events.EventUserCreated, events.EventUserDeleted},
+		Active: true,
+	}
+	if err := service.UpdateWebhook(ctx, update); err != nil {
+		t.Fatalf("update returned error: %v", err)
+	}
+
+	got, err := service.GetWebhook(ctx, webhook.ID)
+	if err != nil {
+		t.Fatalf("get returned error: %v", err)
+	}
+	if got.Name != "crm-v2" || len(got.Events) != 2 {
+		t.Fatalf("expected update to be applied, got %+v", got)
+	}
+	if got.Secret != "initial-secret" {
+		t.Fatalf("expected empty secret on update to keep the stored secret")
+	}
+	if !got.CreatedAt.Equal(webhook.CreatedAt) {
+		t.Fatalf("expected created_at to be preserved")
+	}
+
+	list, err := service.ListWebhooks(ctx)
+	if err != nil || len(list) != 1 {
+		t.Fatalf("expected one webhook, got %d (err %v)", len(list), err)
+	}
+
+	if err := service.DeleteWebhook(ctx, webhook.ID); err != nil {
+		t.Fatalf("delete returned error: %v", err)
+	}
+	if _, err := service.GetWebhook(ctx, webhook.ID); !errors.Is(err, events.ErrWebhookNotFound) {
+		t.Fatalf("expected not found after delete, got %v", err)
+	}
+	if err := service.DeleteWebhook(ctx, webhook.ID); !errors.Is(err, events.ErrWebhookNotFound) {
+		t.Fatalf("expected not found on second delete, got %v", err)
+	}
+}
+
+func TestInMemoryWebhookServiceTestAndHistoryTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := events.WithOperator(context.Background())
+
+	var hits int64
+	deliverer := events.NewDefaultWebhookDeliverer(0,
+		events.WithTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			attempt := atomic.AddInt64(&hits, 1)
+			status := http.StatusOK
+			if attempt%2 == 0 {
+				status = http.StatusBadGateway
+			}
+			header := make(http.Header)
+			header.Set("X-Receiver", "test")
+			return &http.Response{
+				Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+				StatusCode: status,
+				Body:       io.NopCloser(strings.NewReader("received")),
+				Header:     header,
+			}, nil
+		})),
+		events.WithRetryPolicy(events.RetryPolicy{Delay: time.Millisecond}),
+	)
+
+	service := events.NewInMemoryWebhookService(deliverer, deliverer)
+	webhook := &events.Webhook{
+		Name:   "crm",
+		URL:    "https://hooks.example.com/goat",
+		Events: []events.EventType{events.EventUserLogin},
+		Active: true,
+	}
+	if err := service.CreateWebhook(ctx, webhook); err != nil {
+		t.Fatalf("create returned error: %v", err)
+	}
+
+	result, err := service.TestWebhook(ctx, webhook.ID)
+	if err != nil {
+		t.Fatalf("test webhook returned error: %v", err)
+	}
+	if !result.Success || result.StatusCode != http.StatusOK {
+		t.Fatalf("expected successful test result, got %+v", result)
+	}
+	if result.Headers["X-Receiver"] != "test" || result.Body != "received" {
+		t.Fatalf("expected response details in test result, got %+v", result)
+	}
+
+	history, err := service.GetDeliveryHistory(ctx, webhook.ID, nil)
+	if err != nil {
+		t.Fatalf("history returned error: %v", err)
+	}
+	if len(history) != 0 {
+		t.Fatalf("expected test sends not to be recorded as deliveries, got %d", len(history))
+	}
+
+	for i := 0; i < 4; i++ {
+		_, _ = deliverer.Deliver(ctx, webhook, &events.Event{ID: fmt.Sprintf("event-%d", i), Type: events.EventUserLogin})
+	}
+
+	all, err := service.GetDeliveryHistory(ctx, webhook.ID, &events.DeliveryFilter{})
+	if err != nil {
+		t.Fatalf("history returned error: %v", err)
+	}
+	if len(all) != 4 {
+		t.Fatalf("expected 4 deliveries, got %d", len(all))
+	}
+
+	succeeded, err := service.GetDeliveryHistory(ctx, webhook.ID, &events.DeliveryFilter{Status: events.DeliveryStatusSucceeded})
+	if err != nil {
+		t.Fatalf("history returned error: %v", err)
+	}
+	for _, delivery := range succeeded {
+		if !delivery.Success {
+			t.Fatalf("expected only successful deliveries, got %+v", delivery)
+		}
+	}
+	if len(succeeded) != 2 {
+		t.Fatalf("expected 2 successful deliveries, got %d", len(succeeded))
+	}
+
+	page, err := service.GetDeliveryHistory(ctx, webhook.ID, &events.DeliveryFilter{Limit: 3, Offset: 2})
+	if err != nil {
+		t.Fatalf("history returned error: %v", err)
+	}
+	if len(page) != 2 {
+		t.Fatalf("expected second page to hold 2 deliveries, got %d", len(page))
+	}
+
+	future := time.Now().Add(time.Hour)
+	none, err := service.GetDeliveryHistory(ctx, webhook.ID, &events.DeliveryFilter{StartTime: &future})
+	if err != nil {
+		t.Fatalf("history returned error: %v", err)
+	}
+	if len(none) != 0 {
+		t.Fatalf("expected no deliveries after start time filter, got %d", len(none))
+	}
+}
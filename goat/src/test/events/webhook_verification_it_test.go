@@ -0,0 +1,56 @@
+package events_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	events "goat/internal/events"
+)
+
+func TestWebhookVerificationRedirectIT(t *testing.T) {
+	t.Parallel()
+
+	// The redirect target echoes every challenge, as an endpoint the registrant does not own might
+	var echoed int64
+	echoer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&echoed, 1)
+		var challenge events.VerificationChallenge
+		_ = json.NewDecoder(r.Body).Decode(&challenge)
+		_ = json.NewEncoder(w).Encode(map[string]string{"challenge": challenge.Challenge})
+	}))
+	defer echoer.Close()
+	registered := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, echoer.URL+"/hook", http.StatusTemporaryRedirect)
+	}))
+	defer registered.Close()
+
+	policy := events.DefaultSSRFPolicy()
+	if err := policy.AllowCIDRs("127.0.0.0/8"); err != nil {
+		t.Fatalf("allow cidrs returned error: %v", err)
+	}
+	deliverer := events.NewDefaultWebhookDeliverer(0, events.WithSSRFPolicy(policy))
+
+	for name, auth := range map[string]*events.WebhookAuth{
+		"shared client": nil,
+		"auth client":   {Bearer: &events.BearerAuth{Token: "static-token"}},
+	} {
+		webhook := &events.Webhook{ID: "verify-" + strings.ReplaceAll(name, " ", "-"), URL: registered.URL, Auth: auth}
+		err := deliverer.VerifyEndpoint(context.Background(), webhook)
+		if err == nil || !strings.Contains(err.Error(), "status 307") {
+			t.Fatalf("%s: expected the redirect to fail verification, got %v", name, err)
+		}
+	}
+	if n := atomic.LoadInt64(&echoed); n != 0 {
+		t.Fatalf("expected the challenge not to follow the redirect, got %d requests", n)
+	}
+
+	webhook := &events.Webhook{ID: "verify-direct", URL: echoer.URL}
+	if err := deliverer.VerifyEndpoint(context.Background(), webhook); err != nil {
+		t.Fatalf("expected a direct echo to verify, got %v", err)
+	}
+}
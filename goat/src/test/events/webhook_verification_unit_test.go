@@ -0,0 +1,119 @@
+package events_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	events "goat/internal/events"
+)
+
+func TestWebhookOwnershipVerificationTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := events.WithOperator(context.Background())
+	var (
+		mu         sync.Mutex
+		echo       = map[string]bool{"owned.example": true}
+		challenges []string
+		deliveries int
+	)
+	deliverer := events.NewDefaultWebhookDeliverer(0,
+		events.WithTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			body, _ := io.ReadAll(req.Body)
+			respond := ""
+			if req.Header.Get("X-Event-Type") == events.VerificationEventType {
+				mac := hmac.New(sha256.New, []byte("s3cret"))
+				mac.Write(body)
+				if req.Header.Get("X-Webhook-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+					t.Errorf("expected a signed challenge")
+				}
+				var challenge events.VerificationChallenge
+				if err := json.Unmarshal(body, &challenge); err != nil {
+					t.Errorf("invalid challenge: %v", err)
+				}
+				challenges = append(challenges, req.URL.Host)
+				if echo[req.URL.Host] {
+					respond = `{"challenge":"` + challenge.Challenge + `"}`
+				}
+			} else {
+				deliveries++
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(respond)), Header: make(http.Header)}, nil
+		})),
+	)
+	service := events.NewInMemoryWebhookService(deliverer, deliverer)
+	service.SetWebhookVerifier(deliverer)
+
+	webhook := &events.Webhook{
+		Name:               "crm",
+		URL:                "https://third-party.example/hook",
+		Secret:             "s3cret",
+		Events:             []events.EventType{events.EventUserLogin},
+		Active:             true,
+		VerifyOwnership:    true,
+		VerificationStatus: events.VerificationVerified,
+	}
+	if err := service.CreateWebhook(ctx, webhook); err != nil {
+		t.Fatalf("create returned error: %v", err)
+	}
+	if webhook.VerificationStatus != events.VerificationPending {
+		t.Fatalf("expected a webhook that did not echo to stay pending, got %q", webhook.VerificationStatus)
+	}
+
+	event := &events.Event{ID: "event-verify", Type: events.EventUserLogin}
+	delivery, err := deliverer.Deliver(ctx, webhook, event)
+	if err == nil || delivery.ErrorCode != events.DeliveryErrorUnverified {
+		t.Fatalf("expected delivery to a pending webhook to be refused, got %v", err)
+	}
+	if delivery.NextRetryAt != nil || deliveries != 0 {
+		t.Fatalf("expected no request and no retry for a pending webhook")
+	}
+	if _, err := service.TestWebhook(ctx, webhook.ID); !errors.Is(err, events.ErrWebhookNotVerified) {
+		t.Fatalf("expected test of a pending webhook to be refused, got %v", err)
+	}
+	if _, err := service.VerifyWebhook(ctx, webhook.ID); err == nil {
+		t.Fatalf("expected verification to fail while the endpoint does not echo")
+	}
+
+	webhook.URL = "https://owned.example/hook"
+	if err := service.UpdateWebhook(ctx, webhook); err != nil {
+		t.Fatalf("update returned error: %v", err)
+	}
+	stored, _ := service.GetWebhook(ctx, webhook.ID)
+	if stored.VerificationStatus != events.VerificationVerified || stored.VerifiedAt == nil {
+		t.Fatalf("expected the new URL to be verified, got %q", stored.VerificationStatus)
+	}
+	if _, err := deliverer.Deliver(ctx, stored, event); err != nil || deliveries != 1 {
+		t.Fatalf("expected delivery to a verified webhook, got %v", err)
+	}
+
+	// Saving without changing the URL keeps the verification; a new URL is challenged again.
+	stored.Name = "crm-renamed"
+	if err := service.UpdateWebhook(ctx, stored); err != nil || stored.VerificationStatus != events.VerificationVerified {
+		t.Fatalf("expected rename to keep verification, got %q (%v)", stored.VerificationStatus, err)
+	}
+	before := len(challenges)
+	stored.URL = "https://third-party.example/other"
+	if err := service.UpdateWebhook(ctx, stored); err != nil {
+		t.Fatalf("update returned error: %v", err)
+	}
+	if stored.VerificationStatus != events.VerificationPending || stored.VerifiedAt != nil || len(challenges) != before+1 {
+		t.Fatalf("expected a URL change to re-run verification, got %q", stored.VerificationStatus)
+	}
+
+	plain := &events.Webhook{Name: "plain", URL: "https://third-party.example/plain", Events: []events.EventType{events.EventUserLogin}}
+	if err := service.CreateWebhook(ctx, plain); err != nil || plain.VerificationStatus != "" {
+		t.Fatalf("expected webhooks without verify_ownership to skip the handshake, got %q (%v)", plain.VerificationStatus, err)
+	}
+}
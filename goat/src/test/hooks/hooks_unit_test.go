@@ -0,0 +1,202 @@
+package hooks_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"goat/internal/audit"
+	"goat/internal/hooks"
+)
+
+type recordingAuditService struct {
+	mu   sync.Mutex
+	logs []*audit.AuditLog
+}
+
+func (s *recordingAuditService) Log(ctx context.Context, log *audit.AuditLog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logs = append(s.logs, log)
+	return nil
+}
+
+func (s *recordingAuditService) Query(ctx context.Context, filter *audit.AuditFilter) ([]*audit.AuditLog, error) {
+	return nil, nil
+}
+
+func (s *recordingAuditService) GetUserLogs(ctx context.Context, userID string) ([]*audit.AuditLog, error) {
+	return nil, nil
+}
+
+func (s *recordingAuditService) ExportUserData(ctx context.Context, userID string) (*audit.ComplianceReport, error) {
+	return nil, nil
+}
+
+func (s *recordingAuditService) DeleteUserData(ctx context.Context, userID string) error { return nil }
+
+func (s *recordingAuditService) GenerateComplianceReport(ctx context.Context, reportType string) (*audit.ComplianceReport, error) {
+	return nil, nil
+}
+
+func (s *recordingAuditService) CreateSecurityAlert(ctx context.Context, alert *audit.SecurityAlert) error {
+	return nil
+}
+
+func (s *recordingAuditService) GetSecurityAlerts(ctx context.Context) ([]*audit.SecurityAlert, error) {
+	return nil, nil
+}
+
+func (s *recordingAuditService) ResolveSecurityAlert(ctx context.Context, alertID string) error {
+	return nil
+}
+
+func decide(decision *hooks.Decision) hooks.Handler {
+	return func(ctx context.Context, req *hooks.Request) (*hooks.Decision, error) {
+		return decision, nil
+	}
+}
+
+func TestHookChainDecisionsTest(t *testing.T) {
+	t.Parallel()
+
+	service := &recordingAuditService{}
+	runner := hooks.NewRunner(audit.NewAuditLogger(service))
+	var seenClaims map[string]interface{}
+	for _, hook := range []hooks.Hook{
+		{Name: "claims", Points: []hooks.Point{hooks.PointPostLogin}, Handler: decide(&hooks.Decision{Action: hooks.ActionModify, Claims: map[string]interface{}{"dept": "eng"}})},
+		{Name: "observe", Points: []hooks.Point{hooks.PointPostLogin}, Handler: func(ctx context.Context, req *hooks.Request) (*hooks.Decision, error) {
+			seenClaims = req.Claims
+			return &hooks.Decision{Action: hooks.ActionAllow}, nil
+		}},
+		{Name: "contractors", Points: []hooks.Point{hooks.PointPreLogin}, Handler: decide(&hooks.Decision{Action: hooks.ActionDeny, Reason: "outside business hours"})},
+		{Name: "never", Points: []hooks.Point{hooks.PointPreLogin}, Handler: func(ctx context.Context, req *hooks.Request) (*hooks.Decision, error) {
+			t.Errorf("hooks after a deny must not run")
+			return nil, nil
+		}},
+	} {
+		if err := runner.Register(hook); err != nil {
+			t.Fatalf("register %s returned error: %v", hook.Name, err)
+		}
+	}
+
+	result, err := runner.Run(context.Background(), hooks.PointPostLogin, &hooks.Request{UserID: "u1", Claims: map[string]interface{}{"sub": "u1"}})
+	if err != nil || !result.Allowed() {
+		t.Fatalf("expected post-login to be allowed, got %+v (%v)", result, err)
+	}
+	if result.Claims["dept"] != "eng" || result.Claims["sub"] != "u1" || seenClaims["dept"] != "eng" {
+		t.Fatalf("expected modified claims to reach later hooks and the result, got %v / %v", result.Claims, seenClaims)
+	}
+
+	result, err = runner.Run(context.Background(), hooks.PointPreLogin, &hooks.Request{UserID: "u2", IP: "203.0.113.5"})
+	if err != nil || result.Allowed() || result.DeniedBy != "contractors" || result.Reason != "outside business hours" {
+		t.Fatalf("expected pre-login to be denied by contractors, got %+v (%v)", result, err)
+	}
+	if len(result.Evaluations) != 1 {
+		t.Fatalf("expected the chain to stop at the deny, got %+v", result.Evaluations)
+	}
+
+	result, err = runner.Run(context.Background(), hooks.PointPreMFA, &hooks.Request{UserID: "u3"})
+	if err != nil || !result.Allowed() || len(result.Evaluations) != 0 {
+		t.Fatalf("expected a point without hooks to be allowed, got %+v (%v)", result, err)
+	}
+
+	if len(service.logs) != 2 {
+		t.Fatalf("expected each chain with hooks to be audited, got %d entries", len(service.logs))
+	}
+	denied := service.logs[1]
+	if denied.EventType != audit.EventTypeAuthHook || denied.Result != "deny" || denied.Severity != audit.SeverityWarning ||
+		denied.Resource != "pre_login" || denied.IP != "203.0.113.5" || denied.Details["denied_by"] != "contractors" {
+		t.Fatalf("unexpected audit entry %+v", denied)
+	}
+}
+
+func TestHookFailurePoliciesTest(t *testing.T) {
+	t.Parallel()
+
+	slow := func(ctx context.Context, req *hooks.Request) (*hooks.Decision, error) {
+		time.Sleep(time.Second)
+		return &hooks.Decision{Action: hooks.ActionAllow}, nil
+	}
+	failing := func(ctx context.Context, req *hooks.Request) (*hooks.Decision, error) {
+		return nil, errors.New("hr system unavailable")
+	}
+
+	open := hooks.NewRunner(nil)
+	_ = open.Register(hooks.Hook{Name: "slow", Points: []hooks.Point{hooks.PointPreMFA}, Handler: slow, Timeout: 20 * time.Millisecond, FailurePolicy: hooks.FailOpen})
+	_ = open.Register(hooks.Hook{Name: "failing", Points: []hooks.Point{hooks.PointPreMFA}, Handler: failing, FailurePolicy: hooks.FailOpen})
+	start := time.Now()
+	result, err := open.Run(context.Background(), hooks.PointPreMFA, nil)
+	if err != nil || !result.Allowed() {
+		t.Fatalf("expected fail-open hooks to allow, got %+v (%v)", result, err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("expected the timeout to cut the slow hook short, took %s", elapsed)
+	}
+	if len(result.Evaluations) != 2 || !result.Evaluations[0].FailedOpen || result.Evaluations[1].Error == "" {
+		t.Fatalf("expected failures to be recorded, got %+v", result.Evaluations)
+	}
+
+	closed := hooks.NewRunner(nil)
+	_ = closed.Register(hooks.Hook{Name: "failing", Points: []hooks.Point{hooks.PointPreUserCreate}, Handler: failing})
+	result, err = closed.Run(context.Background(), hooks.PointPreUserCreate, nil)
+	if err != nil || result.Allowed() || result.DeniedBy != "failing" {
+		t.Fatalf("expected a fail-closed hook to deny by default, got %+v (%v)", result, err)
+	}
+
+	for _, hook := range []hooks.Hook{
+		{Points: []hooks.Point{hooks.PointPreLogin}, Handler: failing},
+		{Name: "nowhere", Handler: failing},
+		{Name: "bad-point", Points: []hooks.Point{"mid_login"}, Handler: failing},
+		{Name: "no-target", Points: []hooks.Point{hooks.PointPreLogin}},
+		{Name: "too-slow", Points: []hooks.Point{hooks.PointPreLogin}, Handler: failing, Timeout: time.Minute},
+		{Name: "failing", Points: []hooks.Point{hooks.PointPreLogin}, Handler: failing},
+	} {
+		if err := closed.Register(hook); !errors.Is(err, hooks.ErrInvalidHook) {
+			t.Fatalf("expected %+v to be rejected, got %v", hook.Name, err)
+		}
+	}
+}
+
+func TestHTTPHookSignedRequestTest(t *testing.T) {
+	t.Parallel()
+
+	const secret = "hook-secret"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := hooks.Verify(secret, r.Header, body, time.Minute, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		var req hooks.Request
+		if err := json.Unmarshal(body, &req); err != nil || req.Point != hooks.PointPreUserCreate || r.Header.Get(hooks.PointHeader) != "pre_user_create" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(hooks.Decision{Action: hooks.ActionModify, Attributes: map[string]interface{}{"employee_id": "E-42"}})
+	}))
+	defer server.Close()
+
+	runner := hooks.NewRunner(nil, hooks.WithHTTPClient(server.Client()))
+	if err := runner.Register(hooks.Hook{Name: "hr", Points: []hooks.Point{hooks.PointPreUserCreate}, URL: server.URL, Secret: secret}); err != nil {
+		t.Fatalf("register returned error: %v", err)
+	}
+	if err := runner.Register(hooks.Hook{Name: "unsigned", Points: []hooks.Point{hooks.PointPreLogin}, URL: server.URL, Secret: "wrong"}); err != nil {
+		t.Fatalf("register returned error: %v", err)
+	}
+
+	result, err := runner.Run(context.Background(), hooks.PointPreUserCreate, &hooks.Request{Username: "ada"})
+	if err != nil || !result.Allowed() || result.Attributes["employee_id"] != "E-42" {
+		t.Fatalf("expected the HTTP hook to modify attributes, got %+v (%v)", result, err)
+	}
+	result, err = runner.Run(context.Background(), hooks.PointPreLogin, &hooks.Request{Username: "ada"})
+	if err != nil || result.Allowed() || result.Evaluations[0].Error == "" {
+		t.Fatalf("expected a rejected signature to fail closed, got %+v (%v)", result, err)
+	}
+}
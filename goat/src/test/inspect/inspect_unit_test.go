@@ -0,0 +1,140 @@
+package inspect_test
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"goat/internal/events"
+	"goat/internal/inspect"
+)
+
+// handlerTransport serves requests with an http.Handler instead of the network
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	t.handler.ServeHTTP(rec, req)
+	return rec.Result(), nil
+}
+
+type syncBuffer struct {
+	mu sync.Mutex
+	b  strings.Builder
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+func TestParseScriptsTest(t *testing.T) {
+	t.Parallel()
+
+	statuses, err := inspect.ParseStatusScript("503x2, 429,200")
+	if err != nil || !reflect.DeepEqual(statuses, []int{503, 503, 429, 200}) {
+		t.Fatalf("unexpected statuses %v (%v)", statuses, err)
+	}
+	latencies, err := inspect.ParseLatencyScript("1sx2,0s")
+	if err != nil || !reflect.DeepEqual(latencies, []time.Duration{time.Second, time.Second, 0}) {
+		t.Fatalf("unexpected latencies %v (%v)", latencies, err)
+	}
+	for _, bad := range []string{"99", "abc", "200x0", "200x"} {
+		if _, err := inspect.ParseStatusScript(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+	if _, err := inspect.ParseLatencyScript("-1s"); err == nil {
+		t.Fatalf("expected a negative latency to be rejected")
+	}
+}
+
+func TestReceiverTest(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	out := &syncBuffer{}
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	receiver, err := inspect.NewReceiver(inspect.Config{
+		Secret:        "s3cret",
+		OutputDir:     dir,
+		Statuses:      []int{503, 200},
+		DecryptionKey: key,
+		Out:           out,
+	})
+	if err != nil {
+		t.Fatalf("new receiver returned error: %v", err)
+	}
+	deliverer := events.NewDefaultWebhookDeliverer(0, events.WithTransport(handlerTransport{receiver}))
+	webhook := &events.Webhook{ID: "wh-local", URL: "http://localhost:8089/hook", Secret: "s3cret"}
+	event := &events.Event{ID: "event-1", Type: events.EventUserLogin, Data: map[string]interface{}{"user": "ada"}}
+
+	delivery, err := deliverer.Deliver(ctx, webhook, event)
+	if err == nil || delivery.StatusCode != http.StatusServiceUnavailable || delivery.NextRetryAt == nil {
+		t.Fatalf("expected the scripted 503 to be retryable, got %+v (%v)", delivery, err)
+	}
+	if delivery, err = deliverer.Deliver(ctx, webhook, event); err != nil || delivery.StatusCode != http.StatusOK {
+		t.Fatalf("expected the scripted 200, got %v", err)
+	}
+	if !strings.Contains(out.String(), "signature: valid") || !strings.Contains(out.String(), `"user": "ada"`) {
+		t.Fatalf("expected pretty-printed, verified requests, got:\n%s", out.String())
+	}
+
+	forged := &events.Webhook{ID: "wh-forged", URL: webhook.URL, Secret: "wrong"}
+	if delivery, _ = deliverer.Deliver(ctx, forged, event); delivery.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected a bad signature to be rejected, got %d", delivery.StatusCode)
+	}
+
+	if err := deliverer.VerifyEndpoint(ctx, webhook); err != nil {
+		t.Fatalf("expected the receiver to echo ownership challenges, got %v", err)
+	}
+
+	jwks := fmt.Sprintf(`{"keys":[{"kty":"OKP","crv":"X25519","kid":"local","x":%q}]}`,
+		base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()))
+	webhook.Encryption = &events.WebhookEncryption{Algorithm: events.JWEAlgECDHES, JWKS: json.RawMessage(jwks)}
+	if _, err := deliverer.Deliver(ctx, webhook, event); err != nil {
+		t.Fatalf("encrypted delivery returned error: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 5 {
+		t.Fatalf("expected one saved file per request, got %d", len(files))
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "000005-event-1.json"))
+	if err != nil {
+		t.Fatalf("expected the encrypted request to be saved: %v", err)
+	}
+	var saved inspect.Request
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("invalid saved request: %v", err)
+	}
+	var decrypted events.Event
+	if err := json.Unmarshal(saved.Decrypted, &decrypted); err != nil || decrypted.ID != "event-1" || saved.Signature != inspect.SignatureValid {
+		t.Fatalf("expected the decrypted payload to be saved, got %s", data)
+	}
+}
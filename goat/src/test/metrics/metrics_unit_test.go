@@ -0,0 +1,84 @@
+package metrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"goat/internal/metrics"
+)
+
+func TestRegistryTextFormatTest(t *testing.T) {
+	t.Parallel()
+
+	registry := metrics.NewRegistry()
+	requests := registry.NewCounter("http_requests_total", "Requests served", "code")
+	inFlight := registry.NewGauge("in_flight", "Requests in flight")
+	latency := registry.NewHistogram("latency_seconds", "Request latency", []float64{0.5, 0.1}, "path")
+	registry.NewGaugeFunc("queue_length", "Queued items", func() float64 { return 3 })
+
+	requests.Inc("200")
+	requests.Add(2, "200")
+	requests.Inc(`5"00`)
+	inFlight.Inc()
+	inFlight.Inc()
+	inFlight.Dec()
+	latency.Observe(0.05, "/a")
+	latency.Observe(0.3, "/a")
+	latency.Observe(7, "/a")
+
+	var out strings.Builder
+	if err := registry.WriteText(&out); err != nil {
+		t.Fatalf("write returned error: %v", err)
+	}
+	want := `# HELP http_requests_total Requests served
+# TYPE http_requests_total counter
+http_requests_total{code="200"} 3
+http_requests_total{code="5\"00"} 1
+# HELP in_flight Requests in flight
+# TYPE in_flight gauge
+in_flight 1
+# HELP latency_seconds Request latency
+# TYPE latency_seconds histogram
+latency_seconds_bucket{path="/a",le="0.1"} 1
+latency_seconds_bucket{path="/a",le="0.5"} 2
+latency_seconds_bucket{path="/a",le="+Inf"} 3
+latency_seconds_sum{path="/a"} 7.35
+latency_seconds_count{path="/a"} 3
+# HELP queue_length Queued items
+# TYPE queue_length gauge
+queue_length 3
+`
+	if out.String() != want {
+		t.Fatalf("unexpected exposition:\n%s", out.String())
+	}
+
+	rec := httptest.NewRecorder()
+	registry.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != metrics.ContentType || rec.Body.String() != want {
+		t.Fatalf("unexpected scrape response: %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestRegistryRejectsMisuseTest(t *testing.T) {
+	t.Parallel()
+
+	mustPanic := func(name string, fn func()) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("%s: expected a panic", name)
+			}
+		}()
+		fn()
+	}
+
+	registry := metrics.NewRegistry()
+	counter := registry.NewCounter("events_total", "Events", "type")
+	mustPanic("duplicate name", func() { registry.NewGauge("events_total", "Events") })
+	mustPanic("invalid name", func() { registry.NewGauge("events-total", "Events") })
+	mustPanic("reserved label", func() { registry.NewHistogram("sizes", "Sizes", nil, "le") })
+	mustPanic("label count", func() { counter.Inc() })
+	mustPanic("negative counter", func() { counter.Add(-1, "x") })
+}